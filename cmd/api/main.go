@@ -13,15 +13,22 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
 
+	authcustomer "music-master/internal/api/v1/customer/auth"
+	foldercustomer "music-master/internal/api/v1/customer/folder"
 	musictrackcustomer "music-master/internal/api/v1/customer/musictrack"
 	playlistcustomer "music-master/internal/api/v1/customer/playlist"
+	uploadcustomer "music-master/internal/api/v1/customer/upload"
 	"music-master/internal/db"
 	"music-master/internal/db/elasticsearch"
+	"music-master/internal/storage/driver"
 	"music-master/internal/util/converter"
 	"music-master/internal/util/server"
 	_ "music-master/internal/util/swagger"
 )
 
+// uploadJanitorInterval is how often expired uploads are looked for
+const uploadJanitorInterval = 15 * time.Minute
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -42,10 +49,20 @@ func main() {
 
 	musicTrackCollection := db.NewMusicTrackCollection(mongoDB)
 	playlistCollection := db.NewPlaylistCollection(mongoDB)
+	playlistRevisionCollection := db.NewPlaylistRevisionCollection(mongoDB)
+	folderCollection := db.NewFolderCollection(mongoDB)
+	waveformCollection := db.NewWaveformCollection(mongoDB)
+	uploadCollection := db.NewUploadCollection(mongoDB)
+	hlsIndexCollection := db.NewHLSIndexCollection(mongoDB)
+	fingerprintCollection := db.NewFingerprintCollection(mongoDB)
+
+	audioStorage, err := driver.New(cfg, mongoDB)
+	if err != nil {
+		panic(err)
+	}
 
 	musicTrackES := elasticsearch.NewMusicTrackCollection(es)
 
-	fmt.Println("cfg", cfg)
 	// Init HTTP server
 	e := New(&Config{
 		Stage:        cfg.Stage,
@@ -55,15 +72,37 @@ func main() {
 	})
 
 	converter := converter.NewModelConverter()
-	musicTrackCustomer := musictrackcustomer.New(musicTrackCollection, converter, musicTrackES)
-	playlistCustomer := playlistcustomer.New(playlistCollection, converter)
+	customerAuth := authcustomer.New(cfg.JWTSecret)
+	musicTrackCustomer := musictrackcustomer.New(musictrackcustomer.Deps{
+		DB:                    mongoDB,
+		MusicTrackCollection:  musicTrackCollection,
+		WaveformCollection:    waveformCollection,
+		HLSIndexCollection:    hlsIndexCollection,
+		FingerprintCollection: fingerprintCollection,
+		PlaylistCollection:    playlistCollection,
+		AudioStorage:          audioStorage,
+		Converter:             converter,
+		Validator:             e.Validator,
+		MusicTrackES:          musicTrackES,
+	}, musictrackcustomer.Config{
+		WaveformBuckets:      cfg.WaveformBuckets,
+		FingerprintThreshold: cfg.FingerprintThreshold,
+	})
+	playlistCustomer := playlistcustomer.New(playlistCollection, musicTrackCollection, playlistRevisionCollection, converter, e.Validator)
+	folderCustomer := foldercustomer.New(mongoDB, folderCollection, playlistCollection, playlistRevisionCollection, e.Validator)
+	uploadCustomer := uploadcustomer.New(uploadCollection, audioStorage, musicTrackCustomer, cfg.UploadMaxSize, cfg.UploadTTL)
+
+	// * abandoned uploads are removed in the background
+	go uploadCustomer.RunJanitor(context.Background(), uploadJanitorInterval)
 
 	v1cRouter := e.Group("/v1")
 
 	// * customer
 	v1cRouter = v1cRouter.Group("/customer")
 	musictrackcustomer.NewHTTP(musicTrackCustomer, nil, v1cRouter.Group("/music-tracks"))
-	playlistcustomer.NewHTTP(playlistCustomer, nil, v1cRouter.Group("/playlists"))
+	playlistcustomer.NewHTTP(playlistCustomer, customerAuth, v1cRouter.Group("/playlists", customerAuth.Middleware()))
+	foldercustomer.NewHTTP(folderCustomer, customerAuth, v1cRouter.Group("/folders", customerAuth.Middleware()))
+	uploadcustomer.NewHTTP(uploadCustomer, customerAuth, v1cRouter.Group("/uploads", customerAuth.Middleware()))
 
 	// Static page for Swagger API specs
 	if cfg.Debug {
@@ -124,7 +163,7 @@ func Start(e *echo.Echo) {
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 10 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -149,10 +188,13 @@ func Headers() echo.MiddlewareFunc {
 func CORS(allowOrigins []string) echo.MiddlewareFunc {
 	return middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     allowOrigins,
-		AllowMethods:     []string{"POST", "GET", "PUT", "DELETE", "PATCH", "HEAD"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowMethods:     []string{"POST", "GET", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"},
 		AllowCredentials: true,
-		ExposeHeaders:    []string{"Content-Length"},
-		MaxAge:           86400,
+		ExposeHeaders: []string{
+			"Content-Length", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires", "Music-Track-Id",
+		},
+		MaxAge: 86400,
 	})
 }
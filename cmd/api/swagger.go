@@ -3,7 +3,9 @@
 // API documents for Music application.
 //
 // ## Authentication
-// Coming soon
+// Playlist requests carry a JWT bearer token signed with HS256 with the JWT_SECRET of
+// the server, `Authorization: Bearer <token>`. The id claim, or sub without one, is the
+// id of the user.
 //
 // Terms Of Service: N/A
 //
@@ -0,0 +1,71 @@
+// Command assign-playlist-owners gives the playlists made before playlists had owners
+// an owner, who can then rename, share, file away and delete them. Until they have
+// one, every user is an editor of them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"music-master/config"
+	"music-master/internal/db"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	owner := flag.String("owner", "", "id of the user the playlists are given to")
+	playlist := flag.String("playlist", "", "id of the only playlist to give, all playlists without an owner when empty")
+	dryRun := flag.Bool("dry-run", false, "only report the playlists that would be given an owner")
+	flag.Parse()
+
+	if *owner == "" {
+		fmt.Fprintln(os.Stderr, "-owner is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	where := bson.M{"owner_id": bson.M{"$exists": false}}
+	if *playlist != "" {
+		id, err := primitive.ObjectIDFromHex(*playlist)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-playlist is not a playlist id:", err)
+			os.Exit(2)
+		}
+		where["_id"] = id
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	mongoDB, err := db.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoDB.Disconnect()
+
+	playlistCollection := db.NewPlaylistCollection(mongoDB)
+
+	ctx := context.Background()
+	playlists, err := playlistCollection.FindSummaries(ctx, where)
+	if err != nil {
+		panic(err)
+	}
+	for _, rec := range playlists {
+		fmt.Printf("giving %s (%s) to %s\n", rec.ID.Hex(), rec.Name, *owner)
+	}
+	if *dryRun {
+		fmt.Println("playlists without an owner:", len(playlists))
+		return
+	}
+
+	assigned, err := playlistCollection.AssignOwner(ctx, where, *owner)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("assigned playlists:", assigned)
+}
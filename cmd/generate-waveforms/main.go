@@ -0,0 +1,94 @@
+// Command generate-waveforms computes the waveform peaks of music tracks uploaded before
+// waveforms existed, or of every track with -force, e.g. after changing WAVEFORM_BUCKETS
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"music-master/config"
+	"music-master/internal/db"
+	"music-master/internal/model"
+	"music-master/internal/storage/driver"
+	"music-master/internal/util/audio"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	force := flag.Bool("force", false, "regenerate waveforms that are up to date")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	mongoDB, err := db.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoDB.Disconnect()
+
+	musicTrackCollection := db.NewMusicTrackCollection(mongoDB)
+	waveformCollection := db.NewWaveformCollection(mongoDB)
+	audioStorage, err := driver.New(cfg, mongoDB)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	generated, skipped, failed := 0, 0, 0
+	err = musicTrackCollection.Each(ctx, bson.M{"audio.key": bson.M{"$exists": true}}, func(rec *model.MusicTrack) error {
+		if !*force {
+			curr, err := waveformCollection.FindOne(ctx, bson.M{"track_id": rec.ID})
+			if err != nil && err != mongo.ErrNoDocuments {
+				return err
+			}
+			if curr != nil && curr.Checksum == rec.Audio.Checksum && curr.Length > 0 {
+				skipped++
+				return nil
+			}
+		}
+
+		fmt.Printf("generating %s (%s)\n", rec.ID.Hex(), rec.Title)
+		content, err := audioStorage.Open(ctx, rec.Audio.Key)
+		if err != nil {
+			fmt.Println("Error opening audio of track", rec.ID.Hex(), err)
+			failed++
+			return nil
+		}
+		peaks, err := audio.ComputeWaveform(content, cfg.WaveformBuckets)
+		content.Close()
+		if err != nil {
+			// * tracks in formats without decoder are reported and left alone
+			fmt.Println("Error decoding audio of track", rec.ID.Hex(), err)
+			failed++
+			return nil
+		}
+
+		_, err = waveformCollection.Upsert(ctx, &model.Waveform{
+			TrackID:         rec.ID,
+			Checksum:        rec.Audio.Checksum,
+			SampleRate:      peaks.SampleRate,
+			SamplesPerPixel: peaks.SamplesPerPixel,
+			Bits:            audio.WaveformBits,
+			Length:          peaks.Length(),
+			Data:            peaks.Data,
+			CreatedAt:       time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		generated++
+
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("generated:", generated, "up to date:", skipped, "failed:", failed)
+}
@@ -0,0 +1,139 @@
+// Command migrate-audio moves audio stored by earlier versions into the configured storage:
+// inline mp3_file bytes of music tracks and files referenced by GridFS id (audio.file_id).
+// It also moves the top level sample_rate of older tracks into their format descriptor.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"music-master/config"
+	"music-master/internal/db"
+	"music-master/internal/model"
+	"music-master/internal/storage"
+	"music-master/internal/storage/driver"
+	"music-master/internal/storage/gridfs"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the tracks that would be migrated")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	mongoDB, err := db.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoDB.Disconnect()
+
+	musicTrackCollection := db.NewMusicTrackCollection(mongoDB)
+	audioStorage, err := driver.New(cfg, mongoDB)
+	if err != nil {
+		panic(err)
+	}
+	legacyBucket, err := mongoDB.Bucket(driver.AudioBucket)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	where := bson.M{"$or": []bson.M{
+		{"mp3_file": bson.M{"$exists": true}},
+		{"audio.file_id": bson.M{"$exists": true}},
+	}}
+	migrated := 0
+	err = musicTrackCollection.Each(ctx, where, func(rec *model.MusicTrack) error {
+		fmt.Printf("migrating %s (%s)\n", rec.ID.Hex(), rec.Title)
+		if *dryRun {
+			migrated++
+			return nil
+		}
+
+		var (
+			file     *model.AudioFile
+			legacyID primitive.ObjectID
+		)
+		switch {
+		case rec.Audio != nil && rec.Audio.Key != "":
+			// * already migrated, only leftover inline bytes are dropped
+			file = rec.Audio
+
+		case rec.Audio != nil && !rec.Audio.FileID.IsZero():
+			legacyID = rec.Audio.FileID
+			content, err := gridfs.OpenFile(ctx, legacyBucket, legacyID)
+			if err != nil {
+				return fmt.Errorf("open GridFS file %s: %w", legacyID.Hex(), err)
+			}
+			file, err = put(ctx, audioStorage, rec, content, rec.Audio.Size, rec.Audio.Filename, rec.Audio.ContentType)
+			content.Close()
+			if err != nil {
+				return err
+			}
+
+		case len(rec.MP3File) > 0:
+			file, err = put(ctx, audioStorage, rec, bytes.NewReader(rec.MP3File), int64(len(rec.MP3File)), rec.Title+".mp3", "audio/mpeg")
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := musicTrackCollection.SetAudio(ctx, bson.M{"_id": rec.ID}, file); err != nil {
+			if file != nil && file != rec.Audio {
+				audioStorage.Delete(ctx, file.Key)
+			}
+			return err
+		}
+		if !legacyID.IsZero() {
+			if err := legacyBucket.DeleteContext(ctx, legacyID); err != nil {
+				fmt.Println("Error deleting GridFS file", legacyID.Hex(), err)
+			}
+		}
+		migrated++
+
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("migrated tracks:", migrated)
+
+	if *dryRun {
+		return
+	}
+	moved, err := musicTrackCollection.MoveSampleRate(ctx)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("moved sample rates:", moved)
+}
+
+// put copies the audio of rec into storage the same way the musictrack service stores uploads
+func put(ctx context.Context, s storage.Storage, rec *model.MusicTrack, r io.Reader, size int64, filename, contentType string) (*model.AudioFile, error) {
+	key := "tracks/" + rec.ID.Hex() + "/" + primitive.NewObjectID().Hex()
+	hash := sha256.New()
+	if err := s.Put(ctx, key, io.TeeReader(r, hash), size, contentType); err != nil {
+		return nil, err
+	}
+
+	return &model.AudioFile{
+		Key:         key,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		Size:        size,
+		Filename:    filename,
+		ContentType: contentType,
+		UploadedAt:  time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,82 @@
+// Command migrate-playlists converts playlists stored by earlier versions, holding copies
+// of their tracks, into lists of references to the tracks. Copies of tracks that no
+// longer exist are dropped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"music-master/config"
+	"music-master/internal/db"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the playlists that would be migrated")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	mongoDB, err := db.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoDB.Disconnect()
+
+	playlistCollection := db.NewPlaylistCollection(mongoDB)
+	musicTrackCollection := db.NewMusicTrackCollection(mongoDB)
+
+	ctx := context.Background()
+	migrated := 0
+	err = playlistCollection.EachEmbedded(ctx, func(rec *db.EmbeddedPlaylist) error {
+		ids := make([]primitive.ObjectID, 0, len(rec.Tracks))
+		for _, t := range rec.Tracks {
+			if t != nil && !t.ID.IsZero() {
+				ids = append(ids, t.ID)
+			}
+		}
+		found, err := musicTrackCollection.FindIDs(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		exists := map[primitive.ObjectID]bool{}
+		for _, id := range found {
+			exists[id] = true
+		}
+
+		// * the copies carry no date, the playlist was created with them
+		fmt.Printf("migrating %s (%d tracks)\n", rec.ID.Hex(), len(rec.Tracks))
+		addedAt := rec.ID.Timestamp().UTC()
+		entries := []*model.PlaylistTrack{}
+		for _, id := range ids {
+			if !exists[id] {
+				fmt.Printf("  dropping missing track %s\n", id.Hex())
+				continue
+			}
+			entries = append(entries, &model.PlaylistTrack{TrackID: id, AddedAt: &addedAt})
+		}
+		if *dryRun {
+			migrated++
+			return nil
+		}
+
+		if _, err := playlistCollection.UpdateOne(ctx, bson.M{"_id": rec.ID}, bson.M{"tracks": entries}); err != nil {
+			return err
+		}
+		migrated++
+
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("migrated playlists:", migrated)
+}
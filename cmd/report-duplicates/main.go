@@ -0,0 +1,46 @@
+// Command report-duplicates lists music tracks that share the same audio file. The unique
+// index on audio.checksum cannot be created while such tracks exist.
+package main
+
+import (
+	"context"
+	"fmt"
+	"music-master/config"
+	"music-master/internal/db"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	mongoDB, err := db.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoDB.Disconnect()
+
+	musicTrackCollection := db.NewMusicTrackCollection(mongoDB)
+
+	groups, err := musicTrackCollection.FindAudioDuplicates(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	redundant := 0
+	for _, g := range groups {
+		fmt.Printf("sha256 %s, %d tracks\n", g.Checksum, len(g.Tracks))
+		for i, rec := range g.Tracks {
+			mark := " "
+			if i == 0 {
+				// * the oldest track is the one uploads are deduplicated against
+				mark = "*"
+			}
+			fmt.Printf("  %s %s  %s - %s\n", mark, rec.ID.Hex(), rec.Artist, rec.Title)
+		}
+		redundant += len(g.Tracks) - 1
+	}
+
+	fmt.Println("duplicate groups:", len(groups), "redundant tracks:", redundant)
+}
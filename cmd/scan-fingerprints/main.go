@@ -0,0 +1,184 @@
+// Command scan-fingerprints computes the missing acoustic fingerprints of music tracks,
+// then compares them all and lists the groups of tracks that are the same recording
+// uploaded as different files or with different metadata
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"music-master/config"
+	"music-master/internal/db"
+	"music-master/internal/model"
+	"music-master/internal/storage/driver"
+	"music-master/internal/util/audio"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	threshold := flag.Float64("threshold", 0, "minimum similarity of the same recording, FINGERPRINT_THRESHOLD when 0")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+	if *threshold == 0 {
+		*threshold = cfg.FingerprintThreshold
+	}
+
+	mongoDB, err := db.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoDB.Disconnect()
+
+	musicTrackCollection := db.NewMusicTrackCollection(mongoDB)
+	fingerprintCollection := db.NewFingerprintCollection(mongoDB)
+	audioStorage, err := driver.New(cfg, mongoDB)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	tracks := map[primitive.ObjectID]*model.MusicTrack{}
+	generated, failed := 0, 0
+	err = musicTrackCollection.Each(ctx, bson.M{"audio.key": bson.M{"$exists": true}}, func(rec *model.MusicTrack) error {
+		tracks[rec.ID] = rec
+		curr, err := fingerprintCollection.FindOne(ctx, bson.M{"track_id": rec.ID})
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if curr != nil && curr.Checksum == rec.Audio.Checksum {
+			return nil
+		}
+
+		fmt.Printf("fingerprinting %s (%s)\n", rec.ID.Hex(), rec.Title)
+		content, err := audioStorage.Open(ctx, rec.Audio.Key)
+		if err != nil {
+			fmt.Println("Error opening audio of track", rec.ID.Hex(), err)
+			failed++
+			return nil
+		}
+		fingerprint, err := audio.ComputeFingerprint(content)
+		content.Close()
+		if err != nil {
+			// * tracks in formats without decoder are reported and left out
+			fmt.Println("Error decoding audio of track", rec.ID.Hex(), err)
+			failed++
+			return nil
+		}
+
+		_, err = fingerprintCollection.Upsert(ctx, &model.Fingerprint{
+			TrackID:   rec.ID,
+			Checksum:  rec.Audio.Checksum,
+			Duration:  fingerprint.Duration,
+			Hashes:    fingerprint.Hashes,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		generated++
+
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("fingerprinted:", generated, "failed:", failed)
+
+	// * fingerprints come shortest first, each is only compared with the ones before it
+	// that are close enough in duration
+	groups := newGroups()
+	var window []*model.Fingerprint
+	err = fingerprintCollection.Each(ctx, bson.M{}, func(fp *model.Fingerprint) error {
+		rec, ok := tracks[fp.TrackID]
+		if !ok || rec.Audio.Checksum != fp.Checksum {
+			return nil
+		}
+		for len(window) > 0 && window[0].Duration < fp.Duration-audio.MaxDurationDifference {
+			window = window[1:]
+		}
+		for _, other := range window {
+			if audio.Similarity(fp.Hashes, other.Hashes) >= *threshold {
+				groups.union(fp.TrackID, other.TrackID)
+			}
+		}
+		window = append(window, fp)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	redundant := 0
+	candidates := groups.list()
+	for _, ids := range candidates {
+		fmt.Printf("%d tracks\n", len(ids))
+		for i, id := range ids {
+			mark := " "
+			if i == 0 {
+				mark = "*"
+			}
+			rec := tracks[id]
+			fmt.Printf("  %s %s  %s - %s (%s)\n", mark, id.Hex(), rec.Artist, rec.Title, rec.Audio.Filename)
+		}
+		redundant += len(ids) - 1
+	}
+
+	fmt.Println("candidate groups:", len(candidates), "redundant tracks:", redundant)
+}
+
+// groups is a union-find over the ids of tracks found to be the same recording
+type groups struct {
+	parent map[primitive.ObjectID]primitive.ObjectID
+}
+
+func newGroups() *groups {
+	return &groups{parent: map[primitive.ObjectID]primitive.ObjectID{}}
+}
+
+func (g *groups) find(id primitive.ObjectID) primitive.ObjectID {
+	for g.parent[id] != id {
+		g.parent[id] = g.parent[g.parent[id]]
+		id = g.parent[id]
+	}
+
+	return id
+}
+
+// union puts a and b into the same group
+func (g *groups) union(a, b primitive.ObjectID) {
+	for _, id := range []primitive.ObjectID{a, b} {
+		if _, ok := g.parent[id]; !ok {
+			g.parent[id] = id
+		}
+	}
+	if ra, rb := g.find(a), g.find(b); ra != rb {
+		g.parent[ra] = rb
+	}
+}
+
+// list returns the ids of every group, oldest track first, groups ordered by their oldest track
+func (g *groups) list() [][]primitive.ObjectID {
+	members := map[primitive.ObjectID][]primitive.ObjectID{}
+	for id := range g.parent {
+		root := g.find(id)
+		members[root] = append(members[root], id)
+	}
+
+	var result [][]primitive.ObjectID
+	for _, ids := range members {
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+		result = append(result, ids)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0].Hex() < result[j][0].Hex() })
+
+	return result
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"errors"
+	"time"
+
 	"github.com/caarlos0/env/v5"
 	"github.com/joho/godotenv"
 )
@@ -14,6 +17,30 @@ type Configuration struct {
 	Debug        bool     `env:"DEBUG"`
 	DBUrl        string   `env:"DB_URL"`
 	DBName       string   `env:"DB_NAME"`
+
+	// Key the JWT bearer tokens of customers are signed with, HS256
+	JWTSecret string `env:"JWT_SECRET,required"`
+
+	// Storage of track audio: gridfs, filesystem or s3
+	StorageDriver string `env:"STORAGE_DRIVER" envDefault:"gridfs"`
+	StoragePath   string `env:"STORAGE_PATH" envDefault:"data/storage"`
+	S3Endpoint    string `env:"S3_ENDPOINT"`
+	S3Region      string `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket      string `env:"S3_BUCKET"`
+	S3AccessKey   string `env:"S3_ACCESS_KEY"`
+	S3SecretKey   string `env:"S3_SECRET_KEY"`
+	S3UseSSL      bool   `env:"S3_USE_SSL"`
+
+	// Number of min/max peak pairs computed for track waveforms
+	WaveformBuckets int `env:"WAVEFORM_BUCKETS" envDefault:"1000"`
+
+	// Similarity from 0 to 1 above which two tracks are reported as the same recording,
+	// unrelated audio scores around 0.5
+	FingerprintThreshold float64 `env:"FINGERPRINT_THRESHOLD" envDefault:"0.75"`
+
+	// Resumable uploads: largest accepted file in bytes and how long an unfinished upload is kept
+	UploadMaxSize int64         `env:"UPLOAD_MAX_SIZE" envDefault:"2147483648"`
+	UploadTTL     time.Duration `env:"UPLOAD_TTL" envDefault:"24h"`
 }
 
 // Load returns Configuration struct
@@ -26,6 +53,9 @@ func Load() (*Configuration, error) {
 	if err := env.Parse(cfg); err != nil {
 		return cfg, err
 	}
+	if cfg.WaveformBuckets <= 0 {
+		return cfg, errors.New("WAVEFORM_BUCKETS must be positive")
+	}
 
 	return cfg, nil
 }
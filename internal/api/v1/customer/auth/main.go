@@ -1,17 +1,77 @@
 package auth
 
+import (
+	"fmt"
+	"music-master/internal/model"
+	"music-master/internal/util/server"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// claimKeys are the claims of a token kept in the context of its request
+var claimKeys = []string{"id", "first_name", "last_name", "email", "phone_number"}
+
+// Middleware rejects requests without a valid bearer token and keeps the claims of the
+// token in the context for User. The id of the user is the id claim, or sub without one.
+func (s *Auth) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			token := strings.TrimPrefix(header, "Bearer ")
+			if token == header || token == "" {
+				return errUnauthorized("Missing bearer token")
+			}
+
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+				if t.Method != jwt.SigningMethodHS256 {
+					return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+				}
+				return s.secret, nil
+			})
+			if err != nil {
+				return errUnauthorized("Invalid bearer token").SetInternal(err)
+			}
+			if _, ok := claims["id"].(string); !ok {
+				claims["id"] = claims["sub"]
+			}
+			if id, _ := claims["id"].(string); id == "" {
+				return errUnauthorized("The bearer token names no user")
+			}
+
+			for _, key := range claimKeys {
+				if v, ok := claims[key].(string); ok {
+					c.Set(key, v)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
 // User returns user data stored in jwt token
-// func (s *Auth) User(c echo.Context) *model.AuthUser {
-// 	id, _ := c.Get("id").(string)
-// 	firstName, _ := c.Get("first_name").(string)
-// 	lastName, _ := c.Get("last_name").(string)
-// 	email, _ := c.Get("email").(string)
-// 	phoneNumber, _ := c.Get("phone_number").(string)
-// 	return &model.AuthUser{
-// 		ID:          id,
-// 		FirstName:   firstName,
-// 		LastName:    lastName,
-// 		Email:       email,
-// 		PhoneNumber: phoneNumber,
-// 	}
-// }
+func (s *Auth) User(c echo.Context) *model.AuthUser {
+	id, _ := c.Get("id").(string)
+	firstName, _ := c.Get("first_name").(string)
+	lastName, _ := c.Get("last_name").(string)
+	email, _ := c.Get("email").(string)
+	phoneNumber, _ := c.Get("phone_number").(string)
+	if id == "" {
+		return nil
+	}
+	return &model.AuthUser{
+		ID:          id,
+		FirstName:   firstName,
+		LastName:    lastName,
+		Email:       email,
+		PhoneNumber: phoneNumber,
+	}
+}
+
+func errUnauthorized(message string) *server.HTTPError {
+	return server.NewHTTPError(http.StatusUnauthorized, server.GenericErrorType, message)
+}
@@ -1 +1,12 @@
 package auth
+
+// New creates new auth service, verifying tokens signed with secret
+func New(secret string) *Auth {
+	return &Auth{secret: []byte(secret)}
+}
+
+// Auth represents auth application service: customers are authenticated by the JWT
+// bearer tokens they are issued, signed with HS256
+type Auth struct {
+	secret []byte
+}
@@ -0,0 +1,359 @@
+package folder
+
+import (
+	"context"
+	"music-master/internal/model"
+	"net/http"
+
+	httputil "music-master/internal/util/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HTTP represents folder http service
+type HTTP struct {
+	svc  Service
+	auth model.Auth
+}
+
+// Service represents folder application interface
+type Service interface {
+	Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.Folder, error)
+	Update(ctx context.Context, authUsr *model.AuthUser, id string, data UpdateData) (*model.Folder, error)
+	Delete(ctx context.Context, authUsr *model.AuthUser, id string, req DeleteRequest) (*DeleteResp, error)
+	Tree(ctx context.Context, authUsr *model.AuthUser) (*TreeResp, error)
+	AddPlaylist(ctx context.Context, authUsr *model.AuthUser, id, playlistID string) error
+	RemovePlaylist(ctx context.Context, authUsr *model.AuthUser, id, playlistID string) error
+}
+
+// NewHTTP creates new folder http service
+func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
+	h := HTTP{svc, auth}
+
+	// swagger:operation POST /v1/customer/folders customer-folders customerFolderCreate
+	// ---
+	// summary: Creates new playlist folder
+	// parameters:
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   required: true
+	//   schema:
+	//     "$ref": "#/definitions/CustomerFolderCreationData"
+	// responses:
+	//   "200":
+	//     description: The new folder
+	//     schema:
+	//       "$ref": "#/definitions/Folder"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("", h.create)
+
+	// swagger:operation GET /v1/customer/folders/tree customer-folders customerFolderTree
+	// ---
+	// summary: Returns the folders of the user with their playlists
+	// description: All folders of the user, nested, and the playlists the user owns in each of them, sorted by name. Playlists in no folder are listed at the top level.
+	// responses:
+	//   "200":
+	//     description: The folder tree
+	//     schema:
+	//       "$ref": "#/definitions/CustomerFolderTreeResp"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/tree", h.tree)
+
+	// swagger:operation PATCH /v1/customer/folders/{id} customer-folders customerFolderUpdate
+	// ---
+	// summary: Renames a playlist folder or moves it into another one
+	// description: An empty parent_id moves the folder to the top level. A folder cannot move into itself or one of its subfolders.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of folder
+	//   type: string
+	//   required: true
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   required: true
+	//   schema:
+	//     "$ref": "#/definitions/CustomerFolderUpdateData"
+	// responses:
+	//   "200":
+	//     description: The updated folder
+	//     schema:
+	//       "$ref": "#/definitions/Folder"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.PATCH("/:id", h.update)
+
+	// swagger:operation DELETE /v1/customer/folders/{id} customer-folders customerFolderDelete
+	// ---
+	// summary: Deletes a playlist folder
+	// description: By default the subfolders and playlists of the folder move up into the folder that held it. cascade=true deletes the subfolders too, their playlists still move up. Playlists are only deleted with delete_playlists=true, which needs cascade=true.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of folder
+	//   type: string
+	//   required: true
+	// - name: cascade
+	//   in: query
+	//   description: true deletes the subfolders of the folder, at any depth
+	//   type: boolean
+	// - name: delete_playlists
+	//   in: query
+	//   description: true deletes the playlists in the deleted folders too
+	//   type: boolean
+	// responses:
+	//   "200":
+	//     description: What was deleted and moved
+	//     schema:
+	//       "$ref": "#/definitions/CustomerFolderDeleteResp"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.DELETE("/:id", h.delete)
+
+	// swagger:operation PUT /v1/customer/folders/{id}/playlists/{playlist_id} customer-folders customerFolderPlaylistAdd
+	// ---
+	// summary: Puts a playlist into a folder
+	// description: Takes the playlist out of the folder it was in. Only the owner of a playlist files it.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of folder
+	//   type: string
+	//   required: true
+	// - name: playlist_id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/ok"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.PUT("/:id/playlists/:playlist_id", h.addPlaylist)
+
+	// swagger:operation DELETE /v1/customer/folders/{id}/playlists/{playlist_id} customer-folders customerFolderPlaylistRemove
+	// ---
+	// summary: Takes a playlist out of a folder
+	// description: The playlist moves to the top level, it is not deleted.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of folder
+	//   type: string
+	//   required: true
+	// - name: playlist_id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/ok"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.DELETE("/:id/playlists/:playlist_id", h.removePlaylist)
+}
+
+// CreationData contains folder data from json request
+// swagger:model CustomerFolderCreationData
+type CreationData struct {
+	// example: Road trips
+	Name string `json:"name" validate:"required,max=200"`
+	// Folder the new folder goes into, the top level without one
+	// example: 6620db0b3e1ac4c9d158ae42
+	ParentID string `json:"parent_id"`
+}
+
+// UpdateData contains folder data from json request
+// swagger:model CustomerFolderUpdateData
+type UpdateData struct {
+	// example: Summer road trips
+	Name *string `json:"name" validate:"omitempty,max=200"`
+	// Folder to move the folder into, empty for the top level
+	// example: 6620db0b3e1ac4c9d158ae42
+	ParentID *string `json:"parent_id"`
+}
+
+// DeleteRequest holds the query of a folder delete request
+type DeleteRequest struct {
+	// Deletes the subfolders too
+	Cascade bool `query:"cascade"`
+	// Deletes the playlists in the deleted folders too
+	DeletePlaylists bool `query:"delete_playlists"`
+}
+
+// DeleteResp contains what deleting a folder deleted and moved
+// swagger:model CustomerFolderDeleteResp
+type DeleteResp struct {
+	// Number of folders deleted
+	// example: 3
+	Folders int `json:"folders"`
+	// Number of playlists moved up out of the deleted folders
+	// example: 12
+	MovedPlaylists int `json:"moved_playlists"`
+	// Number of playlists deleted
+	// example: 0
+	DeletedPlaylists int `json:"deleted_playlists"`
+}
+
+// TreeResp contains the folders of a user and the playlists at the top level
+// swagger:model CustomerFolderTreeResp
+type TreeResp struct {
+	Folders   []*Node            `json:"folders"`
+	Playlists []*PlaylistSummary `json:"playlists"`
+}
+
+// Node is a folder of the folder tree with its subfolders and playlists
+// swagger:model CustomerFolderNode
+type Node struct {
+	ID primitive.ObjectID `json:"id"`
+	// example: Road trips
+	Name      string             `json:"name"`
+	Folders   []*Node            `json:"folders"`
+	Playlists []*PlaylistSummary `json:"playlists"`
+}
+
+// PlaylistSummary is a playlist of the folder tree
+// swagger:model CustomerFolderPlaylistSummary
+type PlaylistSummary struct {
+	ID primitive.ObjectID `json:"id"`
+	// example: My playlist
+	Name       string `json:"name"`
+	SnapshotID string `json:"snapshot_id"`
+	// Number of tracks, missing for smart playlists
+	// example: 12
+	TrackCount *int64 `json:"track_count,omitempty"`
+	// Whether rules select the tracks of the playlist
+	Smart bool `json:"smart"`
+}
+
+func (h *HTTP) create(c echo.Context) error {
+	r := CreationData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Create(c.Request().Context(), h.auth.User(c), r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) tree(c echo.Context) error {
+	resp, err := h.svc.Tree(c.Request().Context(), h.auth.User(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) update(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := UpdateData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Update(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) delete(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := DeleteRequest{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Delete(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) addPlaylist(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.svc.AddPlaylist(c.Request().Context(), h.auth.User(c), id, c.Param("playlist_id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *HTTP) removePlaylist(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.svc.RemovePlaylist(c.Request().Context(), h.auth.User(c), id, c.Param("playlist_id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
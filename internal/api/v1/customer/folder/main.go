@@ -0,0 +1,347 @@
+package folder
+
+import (
+	"context"
+	"fmt"
+	"music-master/internal/model"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"music-master/internal/util/server"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errForbidden        = server.NewHTTPError(http.StatusForbidden, server.GenericErrorType, "Folders belong to signed in users")
+	errFolderNotFound   = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Folder not found")
+	errPlaylistNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Playlist not found")
+	errNotInFolder      = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "The playlist is not in this folder")
+	errParentNotFound   = server.NewHTTPValidationError("parent_id names none of your folders")
+	errEmptyName        = server.NewHTTPValidationError("name must not be blank")
+	errFolderCycle      = server.NewHTTPValidationError("A folder cannot be moved into itself or one of its subfolders")
+)
+
+// Create creates a new Folder, inside the folder data.ParentID when given
+func (s *Folder) Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.Folder, error) {
+	if authUsr == nil {
+		return nil, errForbidden
+	}
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(data.Name) == "" {
+		return nil, errEmptyName
+	}
+	parentID, err := s.parent(ctx, authUsr, data.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return s.folderCollection.InsertOne(ctx, &model.Folder{
+		Name:      strings.TrimSpace(data.Name),
+		OwnerID:   authUsr.ID,
+		ParentID:  parentID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+// Update renames a Folder or moves it into another one, or to the top level with an
+// empty parent id
+func (s *Folder) Update(ctx context.Context, authUsr *model.AuthUser, id string, data UpdateData) (*model.Folder, error) {
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+	curr, err := s.find(ctx, authUsr, id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	update := bson.M{"$set": set}
+	if data.Name != nil {
+		if strings.TrimSpace(*data.Name) == "" {
+			return nil, errEmptyName
+		}
+		set["name"] = strings.TrimSpace(*data.Name)
+	}
+	if data.ParentID != nil {
+		parentID, err := s.parent(ctx, authUsr, *data.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parentID == nil {
+			update["$unset"] = bson.M{"parent_id": ""}
+		} else {
+			if err := s.checkMove(ctx, authUsr, curr.ID, *parentID); err != nil {
+				return nil, err
+			}
+			set["parent_id"] = *parentID
+		}
+	}
+
+	rec, err := s.folderCollection.UpdateOne(ctx, bson.M{"_id": curr.ID, "owner_id": authUsr.ID}, update)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errFolderNotFound
+		}
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// Delete deletes a Folder. Its subfolders and playlists move up into the folder that
+// held it, unless req.Cascade deletes its subfolders too, and with req.DeletePlaylists
+// the playlists of all of them. Playlists are never deleted otherwise.
+func (s *Folder) Delete(ctx context.Context, authUsr *model.AuthUser, id string, req DeleteRequest) (*DeleteResp, error) {
+	if req.DeletePlaylists && !req.Cascade {
+		return nil, server.NewHTTPValidationError("delete_playlists needs cascade")
+	}
+	curr, err := s.find(ctx, authUsr, id)
+	if err != nil {
+		return nil, err
+	}
+
+	folderIDs := []primitive.ObjectID{curr.ID}
+	if req.Cascade {
+		folders, err := s.folderCollection.Find(ctx, bson.M{"owner_id": authUsr.ID})
+		if err != nil {
+			return nil, err
+		}
+		folderIDs = append(folderIDs, descendants(folders, curr.ID)...)
+	}
+	inFolders := bson.M{"owner_id": authUsr.ID, "folder_id": bson.M{"$in": folderIDs}}
+
+	resp := &DeleteResp{Folders: len(folderIDs)}
+	err = s.db.ExecTx(ctx, func(sessionCtx mongo.SessionContext) error {
+		if req.DeletePlaylists {
+			playlists, err := s.playlistCollection.FindSummaries(sessionCtx, inFolders)
+			if err != nil {
+				return err
+			}
+			ids := make([]primitive.ObjectID, len(playlists))
+			for i, p := range playlists {
+				ids[i] = p.ID
+			}
+			if err := s.playlistCollection.RemoveMany(sessionCtx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return err
+			}
+			if err := s.revisionCollection.RemoveMany(sessionCtx, bson.M{"playlist_id": bson.M{"$in": ids}}); err != nil {
+				return err
+			}
+			resp.DeletedPlaylists = len(ids)
+		} else {
+			moved, err := s.playlistCollection.SetFolder(sessionCtx, inFolders, curr.ParentID)
+			if err != nil {
+				return err
+			}
+			resp.MovedPlaylists = int(moved)
+		}
+
+		if !req.Cascade {
+			// * subfolders take the place of the deleted folder
+			update := bson.M{"$unset": bson.M{"parent_id": ""}}
+			if curr.ParentID != nil {
+				update = bson.M{"$set": bson.M{"parent_id": *curr.ParentID}}
+			}
+			if err := s.folderCollection.UpdateMany(sessionCtx, bson.M{"owner_id": authUsr.ID, "parent_id": curr.ID}, update); err != nil {
+				return err
+			}
+		}
+
+		return s.folderCollection.RemoveMany(sessionCtx, bson.M{"owner_id": authUsr.ID, "_id": bson.M{"$in": folderIDs}})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Tree returns all folders of the user, nested, with the playlists the user owns in
+// them. Folders and playlists are sorted by name.
+func (s *Folder) Tree(ctx context.Context, authUsr *model.AuthUser) (*TreeResp, error) {
+	if authUsr == nil {
+		return nil, errForbidden
+	}
+	folders, err := s.folderCollection.Find(ctx, bson.M{"owner_id": authUsr.ID})
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := s.playlistCollection.FindSummaries(ctx, bson.M{"owner_id": authUsr.ID})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(playlists, func(i, j int) bool {
+		return strings.ToLower(playlists[i].Name) < strings.ToLower(playlists[j].Name)
+	})
+
+	nodes := make(map[primitive.ObjectID]*Node, len(folders))
+	for _, f := range folders {
+		nodes[f.ID] = &Node{ID: f.ID, Name: f.Name, Folders: []*Node{}, Playlists: []*PlaylistSummary{}}
+	}
+	resp := &TreeResp{Folders: []*Node{}, Playlists: []*PlaylistSummary{}}
+	for _, f := range folders {
+		// * folders whose parent is gone are shown at the top level
+		if f.ParentID != nil && nodes[*f.ParentID] != nil {
+			parent := nodes[*f.ParentID]
+			parent.Folders = append(parent.Folders, nodes[f.ID])
+			continue
+		}
+		resp.Folders = append(resp.Folders, nodes[f.ID])
+	}
+	for _, p := range playlists {
+		summary := &PlaylistSummary{ID: p.ID, Name: p.Name, SnapshotID: p.SnapshotID, TrackCount: p.TrackCount, Smart: p.Rules != nil}
+		if p.FolderID != nil && nodes[*p.FolderID] != nil {
+			folder := nodes[*p.FolderID]
+			folder.Playlists = append(folder.Playlists, summary)
+			continue
+		}
+		resp.Playlists = append(resp.Playlists, summary)
+	}
+
+	return resp, nil
+}
+
+// AddPlaylist puts a playlist the user owns into a Folder, taking it out of the folder
+// it was in
+func (s *Folder) AddPlaylist(ctx context.Context, authUsr *model.AuthUser, id, playlistID string) error {
+	curr, err := s.find(ctx, authUsr, id)
+	if err != nil {
+		return err
+	}
+	objectID, err := primitive.ObjectIDFromHex(playlistID)
+	if err != nil {
+		return server.NewHTTPValidationError("Invalid playlist id")
+	}
+	playlist, err := s.playlistCollection.FindOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errPlaylistNotFound
+		}
+		return err
+	}
+	// * playlists without an owner are filed away once they are given one
+	if playlist.OwnerID != authUsr.ID {
+		return server.NewHTTPError(http.StatusForbidden, server.GenericErrorType, "Only the owner of a playlist can put it in a folder")
+	}
+
+	if _, err := s.playlistCollection.SetFolder(ctx, bson.M{"_id": objectID, "owner_id": authUsr.ID}, &curr.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemovePlaylist takes a playlist out of a Folder, to the top level
+func (s *Folder) RemovePlaylist(ctx context.Context, authUsr *model.AuthUser, id, playlistID string) error {
+	curr, err := s.find(ctx, authUsr, id)
+	if err != nil {
+		return err
+	}
+	objectID, err := primitive.ObjectIDFromHex(playlistID)
+	if err != nil {
+		return server.NewHTTPValidationError("Invalid playlist id")
+	}
+
+	matched, err := s.playlistCollection.SetFolder(ctx, bson.M{"_id": objectID, "owner_id": authUsr.ID, "folder_id": curr.ID}, nil)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return errNotInFolder
+	}
+
+	return nil
+}
+
+// find returns the folder of the user named by id, folders of other users are not found
+func (s *Folder) find(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Folder, error) {
+	if authUsr == nil {
+		return nil, errForbidden
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		fmt.Println("Error when parse object id", err)
+		return nil, errFolderNotFound
+	}
+
+	rec, err := s.folderCollection.FindOne(ctx, bson.M{"_id": objectID, "owner_id": authUsr.ID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errFolderNotFound
+		}
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// parent returns the id of the folder of the user named by id, nil for an empty id
+func (s *Folder) parent(ctx context.Context, authUsr *model.AuthUser, id string) (*primitive.ObjectID, error) {
+	if id == "" {
+		return nil, nil
+	}
+	rec, err := s.find(ctx, authUsr, id)
+	if err != nil {
+		if err == errFolderNotFound {
+			return nil, errParentNotFound
+		}
+		return nil, err
+	}
+
+	return &rec.ID, nil
+}
+
+// checkMove fails when the folder id would end up inside itself in parentID
+func (s *Folder) checkMove(ctx context.Context, authUsr *model.AuthUser, id, parentID primitive.ObjectID) error {
+	folders, err := s.folderCollection.Find(ctx, bson.M{"owner_id": authUsr.ID})
+	if err != nil {
+		return err
+	}
+	if parentID == id {
+		return errFolderCycle
+	}
+	for _, d := range descendants(folders, id) {
+		if d == parentID {
+			return errFolderCycle
+		}
+	}
+
+	return nil
+}
+
+// descendants returns the ids of the folders inside the folder id, at any depth
+func descendants(folders []*model.Folder, id primitive.ObjectID) []primitive.ObjectID {
+	children := map[primitive.ObjectID][]primitive.ObjectID{}
+	for _, f := range folders {
+		if f.ParentID != nil {
+			children[*f.ParentID] = append(children[*f.ParentID], f.ID)
+		}
+	}
+
+	result := []primitive.ObjectID{}
+	seen := map[primitive.ObjectID]bool{id: true}
+	queue := []primitive.ObjectID{id}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for _, child := range children[next] {
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			result = append(result, child)
+			queue = append(queue, child)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,413 @@
+package folder
+
+import (
+	"context"
+	"music-master/internal/model"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeDB runs transactions without a session, the fakes ignore it
+type fakeDB struct{}
+
+func (fakeDB) ExecTx(ctx context.Context, fn func(sessionCtx mongo.SessionContext) error) error {
+	return fn(nil)
+}
+
+// fakeFolders keeps folders in memory and understands the filters of the service only
+type fakeFolders struct {
+	FolderCollection
+	folders []*model.Folder
+}
+
+func (f *fakeFolders) FindOne(ctx context.Context, where bson.M) (*model.Folder, error) {
+	for _, rec := range f.folders {
+		if rec.ID == where["_id"] && rec.OwnerID == where["owner_id"] {
+			found := *rec
+			return &found, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+// Find returns the folders of the owner sorted by name, like the collation does
+func (f *fakeFolders) Find(ctx context.Context, where bson.M) ([]*model.Folder, error) {
+	result := []*model.Folder{}
+	for _, rec := range f.folders {
+		if rec.OwnerID == where["owner_id"] {
+			found := *rec
+			result = append(result, &found)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name)
+	})
+
+	return result, nil
+}
+
+func (f *fakeFolders) UpdateOne(ctx context.Context, where bson.M, update bson.M) (*model.Folder, error) {
+	for _, rec := range f.folders {
+		if rec.ID == where["_id"] && rec.OwnerID == where["owner_id"] {
+			apply(rec, update)
+			found := *rec
+			return &found, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (f *fakeFolders) UpdateMany(ctx context.Context, where bson.M, update bson.M) error {
+	for _, rec := range f.folders {
+		if rec.OwnerID == where["owner_id"] && rec.ParentID != nil && *rec.ParentID == where["parent_id"] {
+			apply(rec, update)
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeFolders) RemoveMany(ctx context.Context, where bson.M) error {
+	ids := where["_id"].(bson.M)["$in"].([]primitive.ObjectID)
+	kept := []*model.Folder{}
+	for _, rec := range f.folders {
+		if rec.OwnerID != where["owner_id"] || !contains(ids, rec.ID) {
+			kept = append(kept, rec)
+		}
+	}
+	f.folders = kept
+
+	return nil
+}
+
+// apply sets or unsets the name and parent of rec
+func apply(rec *model.Folder, update bson.M) {
+	if set, ok := update["$set"].(bson.M); ok {
+		if name, ok := set["name"].(string); ok {
+			rec.Name = name
+		}
+		if parentID, ok := set["parent_id"].(primitive.ObjectID); ok {
+			rec.ParentID = &parentID
+		}
+	}
+	if unset, ok := update["$unset"].(bson.M); ok {
+		if _, ok := unset["parent_id"]; ok {
+			rec.ParentID = nil
+		}
+	}
+}
+
+// fakePlaylists keeps playlists in memory, filtered by owner and by the folders in $in
+type fakePlaylists struct {
+	PlaylistCollection
+	playlists []*model.Playlist
+}
+
+func (f *fakePlaylists) FindSummaries(ctx context.Context, where bson.M) ([]*model.Playlist, error) {
+	result := []*model.Playlist{}
+	for _, p := range f.playlists {
+		if inFolders(where, p) {
+			found := *p
+			result = append(result, &found)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *fakePlaylists) SetFolder(ctx context.Context, where bson.M, folderID *primitive.ObjectID) (int64, error) {
+	var n int64
+	for _, p := range f.playlists {
+		if inFolders(where, p) {
+			p.FolderID = folderID
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (f *fakePlaylists) RemoveMany(ctx context.Context, where bson.M) error {
+	ids := where["_id"].(bson.M)["$in"].([]primitive.ObjectID)
+	kept := []*model.Playlist{}
+	for _, p := range f.playlists {
+		if !contains(ids, p.ID) {
+			kept = append(kept, p)
+		}
+	}
+	f.playlists = kept
+
+	return nil
+}
+
+// inFolders reports whether p matches the owner and folder_id $in filter of where
+func inFolders(where bson.M, p *model.Playlist) bool {
+	if p.OwnerID != where["owner_id"] {
+		return false
+	}
+	if in, ok := where["folder_id"].(bson.M); ok {
+		return p.FolderID != nil && contains(in["$in"].([]primitive.ObjectID), *p.FolderID)
+	}
+
+	return true
+}
+
+// fakeRevisions records the playlists whose revisions were removed
+type fakeRevisions struct {
+	removed []primitive.ObjectID
+}
+
+func (f *fakeRevisions) RemoveMany(ctx context.Context, where bson.M) error {
+	f.removed = append(f.removed, where["playlist_id"].(bson.M)["$in"].([]primitive.ObjectID)...)
+	return nil
+}
+
+type acceptAll struct{}
+
+func (acceptAll) Validate(i interface{}) error {
+	return nil
+}
+
+func contains(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// library is a service over the folders and playlists of user
+//
+//	music
+//	  jazz: Blue (smart)
+//	  rock: Riffs, anthems
+//	    punk: Pogo
+//	  mix
+//	other
+//	orphan, whose parent is gone
+//	loose
+type library struct {
+	svc       *Folder
+	folders   *fakeFolders
+	playlists *fakePlaylists
+	revisions *fakeRevisions
+	ids       map[string]primitive.ObjectID
+	names     map[primitive.ObjectID]string
+}
+
+func newLibrary() *library {
+	l := &library{
+		folders:   &fakeFolders{},
+		playlists: &fakePlaylists{},
+		revisions: &fakeRevisions{},
+		ids:       map[string]primitive.ObjectID{},
+		names:     map[primitive.ObjectID]string{},
+	}
+	l.svc = New(fakeDB{}, l.folders, l.playlists, l.revisions, acceptAll{})
+	id := func(name string) *primitive.ObjectID {
+		if name == "" {
+			return nil
+		}
+		if _, ok := l.ids[name]; !ok {
+			l.ids[name] = primitive.NewObjectID()
+			l.names[l.ids[name]] = name
+		}
+		objectID := l.ids[name]
+		return &objectID
+	}
+	folder := func(name, parent, owner string) {
+		l.folders.folders = append(l.folders.folders, &model.Folder{ID: *id(name), Name: name, OwnerID: owner, ParentID: id(parent)})
+	}
+	playlist := func(name, folder string, rules *model.PlaylistRules) {
+		l.playlists.playlists = append(l.playlists.playlists, &model.Playlist{ID: *id(name), Name: name, OwnerID: "user", FolderID: id(folder), Rules: rules})
+	}
+
+	folder("rock", "music", "user")
+	folder("music", "", "user")
+	folder("punk", "rock", "user")
+	folder("other", "", "user")
+	folder("jazz", "music", "user")
+	folder("orphan", "gone", "user")
+	folder("theirs", "", "someone")
+	playlist("Riffs", "rock", nil)
+	playlist("Pogo", "punk", nil)
+	playlist("anthems", "rock", nil)
+	playlist("Blue", "jazz", &model.PlaylistRules{})
+	playlist("mix", "music", nil)
+	playlist("loose", "", nil)
+
+	return l
+}
+
+// parentOf returns the name of the folder holding the folder or playlist name, empty at
+// the top level and "deleted" when name is gone
+func (l *library) parentOf(name string) string {
+	for _, f := range l.folders.folders {
+		if f.ID == l.ids[name] {
+			return l.nameOf(f.ParentID)
+		}
+	}
+	for _, p := range l.playlists.playlists {
+		if p.ID == l.ids[name] {
+			return l.nameOf(p.FolderID)
+		}
+	}
+
+	return "deleted"
+}
+
+func (l *library) nameOf(id *primitive.ObjectID) string {
+	if id == nil {
+		return ""
+	}
+
+	return l.names[*id]
+}
+
+func TestCheckMove(t *testing.T) {
+	user := &model.AuthUser{ID: "user"}
+
+	tests := []struct {
+		name, folder, parent string
+		wantErr              error
+	}{
+		{"into itself", "music", "music", errFolderCycle},
+		{"into a child", "music", "rock", errFolderCycle},
+		{"into a grandchild", "music", "punk", errFolderCycle},
+		{"into a sibling", "rock", "jazz", nil},
+		{"into a folder of its parent", "punk", "music", nil},
+		{"into another tree", "music", "other", nil},
+		{"to the top level", "punk", "", nil},
+		{"into a folder of someone else", "rock", "theirs", errParentNotFound},
+	}
+	for _, tt := range tests {
+		l := newLibrary()
+		parentID := ""
+		if tt.parent != "" {
+			parentID = l.ids[tt.parent].Hex()
+		}
+		_, err := l.svc.Update(context.Background(), user, l.ids[tt.folder].Hex(), UpdateData{ParentID: &parentID})
+		if err != tt.wantErr {
+			t.Errorf("%s: Update() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		want := tt.parent
+		if err != nil {
+			want = newLibrary().parentOf(tt.folder)
+		}
+		if got := l.parentOf(tt.folder); got != want {
+			t.Errorf("%s: %s is in %q, want %q", tt.name, tt.folder, got, want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	user := &model.AuthUser{ID: "user"}
+
+	tests := []struct {
+		name    string
+		folder  string
+		req     DeleteRequest
+		want    DeleteResp
+		parents map[string]string
+	}{
+		{
+			"reparents its contents", "rock", DeleteRequest{},
+			DeleteResp{Folders: 1, MovedPlaylists: 2},
+			map[string]string{"rock": "deleted", "punk": "music", "Riffs": "music", "anthems": "music", "Pogo": "punk"},
+		},
+		{
+			"reparents to the top level", "music", DeleteRequest{},
+			DeleteResp{Folders: 1, MovedPlaylists: 1},
+			map[string]string{"music": "deleted", "rock": "", "jazz": "", "mix": "", "punk": "rock", "Blue": "jazz"},
+		},
+		{
+			"cascades to subfolders", "rock", DeleteRequest{Cascade: true},
+			DeleteResp{Folders: 2, MovedPlaylists: 3},
+			map[string]string{"rock": "deleted", "punk": "deleted", "Riffs": "music", "anthems": "music", "Pogo": "music", "jazz": "music"},
+		},
+		{
+			"cascades to playlists", "music", DeleteRequest{Cascade: true, DeletePlaylists: true},
+			DeleteResp{Folders: 4, DeletedPlaylists: 5},
+			map[string]string{"music": "deleted", "punk": "deleted", "jazz": "deleted", "Blue": "deleted", "Pogo": "deleted", "mix": "deleted", "other": "", "loose": ""},
+		},
+	}
+	for _, tt := range tests {
+		l := newLibrary()
+		resp, err := l.svc.Delete(context.Background(), user, l.ids[tt.folder].Hex(), tt.req)
+		if err != nil {
+			t.Fatalf("%s: Delete() error = %v", tt.name, err)
+		}
+		if *resp != tt.want {
+			t.Errorf("%s: Delete() = %+v, want %+v", tt.name, *resp, tt.want)
+		}
+		for name, want := range tt.parents {
+			if got := l.parentOf(name); got != want {
+				t.Errorf("%s: %s is in %q, want %q", tt.name, name, got, want)
+			}
+		}
+		if tt.req.DeletePlaylists != (len(l.revisions.removed) > 0) {
+			t.Errorf("%s: revisions removed of %d playlists", tt.name, len(l.revisions.removed))
+		}
+	}
+
+	t.Run("delete_playlists needs cascade", func(t *testing.T) {
+		l := newLibrary()
+		if _, err := l.svc.Delete(context.Background(), user, l.ids["rock"].Hex(), DeleteRequest{DeletePlaylists: true}); err == nil {
+			t.Fatal("Delete() succeeded")
+		}
+		if len(l.folders.folders) != 7 || len(l.playlists.playlists) != 6 {
+			t.Errorf("Delete() left %d folders and %d playlists, want all of them", len(l.folders.folders), len(l.playlists.playlists))
+		}
+	})
+
+	t.Run("folder of someone else", func(t *testing.T) {
+		l := newLibrary()
+		if _, err := l.svc.Delete(context.Background(), user, l.ids["theirs"].Hex(), DeleteRequest{Cascade: true}); err != errFolderNotFound {
+			t.Errorf("Delete() error = %v, want %v", err, errFolderNotFound)
+		}
+	})
+}
+
+// render writes folders as name[contents] and smart playlists as name*
+func render(folders []*Node, playlists []*PlaylistSummary) string {
+	parts := []string{}
+	for _, f := range folders {
+		parts = append(parts, f.Name+"["+render(f.Folders, f.Playlists)+"]")
+	}
+	for _, p := range playlists {
+		if p.Smart {
+			parts = append(parts, p.Name+"*")
+		} else {
+			parts = append(parts, p.Name)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func TestTree(t *testing.T) {
+	l := newLibrary()
+	resp, err := l.svc.Tree(context.Background(), &model.AuthUser{ID: "user"})
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+
+	// * playlists sort by name whatever the case, the orphan shows at the top level
+	want := "music[jazz[Blue*] rock[punk[Pogo] anthems Riffs] mix] orphan[] other[] loose"
+	if got := render(resp.Folders, resp.Playlists); got != want {
+		t.Errorf("Tree() = %s, want %s", got, want)
+	}
+
+	if _, err := l.svc.Tree(context.Background(), nil); err != errForbidden {
+		t.Errorf("Tree() without a user error = %v, want %v", err, errForbidden)
+	}
+}
@@ -0,0 +1,58 @@
+package folder
+
+import (
+	"context"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// New creates new folder application service
+func New(db Database, folderCollection FolderCollection, playlistCollection PlaylistCollection, revisionCollection RevisionCollection, validator Validator) *Folder {
+	return &Folder{
+		db:                 db,
+		folderCollection:   folderCollection,
+		playlistCollection: playlistCollection,
+		revisionCollection: revisionCollection,
+		validator:          validator,
+	}
+}
+
+// Folder represents playlist folder application service
+type Folder struct {
+	db                 Database
+	folderCollection   FolderCollection
+	playlistCollection PlaylistCollection
+	revisionCollection RevisionCollection
+	validator          Validator
+}
+
+type Database interface {
+	ExecTx(ctx context.Context, fn func(sessionCtx mongo.SessionContext) error) error
+}
+
+type FolderCollection interface {
+	InsertOne(ctx context.Context, data *model.Folder) (*model.Folder, error)
+	FindOne(ctx context.Context, where bson.M) (*model.Folder, error)
+	Find(ctx context.Context, where bson.M) ([]*model.Folder, error)
+	UpdateOne(ctx context.Context, where bson.M, update bson.M) (*model.Folder, error)
+	UpdateMany(ctx context.Context, where bson.M, update bson.M) error
+	RemoveMany(ctx context.Context, where bson.M) error
+}
+
+type PlaylistCollection interface {
+	FindOne(ctx context.Context, where bson.M) (*model.Playlist, error)
+	FindSummaries(ctx context.Context, where bson.M) ([]*model.Playlist, error)
+	SetFolder(ctx context.Context, where bson.M, folderID *primitive.ObjectID) (int64, error)
+	RemoveMany(ctx context.Context, where bson.M) error
+}
+
+type RevisionCollection interface {
+	RemoveMany(ctx context.Context, where bson.M) error
+}
+
+type Validator interface {
+	Validate(i interface{}) error
+}
@@ -1,11 +1,20 @@
 package musictrack
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"mime"
 	"music-master/internal/model"
+	"music-master/internal/util/audio"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"music-master/internal/util/fields"
 	httputil "music-master/internal/util/http"
+	"music-master/internal/util/server"
 
 	"github.com/labstack/echo/v4"
 )
@@ -18,11 +27,21 @@ type HTTP struct {
 // Service represents music track application interface
 type Service interface {
 	Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.MusicTrack, error)
-	View(ctx context.Context, authUsr *model.AuthUser, id string) (*model.MusicTrack, error)
+	View(ctx context.Context, authUsr *model.AuthUser, id string, names []string) (*model.MusicTrack, error)
 	Search(ctx context.Context, authUsr *model.AuthUser, lq *httputil.ListRequest) ([]*model.MusicTrack, error)
 	// List(ctx context.Context, authUsr *model.AuthUser, lq *dbutil.ListQueryCondition, count *int64) (*ListLateFeeResp, error)
 	Update(ctx context.Context, authUsr *model.AuthUser, id string, data UpdateData) (*model.MusicTrack, error)
 	Delete(ctx context.Context, authUsr *model.AuthUser, id string) error
+	UploadAudio(ctx context.Context, authUsr *model.AuthUser, id string, data AudioUpload) (*model.MusicTrack, error)
+	Stream(ctx context.Context, authUsr *model.AuthUser, id string) (*AudioContent, error)
+	Cover(ctx context.Context, authUsr *model.AuthUser, id string, size int) (*CoverImage, error)
+	Waveform(ctx context.Context, authUsr *model.AuthUser, id string, buckets int) (*model.Waveform, error)
+	GenerateWaveform(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Waveform, error)
+	HLSPlaylist(ctx context.Context, authUsr *model.AuthUser, id string) (*HLSContent, error)
+	HLSSegment(ctx context.Context, authUsr *model.AuthUser, id string, n int) (*HLSContent, error)
+	Matches(ctx context.Context, authUsr *model.AuthUser, id string, threshold float64) ([]*Match, error)
+	Playlists(ctx context.Context, authUsr *model.AuthUser, id string, names []string) ([]*model.Playlist, error)
+	MeasureLoudness(ctx context.Context, authUsr *model.AuthUser, id string) (*model.MusicTrack, error)
 }
 
 // NewHTTP creates new music track http service
@@ -39,9 +58,14 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//   required: true
 	//   schema:
 	//     "$ref": "#/definitions/CustomerMusicTrackCreationData"
+	// - name: on_duplicate
+	//   in: query
+	//   description: error (default) answers 409 with the id of the track that has the same audio, return answers with that track
+	//   type: string
+	//   enum: [error, return]
 	// responses:
 	//   "200":
-	//     description: The new music track
+	//     description: The new music track, or the existing one with on_duplicate=return
 	//     schema:
 	//       "$ref": "#/definitions/MusicTrack"
 	//   "400":
@@ -50,6 +74,8 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//     "$ref": "#/responses/errDetails"
 	//   "403":
 	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
 	//   "500":
 	//     "$ref": "#/responses/errDetails"
 	eg.POST("", h.create)
@@ -63,6 +89,10 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//   description: id of music track
 	//   type: string
 	//   required: true
+	// - name: fields
+	//   in: query
+	//   description: comma separated fields to return, dotted for nested ones, e.g. id,title,artist. The audio bytes of legacy tracks are only returned when mp3_file is listed.
+	//   type: string
 	// responses:
 	//   "200":
 	//     description: The music track
@@ -83,6 +113,11 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	// swagger:operation GET /v1/customer/music-tracks customer-musictracks customerMusicTrackSearch
 	// ---
 	// summary: Returns a list music track
+	// parameters:
+	// - name: fields
+	//   in: query
+	//   description: comma separated fields to return, dotted for nested ones, e.g. id,title,artist. The audio bytes of legacy tracks are only returned when mp3_file is listed.
+	//   type: string
 	// responses:
 	//   "200":
 	//     description: The music track
@@ -128,6 +163,8 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//     "$ref": "#/responses/errDetails"
 	//   "404":
 	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
 	//   "500":
 	//     "$ref": "#/responses/errDetails"
 	eg.PATCH("/:id", h.update)
@@ -155,9 +192,329 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//   "500":
 	//     "$ref": "#/responses/errDetails"
 	eg.DELETE("/:id", h.delete)
+
+	// swagger:operation POST /v1/customer/music-tracks/{id}/audio customer-musictracks customerMusicTrackUploadAudio
+	// ---
+	// summary: Uploads the audio file of a music track, replacing the current one
+	// consumes:
+	// - multipart/form-data
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: file
+	//   in: formData
+	//   description: Audio file
+	//   type: file
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The updated music track
+	//     schema:
+	//       "$ref": "#/definitions/MusicTrack"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/audio", h.uploadAudio)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/stream customer-musictracks customerMusicTrackStream
+	// ---
+	// summary: Streams the audio of a music track, supports Range requests for seeking
+	// produces:
+	// - audio/mpeg
+	// - audio/flac
+	// - audio/ogg
+	// - audio/wav
+	// - audio/mp4
+	// - audio/aac
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: Range
+	//   in: header
+	//   description: Byte range to return, e.g. bytes=0-1023
+	//   type: string
+	// - name: If-Range
+	//   in: header
+	//   description: ETag or Last-Modified value the Range is only valid for
+	//   type: string
+	// responses:
+	//   "200":
+	//     description: The full audio file
+	//   "206":
+	//     description: The requested part of the audio file
+	//   "304":
+	//     description: The audio file is not modified
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "416":
+	//     description: The requested range is not satisfiable
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/stream", h.stream)
+	eg.HEAD("/:id/stream", h.stream)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/download customer-musictracks customerMusicTrackDownload
+	// ---
+	// summary: Downloads the audio file of a music track as an attachment
+	// produces:
+	// - audio/mpeg
+	// - audio/flac
+	// - audio/ogg
+	// - audio/wav
+	// - audio/mp4
+	// - audio/aac
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The audio file
+	//   "206":
+	//     description: The requested part of the audio file
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/download", h.download)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/hls/index.m3u8 customer-musictracks customerMusicTrackHLSPlaylist
+	// ---
+	// summary: Returns the HLS media playlist of the audio of a music track
+	// description: Only MP3 audio is packaged for HLS.
+	// produces:
+	// - application/vnd.apple.mpegurl
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The media playlist
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/hls/index.m3u8", h.hlsPlaylist)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/hls/{segment} customer-musictracks customerMusicTrackHLSSegment
+	// ---
+	// summary: Returns a segment of the HLS playlist of a music track
+	// produces:
+	// - audio/mpeg
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: segment
+	//   in: path
+	//   description: segment name as listed in the playlist, e.g. 0.mp3
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The segment
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/hls/:segment", h.hlsSegment)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/cover customer-musictracks customerMusicTrackCover
+	// ---
+	// summary: Returns the cover art of a music track, extracted from its audio file
+	// produces:
+	// - image/jpeg
+	// - image/png
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: size
+	//   in: query
+	//   description: Size of the square box the image is scaled down to, the original image when omitted
+	//   type: integer
+	//   enum: [64, 300, 640]
+	// responses:
+	//   "200":
+	//     description: The cover image
+	//   "304":
+	//     description: The cover image is not modified
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/cover", h.cover)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/waveform customer-musictracks customerMusicTrackWaveform
+	// ---
+	// summary: Returns the waveform peaks of a music track, in the JSON or binary .dat format of audiowaveform
+	// produces:
+	// - application/json
+	// - application/octet-stream
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: buckets
+	//   in: query
+	//   description: Maximum number of min/max pairs, adjacent peaks are merged to fit
+	//   type: integer
+	// - name: format
+	//   in: query
+	//   description: json (default) or dat
+	//   type: string
+	// responses:
+	//   "200":
+	//     description: The waveform
+	//     schema:
+	//       "$ref": "#/definitions/Waveform"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/waveform", h.waveform)
+
+	// swagger:operation POST /v1/customer/music-tracks/{id}/waveform customer-musictracks customerMusicTrackGenerateWaveform
+	// ---
+	// summary: Generates the waveform peaks of a music track again
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The new waveform
+	//     schema:
+	//       "$ref": "#/definitions/Waveform"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/waveform", h.generateWaveform)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/matches customer-musictracks customerMusicTrackMatches
+	// ---
+	// summary: Lists the tracks whose audio is the same recording as the audio of a music track
+	// description: Tracks are compared by acoustic fingerprint, so re-encoded or retagged copies are found.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: threshold
+	//   in: query
+	//   description: minimum similarity from 0 to 1, the configured one when omitted
+	//   type: number
+	// responses:
+	//   "200":
+	//     description: The matching tracks, most similar first
+	//     schema:
+	//       "$ref": "#/definitions/CustomerMusicTrackMatchesResp"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/matches", h.matches)
+
+	// swagger:operation GET /v1/customer/music-tracks/{id}/playlists customer-musictracks customerMusicTrackPlaylists
+	// ---
+	// summary: Lists the playlists holding a music track
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// - name: fields
+	//   in: query
+	//   description: comma separated fields of the playlists to return, dotted for nested ones, e.g. id,name
+	//   type: string
+	// responses:
+	//   "200":
+	//     description: The playlists
+	//     schema:
+	//       "$ref": "#/definitions/CustomerMusicTrackPlaylistsResp"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/playlists", h.playlists)
+
+	// swagger:operation POST /v1/customer/music-tracks/{id}/loudness customer-musictracks customerMusicTrackMeasureLoudness
+	// ---
+	// summary: Measures the loudness of the audio of a music track again
+	// description: The album gain of the tracks of the same album is updated too.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of music track
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The music track with its new loudness
+	//     schema:
+	//       "$ref": "#/definitions/MusicTrack"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "422":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/loudness", h.measureLoudness)
 }
 
-// CreationData contains music track data from json request
+// CreationData contains music track data from json request.
+// Title, artist and album may be left empty when the audio file carries them in its tags.
 // swagger:model CustomerMusicTrackCreationData
 type CreationData struct {
 	// example: Em của ngày hôm qua
@@ -170,11 +527,23 @@ type CreationData struct {
 	Genre string `json:"genre"`
 	// example: 2017
 	ReleaseYear int `json:"release_year"`
+	// example: 1
+	TrackNumber int `json:"track_number"`
 	// example: 189
-	Duration int    `json:"duration"` // Duration in seconds
-	MP3File  []byte `json:"mp3_file"` // Binary data of the MP3 file
+	Duration int    `json:"duration"` // Duration in seconds, measured from mp3_file when it is given
+	MP3File  []byte `json:"mp3_file"` // Binary data of the MP3, FLAC, Ogg, WAV or M4A file, prefer POST /music-tracks/{id}/audio
+	// What to do when mp3_file was already uploaded, taken from the on_duplicate query parameter
+	OnDuplicate string `json:"-"`
 }
 
+// Values of the on_duplicate query parameter of music track creation
+const (
+	// OnDuplicateError answers 409 Conflict with the id of the existing track
+	OnDuplicateError = "error"
+	// OnDuplicateReturn answers with the existing track
+	OnDuplicateReturn = "return"
+)
+
 // UpdateData contains music track data from json request
 // swagger:model CustomerMusicTrackUpdateData
 type UpdateData struct {
@@ -183,10 +552,65 @@ type UpdateData struct {
 	Album       *string `json:"album,omitempty"`
 	Genre       *string `json:"genre,omitempty"`
 	ReleaseYear *int    `json:"release_year,omitempty"`
+	TrackNumber *int    `json:"track_number,omitempty"`
 	Duration    *int    `json:"duration,omitempty"`
 	MP3File     *[]byte `json:"mp3_file,omitempty"`
 }
 
+// AudioUpload contains an audio file from multipart request
+type AudioUpload struct {
+	Filename    string
+	ContentType string
+	Size        int64
+	Content     io.ReaderAt
+}
+
+// AudioContent contains a seekable audio payload to be served
+type AudioContent struct {
+	Content     io.ReadSeekCloser
+	ContentType string
+	Filename    string
+	ModTime     time.Time
+	ETag        string
+}
+
+// CoverImage contains a seekable cover image to be served
+type CoverImage struct {
+	Content     io.ReadSeekCloser
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// Match is a music track whose audio is the same recording as that of another track
+// swagger:model CustomerMusicTrackMatch
+type Match struct {
+	Track *model.MusicTrack `json:"track"`
+	// Similarity of the fingerprints, from 0 to 1
+	// example: 0.93
+	Similarity float64 `json:"similarity"`
+}
+
+// MatchesResp contains the tracks matching a music track
+// swagger:model CustomerMusicTrackMatchesResp
+type MatchesResp struct {
+	Data []*Match `json:"data"`
+}
+
+// PlaylistsResp contains the playlists holding a music track
+// swagger:model CustomerMusicTrackPlaylistsResp
+type PlaylistsResp struct {
+	Data []*model.Playlist `json:"data"`
+}
+
+// HLSContent contains an HLS playlist or segment to be served
+type HLSContent struct {
+	Content     []byte
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
 // ListResp contains list of music track and current page number response
 // swagger:model CustomerMusicTrackListResp
 type ListResp struct {
@@ -199,6 +623,10 @@ func (h *HTTP) create(c echo.Context) error {
 	if err := c.Bind(&r); err != nil {
 		return err
 	}
+	r.OnDuplicate = c.QueryParam("on_duplicate")
+	if r.OnDuplicate != "" && r.OnDuplicate != OnDuplicateError && r.OnDuplicate != OnDuplicateReturn {
+		return server.NewHTTPValidationError("on_duplicate must be error or return")
+	}
 
 	resp, err := h.svc.Create(c.Request().Context(), nil, r)
 	if err != nil {
@@ -213,12 +641,17 @@ func (h *HTTP) view(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	resp, err := h.svc.View(c.Request().Context(), nil, id)
+	names := fields.Parse(c.QueryParam("fields"))
+	resp, err := h.svc.View(c.Request().Context(), nil, id, names)
+	if err != nil {
+		return err
+	}
+	filtered, err := fields.Filter(resp, names)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, resp)
+	return c.JSON(http.StatusOK, filtered)
 }
 
 func (h *HTTP) search(c echo.Context) error {
@@ -231,10 +664,14 @@ func (h *HTTP) search(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-
-	return c.JSON(http.StatusOK, ListResp{
+	filtered, err := fields.Filter(ListResp{
 		Data: resp,
-	})
+	}, fields.Parse(lr.Fields), "data")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, filtered)
 }
 
 func (h *HTTP) update(c echo.Context) error {
@@ -266,3 +703,237 @@ func (h *HTTP) delete(c echo.Context) error {
 
 	return c.NoContent(http.StatusOK)
 }
+
+func (h *HTTP) uploadAudio(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return server.NewHTTPValidationError("file is required").SetInternal(err)
+	}
+	file, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	resp, err := h.svc.UploadAudio(c.Request().Context(), nil, id, AudioUpload{
+		Filename:    fh.Filename,
+		ContentType: fh.Header.Get(echo.HeaderContentType),
+		Size:        fh.Size,
+		Content:     file,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) stream(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	audio, err := h.svc.Stream(c.Request().Context(), nil, id)
+	if err != nil {
+		return err
+	}
+	defer audio.Content.Close()
+
+	// * ServeContent takes care of Range, If-Range, conditional headers and HEAD
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, audio.ContentType)
+	res.Header().Set("ETag", audio.ETag)
+	http.ServeContent(res, c.Request(), "", audio.ModTime, audio.Content)
+
+	return nil
+}
+
+func (h *HTTP) download(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	audio, err := h.svc.Stream(c.Request().Context(), nil, id)
+	if err != nil {
+		return err
+	}
+	defer audio.Content.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, audio.ContentType)
+	res.Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": audio.Filename}))
+	res.Header().Set("ETag", audio.ETag)
+	http.ServeContent(res, c.Request(), "", audio.ModTime, audio.Content)
+
+	return nil
+}
+
+func (h *HTTP) matches(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	threshold := 0.0
+	if v := c.QueryParam("threshold"); v != "" {
+		if threshold, err = strconv.ParseFloat(v, 64); err != nil {
+			return server.NewHTTPValidationError("threshold must be a number").SetInternal(err)
+		}
+	}
+
+	resp, err := h.svc.Matches(c.Request().Context(), nil, id, threshold)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, MatchesResp{
+		Data: resp,
+	})
+}
+
+func (h *HTTP) playlists(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	names := fields.Parse(c.QueryParam("fields"))
+	resp, err := h.svc.Playlists(c.Request().Context(), nil, id, names)
+	if err != nil {
+		return err
+	}
+	filtered, err := fields.Filter(PlaylistsResp{
+		Data: resp,
+	}, names, "data")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, filtered)
+}
+
+func (h *HTTP) measureLoudness(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	resp, err := h.svc.MeasureLoudness(c.Request().Context(), nil, id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) hlsPlaylist(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	playlist, err := h.svc.HLSPlaylist(c.Request().Context(), nil, id)
+	if err != nil {
+		return err
+	}
+
+	serveHLSContent(c, playlist)
+
+	return nil
+}
+
+func (h *HTTP) hlsSegment(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	name := c.Param("segment")
+	n, err := strconv.Atoi(strings.TrimSuffix(name, hlsSegmentExt))
+	if err != nil || !strings.HasSuffix(name, hlsSegmentExt) {
+		return errSegmentNotFound
+	}
+	segment, err := h.svc.HLSSegment(c.Request().Context(), nil, id, n)
+	if err != nil {
+		return err
+	}
+
+	serveHLSContent(c, segment)
+
+	return nil
+}
+
+// serveHLSContent writes content, answering conditional requests from its ETag
+func serveHLSContent(c echo.Context, content *HLSContent) {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, content.ContentType)
+	res.Header().Set("ETag", content.ETag)
+	http.ServeContent(res, c.Request(), "", content.ModTime, bytes.NewReader(content.Content))
+}
+
+func (h *HTTP) cover(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	size := 0
+	if v := c.QueryParam("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil {
+			return server.NewHTTPValidationError("size must be a number").SetInternal(err)
+		}
+	}
+	cover, err := h.svc.Cover(c.Request().Context(), nil, id, size)
+	if err != nil {
+		return err
+	}
+	defer cover.Content.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, cover.ContentType)
+	res.Header().Set("ETag", cover.ETag)
+	http.ServeContent(res, c.Request(), "", cover.ModTime, cover.Content)
+
+	return nil
+}
+
+func (h *HTTP) waveform(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	buckets := 0
+	if v := c.QueryParam("buckets"); v != "" {
+		if buckets, err = strconv.Atoi(v); err != nil {
+			return server.NewHTTPValidationError("buckets must be a number").SetInternal(err)
+		}
+	}
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "dat" {
+		return server.NewHTTPValidationError("format must be json or dat")
+	}
+
+	resp, err := h.svc.Waveform(c.Request().Context(), nil, id, buckets)
+	if err != nil {
+		return err
+	}
+
+	if format == "dat" {
+		peaks := &audio.Waveform{SampleRate: resp.SampleRate, SamplesPerPixel: resp.SamplesPerPixel, Data: resp.Data}
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+id+`.dat"`)
+		return c.Blob(http.StatusOK, echo.MIMEOctetStream, peaks.MarshalDat())
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) generateWaveform(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	resp, err := h.svc.GenerateWaveform(c.Request().Context(), nil, id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -1,44 +1,459 @@
 package musictrack
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"music-master/internal/model"
+	"music-master/internal/storage"
+	"music-master/internal/util/artwork"
+	"music-master/internal/util/audio"
+	"music-master/internal/util/fields"
+	"music-master/internal/util/hls"
 	httputil "music-master/internal/util/http"
+	"music-master/internal/util/server"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// legacyAudioContentType is the type of audio stored before formats were detected,
+// which could only be MP3
+const legacyAudioContentType = "audio/mpeg"
+
+var errAudioNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Music track has no audio")
+
+var errHLSUnavailable = server.NewHTTPError(http.StatusUnprocessableEntity, server.GenericErrorType, "HLS is only available for MP3 audio")
+
+var errSegmentNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "HLS segment not found")
+
+var errCoverNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Music track has no cover art")
+
+var errForbidden = server.NewHTTPError(http.StatusForbidden, server.GenericErrorType, "Music track belongs to another user")
+
+var errCoverTooLarge = server.NewHTTPError(http.StatusUnprocessableEntity, server.GenericErrorType, "The cover art of the music track is too large to resize")
+
+// hlsSegmentExt is the extension of HLS segment names
+const hlsSegmentExt = ".mp3"
+
+// coverSizes are the thumbnail sizes the cover endpoint serves, in pixels
+var coverSizes = []int{64, 300, 640}
+
+// nopCloser lets in-memory audio be served like a stored file
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
 // Create creates a new MusicTrack account
 func (s *MusicTrack) Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.MusicTrack, error) {
+	if len(data.MP3File) == 0 {
+		return s.create(ctx, authUsr, data, nil)
+	}
+	file := data.MP3File
+	data.MP3File = nil
+
+	return s.create(ctx, authUsr, data, &AudioUpload{
+		Size:    int64(len(file)),
+		Content: bytes.NewReader(file),
+	})
+}
+
+// CreateWithAudio creates a new MusicTrack from an audio file uploaded separately, such as
+// a completed resumable upload
+func (s *MusicTrack) CreateWithAudio(ctx context.Context, authUsr *model.AuthUser, data CreationData, file AudioUpload) (*model.MusicTrack, error) {
+	data.MP3File = nil
+
+	return s.create(ctx, authUsr, data, &file)
+}
+
+func (s *MusicTrack) create(ctx context.Context, authUsr *model.AuthUser, data CreationData, file *AudioUpload) (*model.MusicTrack, error) {
+	var (
+		info     *audio.Info
+		checksum string
+	)
+	if file != nil {
+		var err error
+		if info, err = probeAudio(file.Content, file.Size); err != nil {
+			return nil, err
+		}
+		if checksum, err = checksumAudio(file.Content, file.Size); err != nil {
+			return nil, err
+		}
+		if existing, err := s.findDuplicate(ctx, checksum, primitive.NilObjectID); err != nil || existing != nil {
+			return duplicateResult(existing, data.OnDuplicate, err)
+		}
+		// * tags embedded in the file fill whatever the client left empty
+		if info.Tags != nil {
+			applyTags(&data, info.Tags)
+		}
+	}
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+
 	rec := &model.MusicTrack{}
 
 	s.converter.ToModel(rec, data)
+	rec.ID = primitive.NewObjectID()
+	rec.MP3File = nil
+	if authUsr != nil {
+		rec.OwnerID = authUsr.ID
+	}
+	if file != nil {
+		applyStreamInfo(rec, info)
+		if file.Filename == "" {
+			file.Filename = data.Title + "." + info.Format.Extension()
+		}
+		audioFile, err := s.storeAudio(ctx, rec.ID, info, checksum, *file)
+		if err != nil {
+			return nil, err
+		}
+		rec.Audio = audioFile
+		rec.Cover = s.extractCover(ctx, rec.ID, info)
+	}
+
 	result, err := s.musicTrackCollection.InsertOne(ctx, rec)
 	if err != nil {
+		if rec.Audio != nil {
+			s.deleteAudioFile(ctx, rec.Audio)
+		}
+		if rec.Cover != nil {
+			s.deleteCover(ctx, rec.Cover)
+		}
+		// * the unique index catches uploads racing past findDuplicate
+		if mongo.IsDuplicateKeyError(err) && checksum != "" {
+			existing, findErr := s.findDuplicate(ctx, checksum, primitive.NilObjectID)
+			if existing != nil || findErr != nil {
+				return duplicateResult(existing, data.OnDuplicate, findErr)
+			}
+		}
 		return nil, err
 	}
+	if result.Audio != nil {
+		s.analyzeAudio(result)
+	}
 
 	return result, nil
 }
 
-// View returns single MusicTrack
-func (s *MusicTrack) View(ctx context.Context, authUsr *model.AuthUser, id string) (*model.MusicTrack, error) {
-	rec := new(model.MusicTrack)
+// View returns single MusicTrack with the named fields only, see fields.Projection.
+// Legacy audio bytes are left out unless named.
+func (s *MusicTrack) View(ctx context.Context, authUsr *model.AuthUser, id string, names []string) (*model.MusicTrack, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 
-	rec, err = s.musicTrackCollection.FindOne(ctx, bson.M{"_id": objectID})
+	rec, err := s.musicTrackCollection.FindOneFields(ctx, bson.M{"_id": objectID}, names)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Music track not found")
+		}
 		return nil, err
 	}
 
 	return rec, nil
 }
 
+// find returns the whole MusicTrack, legacy audio bytes included
+func (s *MusicTrack) find(ctx context.Context, id string) (*model.MusicTrack, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := s.musicTrackCollection.FindOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Music track not found")
+		}
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// Stream opens the audio of a MusicTrack for playback, wherever its bytes are stored
+func (s *MusicTrack) Stream(ctx context.Context, authUsr *model.AuthUser, id string) (*AudioContent, error) {
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	return s.openAudio(ctx, rec)
+}
+
+// openAudio opens the audio of rec, wherever its bytes are stored
+func (s *MusicTrack) openAudio(ctx context.Context, rec *model.MusicTrack) (*AudioContent, error) {
+	switch {
+	case rec.Audio != nil:
+		content, err := s.audioStorage.Open(ctx, rec.Audio.Key)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return nil, errAudioNotFound
+			}
+			return nil, err
+		}
+		// * stored audio is never modified in place, replacing audio creates a new key
+		return &AudioContent{
+			Content:     content,
+			ContentType: audioContentType(rec),
+			Filename:    downloadFilename(rec),
+			ModTime:     rec.Audio.UploadedAt,
+			ETag:        `"` + rec.Audio.Checksum + `"`,
+		}, nil
+
+	case len(rec.MP3File) > 0:
+		return &AudioContent{
+			Content:     nopCloser{bytes.NewReader(rec.MP3File)},
+			ContentType: legacyAudioContentType,
+			Filename:    downloadFilename(rec),
+			ModTime:     rec.ID.Timestamp(),
+			ETag:        fmt.Sprintf(`"%x"`, md5.Sum(rec.MP3File)),
+		}, nil
+	}
+
+	return nil, errAudioNotFound
+}
+
+// Cover returns the cover art of a MusicTrack scaled to fit size x size pixels, or the
+// original image for size 0. Thumbnails are generated on first request and kept in storage.
+func (s *MusicTrack) Cover(ctx context.Context, authUsr *model.AuthUser, id string, size int) (*CoverImage, error) {
+	if size != 0 && !validCoverSize(size) {
+		return nil, server.NewHTTPValidationError(fmt.Sprintf("size must be one of %v", coverSizes))
+	}
+
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	if rec.Cover == nil {
+		return nil, errCoverNotFound
+	}
+
+	key := rec.Cover.Key
+	if size != 0 {
+		key = thumbnailKey(rec.Cover, size)
+	}
+	content, err := s.audioStorage.Open(ctx, key)
+	switch {
+	case err == storage.ErrNotFound && size != 0:
+		content, err = s.generateThumbnail(ctx, rec.Cover, size)
+	case err == storage.ErrNotFound:
+		return nil, errCoverNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// * the cover key changes whenever the artwork does, so cached thumbnails never go stale
+	return &CoverImage{
+		Content:     content,
+		ContentType: rec.Cover.MimeType,
+		ModTime:     rec.Cover.UploadedAt,
+		ETag:        fmt.Sprintf(`"%s-%d"`, rec.Cover.Checksum, size),
+	}, nil
+}
+
+// Waveform returns the min/max peaks of the audio of a MusicTrack, merged down to at most
+// buckets pairs when buckets is positive. Peaks missing or computed from audio that has
+// since been replaced are generated on the fly.
+func (s *MusicTrack) Waveform(ctx context.Context, authUsr *model.AuthUser, id string, buckets int) (*model.Waveform, error) {
+	if buckets < 0 {
+		return nil, server.NewHTTPValidationError("buckets must be positive")
+	}
+
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	waveform, err := s.waveformCollection.FindOne(ctx, bson.M{"track_id": rec.ID})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if waveform == nil || waveform.Checksum != audioChecksum(rec) {
+		if waveform, err = s.generateWaveform(ctx, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	return resampleWaveform(waveform, buckets), nil
+}
+
+// GenerateWaveform computes the peaks of the audio of a MusicTrack again
+func (s *MusicTrack) GenerateWaveform(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Waveform, error) {
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	waveform, err := s.generateWaveform(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return resampleWaveform(waveform, 0), nil
+}
+
+// HLSPlaylist returns the HLS media playlist of the audio of a MusicTrack. The audio is
+// split into segments on first request, the segments are cut again once it is replaced.
+func (s *MusicTrack) HLSPlaylist(ctx context.Context, authUsr *model.AuthUser, id string) (*HLSContent, error) {
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	index, err := s.hlsIndex(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := hls.WritePlaylist(buf, hlsSegments(index), hlsSegmentName); err != nil {
+		return nil, err
+	}
+
+	return &HLSContent{
+		Content:     buf.Bytes(),
+		ContentType: hls.PlaylistContentType,
+		ModTime:     index.CreatedAt,
+		ETag:        `"` + index.Checksum + `"`,
+	}, nil
+}
+
+// HLSSegment returns segment n of the HLS playlist of a MusicTrack, prefixed with the
+// ID3 timestamp HLS requires at the start of packed audio
+func (s *MusicTrack) HLSSegment(ctx context.Context, authUsr *model.AuthUser, id string, n int) (*HLSContent, error) {
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	index, err := s.hlsIndex(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= len(index.Segments) {
+		return nil, errSegmentNotFound
+	}
+	segment := index.Segments[n]
+
+	content, err := s.openAudio(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Content.Close()
+
+	tag := hls.TimestampTag(segment.Start)
+	data := make([]byte, int64(len(tag))+segment.Length)
+	copy(data, tag)
+	if _, err := content.Content.Seek(segment.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(content.Content, data[len(tag):]); err != nil {
+		return nil, err
+	}
+
+	return &HLSContent{
+		Content:     data,
+		ContentType: hls.SegmentContentType,
+		ModTime:     index.CreatedAt,
+		ETag:        fmt.Sprintf(`"%s-%d"`, index.Checksum, n),
+	}, nil
+}
+
+// Matches lists the tracks whose audio is the same recording as the audio of a MusicTrack,
+// even when encoded differently or tagged with other metadata, most similar first.
+// A positive threshold overrides the configured minimum similarity.
+func (s *MusicTrack) Matches(ctx context.Context, authUsr *model.AuthUser, id string, threshold float64) ([]*Match, error) {
+	if threshold < 0 || threshold > 1 {
+		return nil, server.NewHTTPValidationError("threshold must be between 0 and 1")
+	}
+	if threshold == 0 {
+		threshold = s.fingerprintThreshold
+	}
+
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	fingerprint, err := s.fingerprintCollection.FindOne(ctx, bson.M{"track_id": rec.ID})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if fingerprint == nil || fingerprint.Checksum != audioChecksum(rec) {
+		if fingerprint, err = s.generateFingerprint(ctx, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := []*Match{}
+	where := bson.M{
+		"track_id": bson.M{"$ne": rec.ID},
+		"duration": bson.M{"$gte": fingerprint.Duration - audio.MaxDurationDifference, "$lte": fingerprint.Duration + audio.MaxDurationDifference},
+	}
+	err = s.fingerprintCollection.Each(ctx, where, func(other *model.Fingerprint) error {
+		similarity := audio.Similarity(fingerprint.Hashes, other.Hashes)
+		if similarity < threshold {
+			return nil
+		}
+		track, err := s.musicTrackCollection.FindOneFields(ctx, bson.M{"_id": other.TrackID}, nil)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil
+			}
+			return err
+		}
+		checksum, err := s.legacyChecksum(ctx, track)
+		if err != nil {
+			return err
+		}
+		// * fingerprints of replaced audio are ignored until computed again
+		if other.Checksum != checksum {
+			return nil
+		}
+		matches = append(matches, &Match{Track: track, Similarity: similarity})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Similarity > matches[j].Similarity
+	})
+
+	return matches, nil
+}
+
+// MeasureLoudness measures the loudness of the audio of a MusicTrack again and updates
+// the album gain of its album
+func (s *MusicTrack) MeasureLoudness(ctx context.Context, authUsr *model.AuthUser, id string) (*model.MusicTrack, error) {
+	rec, err := s.find(ctx, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	return s.measureLoudness(ctx, rec)
+}
+
 // Search returns single MusicTrack
 func (s *MusicTrack) Search(ctx context.Context, authUsr *model.AuthUser, lq *httputil.ListRequest) ([]*model.MusicTrack, error) {
 	query := map[string]interface{}{}
@@ -49,7 +464,7 @@ func (s *MusicTrack) Search(ctx context.Context, authUsr *model.AuthUser, lq *ht
 	s.musicTrackES.Search(ctx)
 
 	queryStr := query["query"].(string)
-	rec, err := s.musicTrackCollection.Search(ctx, queryStr, lq.Page, lq.Limit)
+	rec, err := s.musicTrackCollection.Search(ctx, queryStr, lq.Page, lq.Limit, fields.Parse(lq.Fields))
 	if err != nil {
 		return nil, err
 	}
@@ -57,10 +472,21 @@ func (s *MusicTrack) Search(ctx context.Context, authUsr *model.AuthUser, lq *ht
 	return rec, nil
 }
 
+// Playlists returns the playlists holding a MusicTrack with the named fields only,
+// see fields.Projection
+func (s *MusicTrack) Playlists(ctx context.Context, authUsr *model.AuthUser, id string, names []string) ([]*model.Playlist, error) {
+	rec, err := s.View(ctx, authUsr, id, []string{"id"})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.playlistCollection.FindByTrack(ctx, rec.ID, names)
+}
+
 // Update updates MusicTrack information
 func (s *MusicTrack) Update(ctx context.Context, authUsr *model.AuthUser, id string, data UpdateData) (*model.MusicTrack, error) {
 	// * do validation
-	curr, err := s.View(ctx, authUsr, id)
+	curr, err := s.find(ctx, id)
 	if err != nil || curr == nil {
 		return nil, err
 	}
@@ -70,21 +496,66 @@ func (s *MusicTrack) Update(ctx context.Context, authUsr *model.AuthUser, id str
 		return nil, err
 	}
 
+	mp3File := data.MP3File
+	data.MP3File = nil
+	// * the duration of a track with audio is measured, never taken from the client
+	if curr.Audio != nil || mp3File != nil {
+		data.Duration = nil
+	}
+
+	album := curr.Album
 	rec := new(model.MusicTrack)
 	s.converter.ToModel(&curr, &data)
+	curr.MP3File = nil
 
 	rec, err = s.musicTrackCollection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, curr)
 	if err != nil {
 		return nil, err
 	}
+	if rec.Album != album && rec.Loudness != nil {
+		// * the track leaves the album gain of one album for that of another
+		if rec.Album == "" {
+			_, err = s.musicTrackCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"loudness.album_gain": nil, "loudness.album_peak": nil})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, a := range []string{album, rec.Album} {
+			if err := s.updateAlbumGain(ctx, a); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	return rec, nil
+	if mp3File != nil && len(*mp3File) > 0 {
+		return s.replaceAudio(ctx, rec, AudioUpload{
+			Size:    int64(len(*mp3File)),
+			Content: bytes.NewReader(*mp3File),
+		})
+	}
+
+	// * the updated document still holds any legacy audio bytes
+	return s.musicTrackCollection.FindOneFields(ctx, bson.M{"_id": objectID}, nil)
+}
+
+// UploadAudio stores a new audio file for a MusicTrack, replacing the previous one. A
+// signed in user only replaces the audio of tracks of their own or of no one.
+func (s *MusicTrack) UploadAudio(ctx context.Context, authUsr *model.AuthUser, id string, data AudioUpload) (*model.MusicTrack, error) {
+	curr, err := s.find(ctx, id)
+	if err != nil || curr == nil {
+		return nil, err
+	}
+	if authUsr != nil && curr.OwnerID != "" && curr.OwnerID != authUsr.ID {
+		return nil, errForbidden
+	}
+
+	return s.replaceAudio(ctx, curr, data)
 }
 
 // Delete deletes a MusicTrack
 func (s *MusicTrack) Delete(ctx context.Context, authUsr *model.AuthUser, id string) error {
 	// * do validation
-	curr, err := s.View(ctx, authUsr, id)
+	curr, err := s.find(ctx, id)
 	if err != nil || curr == nil {
 		return err
 	}
@@ -94,10 +565,706 @@ func (s *MusicTrack) Delete(ctx context.Context, authUsr *model.AuthUser, id str
 		return err
 	}
 
-	err = s.musicTrackCollection.RemoveOne(ctx, bson.M{"_id": objectID})
+	// * playlists never list a track that is gone
+	err = s.db.ExecTx(ctx, func(sessionCtx mongo.SessionContext) error {
+		if err := s.musicTrackCollection.RemoveOne(sessionCtx, bson.M{"_id": objectID}); err != nil {
+			return err
+		}
+		return s.playlistCollection.PullTrack(sessionCtx, objectID)
+	})
 	if err != nil {
 		return err
 	}
 
+	if curr.Audio != nil {
+		s.deleteAudioFile(ctx, curr.Audio)
+	}
+	if curr.Cover != nil {
+		s.deleteCover(ctx, curr.Cover)
+	}
+	if err := s.waveformCollection.RemoveOne(ctx, bson.M{"track_id": curr.ID}); err != nil {
+		fmt.Println("Error deleting waveform of track", curr.ID.Hex(), err)
+	}
+	if err := s.hlsIndexCollection.RemoveOne(ctx, bson.M{"track_id": curr.ID}); err != nil {
+		fmt.Println("Error deleting HLS index of track", curr.ID.Hex(), err)
+	}
+	if err := s.fingerprintCollection.RemoveOne(ctx, bson.M{"track_id": curr.ID}); err != nil {
+		fmt.Println("Error deleting fingerprint of track", curr.ID.Hex(), err)
+	}
+	if curr.Loudness != nil {
+		if err := s.updateAlbumGain(ctx, curr.Album); err != nil {
+			fmt.Println("Error updating album gain of", curr.Album, err)
+		}
+	}
+
 	return nil
 }
+
+// replaceAudio uploads data, points curr at it and removes the file it replaced
+func (s *MusicTrack) replaceAudio(ctx context.Context, curr *model.MusicTrack, data AudioUpload) (*model.MusicTrack, error) {
+	info, err := probeAudio(data.Content, data.Size)
+	if err != nil {
+		return nil, err
+	}
+	if data.Filename == "" {
+		data.Filename = curr.Title + "." + info.Format.Extension()
+	}
+	checksum, err := checksumAudio(data.Content, data.Size)
+	if err != nil {
+		return nil, err
+	}
+	if existing, err := s.findDuplicate(ctx, checksum, curr.ID); err != nil || existing != nil {
+		return duplicateResult(existing, "", err)
+	}
+
+	file, err := s.storeAudio(ctx, curr.ID, info, checksum, data)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := s.musicTrackCollection.SetAudio(ctx, bson.M{"_id": curr.ID}, file)
+	if err != nil {
+		s.deleteAudioFile(ctx, file)
+		if mongo.IsDuplicateKeyError(err) {
+			existing, findErr := s.findDuplicate(ctx, checksum, curr.ID)
+			if existing != nil || findErr != nil {
+				return duplicateResult(existing, "", findErr)
+			}
+		}
+		return nil, err
+	}
+
+	if curr.Audio != nil {
+		s.deleteAudioFile(ctx, curr.Audio)
+	}
+
+	updates := streamUpdates(info)
+	if info.Tags != nil {
+		for k, v := range tagUpdates(rec, info.Tags) {
+			if _, ok := updates[k]; !ok {
+				updates[k] = v
+			}
+		}
+	}
+	// * a file without artwork keeps the cover the track already has
+	cover := s.extractCover(ctx, curr.ID, info)
+	if cover != nil {
+		updates["cover"] = cover
+	}
+
+	rec, err = s.musicTrackCollection.UpdateOne(ctx, bson.M{"_id": curr.ID}, updates)
+	if err != nil {
+		if cover != nil {
+			s.deleteCover(ctx, cover)
+		}
+		return nil, err
+	}
+	if cover != nil && curr.Cover != nil {
+		s.deleteCover(ctx, curr.Cover)
+	}
+	s.analyzeAudio(rec)
+
+	return rec, nil
+}
+
+// analyzeAudio queues rec for the analysis of its audio in the background, so uploads
+// do not wait for the whole file to be decoded. When the queue is full the track is
+// skipped, its data is then computed on first request.
+func (s *MusicTrack) analyzeAudio(rec *model.MusicTrack) {
+	select {
+	case s.analysisQueue <- rec:
+	default:
+		fmt.Println("Skipping analysis of track, the queue is full", rec.ID.Hex())
+	}
+}
+
+// analysisWorker analyzes the queued tracks one after the other
+func (s *MusicTrack) analysisWorker() {
+	for rec := range s.analysisQueue {
+		s.analyze(context.Background(), rec)
+	}
+}
+
+// analyze computes the data derived from the audio of rec. The decoders run on
+// uploaded files, a panic in one step is logged and the other steps still run.
+func (s *MusicTrack) analyze(ctx context.Context, rec *model.MusicTrack) {
+	step := func(name string, fn func() error) {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Println("Panic", name, "of track", rec.ID.Hex(), r)
+			}
+		}()
+		if err := fn(); err != nil {
+			fmt.Println("Error", name, "of track", rec.ID.Hex(), err)
+		}
+	}
+
+	step("generating waveform", func() error {
+		_, err := s.generateWaveform(ctx, rec)
+		return err
+	})
+	step("generating fingerprint", func() error {
+		_, err := s.generateFingerprint(ctx, rec)
+		return err
+	})
+	step("measuring loudness", func() error {
+		_, err := s.measureLoudness(ctx, rec)
+		return err
+	})
+	if !hlsAvailable(rec) {
+		// * segments of the audio this replaced are of no use anymore
+		step("deleting HLS index", func() error {
+			return s.hlsIndexCollection.RemoveOne(ctx, bson.M{"track_id": rec.ID})
+		})
+		return
+	}
+	step("generating HLS index", func() error {
+		_, err := s.generateHLSIndex(ctx, rec)
+		return err
+	})
+}
+
+// generateWaveform decodes the audio of rec into peaks and stores them
+func (s *MusicTrack) generateWaveform(ctx context.Context, rec *model.MusicTrack) (*model.Waveform, error) {
+	content, err := s.openAudio(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Content.Close()
+
+	peaks, err := audio.ComputeWaveform(content.Content, s.waveformBuckets)
+	if err != nil {
+		if err == audio.ErrNoDecoder {
+			return nil, noDecoderError(rec, "Waveforms")
+		}
+		return nil, err
+	}
+
+	return s.waveformCollection.Upsert(ctx, &model.Waveform{
+		TrackID:         rec.ID,
+		Checksum:        audioChecksum(rec),
+		SampleRate:      peaks.SampleRate,
+		SamplesPerPixel: peaks.SamplesPerPixel,
+		Bits:            audio.WaveformBits,
+		Length:          peaks.Length(),
+		Data:            peaks.Data,
+		CreatedAt:       time.Now().UTC(),
+	})
+}
+
+// generateFingerprint decodes the start of the audio of rec into an acoustic
+// fingerprint and stores it
+func (s *MusicTrack) generateFingerprint(ctx context.Context, rec *model.MusicTrack) (*model.Fingerprint, error) {
+	content, err := s.openAudio(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Content.Close()
+
+	fingerprint, err := audio.ComputeFingerprint(content.Content)
+	if err != nil {
+		if err == audio.ErrNoDecoder {
+			return nil, noDecoderError(rec, "Fingerprints")
+		}
+		return nil, err
+	}
+
+	return s.fingerprintCollection.Upsert(ctx, &model.Fingerprint{
+		TrackID:   rec.ID,
+		Checksum:  audioChecksum(rec),
+		Duration:  fingerprint.Duration,
+		Hashes:    fingerprint.Hashes,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// measureLoudness decodes the audio of rec, stores its loudness and updates the album
+// gain of its album
+func (s *MusicTrack) measureLoudness(ctx context.Context, rec *model.MusicTrack) (*model.MusicTrack, error) {
+	content, err := s.openAudio(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Content.Close()
+
+	loudness, err := audio.MeasureLoudness(content.Content)
+	if err != nil {
+		if err == audio.ErrNoDecoder {
+			return nil, noDecoderError(rec, "Loudness measurements")
+		}
+		return nil, err
+	}
+
+	rec, err = s.musicTrackCollection.UpdateOne(ctx, bson.M{"_id": rec.ID}, bson.M{"loudness": &model.Loudness{
+		Checksum:   audioChecksum(rec),
+		Integrated: loudness.Integrated,
+		Range:      loudness.Range,
+		TruePeak:   loudness.TruePeak,
+		TrackGain:  loudness.Gain(),
+		MeasuredAt: time.Now().UTC(),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateAlbumGain(ctx, rec.Album); err != nil {
+		return nil, err
+	}
+
+	return s.musicTrackCollection.FindOneFields(ctx, bson.M{"_id": rec.ID}, nil)
+}
+
+// updateAlbumGain computes the gain of the tracks of album as a whole, from the
+// loudness measured for each of them, and stores it on all of them
+func (s *MusicTrack) updateAlbumGain(ctx context.Context, album string) error {
+	if album == "" {
+		return nil
+	}
+
+	var (
+		integrated, durations []float64
+		peak                  = math.Inf(-1)
+	)
+	where := bson.M{"album": album, "loudness": bson.M{"$exists": true}}
+	err := s.musicTrackCollection.Each(ctx, where, func(rec *model.MusicTrack) error {
+		if rec.Loudness.Checksum != audioChecksum(rec) {
+			return nil
+		}
+		duration := float64(rec.DurationMs)
+		if duration == 0 {
+			duration = math.Max(float64(rec.Duration)*1000, 1)
+		}
+		integrated = append(integrated, rec.Loudness.Integrated)
+		durations = append(durations, duration)
+		peak = math.Max(peak, rec.Loudness.TruePeak)
+		return nil
+	})
+	if err != nil || len(integrated) == 0 {
+		return err
+	}
+
+	gain := audio.ReplayGainReference - audio.AlbumLoudness(integrated, durations)
+	return s.musicTrackCollection.UpdateMany(ctx, where, bson.M{
+		"loudness.album_gain": gain,
+		"loudness.album_peak": peak,
+	})
+}
+
+// hlsIndex returns the HLS segments of the audio of rec, cutting them when they are
+// missing or were cut from audio that has since been replaced
+func (s *MusicTrack) hlsIndex(ctx context.Context, rec *model.MusicTrack) (*model.HLSIndex, error) {
+	if rec.Audio == nil && len(rec.MP3File) == 0 {
+		return nil, errAudioNotFound
+	}
+	if !hlsAvailable(rec) {
+		return nil, errHLSUnavailable
+	}
+
+	index, err := s.hlsIndexCollection.FindOne(ctx, bson.M{"track_id": rec.ID})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if index == nil || index.Checksum != audioChecksum(rec) {
+		return s.generateHLSIndex(ctx, rec)
+	}
+
+	return index, nil
+}
+
+// generateHLSIndex splits the MP3 audio of rec into segments at frame boundaries and
+// stores where they are
+func (s *MusicTrack) generateHLSIndex(ctx context.Context, rec *model.MusicTrack) (*model.HLSIndex, error) {
+	content, err := s.openAudio(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Content.Close()
+
+	size, err := content.Content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := audio.MPEGFrames(audio.NewReaderAt(content.Content), size)
+	if err != nil {
+		if err == audio.ErrNotMPEG {
+			return nil, errHLSUnavailable
+		}
+		return nil, err
+	}
+
+	segments := hls.Split(frames, hls.DefaultSegmentDuration)
+	index := &model.HLSIndex{
+		TrackID:        rec.ID,
+		Checksum:       audioChecksum(rec),
+		TargetDuration: hls.TargetDuration(segments),
+		Segments:       make([]model.HLSSegment, len(segments)),
+		CreatedAt:      time.Now().UTC(),
+	}
+	for i, seg := range segments {
+		index.Segments[i] = model.HLSSegment{Offset: seg.Offset, Length: seg.Length, Start: seg.Start, Duration: seg.Duration}
+	}
+
+	return s.hlsIndexCollection.Upsert(ctx, index)
+}
+
+// storeAudio puts an audio file of a track into storage under a fresh key, so the
+// file it replaces stays readable until the track points at the new one
+func (s *MusicTrack) storeAudio(ctx context.Context, trackID primitive.ObjectID, info *audio.Info, checksum string, data AudioUpload) (*model.AudioFile, error) {
+	// * the detected format is trusted over the type claimed by the client
+	contentType := info.MimeType
+	key := "tracks/" + trackID.Hex() + "/" + primitive.NewObjectID().Hex()
+
+	content := io.NewSectionReader(data.Content, 0, data.Size)
+	if err := s.audioStorage.Put(ctx, key, content, data.Size, contentType); err != nil {
+		return nil, err
+	}
+
+	return &model.AudioFile{
+		Key:         key,
+		Checksum:    checksum,
+		Size:        data.Size,
+		Filename:    data.Filename,
+		ContentType: contentType,
+		UploadedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// checksumAudio returns the hex encoded SHA-256 of an audio file, which identifies
+// uploads of the same file
+func checksumAudio(content io.ReaderAt, size int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(content, 0, size)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findDuplicate returns the track other than exclude whose audio has the checksum, if any
+func (s *MusicTrack) findDuplicate(ctx context.Context, checksum string, exclude primitive.ObjectID) (*model.MusicTrack, error) {
+	where := bson.M{"audio.checksum": checksum}
+	if !exclude.IsZero() {
+		where["_id"] = bson.M{"$ne": exclude}
+	}
+	rec, err := s.musicTrackCollection.FindOneFields(ctx, where, nil)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return rec, err
+}
+
+// duplicateResult answers an upload of audio that existing already has: the existing
+// track itself when the client asked for it, a conflict naming it otherwise
+func duplicateResult(existing *model.MusicTrack, onDuplicate string, err error) (*model.MusicTrack, error) {
+	if err != nil {
+		return nil, err
+	}
+	if onDuplicate == OnDuplicateReturn {
+		return existing, nil
+	}
+
+	return nil, server.NewHTTPConflictError("The same audio file was already uploaded as another music track").
+		SetData(map[string]string{"id": existing.ID.Hex()})
+}
+
+// extractCover stores the artwork embedded in an audio file. Artwork is optional, so
+// failures are only logged and leave the track without cover.
+func (s *MusicTrack) extractCover(ctx context.Context, trackID primitive.ObjectID, info *audio.Info) *model.CoverArt {
+	if info == nil || info.Picture == nil {
+		return nil
+	}
+	picture := info.Picture
+
+	width, height, err := artwork.Dimensions(bytes.NewReader(picture.Data))
+	if err != nil {
+		fmt.Println("Error reading cover art of track", trackID.Hex(), err)
+		return nil
+	}
+
+	key := "tracks/" + trackID.Hex() + "/cover/" + primitive.NewObjectID().Hex()
+	size := int64(len(picture.Data))
+	if err := s.audioStorage.Put(ctx, key, bytes.NewReader(picture.Data), size, picture.MimeType); err != nil {
+		fmt.Println("Error storing cover art of track", trackID.Hex(), err)
+		return nil
+	}
+	checksum := sha256.Sum256(picture.Data)
+
+	return &model.CoverArt{
+		Key:        key,
+		Checksum:   hex.EncodeToString(checksum[:]),
+		MimeType:   picture.MimeType,
+		Width:      width,
+		Height:     height,
+		Size:       size,
+		UploadedAt: time.Now().UTC(),
+	}
+}
+
+// generateThumbnail scales a cover down to size and caches the result in storage
+func (s *MusicTrack) generateThumbnail(ctx context.Context, cover *model.CoverArt, size int) (io.ReadSeekCloser, error) {
+	original, err := s.audioStorage.Open(ctx, cover.Key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, errCoverNotFound
+		}
+		return nil, err
+	}
+	defer original.Close()
+
+	thumbnail, contentType, err := artwork.Thumbnail(original, size)
+	if err != nil {
+		if err == artwork.ErrImageTooLarge {
+			return nil, errCoverTooLarge
+		}
+		return nil, err
+	}
+
+	// * a failed cache write is retried by the next request, this one is still served
+	key := thumbnailKey(cover, size)
+	if err := s.audioStorage.Put(ctx, key, bytes.NewReader(thumbnail), int64(len(thumbnail)), contentType); err != nil {
+		fmt.Println("Error caching cover thumbnail", key, err)
+	}
+
+	return nopCloser{bytes.NewReader(thumbnail)}, nil
+}
+
+// deleteCover removes a cover and its cached thumbnails, failures are only logged
+func (s *MusicTrack) deleteCover(ctx context.Context, cover *model.CoverArt) {
+	keys := []string{cover.Key}
+	for _, size := range coverSizes {
+		keys = append(keys, thumbnailKey(cover, size))
+	}
+	for _, key := range keys {
+		if err := s.audioStorage.Delete(ctx, key); err != nil {
+			fmt.Println("Error deleting cover art", key, err)
+		}
+	}
+}
+
+func thumbnailKey(cover *model.CoverArt, size int) string {
+	return cover.Key + "-" + strconv.Itoa(size)
+}
+
+func validCoverSize(size int) bool {
+	for _, s := range coverSizes {
+		if s == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+// audioChecksum identifies the audio data of rec, data derived from it is recomputed
+// when the checksum changes
+func audioChecksum(rec *model.MusicTrack) string {
+	if rec.Audio != nil {
+		return rec.Audio.Checksum
+	}
+
+	return fmt.Sprintf("%x", md5.Sum(rec.MP3File))
+}
+
+// legacyChecksum is audioChecksum of a track read without its legacy audio bytes,
+// which are read on their own when the track has no other audio
+func (s *MusicTrack) legacyChecksum(ctx context.Context, rec *model.MusicTrack) (string, error) {
+	if rec.Audio != nil {
+		return audioChecksum(rec), nil
+	}
+
+	legacy, err := s.musicTrackCollection.FindOneFields(ctx, bson.M{"_id": rec.ID}, []string{"mp3_file"})
+	if err != nil {
+		return "", err
+	}
+
+	return audioChecksum(legacy), nil
+}
+
+// hlsAvailable reports whether the audio of rec can be packaged for HLS, which is only
+// done for MP3. Tracks without a detected format predate other formats and are MP3.
+func hlsAvailable(rec *model.MusicTrack) bool {
+	return rec.Format == nil || audio.Format(rec.Format.Format) == audio.FormatMP3
+}
+
+// hlsSegments converts the stored segments of index
+func hlsSegments(index *model.HLSIndex) []hls.Segment {
+	segments := make([]hls.Segment, len(index.Segments))
+	for i, seg := range index.Segments {
+		segments[i] = hls.Segment{Offset: seg.Offset, Length: seg.Length, Start: seg.Start, Duration: seg.Duration}
+	}
+
+	return segments
+}
+
+// hlsSegmentName names segment i in the playlist, relative to the playlist URL
+func hlsSegmentName(i int) string {
+	return strconv.Itoa(i) + hlsSegmentExt
+}
+
+// noDecoderError tells that what is computed from decoded audio is not available for
+// the codec of rec
+func noDecoderError(rec *model.MusicTrack, what string) error {
+	codec := "this"
+	if rec.Format != nil {
+		codec = rec.Format.Codec
+	}
+
+	return server.NewHTTPError(http.StatusUnprocessableEntity, server.GenericErrorType, fmt.Sprintf("%s are not available for %s audio", what, codec))
+}
+
+// resampleWaveform merges the peaks of w down to buckets pairs and fills the fields
+// of the audiowaveform JSON format
+func resampleWaveform(w *model.Waveform, buckets int) *model.Waveform {
+	w.Version = 2
+	w.Channels = 1
+	if buckets <= 0 || buckets >= w.Length {
+		return w
+	}
+
+	peaks := (&audio.Waveform{SampleRate: w.SampleRate, SamplesPerPixel: w.SamplesPerPixel, Data: w.Data}).Resample(buckets)
+	w.SamplesPerPixel = peaks.SamplesPerPixel
+	w.Length = peaks.Length()
+	w.Data = peaks.Data
+
+	return w
+}
+
+// probeAudio detects the format of an audio file and reads its stream properties and
+// tags, rejecting files that are not audio
+func probeAudio(content io.ReaderAt, size int64) (*audio.Info, error) {
+	info, err := audio.Probe(content, size)
+	if err != nil {
+		if err == audio.ErrUnsupportedFormat || err == audio.ErrNotMPEG {
+			return nil, server.NewHTTPValidationError("The uploaded file is not a supported audio format (MP3, FLAC, Ogg Vorbis/Opus, WAV, AAC or M4A)")
+		}
+		return nil, server.NewHTTPValidationError("The uploaded audio file is corrupt").SetInternal(err)
+	}
+	if info.Duration <= 0 {
+		return nil, server.NewHTTPValidationError("The uploaded file contains no audio")
+	}
+
+	return info, nil
+}
+
+// audioFormat returns the format descriptor persisted on a track
+func audioFormat(info *audio.Info) *model.AudioFormat {
+	return &model.AudioFormat{
+		Format:     string(info.Format),
+		Codec:      info.Codec,
+		MimeType:   info.MimeType,
+		BitDepth:   info.BitDepth,
+		SampleRate: info.SampleRate,
+		Channels:   info.Channels,
+	}
+}
+
+// applyStreamInfo sets the measured properties of the audio stream on rec
+func applyStreamInfo(rec *model.MusicTrack, info *audio.Info) {
+	rec.Duration = int(info.Duration.Round(time.Second).Seconds())
+	rec.DurationMs = info.Duration.Milliseconds()
+	rec.Bitrate = info.Bitrate
+	rec.ChannelMode = info.ChannelMode
+	rec.Format = audioFormat(info)
+}
+
+// streamUpdates returns the measured properties of the audio stream as track fields
+func streamUpdates(info *audio.Info) bson.M {
+	return bson.M{
+		"duration":     int(info.Duration.Round(time.Second).Seconds()),
+		"duration_ms":  info.Duration.Milliseconds(),
+		"bitrate":      info.Bitrate,
+		"channel_mode": info.ChannelMode,
+		"format":       audioFormat(info),
+	}
+}
+
+// audioContentType returns the MIME type to serve the audio of rec with
+func audioContentType(rec *model.MusicTrack) string {
+	switch {
+	case rec.Format != nil && rec.Format.MimeType != "":
+		return rec.Format.MimeType
+	case rec.Audio != nil && rec.Audio.ContentType != "":
+		return rec.Audio.ContentType
+	}
+
+	return legacyAudioContentType
+}
+
+// downloadFilename names the audio file of rec after the track, with the extension
+// of its format
+func downloadFilename(rec *model.MusicTrack) string {
+	format := audio.FormatMP3
+	if rec.Format != nil {
+		format = audio.Format(rec.Format.Format)
+	}
+	name := rec.Title
+	if rec.Artist != "" {
+		name = rec.Artist + " - " + name
+	}
+	if strings.TrimSpace(name) == "" {
+		name = rec.ID.Hex()
+	}
+
+	return name + "." + format.Extension()
+}
+
+// applyTags fills the empty fields of data from the tags of its audio file
+func applyTags(data *CreationData, tags *audio.Tags) {
+	if data.Title == "" {
+		data.Title = tags.Title
+	}
+	if data.Artist == "" {
+		data.Artist = tags.Artist
+	}
+	if data.Album == "" {
+		data.Album = tags.Album
+	}
+	if data.Genre == "" {
+		data.Genre = tags.Genre
+	}
+	if data.ReleaseYear == 0 {
+		data.ReleaseYear = tags.Year
+	}
+	if data.TrackNumber == 0 {
+		data.TrackNumber = tags.Track
+	}
+	if data.Duration == 0 {
+		data.Duration = int(tags.Length.Round(time.Second).Seconds())
+	}
+}
+
+// tagUpdates returns the fields of rec that are empty but known from the tags of its audio file
+func tagUpdates(rec *model.MusicTrack, tags *audio.Tags) bson.M {
+	updates := bson.M{}
+	if rec.Title == "" && tags.Title != "" {
+		updates["title"] = tags.Title
+	}
+	if rec.Artist == "" && tags.Artist != "" {
+		updates["artist"] = tags.Artist
+	}
+	if rec.Album == "" && tags.Album != "" {
+		updates["album"] = tags.Album
+	}
+	if rec.Genre == "" && tags.Genre != "" {
+		updates["genre"] = tags.Genre
+	}
+	if rec.ReleaseYear == 0 && tags.Year != 0 {
+		updates["release_year"] = tags.Year
+	}
+	if rec.TrackNumber == 0 && tags.Track != 0 {
+		updates["track_number"] = tags.Track
+	}
+	if rec.Duration == 0 && tags.Length >= time.Second {
+		updates["duration"] = int(tags.Length.Round(time.Second).Seconds())
+	}
+
+	return updates
+}
+
+// deleteAudioFile removes an audio file, a failure leaves an orphaned object which is only
+// logged since the track itself is already consistent
+func (s *MusicTrack) deleteAudioFile(ctx context.Context, audio *model.AudioFile) {
+	if err := s.audioStorage.Delete(ctx, audio.Key); err != nil {
+		fmt.Println("Error deleting audio file", audio.Key, err)
+	}
+}
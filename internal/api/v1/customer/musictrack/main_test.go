@@ -0,0 +1,377 @@
+package musictrack
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"music-master/internal/model"
+	"music-master/internal/util/converter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeTracks keeps tracks in memory. Like the projection of FindOneFields, it leaves
+// the legacy audio bytes out unless they are named.
+type fakeTracks struct {
+	MusicTrackCollection
+	mu     sync.Mutex
+	tracks map[primitive.ObjectID]*model.MusicTrack
+}
+
+// match supports the filters the service reads tracks by
+func (f *fakeTracks) match(where bson.M) *model.MusicTrack {
+	for _, rec := range f.tracks {
+		switch id := where["_id"].(type) {
+		case primitive.ObjectID:
+			if rec.ID != id {
+				continue
+			}
+		case bson.M:
+			if rec.ID == id["$ne"] {
+				continue
+			}
+		}
+		if checksum, ok := where["audio.checksum"]; ok && (rec.Audio == nil || rec.Audio.Checksum != checksum) {
+			continue
+		}
+		copied := *rec
+		return &copied
+	}
+
+	return nil
+}
+
+func (f *fakeTracks) InsertOne(ctx context.Context, data *model.MusicTrack) (*model.MusicTrack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *data
+	f.tracks[data.ID] = &copied
+
+	return data, nil
+}
+
+func (f *fakeTracks) FindOne(ctx context.Context, where bson.M) (*model.MusicTrack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rec := f.match(where); rec != nil {
+		return rec, nil
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (f *fakeTracks) FindOneFields(ctx context.Context, where bson.M, names []string) (*model.MusicTrack, error) {
+	rec, err := f.FindOne(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name == "mp3_file" {
+			return rec, nil
+		}
+	}
+	rec.MP3File = nil
+
+	return rec, nil
+}
+
+// set applies a $set of top level fields to a track, the way Mongo does
+func (f *fakeTracks) set(where bson.M, update interface{}) (*model.MusicTrack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec := f.match(where)
+	if rec == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	doc := bson.M{}
+	for _, v := range []interface{}{rec, update} {
+		b, err := bson.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+	}
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	updated := &model.MusicTrack{}
+	if err := bson.Unmarshal(b, updated); err != nil {
+		return nil, err
+	}
+	f.tracks[rec.ID] = updated
+	copied := *updated
+
+	return &copied, nil
+}
+
+func (f *fakeTracks) UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.MusicTrack, error) {
+	return f.set(where, updateData)
+}
+
+func (f *fakeTracks) FindOneAndUpdate(ctx context.Context, where bson.M, data *model.MusicTrack) (*model.MusicTrack, error) {
+	return f.set(where, data)
+}
+
+func (f *fakeTracks) SetAudio(ctx context.Context, where bson.M, audio *model.AudioFile) (*model.MusicTrack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec := f.match(where)
+	if rec == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	rec.Audio, rec.MP3File, rec.Loudness = audio, nil, nil
+	f.tracks[rec.ID] = rec
+	copied := *rec
+
+	return &copied, nil
+}
+
+func (f *fakeTracks) UpdateMany(ctx context.Context, where bson.M, updateData bson.M) error {
+	return nil
+}
+
+// fakeFingerprints returns the fingerprints of all tracks, whatever the filter
+type fakeFingerprints struct {
+	FingerprintCollection
+	fingerprints []*model.Fingerprint
+}
+
+func (f *fakeFingerprints) FindOne(ctx context.Context, where bson.M) (*model.Fingerprint, error) {
+	for _, fp := range f.fingerprints {
+		if fp.TrackID == where["track_id"] {
+			return fp, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (f *fakeFingerprints) Each(ctx context.Context, where bson.M, fn func(rec *model.Fingerprint) error) error {
+	for _, fp := range f.fingerprints {
+		if fp.TrackID == where["track_id"].(bson.M)["$ne"] {
+			continue
+		}
+		if err := fn(fp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fakeStorage keeps files in memory
+type fakeStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *fakeStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[key] = data
+
+	return nil
+}
+
+func (f *fakeStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return nopCloser{bytes.NewReader(f.files[key])}, nil
+}
+
+func (f *fakeStorage) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, key)
+
+	return nil
+}
+
+type acceptAll struct{}
+
+func (acceptAll) Validate(i interface{}) error {
+	return nil
+}
+
+// toneWAV returns a second of a mono sine tone of freq Hz as a WAV file
+func toneWAV(freq float64) []byte {
+	const rate = 44100
+	data := make([]byte, rate*2)
+	for i := 0; i < rate; i++ {
+		s := int16(0.5 * math.MaxInt16 * math.Sin(2*math.Pi*freq*float64(i)/rate))
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(data)))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+
+	return b.Bytes()
+}
+
+// checkNoAudio fails when the JSON of a response carries legacy audio bytes
+func checkNoAudio(t *testing.T, what string, resp interface{}) {
+	t.Helper()
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("%s: marshaling the response: %v", what, err)
+	}
+	if strings.Contains(string(b), "mp3_file") {
+		t.Errorf("%s response has mp3_file: %.200s", what, b)
+	}
+}
+
+func TestResponsesLeaveOutLegacyAudio(t *testing.T) {
+	ctx := context.Background()
+	legacyAudio, storedAudio := toneWAV(440), toneWAV(660)
+	storedChecksum, err := checksumAudio(bytes.NewReader(storedAudio), int64(len(storedAudio)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// * a track still holding its audio inline, and one whose migration left the bytes behind
+	legacy := &model.MusicTrack{ID: primitive.NewObjectID(), Title: "Legacy", MP3File: legacyAudio}
+	stored := &model.MusicTrack{
+		ID:      primitive.NewObjectID(),
+		Title:   "Stored",
+		Audio:   &model.AudioFile{Key: "tracks/stored", Checksum: storedChecksum, Size: int64(len(storedAudio))},
+		MP3File: storedAudio,
+	}
+	hashes := make([]uint32, 300)
+	for i := range hashes {
+		hashes[i] = uint32(i) * 2654435761
+	}
+
+	newService := func() *MusicTrack {
+		return &MusicTrack{
+			musicTrackCollection: &fakeTracks{tracks: map[primitive.ObjectID]*model.MusicTrack{legacy.ID: legacy, stored.ID: stored}},
+			fingerprintCollection: &fakeFingerprints{fingerprints: []*model.Fingerprint{
+				{TrackID: stored.ID, Checksum: storedChecksum, Hashes: hashes},
+				{TrackID: legacy.ID, Checksum: fmt.Sprintf("%x", md5.Sum(legacyAudio)), Hashes: hashes},
+			}},
+			audioStorage:         &fakeStorage{files: map[string][]byte{"tracks/stored": storedAudio}},
+			converter:            converter.NewModelConverter(),
+			validator:            acceptAll{},
+			fingerprintThreshold: 0.75,
+			analysisQueue:        make(chan *model.MusicTrack, 10),
+		}
+	}
+
+	t.Run("matches", func(t *testing.T) {
+		matches, err := newService().Matches(ctx, nil, stored.ID.Hex(), 0)
+		if err != nil {
+			t.Fatalf("Matches() error = %v", err)
+		}
+		if len(matches) != 1 || matches[0].Track.ID != legacy.ID {
+			t.Fatalf("Matches() = %+v, want the legacy track", matches)
+		}
+		checkNoAudio(t, "Matches", matches)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		title := "Renamed"
+		rec, err := newService().Update(ctx, nil, legacy.ID.Hex(), UpdateData{Title: &title})
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if rec.Title != title {
+			t.Errorf("Update() title = %q, want %q", rec.Title, title)
+		}
+		checkNoAudio(t, "Update", rec)
+	})
+
+	t.Run("measure loudness", func(t *testing.T) {
+		rec, err := newService().MeasureLoudness(ctx, nil, legacy.ID.Hex())
+		if err != nil {
+			t.Fatalf("MeasureLoudness() error = %v", err)
+		}
+		if rec.Loudness == nil {
+			t.Error("MeasureLoudness() stored no loudness")
+		}
+		checkNoAudio(t, "MeasureLoudness", rec)
+	})
+
+	t.Run("upload audio", func(t *testing.T) {
+		file := toneWAV(880)
+		rec, err := newService().UploadAudio(ctx, nil, legacy.ID.Hex(), AudioUpload{Size: int64(len(file)), Content: bytes.NewReader(file)})
+		if err != nil {
+			t.Fatalf("UploadAudio() error = %v", err)
+		}
+		if rec.Audio == nil {
+			t.Error("UploadAudio() stored no audio")
+		}
+		checkNoAudio(t, "UploadAudio", rec)
+	})
+
+	t.Run("duplicate returned", func(t *testing.T) {
+		data := CreationData{Title: "Again", Artist: "A", Album: "B", MP3File: storedAudio, OnDuplicate: OnDuplicateReturn}
+		rec, err := newService().Create(ctx, nil, data)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if rec.ID != stored.ID {
+			t.Errorf("Create() = track %s, want the existing track %s", rec.ID.Hex(), stored.ID.Hex())
+		}
+		checkNoAudio(t, "Create", rec)
+	})
+}
+
+func TestUploadAudioOwner(t *testing.T) {
+	owned := &model.MusicTrack{ID: primitive.NewObjectID(), OwnerID: "owner", MP3File: toneWAV(440)}
+	ownerless := &model.MusicTrack{ID: primitive.NewObjectID(), MP3File: toneWAV(440)}
+
+	tests := []struct {
+		name    string
+		rec     *model.MusicTrack
+		user    *model.AuthUser
+		allowed bool
+	}{
+		{"owner", owned, &model.AuthUser{ID: "owner"}, true},
+		{"another user", owned, &model.AuthUser{ID: "someone"}, false},
+		// * nobody owns a track from before owners
+		{"ownerless", ownerless, &model.AuthUser{ID: "someone"}, true},
+	}
+	for _, tt := range tests {
+		copied := *tt.rec
+		s := &MusicTrack{
+			musicTrackCollection: &fakeTracks{tracks: map[primitive.ObjectID]*model.MusicTrack{copied.ID: &copied}},
+			audioStorage:         &fakeStorage{files: map[string][]byte{}},
+			analysisQueue:        make(chan *model.MusicTrack, 10),
+		}
+		file := toneWAV(880)
+		_, err := s.UploadAudio(context.Background(), tt.user, tt.rec.ID.Hex(), AudioUpload{Size: int64(len(file)), Content: bytes.NewReader(file)})
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("%s: UploadAudio() error = %v, want allowed %v", tt.name, err, tt.allowed)
+		}
+		if !tt.allowed && err != errForbidden {
+			t.Errorf("%s: UploadAudio() error = %v, want errForbidden", tt.name, err)
+		}
+	}
+}
@@ -2,36 +2,133 @@ package musictrack
 
 import (
 	"context"
+	"io"
 	"music-master/internal/model"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // New creates new musictrack application service
-func New(musicTrackCollection MusicTrackCollection,
-	converter ModelConverter,
-	musicTrackES MusicTrackES) *MusicTrack {
-	return &MusicTrack{
-		musicTrackCollection: musicTrackCollection,
-		converter:            converter,
-		musicTrackES:         musicTrackES,
+func New(deps Deps, cfg Config) *MusicTrack {
+	s := &MusicTrack{
+		db:                    deps.DB,
+		musicTrackCollection:  deps.MusicTrackCollection,
+		waveformCollection:    deps.WaveformCollection,
+		hlsIndexCollection:    deps.HLSIndexCollection,
+		fingerprintCollection: deps.FingerprintCollection,
+		playlistCollection:    deps.PlaylistCollection,
+		audioStorage:          deps.AudioStorage,
+		converter:             deps.Converter,
+		validator:             deps.Validator,
+		musicTrackES:          deps.MusicTrackES,
+		waveformBuckets:       cfg.WaveformBuckets,
+		fingerprintThreshold:  cfg.FingerprintThreshold,
+		analysisQueue:         make(chan *model.MusicTrack, analysisQueueSize),
 	}
+	for i := 0; i < analysisWorkers; i++ {
+		go s.analysisWorker()
+	}
+
+	return s
+}
+
+// Deps holds the collections and services the musictrack service is built on
+type Deps struct {
+	DB                    Database
+	MusicTrackCollection  MusicTrackCollection
+	WaveformCollection    WaveformCollection
+	HLSIndexCollection    HLSIndexCollection
+	FingerprintCollection FingerprintCollection
+	PlaylistCollection    PlaylistCollection
+	AudioStorage          AudioStorage
+	Converter             ModelConverter
+	Validator             Validator
+	MusicTrackES          MusicTrackES
 }
 
+// Config holds the settings of the musictrack service
+type Config struct {
+	// WaveformBuckets is the number of peaks waveforms are computed with
+	WaveformBuckets int
+	// FingerprintThreshold is the minimum similarity of two files of the same recording
+	FingerprintThreshold float64
+}
+
+// Uploaded audio is analyzed by analysisWorkers goroutines, at most analysisQueueSize
+// tracks wait for them
+const (
+	analysisWorkers   = 2
+	analysisQueueSize = 100
+)
+
 // MusicTrack represents musictrack application service
 type MusicTrack struct {
-	musicTrackCollection MusicTrackCollection
-	converter            ModelConverter
-	musicTrackES         MusicTrackES
+	db                    Database
+	musicTrackCollection  MusicTrackCollection
+	waveformCollection    WaveformCollection
+	hlsIndexCollection    HLSIndexCollection
+	fingerprintCollection FingerprintCollection
+	playlistCollection    PlaylistCollection
+	audioStorage          AudioStorage
+	converter             ModelConverter
+	validator             Validator
+	musicTrackES          MusicTrackES
+	waveformBuckets       int
+	fingerprintThreshold  float64
+	analysisQueue         chan *model.MusicTrack
+}
+
+type Database interface {
+	ExecTx(ctx context.Context, fn func(sessionCtx mongo.SessionContext) error) error
 }
 
 type MusicTrackCollection interface {
 	InsertOne(ctx context.Context, data *model.MusicTrack) (*model.MusicTrack, error)
 	FindOne(ctx context.Context, where bson.M) (*model.MusicTrack, error)
+	FindOneFields(ctx context.Context, where bson.M, names []string) (*model.MusicTrack, error)
 	UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.MusicTrack, error)
 	FindOneAndUpdate(ctx context.Context, where bson.M, data *model.MusicTrack) (*model.MusicTrack, error)
+	SetAudio(ctx context.Context, where bson.M, audio *model.AudioFile) (*model.MusicTrack, error)
+	UpdateMany(ctx context.Context, where bson.M, updateData bson.M) error
+	Each(ctx context.Context, where bson.M, fn func(rec *model.MusicTrack) error) error
+	RemoveOne(ctx context.Context, where bson.M) error
+	Search(ctx context.Context, searchQuery string, page, pageSize int, names []string) ([]*model.MusicTrack, error)
+}
+
+type WaveformCollection interface {
+	FindOne(ctx context.Context, where bson.M) (*model.Waveform, error)
+	Upsert(ctx context.Context, data *model.Waveform) (*model.Waveform, error)
 	RemoveOne(ctx context.Context, where bson.M) error
-	Search(ctx context.Context, searchQuery string, page, pageSize int) ([]*model.MusicTrack, error)
+}
+
+type HLSIndexCollection interface {
+	FindOne(ctx context.Context, where bson.M) (*model.HLSIndex, error)
+	Upsert(ctx context.Context, data *model.HLSIndex) (*model.HLSIndex, error)
+	RemoveOne(ctx context.Context, where bson.M) error
+}
+
+type FingerprintCollection interface {
+	FindOne(ctx context.Context, where bson.M) (*model.Fingerprint, error)
+	Upsert(ctx context.Context, data *model.Fingerprint) (*model.Fingerprint, error)
+	RemoveOne(ctx context.Context, where bson.M) error
+	Each(ctx context.Context, where bson.M, fn func(rec *model.Fingerprint) error) error
+}
+
+type PlaylistCollection interface {
+	PullTrack(ctx context.Context, trackID primitive.ObjectID) error
+	FindByTrack(ctx context.Context, trackID primitive.ObjectID, names []string) ([]*model.Playlist, error)
+}
+
+type AudioStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Validator interface {
+	Validate(i interface{}) error
 }
 
 type MusicTrackES interface {
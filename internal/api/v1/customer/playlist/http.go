@@ -2,34 +2,51 @@ package playlist
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"music-master/internal/model"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"music-master/internal/util/fields"
 	httputil "music-master/internal/util/http"
+	"music-master/internal/util/playlistio"
+	"music-master/internal/util/server"
 
 	"github.com/labstack/echo/v4"
 )
 
 // HTTP represents playlist http service
 type HTTP struct {
-	svc Service
+	svc  Service
+	auth model.Auth
 }
 
 // Service represents playlist application interface
 type Service interface {
 	Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.Playlist, error)
-	View(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Playlist, error)
+	View(ctx context.Context, authUsr *model.AuthUser, id string, req ViewRequest) (*model.Playlist, error)
 	// List(ctx context.Context, authUsr *model.AuthUser, lq *dbutil.ListQueryCondition, count *int64) (*ListLateFeeResp, error)
 	Update(ctx context.Context, authUsr *model.AuthUser, id string, data UpdateData) (*model.Playlist, error)
 	Delete(ctx context.Context, authUsr *model.AuthUser, id string) error
 	DeleteMusicTrack(ctx context.Context, authUsr *model.AuthUser, id string, data DeleteMusicTrack) error
-	Search(ctx context.Context, authUsr *model.AuthUser, lq *httputil.ListRequest) ([]*model.Playlist, error)
+	AddTracks(ctx context.Context, authUsr *model.AuthUser, id string, data AddTracksData) (*model.Playlist, error)
+	MoveTracks(ctx context.Context, authUsr *model.AuthUser, id string, data MoveTracksData) (*model.Playlist, error)
+	Search(ctx context.Context, authUsr *model.AuthUser, lq *httputil.ListRequest, scope string) ([]*model.Playlist, error)
+	Export(ctx context.Context, authUsr *model.AuthUser, id, format, baseURL string) (*ExportFile, error)
+	Import(ctx context.Context, authUsr *model.AuthUser, data ImportData) (*ImportResp, error)
+	Stats(ctx context.Context, authUsr *model.AuthUser, id string) (*model.PlaylistStats, error)
+	SetCollaborator(ctx context.Context, authUsr *model.AuthUser, id string, data CollaboratorData) (*model.Playlist, error)
+	RemoveCollaborator(ctx context.Context, authUsr *model.AuthUser, id, userID string) error
+	Shuffle(ctx context.Context, authUsr *model.AuthUser, id string, data ShuffleData, persist bool) (*ShuffleResp, error)
+	Revisions(ctx context.Context, authUsr *model.AuthUser, id string, req RevisionListRequest) (*RevisionListResp, error)
+	RevisionDiff(ctx context.Context, authUsr *model.AuthUser, id, rev, from string) (*RevisionDiff, error)
+	Revert(ctx context.Context, authUsr *model.AuthUser, id, rev string, data RevertData) (*model.Playlist, error)
 }
 
 // NewHTTP creates new playlist http service
 func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
-	h := HTTP{svc}
+	h := HTTP{svc, auth}
 
 	// swagger:operation POST /v1/customer/playlists customer-playlists customerPlaylistCreate
 	// ---
@@ -59,12 +76,29 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	// swagger:operation GET /v1/customer/playlists/{id} customer-playlists customerPlaylistView
 	// ---
 	// summary: Returns a single playlist
+	// description: Every entry carries its music track, missing once the track is deleted, and the gain in dB that normalizes its volume when its loudness is measured. The tracks of a smart playlist are those matching its rules, returned a page at a time with their total in track_count. Accept application/xspf+xml or application/jspf+json returns the whole playlist as XSPF or JSPF, as the export does.
+	// produces:
+	// - application/json
+	// - application/xspf+xml
+	// - application/jspf+json
 	// parameters:
 	// - name: id
 	//   in: path
 	//   description: id of playlist
 	//   type: string
 	//   required: true
+	// - name: fields
+	//   in: query
+	//   description: comma separated fields to return, dotted for nested ones, e.g. name,tracks.track.title. The audio bytes of legacy tracks are only returned when tracks.track.mp3_file is listed.
+	//   type: string
+	// - name: l
+	//   in: query
+	//   description: number of tracks per page of a smart playlist, 25 by default and 300 at most
+	//   type: integer
+	// - name: p
+	//   in: query
+	//   description: page of the tracks of a smart playlist, from 1
+	//   type: integer
 	// responses:
 	//   "200":
 	//     description: The playlist
@@ -85,6 +119,16 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	// swagger:operation GET /v1/customer/playlists customer-playlists customerPlaylistSearch
 	// ---
 	// summary: Returns a list playlist
+	// description: Every playlist comes with its track_count and total_duration in seconds.
+	// parameters:
+	// - name: scope
+	//   in: query
+	//   description: owned for the playlists of the user, shared for those shared with them, all of them by default
+	//   type: string
+	// - name: fields
+	//   in: query
+	//   description: comma separated fields to return, dotted for nested ones, e.g. name,tracks.track.title. The audio bytes of legacy tracks are only returned when tracks.track.mp3_file is listed.
+	//   type: string
 	// responses:
 	//   "200":
 	//     description: The playlists
@@ -105,6 +149,7 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	// swagger:operation PATCH /v1/customer/playlists/{id} customer-playlists customerPlaylistUpdate
 	// ---
 	// summary: Update playlist's information
+	// description: Renaming takes the owner of the playlist, changing its tracks or rules an editor.
 	// parameters:
 	// - name: id
 	//   in: path
@@ -130,6 +175,8 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//     "$ref": "#/responses/errDetails"
 	//   "404":
 	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
 	//   "500":
 	//     "$ref": "#/responses/errDetails"
 	eg.PATCH("/:id", h.update)
@@ -137,6 +184,7 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	// swagger:operation DELETE /v1/customer/playlists/{id} customer-playlists customerPlaylistDelete
 	// ---
 	// summary: Deletes a playlist
+	// description: Only the owner of the playlist deletes it.
 	// parameters:
 	// - name: id
 	//   in: path
@@ -184,9 +232,388 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 	//     "$ref": "#/responses/errDetails"
 	//   "404":
 	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
 	//   "500":
 	//     "$ref": "#/responses/errDetails"
 	eg.DELETE("/music-tracks/:id", h.deleteMusicTrack)
+
+	// swagger:operation POST /v1/customer/playlists/{id}/tracks customer-playlists customerPlaylistTracksAdd
+	// ---
+	// summary: Adds music tracks to a playlist
+	// description: Inserts the tracks before position, or appends them without one. Fails with 409 when snapshot_id is given and the playlist has changed since.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   required: true
+	//   schema:
+	//     "$ref": "#/definitions/CustomerPlaylistAddTracksData"
+	// responses:
+	//   "200":
+	//     description: The updated playlist
+	//     schema:
+	//       "$ref": "#/definitions/Playlist"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/tracks", h.addTracks)
+
+	// swagger:operation POST /v1/customer/playlists/{id}/tracks/move customer-playlists customerPlaylistTracksMove
+	// ---
+	// summary: Moves a range of music tracks inside a playlist
+	// description: Moves range_length tracks from range_start to before the track at insert_before, positions are those before the move. Fails with 409 when snapshot_id is given and the playlist has changed since.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   required: true
+	//   schema:
+	//     "$ref": "#/definitions/CustomerPlaylistMoveTracksData"
+	// responses:
+	//   "200":
+	//     description: The updated playlist
+	//     schema:
+	//       "$ref": "#/definitions/Playlist"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/tracks/move", h.moveTracks)
+
+	// swagger:operation POST /v1/customer/playlists/{id}/shuffle customer-playlists customerPlaylistShuffle
+	// ---
+	// summary: Shuffles a playlist
+	// description: Orders the tracks at random, spreading those of the same artist, and of the same album of an artist, evenly over the playlist. The same seed gives the same order of the same tracks, the seed used is returned. Play counts bias the order towards the tracks played most, or least. Smart playlists shuffle the tracks their rules select. The order is only saved with persist=true, which takes an editor and fails with 409 when snapshot_id is given and the playlist has changed since.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: persist
+	//   in: query
+	//   description: true saves the order as the order of the playlist
+	//   type: boolean
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   schema:
+	//     "$ref": "#/definitions/CustomerPlaylistShuffleData"
+	// responses:
+	//   "200":
+	//     description: The shuffled tracks and the seed
+	//     schema:
+	//       "$ref": "#/definitions/CustomerPlaylistShuffleResp"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/shuffle", h.shuffle)
+
+	// swagger:operation GET /v1/customer/playlists/{id}/export customer-playlists customerPlaylistExport
+	// ---
+	// summary: Exports a playlist for other players
+	// description: Extended M3U or PLS listing the stream URL of every track, described by its artist, title and duration in seconds, or XSPF or JSPF which also carry the album, the duration in milliseconds and the URL of the track as its identifier. Deleted tracks are left out.
+	// produces:
+	// - audio/x-mpegurl
+	// - audio/x-scpls
+	// - application/xspf+xml
+	// - application/jspf+json
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: format
+	//   in: query
+	//   description: m3u8, the default, pls, xspf or jspf
+	//   type: string
+	// responses:
+	//   "200":
+	//     description: The playlist file
+	//     schema:
+	//       type: file
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/export", h.export)
+
+	// swagger:operation GET /v1/customer/playlists/{id}/stats customer-playlists customerPlaylistStats
+	// ---
+	// summary: Returns statistics of the tracks of a playlist
+	// description: Total duration, track count, distributions of genres and decades, top artists and average release year, of the tracks selected by the rules for a smart playlist. Deleted tracks are left out.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: The statistics
+	//     schema:
+	//       "$ref": "#/definitions/PlaylistStats"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/stats", h.stats)
+
+	// swagger:operation POST /v1/customer/playlists/import customer-playlists customerPlaylistImport
+	// ---
+	// summary: Creates a playlist from an M3U, PLS, XSPF or JSPF file
+	// description: Matches every entry to a music track by its identifier, stream URL or file name, or else by its title and artist, the duration telling apart tracks of the same name. Entries matching no track are left out and reported with their position. Exported playlists import as the same tracks in the same order.
+	// consumes:
+	// - multipart/form-data
+	// parameters:
+	// - name: file
+	//   in: formData
+	//   description: M3U, M3U8, PLS, XSPF or JSPF file, 1 MB at most
+	//   type: file
+	//   required: true
+	// - name: name
+	//   in: formData
+	//   description: name of the playlist, by default the one in the file or the file name
+	//   type: string
+	// responses:
+	//   "200":
+	//     description: The new playlist and the entries that matched no track
+	//     schema:
+	//       "$ref": "#/definitions/CustomerPlaylistImportResp"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "413":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/import", h.importFile)
+
+	// swagger:operation POST /v1/customer/playlists/{id}/collaborators customer-playlists customerPlaylistCollaboratorSet
+	// ---
+	// summary: Shares a playlist with a user
+	// description: Viewers read the playlist, editors also change its tracks. Sharing the playlist with a collaborator again changes their role. Only the owner of the playlist shares it.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   required: true
+	//   schema:
+	//     "$ref": "#/definitions/CustomerPlaylistCollaboratorData"
+	// responses:
+	//   "200":
+	//     description: The shared playlist
+	//     schema:
+	//       "$ref": "#/definitions/Playlist"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/collaborators", h.setCollaborator)
+
+	// swagger:operation DELETE /v1/customer/playlists/{id}/collaborators/{user_id} customer-playlists customerPlaylistCollaboratorRemove
+	// ---
+	// summary: Stops sharing a playlist with a user
+	// description: The owner of the playlist removes any collaborator, collaborators remove themselves.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: user_id
+	//   in: path
+	//   description: id of the collaborator
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/ok"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.DELETE("/:id/collaborators/:user_id", h.removeCollaborator)
+
+	// swagger:operation GET /v1/customer/playlists/{id}/revisions customer-playlists customerPlaylistRevisions
+	// ---
+	// summary: Returns the revisions of a playlist
+	// description: Every change of the playlist makes a revision, named by the snapshot_id the change gave the playlist. Revisions are listed latest first, without their tracks.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: l
+	//   in: query
+	//   description: number of revisions per page, 25 by default and 100 at most
+	//   type: integer
+	// - name: p
+	//   in: query
+	//   description: page of the revisions, from 1
+	//   type: integer
+	// responses:
+	//   "200":
+	//     description: The revisions
+	//     schema:
+	//       "$ref": "#/definitions/CustomerPlaylistRevisionListResp"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/revisions", h.revisions)
+
+	// swagger:operation GET /v1/customer/playlists/{id}/revisions/{rev}/diff customer-playlists customerPlaylistRevisionDiff
+	// ---
+	// summary: Returns the tracks a revision of a playlist added, removed and moved
+	// description: Compares the tracks of the revision with those of the revision before it, or of the revision from. Positions are indexes into the tracks, from 0. Of the tracks in both revisions, the most that stay in the same order are in place and the others moved.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: rev
+	//   in: path
+	//   description: snapshot_id of the revision
+	//   type: string
+	//   required: true
+	// - name: from
+	//   in: query
+	//   description: snapshot_id of the revision to compare with, the one before rev by default
+	//   type: string
+	// responses:
+	//   "200":
+	//     description: The changes of the tracks
+	//     schema:
+	//       "$ref": "#/definitions/CustomerPlaylistRevisionDiff"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.GET("/:id/revisions/:rev/diff", h.revisionDiff)
+
+	// swagger:operation POST /v1/customer/playlists/{id}/revisions/{rev}/revert customer-playlists customerPlaylistRevert
+	// ---
+	// summary: Restores a playlist to a revision
+	// description: Restores the name, tracks and rules of the revision, which makes a new revision. Tracks deleted since are left out. Reverting to another name takes the owner of the playlist, otherwise an editor. Fails with 409 when snapshot_id is given and the playlist has changed since.
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of playlist
+	//   type: string
+	//   required: true
+	// - name: rev
+	//   in: path
+	//   description: snapshot_id of the revision
+	//   type: string
+	//   required: true
+	// - name: request
+	//   in: body
+	//   description: Request body
+	//   schema:
+	//     "$ref": "#/definitions/CustomerPlaylistRevertData"
+	// responses:
+	//   "200":
+	//     description: The restored playlist
+	//     schema:
+	//       "$ref": "#/definitions/Playlist"
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("/:id/revisions/:rev/revert", h.revert)
 }
 
 // CreationData contains playlist data from json request
@@ -194,21 +621,199 @@ func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
 type CreationData struct {
 	// example: My playlist
 	Name string `json:"name"`
-	// example: [{"id": "661ffc6c12e6a410902997b0", "title": "Em của ngày hôm qua","artist": "Sơn Tùng MTP",  "album": "Em của ngày hôm qua", "genre": "Ballad", "release_year": 2017, "duration": 189, "mp3_file": "AA=="}]
-	Tracks []*model.MusicTrack `json:"tracks"`
+	// Ids of the music tracks of the playlist, in order
+	// example: ["661ffc6c12e6a410902997b0"]
+	TrackIDs []string `json:"track_ids"`
+	// Rules selecting the tracks of a smart playlist, which takes no track_ids
+	Rules *model.PlaylistRules `json:"rules"`
 }
 
 // UpdateData contains playlist data from json request
 // swagger:model CustomerPlaylistUpdateData
 type UpdateData struct {
-	Name   *string              `json:"name"`
-	Tracks *[]*model.MusicTrack `json:"tracks"`
+	Name *string `json:"name"`
+	// Ids of the music tracks of the playlist, in order. Tracks already in the playlist
+	// keep when and by whom they were added.
+	TrackIDs *[]string `json:"track_ids"`
+	// Rules selecting the tracks of a smart playlist. A playlist with tracks only becomes
+	// smart when track_ids empties it in the same update.
+	Rules *model.PlaylistRules `json:"rules"`
+	// Snapshot of the playlist the changes are made against, the update fails with 409
+	// once the playlist has changed
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// ViewRequest holds the query of a playlist view request
+type ViewRequest struct {
+	// Comma separated JSON fields to return, dotted for nested ones
+	Fields string `query:"fields"`
+	// Number of tracks per page of a smart playlist
+	Limit int `query:"l" validate:"min=0,max=300"`
+	// Page of the tracks of a smart playlist, from 1
+	Page int `query:"p"`
 }
 
 // DeleteMusicTrack contains playlist data from json request
 // swagger:model CustomerPlaylistDeleteMusicTrack
 type DeleteMusicTrack struct {
 	MusicTrackID string `json:"music_track_id" validate:"required"`
+	// Snapshot of the playlist the track is removed from, optional
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// AddTracksData contains the music tracks to add to a playlist from json request
+// swagger:model CustomerPlaylistAddTracksData
+type AddTracksData struct {
+	// example: ["661ffc6c12e6a410902997b0"]
+	TrackIDs []string `json:"track_ids"`
+	// Index the tracks are inserted at, they are appended without one
+	// example: 0
+	Position *int `json:"position"`
+	// Snapshot of the playlist the tracks are added to, optional
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// MoveTracksData contains the range of music tracks to move inside a playlist from json request
+// swagger:model CustomerPlaylistMoveTracksData
+type MoveTracksData struct {
+	// Index of the first track to move
+	// example: 0
+	RangeStart int `json:"range_start"`
+	// Index of the track the range is moved before, the length of the playlist moves it to the end
+	// example: 3
+	InsertBefore int `json:"insert_before"`
+	// Number of tracks to move, 1 by default
+	// example: 1
+	RangeLength *int `json:"range_length"`
+	// Snapshot of the playlist the tracks are moved in, optional
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// CollaboratorData contains the user a playlist is shared with from json request
+// swagger:model CustomerPlaylistCollaboratorData
+type CollaboratorData struct {
+	// example: 6620db0b3e1ac4c9d158ae40
+	UserID string `json:"user_id" validate:"required"`
+	// viewer or editor
+	// example: editor
+	Role string `json:"role" validate:"required,oneof=viewer editor"`
+}
+
+// ShuffleData contains playlist shuffle options from json request
+// swagger:model CustomerPlaylistShuffleData
+type ShuffleData struct {
+	// Seed of a shuffle to reproduce, a new one without
+	// example: 4242
+	Seed *int64 `json:"seed" validate:"omitempty,min=0"`
+	// Number of plays of tracks by the user, by track id
+	// example: {"661ffc6c12e6a410902997b0": 12}
+	PlayCounts map[string]int `json:"play_counts"`
+	// played, the default, brings the tracks played most towards the start, unplayed those played least
+	// example: unplayed
+	Favor string `json:"favor" validate:"omitempty,oneof=played unplayed"`
+	// Snapshot of the playlist a persisted shuffle is made against, optional
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// ShuffleResp contains a shuffled order of a playlist
+// swagger:model CustomerPlaylistShuffleResp
+type ShuffleResp struct {
+	// Seed that reproduces the order
+	// example: 4242
+	Seed int64 `json:"seed"`
+	// Snapshot of the playlist the order was made from, or the one it made when persisted
+	SnapshotID string `json:"snapshot_id"`
+	// Whether the order was saved as the order of the playlist
+	Persisted bool                   `json:"persisted"`
+	Tracks    []*model.PlaylistTrack `json:"tracks"`
+}
+
+// RevisionListRequest holds the query of a playlist revisions request
+type RevisionListRequest struct {
+	// Number of revisions per page
+	Limit int `query:"l" validate:"min=0,max=100"`
+	// Page of the revisions, from 1
+	Page int `query:"p"`
+}
+
+// RevisionListResp contains a page of the revisions of a playlist
+// swagger:model CustomerPlaylistRevisionListResp
+type RevisionListResp struct {
+	Data       []*model.PlaylistRevision `json:"data"`
+	TotalCount int64                     `json:"total_count"`
+}
+
+// RevisionDiff contains the tracks a revision of a playlist changed
+// swagger:model CustomerPlaylistRevisionDiff
+type RevisionDiff struct {
+	// Snapshot id of the revision compared with, empty for the first revision
+	// example: 6620db0b3e1ac4c9d158ae39
+	From string `json:"from"`
+	// Snapshot id of the revision
+	// example: 6620db0b3e1ac4c9d158ae41
+	To      string         `json:"to"`
+	Added   []*TrackChange `json:"added"`
+	Removed []*TrackChange `json:"removed"`
+	Moved   []*TrackChange `json:"moved"`
+}
+
+// TrackChange is a track added, removed or moved by a revision
+// swagger:model CustomerPlaylistTrackChange
+type TrackChange struct {
+	// example: 661ffc6c12e6a410902997b0
+	TrackID string `json:"track_id"`
+	// Position of the track before the revision, missing for added tracks
+	// example: 0
+	From *int `json:"from,omitempty"`
+	// Position of the track in the revision, missing for removed tracks
+	// example: 3
+	To *int `json:"to,omitempty"`
+}
+
+// RevertData contains playlist revert data from json request
+// swagger:model CustomerPlaylistRevertData
+type RevertData struct {
+	// Snapshot of the playlist the revert is made against, optional
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// ExportFile contains a playlist file to be downloaded
+type ExportFile struct {
+	Content     []byte
+	ContentType string
+	Filename    string
+}
+
+// ImportData contains an uploaded playlist file
+type ImportData struct {
+	Name     string
+	Filename string
+	Size     int64
+	Content  io.Reader
+}
+
+// ImportResp contains the imported playlist and the entries left out of it
+// swagger:model CustomerPlaylistImportResp
+type ImportResp struct {
+	Playlist *model.Playlist `json:"playlist"`
+	// Number of entries matched to music tracks
+	Matched   int               `json:"matched"`
+	Unmatched []*UnmatchedEntry `json:"unmatched"`
+}
+
+// UnmatchedEntry is a playlist file entry that matched no music track
+// swagger:model CustomerPlaylistUnmatchedEntry
+type UnmatchedEntry struct {
+	// Position of the entry in the file, from 1
+	// example: 2
+	Position int `json:"position"`
+	// Line of the entry in M3U and PLS files, from 1
+	// example: 4
+	Line int `json:"line,omitempty"`
+	// example: C:\Music\em-cua-ngay-hom-qua.mp3
+	Location string `json:"location"`
+	Artist   string `json:"artist,omitempty"`
+	Title    string `json:"title,omitempty"`
 }
 
 // ListResp contains list of playlist and current page number response
@@ -224,7 +829,7 @@ func (h *HTTP) create(c echo.Context) error {
 		return err
 	}
 
-	resp, err := h.svc.Create(c.Request().Context(), nil, r)
+	resp, err := h.svc.Create(c.Request().Context(), h.auth.User(c), r)
 	if err != nil {
 		return err
 	}
@@ -237,12 +842,29 @@ func (h *HTTP) view(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	resp, err := h.svc.View(c.Request().Context(), nil, id)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	if format := negotiateFormat(c.Request().Header.Get(echo.HeaderAccept)); format != "" {
+		resp, err := h.svc.Export(c.Request().Context(), h.auth.User(c), id, format, baseURL(c))
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, resp.ContentType, resp.Content)
+	}
+
+	r := ViewRequest{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	resp, err := h.svc.View(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+	filtered, err := fields.Filter(resp, fields.Parse(r.Fields))
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, resp)
+	return c.JSON(http.StatusOK, filtered)
 }
 
 func (h *HTTP) search(c echo.Context) error {
@@ -251,14 +873,18 @@ func (h *HTTP) search(c echo.Context) error {
 		return err
 	}
 
-	resp, err := h.svc.Search(c.Request().Context(), nil, lr)
+	resp, err := h.svc.Search(c.Request().Context(), h.auth.User(c), lr, c.QueryParam("scope"))
 	if err != nil {
 		return err
 	}
-
-	return c.JSON(http.StatusOK, ListResp{
+	filtered, err := fields.Filter(ListResp{
 		Data: resp,
-	})
+	}, fields.Parse(lr.Fields), "data")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, filtered)
 }
 
 func (h *HTTP) update(c echo.Context) error {
@@ -271,7 +897,7 @@ func (h *HTTP) update(c echo.Context) error {
 		return err
 	}
 
-	usr, err := h.svc.Update(c.Request().Context(), nil, id, u)
+	usr, err := h.svc.Update(c.Request().Context(), h.auth.User(c), id, u)
 	if err != nil {
 		return err
 	}
@@ -284,7 +910,7 @@ func (h *HTTP) delete(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	if err := h.svc.Delete(c.Request().Context(), nil, id); err != nil {
+	if err := h.svc.Delete(c.Request().Context(), h.auth.User(c), id); err != nil {
 		return err
 	}
 
@@ -296,15 +922,233 @@ func (h *HTTP) deleteMusicTrack(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("playlist id", id)
 	r := DeleteMusicTrack{}
 	if err := c.Bind(&r); err != nil {
 		return err
 	}
 
-	if err := h.svc.DeleteMusicTrack(c.Request().Context(), nil, id, r); err != nil {
+	if err := h.svc.DeleteMusicTrack(c.Request().Context(), h.auth.User(c), id, r); err != nil {
 		return err
 	}
 
 	return c.NoContent(http.StatusOK)
 }
+
+func (h *HTTP) addTracks(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := AddTracksData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.AddTracks(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) moveTracks(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := MoveTracksData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.MoveTracks(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) export(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	resp, err := h.svc.Export(c.Request().Context(), h.auth.User(c), id, c.QueryParam("format"), baseURL(c))
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+resp.Filename+`"`)
+	return c.Blob(http.StatusOK, resp.ContentType, resp.Content)
+}
+
+func (h *HTTP) importFile(c echo.Context) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return server.NewHTTPValidationError("file is required").SetInternal(err)
+	}
+	file, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	resp, err := h.svc.Import(c.Request().Context(), h.auth.User(c), ImportData{
+		Name:     c.FormValue("name"),
+		Filename: fh.Filename,
+		Size:     fh.Size,
+		Content:  file,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) stats(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	resp, err := h.svc.Stats(c.Request().Context(), h.auth.User(c), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) setCollaborator(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := CollaboratorData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.SetCollaborator(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) removeCollaborator(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.svc.RemoveCollaborator(c.Request().Context(), h.auth.User(c), id, c.Param("user_id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *HTTP) shuffle(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	persist := false
+	if v := c.QueryParam("persist"); v != "" {
+		if persist, err = strconv.ParseBool(v); err != nil {
+			return server.NewHTTPValidationError("persist must be true or false")
+		}
+	}
+	r := ShuffleData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Shuffle(c.Request().Context(), h.auth.User(c), id, r, persist)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) revisions(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := RevisionListRequest{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Revisions(c.Request().Context(), h.auth.User(c), id, r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) revisionDiff(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.svc.RevisionDiff(c.Request().Context(), h.auth.User(c), id, c.Param("rev"), c.QueryParam("from"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HTTP) revert(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	r := RevertData{}
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Revert(c.Request().Context(), h.auth.User(c), id, c.Param("rev"), r)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// negotiatedFormats are the playlist formats GET /playlists/:id serves besides JSON
+var negotiatedFormats = map[string]string{
+	playlistio.XSPFContentType: playlistio.FormatXSPF,
+	playlistio.JSPFContentType: playlistio.FormatJSPF,
+}
+
+// negotiateFormat returns the first of negotiatedFormats the Accept header lists, empty
+// when it lists none of them before JSON
+func negotiateFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		if format, ok := negotiatedFormats[mediaType]; ok {
+			return format
+		}
+		if mediaType == echo.MIMEApplicationJSON {
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// baseURL returns the scheme and host the request was made to, which exported playlists
+// link tracks under
+func baseURL(c echo.Context) string {
+	return c.Scheme() + "://" + c.Request().Host
+}
@@ -1,59 +1,213 @@
 package playlist
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"music-master/internal/model"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"music-master/internal/util/fields"
 	httputil "music-master/internal/util/http"
+	"music-master/internal/util/playlistio"
+	"music-master/internal/util/server"
+	"music-master/internal/util/shuffle"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Create creates a new Playlist
+// coverURLFormat is the path of the cover art of a music track
+const coverURLFormat = "/v1/customer/music-tracks/%s/cover"
+
+// trackURLFormat is the path of a music track, the identifier of exported tracks
+const trackURLFormat = "/v1/customer/music-tracks/%s"
+
+// streamURLFormat is the path of the audio stream of a music track
+const streamURLFormat = trackURLFormat + "/stream"
+
+// trackURLPattern finds the track of the identifiers and stream URLs in imported
+// playlists, such as exported ones
+var trackURLPattern = regexp.MustCompile(`/music-tracks/([0-9a-f]{24})(?:/stream)?(?:[?#].*)?$`)
+
+// Bounds of imported and exported playlists
+const (
+	maxImportSize   = 1 << 20
+	maxImportTracks = 10000
+	maxExportTracks = 10000
+
+	// importDurationTolerance is how far in milliseconds the duration of a playlist
+	// entry may be from that of the track it matches
+	importDurationTolerance = 3000
+)
+
+// maxTruePeak is the highest true peak in dBTP a gain may raise a track to
+const maxTruePeak = -1.0
+
+var (
+	errPlaylistNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Playlist not found")
+	errSnapshotConflict = server.NewHTTPConflictError("Playlist has changed since snapshot_id, reload it and retry")
+	errSmartPlaylist    = server.NewHTTPValidationError("The tracks of a smart playlist are selected by its rules, a playlist with tracks gets no rules")
+	errForbidden        = server.NewHTTPError(http.StatusForbidden, server.GenericErrorType, "Playlist access is denied")
+	errRevisionNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Playlist revision not found")
+	errNotCollaborator  = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "The playlist is not shared with this user")
+)
+
+// What play counts favor in shuffles
+const (
+	FavorPlayed   = "played"
+	FavorUnplayed = "unplayed"
+)
+
+// Scopes of playlist listings
+const (
+	ScopeOwned  = "owned"
+	ScopeShared = "shared"
+	ScopeAll    = "all"
+)
+
+// defaultRuleTracksPageSize is how many tracks of a smart playlist are returned without
+// a page size
+const defaultRuleTracksPageSize = 25
+
+// defaultRevisionsPageSize is how many revisions are listed without a page size
+const defaultRevisionsPageSize = 25
+
+// Create creates a new Playlist, a smart one when it comes with rules
 func (s *Playlist) Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.Playlist, error) {
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+	if data.Rules != nil {
+		if len(data.TrackIDs) > 0 {
+			return nil, errSmartPlaylist
+		}
+		if err := checkRules(data.Rules); err != nil {
+			return nil, err
+		}
+	}
+	tracks, err := s.entries(ctx, authUsr, data.TrackIDs, nil)
+	if err != nil {
+		return nil, err
+	}
 	rec := &model.Playlist{}
 
 	s.converter.ToModel(rec, data)
+	rec.Tracks = tracks
+	if authUsr != nil {
+		rec.OwnerID = authUsr.ID
+	}
 	result, err := s.playlistCollection.InsertOne(ctx, rec)
 	if err != nil {
 		return nil, err
 	}
+	s.record(ctx, authUsr, model.RevisionOpCreate, result)
 
-	return result, nil
+	return s.View(ctx, authUsr, result.ID.Hex(), ViewRequest{})
+}
+
+// View returns single Playlist with the fields named by req only, see fields.Projection.
+// Legacy audio bytes of its tracks are left out unless named. The tracks of a smart
+// playlist are selected by its rules, a page of them at a time.
+func (s *Playlist) View(ctx context.Context, authUsr *model.AuthUser, id string, req ViewRequest) (*model.Playlist, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return nil, err
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		fmt.Println("Error when parse object id", err)
+		return nil, err
+	}
+
+	names := fields.Parse(req.Fields)
+	if len(names) > 0 {
+		// * read for authorization and to select the tracks of a smart playlist, the
+		// handler leaves them out of the response
+		names = append(names, "owner_id", "collaborators", "rules")
+	}
+	rec, err := s.playlistCollection.FindOneFields(ctx, bson.M{"_id": objectID}, names)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errPlaylistNotFound
+		}
+		return nil, err
+	}
+	if err := authorize(rec, authUsr, model.PlaylistRoleViewer); err != nil {
+		return nil, err
+	}
+	if rec.Rules != nil {
+		if err := s.selectTracks(ctx, rec, req.Page, req.Limit); err != nil {
+			return nil, err
+		}
+	}
+	s.setCoverURLs(ctx, rec)
+	s.setGains(ctx, rec)
+
+	return rec, nil
 }
 
-// View returns single Playlist
-func (s *Playlist) View(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Playlist, error) {
-	rec := new(model.Playlist)
+// find returns the whole Playlist, as stored
+func (s *Playlist) find(ctx context.Context, id string) (*model.Playlist, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		fmt.Println("Error when parse object id", err)
 		return nil, err
 	}
 
-	rec, err = s.playlistCollection.FindOne(ctx, bson.M{"_id": objectID})
+	rec, err := s.playlistCollection.FindOne(ctx, bson.M{"_id": objectID})
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errPlaylistNotFound
+		}
 		return nil, err
 	}
 
 	return rec, nil
 }
 
-// Search returns single Playlist
-func (s *Playlist) Search(ctx context.Context, authUsr *model.AuthUser, lq *httputil.ListRequest) ([]*model.Playlist, error) {
+// Search returns the playlists in scope, owned, shared or all of them, holding tracks
+// matching the query of the filter, if any
+func (s *Playlist) Search(ctx context.Context, authUsr *model.AuthUser, lq *httputil.ListRequest, scope string) ([]*model.Playlist, error) {
+	if authUsr == nil {
+		return nil, errForbidden
+	}
 	query := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(lq.Filter), &query); err != nil {
-		return nil, err
+	if lq.Filter != "" {
+		if err := json.Unmarshal([]byte(lq.Filter), &query); err != nil {
+			return nil, err
+		}
 	}
 
-	queryStr := query["query"].(string)
-	rec, err := s.playlistCollection.Search(ctx, queryStr, lq.Page, lq.Limit)
+	owned := bson.M{"owner_id": authUsr.ID}
+	shared := bson.M{"collaborators.user_id": authUsr.ID}
+	var where bson.M
+	switch scope {
+	case ScopeOwned:
+		where = owned
+	case ScopeShared:
+		where = shared
+	case ScopeAll, "":
+		where = bson.M{"$or": bson.A{owned, shared, bson.M{"owner_id": bson.M{"$exists": false}}}}
+	default:
+		return nil, server.NewHTTPValidationError("scope must be owned, shared or all")
+	}
+
+	queryStr, _ := query["query"].(string)
+	rec, err := s.playlistCollection.Search(ctx, where, queryStr, lq.Page, lq.Limit, fields.Parse(lq.Fields))
 	if err != nil {
 		return nil, err
 	}
+	s.setCoverURLs(ctx, rec...)
 
 	return rec, nil
 }
@@ -61,7 +215,7 @@ func (s *Playlist) Search(ctx context.Context, authUsr *model.AuthUser, lq *http
 // Update updates Playlist information
 func (s *Playlist) Update(ctx context.Context, authUsr *model.AuthUser, id string, data UpdateData) (*model.Playlist, error) {
 	// * do validation
-	curr, err := s.View(ctx, authUsr, id)
+	curr, err := s.find(ctx, id)
 	if err != nil || curr == nil {
 		return nil, err
 	}
@@ -71,24 +225,282 @@ func (s *Playlist) Update(ctx context.Context, authUsr *model.AuthUser, id strin
 		return nil, err
 	}
 
-	rec := new(model.Playlist)
+	role := model.PlaylistRoleViewer
+	if data.TrackIDs != nil || data.Rules != nil {
+		role = model.PlaylistRoleEditor
+	}
+	if data.Name != nil && *data.Name != curr.Name {
+		role = model.PlaylistRoleOwner
+	}
+	if err := authorize(curr, authUsr, role); err != nil {
+		return nil, err
+	}
+	if err := checkSnapshot(curr, data.SnapshotID); err != nil {
+		return nil, err
+	}
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+	if err := s.baseline(ctx, curr); err != nil {
+		return nil, err
+	}
+	rules := curr.Rules
+	if data.Rules != nil {
+		// * a playlist only becomes smart once its tracks are removed explicitly
+		if len(curr.Tracks) > 0 && (data.TrackIDs == nil || len(*data.TrackIDs) > 0) {
+			return nil, errSmartPlaylist
+		}
+		if err := checkRules(data.Rules); err != nil {
+			return nil, err
+		}
+		rules = data.Rules
+	}
+	if data.TrackIDs != nil {
+		if rules != nil && len(*data.TrackIDs) > 0 {
+			return nil, errSmartPlaylist
+		}
+		if curr.Tracks, err = s.entries(ctx, authUsr, *data.TrackIDs, curr.Tracks); err != nil {
+			return nil, err
+		}
+	}
+	// * the whole playlist is written back, so only over the version it was read at
+	where := atSnapshot(objectID, curr.SnapshotID)
 	s.converter.ToModel(&curr, &data)
+	if curr.Tracks == nil {
+		curr.Tracks = []*model.PlaylistTrack{}
+	}
 
-	rec, err = s.playlistCollection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, curr)
+	result, err := s.playlistCollection.UpdateOne(ctx, where, bson.M{
+		"name":        curr.Name,
+		"tracks":      curr.Tracks,
+		"rules":       rules,
+		"snapshot_id": model.NewSnapshotID(),
+	})
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errSnapshotConflict
+		}
 		return nil, err
 	}
+	s.record(ctx, authUsr, model.RevisionOpUpdate, result)
 
-	return rec, nil
+	return s.View(ctx, authUsr, id, ViewRequest{})
+}
+
+// AddTracks inserts tracks into a Playlist before data.Position, or appends them
+func (s *Playlist) AddTracks(ctx context.Context, authUsr *model.AuthUser, id string, data AddTracksData) (*model.Playlist, error) {
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleEditor); err != nil {
+		return nil, err
+	}
+	if err := checkSnapshot(curr, data.SnapshotID); err != nil {
+		return nil, err
+	}
+	if curr.Rules != nil {
+		return nil, errSmartPlaylist
+	}
+	if len(data.TrackIDs) == 0 {
+		return nil, server.NewHTTPValidationError("track_ids must not be empty")
+	}
+	if data.Position != nil && (*data.Position < 0 || *data.Position > len(curr.Tracks)) {
+		return nil, server.NewHTTPValidationError(fmt.Sprintf("position must be between 0 and %d", len(curr.Tracks)))
+	}
+	entries, err := s.entries(ctx, authUsr, data.TrackIDs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.baseline(ctx, curr); err != nil {
+		return nil, err
+	}
+
+	result, err := s.playlistCollection.PushTracks(ctx, editable(curr, data.SnapshotID), entries, data.Position)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errSnapshotConflict
+		}
+		return nil, err
+	}
+	s.record(ctx, authUsr, model.RevisionOpAddTracks, result)
+
+	return s.View(ctx, authUsr, id, ViewRequest{})
+}
+
+// MoveTracks moves data.RangeLength tracks of a Playlist from data.RangeStart to before
+// the track at data.InsertBefore, both positions in the playlist before the move
+func (s *Playlist) MoveTracks(ctx context.Context, authUsr *model.AuthUser, id string, data MoveTracksData) (*model.Playlist, error) {
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleEditor); err != nil {
+		return nil, err
+	}
+	if err := checkSnapshot(curr, data.SnapshotID); err != nil {
+		return nil, err
+	}
+	if curr.Rules != nil {
+		return nil, errSmartPlaylist
+	}
+	length := 1
+	if data.RangeLength != nil {
+		length = *data.RangeLength
+	}
+	n := len(curr.Tracks)
+	switch {
+	case length < 1:
+		return nil, server.NewHTTPValidationError("range_length must be positive")
+	case data.RangeStart < 0 || data.RangeStart+length > n:
+		return nil, server.NewHTTPValidationError(fmt.Sprintf("range_start and range_length must select tracks between 0 and %d", n))
+	case data.InsertBefore < 0 || data.InsertBefore > n:
+		return nil, server.NewHTTPValidationError(fmt.Sprintf("insert_before must be between 0 and %d", n))
+	}
+
+	if err := s.baseline(ctx, curr); err != nil {
+		return nil, err
+	}
+
+	// * without a snapshot the move still fails when the playlist became too short
+	result, err := s.playlistCollection.MoveTracks(ctx, editable(curr, data.SnapshotID), data.RangeStart, length, data.InsertBefore)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errSnapshotConflict
+		}
+		return nil, err
+	}
+	s.record(ctx, authUsr, model.RevisionOpMoveTracks, result)
+
+	return s.View(ctx, authUsr, id, ViewRequest{})
+}
+
+// Shuffle returns the tracks of a Playlist in an order that spreads those of the same
+// artist and album evenly, reproduced by the seed it returns. Play counts bias it towards
+// the tracks played most, or least. The order is only written to the playlist when
+// persist is set, which takes an editor and a playlist that is not smart.
+func (s *Playlist) Shuffle(ctx context.Context, authUsr *model.AuthUser, id string, data ShuffleData, persist bool) (*ShuffleResp, error) {
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		fmt.Println("Error when parse object id", err)
+		return nil, err
+	}
+	rec, err := s.playlistCollection.FindOneFields(ctx, bson.M{"_id": objectID}, nil)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errPlaylistNotFound
+		}
+		return nil, err
+	}
+	role := model.PlaylistRoleViewer
+	if persist {
+		role = model.PlaylistRoleEditor
+	}
+	if err := authorize(rec, authUsr, role); err != nil {
+		return nil, err
+	}
+	if rec.Rules != nil {
+		if persist {
+			return nil, errSmartPlaylist
+		}
+		if err := s.selectTracks(ctx, rec, 1, maxExportTracks); err != nil {
+			return nil, err
+		}
+	}
+	if persist {
+		if err := checkSnapshot(rec, data.SnapshotID); err != nil {
+			return nil, err
+		}
+	}
+
+	seed := shuffle.NewSeed()
+	if data.Seed != nil {
+		seed = *data.Seed
+	}
+	all := rec.Tracks
+	rec.Tracks = make([]*model.PlaylistTrack, 0, len(all))
+	for _, t := range all {
+		if t != nil {
+			rec.Tracks = append(rec.Tracks, t)
+		}
+	}
+	items := make([]shuffle.Item, len(rec.Tracks))
+	for i, t := range rec.Tracks {
+		if t.Track != nil {
+			items[i].Artist, items[i].Album = t.Track.Artist, t.Track.Album
+		}
+	}
+	if len(data.PlayCounts) > 0 {
+		setPlayWeights(items, rec.Tracks, data.PlayCounts, data.Favor)
+	}
+	tracks := make([]*model.PlaylistTrack, len(rec.Tracks))
+	for i, idx := range shuffle.Order(items, seed) {
+		tracks[i] = rec.Tracks[idx]
+	}
+	resp := &ShuffleResp{Seed: seed, SnapshotID: rec.SnapshotID, Tracks: tracks}
+	if !persist {
+		return resp, nil
+	}
+
+	if err := s.baseline(ctx, rec); err != nil {
+		return nil, err
+	}
+
+	// * the tracks are stored without the music tracks looked up for them
+	entries := make([]*model.PlaylistTrack, len(tracks))
+	for i, t := range tracks {
+		entries[i] = &model.PlaylistTrack{TrackID: t.TrackID, AddedAt: t.AddedAt, AddedBy: t.AddedBy}
+	}
+	result, err := s.playlistCollection.UpdateOne(ctx, atSnapshot(rec.ID, rec.SnapshotID), bson.M{
+		"tracks":      entries,
+		"snapshot_id": model.NewSnapshotID(),
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errSnapshotConflict
+		}
+		return nil, err
+	}
+	s.record(ctx, authUsr, model.RevisionOpShuffle, result)
+	resp.SnapshotID = result.SnapshotID
+	resp.Persisted = true
+
+	return resp, nil
+}
+
+// setPlayWeights weights items by the play counts of their tracks, keyed by track id:
+// the more plays the higher the weight, or the lower when favor is unplayed. Weights
+// grow with the logarithm of the count and average 1.
+func setPlayWeights(items []shuffle.Item, tracks []*model.PlaylistTrack, playCounts map[string]int, favor string) {
+	sum := 0.0
+	for i, t := range tracks {
+		w := 1 + math.Log1p(math.Max(float64(playCounts[t.TrackID.Hex()]), 0))
+		if favor == FavorUnplayed {
+			w = 1 / w
+		}
+		items[i].Weight = w
+		sum += w
+	}
+	mean := sum / float64(len(items))
+	for i := range items {
+		items[i].Weight /= mean
+	}
 }
 
 // Delete deletes a Playlist
 func (s *Playlist) Delete(ctx context.Context, authUsr *model.AuthUser, id string) error {
 	// * do validation
-	curr, err := s.View(ctx, authUsr, id)
+	curr, err := s.find(ctx, id)
 	if err != nil || curr == nil {
 		return err
 	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleOwner); err != nil {
+		return err
+	}
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -99,6 +511,9 @@ func (s *Playlist) Delete(ctx context.Context, authUsr *model.AuthUser, id strin
 	if err != nil {
 		return err
 	}
+	if err := s.revisionCollection.RemoveMany(ctx, bson.M{"playlist_id": objectID}); err != nil {
+		fmt.Println("Error deleting revisions of playlist", id, err)
+	}
 
 	return nil
 }
@@ -106,16 +521,799 @@ func (s *Playlist) Delete(ctx context.Context, authUsr *model.AuthUser, id strin
 // Delete deletes a musictrack in Playlist
 func (s *Playlist) DeleteMusicTrack(ctx context.Context, authUsr *model.AuthUser, id string, data DeleteMusicTrack) error {
 	// * do validation
-	curr, err := s.View(ctx, authUsr, id)
+	curr, err := s.find(ctx, id)
 	if err != nil || curr == nil {
 		fmt.Println("Error when view Play list", err)
 		return err
 	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleEditor); err != nil {
+		return err
+	}
+
+	if err := checkSnapshot(curr, data.SnapshotID); err != nil {
+		return err
+	}
+
+	if err := s.baseline(ctx, curr); err != nil {
+		return err
+	}
+
+	result, err := s.playlistCollection.DeleteTrackFromPlaylist(ctx, editable(curr, data.SnapshotID), data.MusicTrackID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errSnapshotConflict
+		}
+		return err
+	}
+	s.record(ctx, authUsr, model.RevisionOpRemoveTrack, result)
+
+	return nil
+}
+
+// Revisions returns a page of the revisions of a playlist, the latest first and without
+// their tracks
+func (s *Playlist) Revisions(ctx context.Context, authUsr *model.AuthUser, id string, req RevisionListRequest) (*RevisionListResp, error) {
+	if err := s.validator.Validate(req); err != nil {
+		return nil, err
+	}
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleViewer); err != nil {
+		return nil, err
+	}
+
+	page, pageSize := req.Page, req.Limit
+	if page < 1 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultRevisionsPageSize
+	}
+	data, total, err := s.revisionCollection.List(ctx, curr.ID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevisionListResp{Data: data, TotalCount: total}, nil
+}
+
+// RevisionDiff lists the tracks added, removed and moved by the revision rev of a
+// playlist, against the revision before it or, when given, the revision from
+func (s *Playlist) RevisionDiff(ctx context.Context, authUsr *model.AuthUser, id, rev, from string) (*RevisionDiff, error) {
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleViewer); err != nil {
+		return nil, err
+	}
+	to, err := s.revision(ctx, curr.ID, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var prev *model.PlaylistRevision
+	if from != "" {
+		if prev, err = s.revision(ctx, curr.ID, from); err != nil {
+			return nil, err
+		}
+	} else {
+		prev, err = s.revisionCollection.Previous(ctx, to)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	resp := &RevisionDiff{To: to.SnapshotID}
+	var prevTracks []*model.PlaylistTrack
+	if prev != nil {
+		resp.From = prev.SnapshotID
+		prevTracks = prev.Tracks
+	}
+	resp.Added, resp.Removed, resp.Moved = diffTracks(prevTracks, to.Tracks)
+
+	return resp, nil
+}
+
+// Revert restores the name, tracks and rules of a playlist to those of the revision rev,
+// which makes a new revision. Tracks deleted since are left out. Reverting to another
+// name takes the owner of the playlist.
+func (s *Playlist) Revert(ctx context.Context, authUsr *model.AuthUser, id, rev string, data RevertData) (*model.Playlist, error) {
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.revision(ctx, curr.ID, rev)
+	if err != nil {
+		return nil, err
+	}
+	role := model.PlaylistRoleEditor
+	if target.Name != curr.Name {
+		role = model.PlaylistRoleOwner
+	}
+	if err := authorize(curr, authUsr, role); err != nil {
+		return nil, err
+	}
+	if err := checkSnapshot(curr, data.SnapshotID); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(target.Tracks))
+	for i, t := range target.Tracks {
+		ids[i] = t.TrackID
+	}
+	found, err := s.musicTrackCollection.FindIDs(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	exists := map[primitive.ObjectID]bool{}
+	for _, id := range found {
+		exists[id] = true
+	}
+	tracks := []*model.PlaylistTrack{}
+	for _, t := range target.Tracks {
+		if exists[t.TrackID] {
+			tracks = append(tracks, t)
+		}
+	}
+
+	if err := s.baseline(ctx, curr); err != nil {
+		return nil, err
+	}
+
+	// * like an update, the whole playlist is written back over the version it was read at
+	result, err := s.playlistCollection.UpdateOne(ctx, atSnapshot(curr.ID, curr.SnapshotID), bson.M{
+		"name":        target.Name,
+		"tracks":      tracks,
+		"rules":       target.Rules,
+		"snapshot_id": model.NewSnapshotID(),
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errSnapshotConflict
+		}
+		return nil, err
+	}
+	revision := model.NewPlaylistRevision(result, model.RevisionOpRevert, userID(authUsr))
+	revision.RevertedTo = target.SnapshotID
+	if _, err := s.revisionCollection.InsertOne(ctx, revision); err != nil {
+		fmt.Println("Error recording revision of playlist", id, err)
+	}
+
+	return s.View(ctx, authUsr, id, ViewRequest{})
+}
+
+// revision returns the revision of the playlist playlistID named by its snapshot id
+func (s *Playlist) revision(ctx context.Context, playlistID primitive.ObjectID, snapshotID string) (*model.PlaylistRevision, error) {
+	rec, err := s.revisionCollection.FindOne(ctx, bson.M{"playlist_id": playlistID, "snapshot_id": snapshotID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errRevisionNotFound
+		}
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// record writes the revision a change by authUsr left rec at. The change is made
+// already, so a failure is only logged.
+func (s *Playlist) record(ctx context.Context, authUsr *model.AuthUser, operation string, rec *model.Playlist) {
+	if _, err := s.revisionCollection.InsertOne(ctx, model.NewPlaylistRevision(rec, operation, userID(authUsr))); err != nil {
+		fmt.Println("Error recording revision of playlist", rec.ID.Hex(), err)
+	}
+}
+
+// baseline records curr as it is before a change, unless a revision of it exists. A
+// playlist stored before snapshot ids is given one first, curr is updated with it.
+func (s *Playlist) baseline(ctx context.Context, curr *model.Playlist) error {
+	if curr.SnapshotID == "" {
+		result, err := s.playlistCollection.UpdateOne(ctx, atSnapshot(curr.ID, ""), bson.M{"snapshot_id": model.NewSnapshotID()})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errSnapshotConflict
+			}
+			return err
+		}
+		curr.SnapshotID = result.SnapshotID
+	}
+	// * like other revisions, a missing baseline does not stop the change
+	if err := s.revisionCollection.InsertBaseline(ctx, curr); err != nil {
+		fmt.Println("Error recording baseline revision of playlist", curr.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// SetCollaborator shares the playlist with a user as a viewer or an editor, or changes
+// the role of a collaborator. Only the owner shares a playlist.
+func (s *Playlist) SetCollaborator(ctx context.Context, authUsr *model.AuthUser, id string, data CollaboratorData) (*model.Playlist, error) {
+	if err := s.validator.Validate(data); err != nil {
+		return nil, err
+	}
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(curr, authUsr, model.PlaylistRoleOwner); err != nil {
+		return nil, err
+	}
+	switch {
+	case curr.OwnerID == "":
+		return nil, server.NewHTTPValidationError("Playlists without an owner cannot be shared")
+	case data.UserID == curr.OwnerID:
+		return nil, server.NewHTTPValidationError("The owner of the playlist cannot be a collaborator")
+	}
+
+	collaborator := &model.PlaylistCollaborator{
+		UserID:  data.UserID,
+		Role:    data.Role,
+		AddedAt: time.Now(),
+	}
+	if _, err := s.playlistCollection.SetCollaborator(ctx, bson.M{"_id": curr.ID}, collaborator); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errPlaylistNotFound
+		}
+		return nil, err
+	}
+
+	return s.View(ctx, authUsr, id, ViewRequest{})
+}
+
+// RemoveCollaborator stops sharing the playlist with a user. The owner removes any
+// collaborator, collaborators remove themselves.
+func (s *Playlist) RemoveCollaborator(ctx context.Context, authUsr *model.AuthUser, id, userID string) error {
+	curr, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	if authUsr == nil || authUsr.ID != userID {
+		if err := authorize(curr, authUsr, model.PlaylistRoleOwner); err != nil {
+			return err
+		}
+	}
 
-	err = s.playlistCollection.DeleteTrackFromPlaylist(ctx, id, data.MusicTrackID)
+	if err := s.playlistCollection.RemoveCollaborator(ctx, bson.M{"_id": curr.ID}, userID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errNotCollaborator
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Stats summarizes the tracks of a playlist, those selected by its rules for a smart one
+func (s *Playlist) Stats(ctx context.Context, authUsr *model.AuthUser, id string) (*model.PlaylistStats, error) {
+	rec, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(rec, authUsr, model.PlaylistRoleViewer); err != nil {
+		return nil, err
+	}
+	if rec.Rules != nil {
+		return s.playlistCollection.RuleStats(ctx, rec.Rules)
+	}
+
+	stats, err := s.playlistCollection.Stats(ctx, bson.M{"_id": rec.ID})
+	if err == mongo.ErrNoDocuments {
+		return nil, errPlaylistNotFound
+	}
+
+	return stats, err
+}
+
+// Export writes the playlist in format, m3u8, pls, xspf or jspf, with the stream URLs
+// of its tracks under baseURL. Deleted tracks are left out.
+func (s *Playlist) Export(ctx context.Context, authUsr *model.AuthUser, id, format, baseURL string) (*ExportFile, error) {
+	if format == "" {
+		format = playlistio.FormatM3U8
+	}
+	contentType, ok := playlistio.ContentTypes[format]
+	if !ok {
+		return nil, server.NewHTTPValidationError("format must be m3u8, pls, xspf or jspf")
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		fmt.Println("Error when parse object id", err)
+		return nil, err
+	}
+
+	rec, err := s.playlistCollection.FindOneFields(ctx, bson.M{"_id": objectID}, nil)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errPlaylistNotFound
+		}
+		return nil, err
+	}
+	if err := authorize(rec, authUsr, model.PlaylistRoleViewer); err != nil {
+		return nil, err
+	}
+	if rec.Rules != nil {
+		if err := s.selectTracks(ctx, rec, 1, maxExportTracks); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &playlistio.Playlist{Name: rec.Name}
+	for _, t := range rec.Tracks {
+		if t == nil || t.Track == nil {
+			continue
+		}
+		out.Entries = append(out.Entries, &playlistio.Entry{
+			Location:   baseURL + fmt.Sprintf(streamURLFormat, t.TrackID.Hex()),
+			Identifier: baseURL + fmt.Sprintf(trackURLFormat, t.TrackID.Hex()),
+			Artist:     t.Track.Artist,
+			Title:      t.Track.Title,
+			Album:      t.Track.Album,
+			Duration:   trackDuration(t.Track),
+		})
+	}
+	var buf bytes.Buffer
+	if err := playlistio.Write(&buf, out, format); err != nil {
+		return nil, err
+	}
+
+	return &ExportFile{
+		Content:     buf.Bytes(),
+		ContentType: contentType,
+		Filename:    id + "." + format,
+	}, nil
+}
+
+// Import creates a playlist from an M3U, PLS, XSPF or JSPF file. Every entry is matched
+// to a music track by its identifier, stream URL or file name, or else by its title and
+// artist, the duration telling apart tracks of the same name. Entries matching no track
+// are reported.
+func (s *Playlist) Import(ctx context.Context, authUsr *model.AuthUser, data ImportData) (*ImportResp, error) {
+	if data.Size > maxImportSize {
+		return nil, server.NewHTTPError(http.StatusRequestEntityTooLarge, server.GenericErrorType, fmt.Sprintf("Playlist files are limited to %d bytes", maxImportSize))
+	}
+	file, err := playlistio.Parse(io.LimitReader(data.Content, maxImportSize))
+	if err != nil {
+		if err == playlistio.ErrNoEntries {
+			return nil, server.NewHTTPValidationError("The file has no playlist entries")
+		}
+		return nil, server.NewHTTPValidationError("The file is not a playlist").SetInternal(err)
+	}
+	if len(file.Entries) > maxImportTracks {
+		return nil, server.NewHTTPValidationError(fmt.Sprintf("Playlists are limited to %d entries", maxImportTracks))
+	}
+
+	resp := &ImportResp{Unmatched: []*UnmatchedEntry{}}
+	trackIDs := []string{}
+	for _, e := range file.Entries {
+		id, err := s.match(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		if id.IsZero() {
+			resp.Unmatched = append(resp.Unmatched, &UnmatchedEntry{
+				Position: e.Position,
+				Line:     e.Line,
+				Location: e.Location,
+				Artist:   e.Artist,
+				Title:    e.Title,
+			})
+			continue
+		}
+		trackIDs = append(trackIDs, id.Hex())
+	}
+
+	name := data.Name
+	if name == "" {
+		name = file.Name
+	}
+	if name == "" {
+		name = strings.TrimSuffix(data.Filename, path.Ext(data.Filename))
+	}
+	if resp.Playlist, err = s.Create(ctx, authUsr, CreationData{Name: name, TrackIDs: trackIDs}); err != nil {
+		return nil, err
+	}
+	resp.Matched = len(trackIDs)
+
+	return resp, nil
+}
+
+// match returns the id of the music track a playlist entry refers to, zero when none
+func (s *Playlist) match(ctx context.Context, e *playlistio.Entry) (primitive.ObjectID, error) {
+	name := fileName(e.Location)
+	for _, uri := range []string{e.Identifier, e.Location} {
+		m := trackURLPattern.FindStringSubmatch(uri)
+		if m == nil {
+			continue
+		}
+		id, _ := primitive.ObjectIDFromHex(m[1])
+		found, err := s.musicTrackCollection.FindIDs(ctx, bson.M{"_id": id})
+		if err != nil || len(found) > 0 {
+			return id, err
+		}
+		// * exported elsewhere, only the title tells the track
+		name = ""
+	}
+	if name != "" {
+		id, err := s.closest(ctx, bson.M{"audio.filename": equalFold(name)}, e.Duration)
+		if err != nil || !id.IsZero() {
+			return id, err
+		}
+	}
+
+	artist, title := e.Artist, e.Title
+	if title == "" {
+		// * files of plain M3U are often named after their track
+		artist, title = playlistio.SplitLabel(strings.TrimSuffix(name, path.Ext(name)))
+	}
+	if title == "" {
+		return primitive.NilObjectID, nil
+	}
+	where := bson.M{"title": equalFold(title)}
+	if artist != "" {
+		where["artist"] = equalFold(artist)
+	}
+
+	return s.closest(ctx, where, e.Duration)
+}
+
+// closest returns the id of the track matching where whose duration is closest to
+// duration, zero when there is none within importDurationTolerance. Tracks without a
+// duration are taken when nothing closer is found.
+func (s *Playlist) closest(ctx context.Context, where bson.M, duration int64) (primitive.ObjectID, error) {
+	best, bestDiff := primitive.NilObjectID, int64(importDurationTolerance+1)
+	err := s.musicTrackCollection.EachFields(ctx, where, []string{"duration", "duration_ms"}, func(rec *model.MusicTrack) error {
+		diff := int64(importDurationTolerance)
+		if d := trackDuration(rec); duration != playlistio.UnknownDuration && d != playlistio.UnknownDuration {
+			diff = d - duration
+			if diff < 0 {
+				diff = -diff
+			}
+		}
+		if diff < bestDiff {
+			best, bestDiff = rec.ID, diff
+		}
+		return nil
+	})
+
+	return best, err
+}
+
+// trackDuration returns the duration of rec in milliseconds, playlistio.UnknownDuration
+// when it is not known
+func trackDuration(rec *model.MusicTrack) int64 {
+	switch {
+	case rec.DurationMs > 0:
+		return rec.DurationMs
+	case rec.Duration > 0:
+		return int64(rec.Duration) * 1000
+	}
+
+	return playlistio.UnknownDuration
+}
+
+// fileName returns the last element of a path or URL, with either kind of slash
+func fileName(location string) string {
+	if u, err := url.Parse(location); err == nil && len(u.Scheme) > 1 {
+		location = u.Path
+	}
+	if i := strings.LastIndexAny(location, `/\`); i >= 0 {
+		location = location[i+1:]
+	}
+
+	return location
+}
+
+// equalFold matches strings equal to s, ignoring case
+func equalFold(s string) bson.M {
+	return bson.M{"$regex": "^" + regexp.QuoteMeta(s) + "$", "$options": "i"}
+}
+
+// selectTracks fills in a page of the tracks of the smart playlist rec
+func (s *Playlist) selectTracks(ctx context.Context, rec *model.Playlist, page, pageSize int) error {
+	if pageSize <= 0 {
+		pageSize = defaultRuleTracksPageSize
+	}
+	tracks, total, err := s.playlistCollection.RuleTracks(ctx, rec.Rules, page, pageSize)
 	if err != nil {
 		return err
 	}
 
+	rec.Tracks = make([]*model.PlaylistTrack, len(tracks))
+	for i, t := range tracks {
+		rec.Tracks[i] = &model.PlaylistTrack{TrackID: t.ID, Track: t}
+	}
+	rec.TrackCount = &total
+
+	return nil
+}
+
+// checkRules checks the values of conditions against their fields and operators, the
+// validator has checked the rest
+func checkRules(rules *model.PlaylistRules) error {
+	for i, cond := range rules.Conditions {
+		switch v := cond.Value.(type) {
+		case string:
+			if !cond.IsText() {
+				return server.NewHTTPValidationError(fmt.Sprintf("Condition %d: %s compares with a number", i, cond.Field))
+			}
+		case float64:
+			if cond.IsText() {
+				return server.NewHTTPValidationError(fmt.Sprintf("Condition %d: %s compares with a string", i, cond.Field))
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return server.NewHTTPValidationError(fmt.Sprintf("Condition %d: value is not a number", i))
+			}
+		default:
+			return server.NewHTTPValidationError(fmt.Sprintf("Condition %d: value must be a string or a number", i))
+		}
+		if cond.Operator == model.RuleOpContains && !cond.IsText() {
+			return server.NewHTTPValidationError(fmt.Sprintf("Condition %d: contains only applies to text fields", i))
+		}
+	}
+
+	return nil
+}
+
+// diffTracks returns the tracks of to that are not in from, the tracks of from that are
+// not in to and the tracks of both whose order changed. The n-th entry of a track in
+// from is the n-th entry of it in to. Of the entries in both, the longest run in the
+// same order stays in place and the others moved.
+func diffTracks(from, to []*model.PlaylistTrack) (added, removed, moved []*TrackChange) {
+	added, removed, moved = []*TrackChange{}, []*TrackChange{}, []*TrackChange{}
+	fromIndexes := map[primitive.ObjectID][]int{}
+	for i, t := range from {
+		fromIndexes[t.TrackID] = append(fromIndexes[t.TrackID], i)
+	}
+
+	kept := make([]bool, len(from))
+	var pairs [][2]int // from and to index of the entries in both, in the order of to
+	for j, t := range to {
+		if indexes := fromIndexes[t.TrackID]; len(indexes) > 0 {
+			pairs = append(pairs, [2]int{indexes[0], j})
+			kept[indexes[0]] = true
+			fromIndexes[t.TrackID] = indexes[1:]
+			continue
+		}
+		added = append(added, &TrackChange{TrackID: t.TrackID.Hex(), To: intPtr(j)})
+	}
+	for i, t := range from {
+		if !kept[i] {
+			removed = append(removed, &TrackChange{TrackID: t.TrackID.Hex(), From: intPtr(i)})
+		}
+	}
+
+	// * longest increasing run of from indexes, tails[k] ends the best run of length k+1
+	tails := []int{}
+	prev := make([]int, len(pairs))
+	for p, pair := range pairs {
+		k := sort.Search(len(tails), func(k int) bool { return pairs[tails[k]][0] >= pair[0] })
+		prev[p] = -1
+		if k > 0 {
+			prev[p] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, p)
+		} else {
+			tails[k] = p
+		}
+	}
+	inPlace := make([]bool, len(pairs))
+	if len(tails) > 0 {
+		for p := tails[len(tails)-1]; p >= 0; p = prev[p] {
+			inPlace[p] = true
+		}
+	}
+	for p, pair := range pairs {
+		if !inPlace[p] {
+			moved = append(moved, &TrackChange{TrackID: from[pair[0]].TrackID.Hex(), From: intPtr(pair[0]), To: intPtr(pair[1])})
+		}
+	}
+
+	return added, removed, moved
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// userID returns the id of authUsr, empty without one
+func userID(authUsr *model.AuthUser) string {
+	if authUsr == nil {
+		return ""
+	}
+
+	return authUsr.ID
+}
+
+// authorize fails with 403 unless the user has role, or a higher one, on the playlist
+func authorize(rec *model.Playlist, authUsr *model.AuthUser, role string) error {
+	if authUsr == nil {
+		return errForbidden
+	}
+	if model.PlaylistRoleRank[rec.Role(authUsr.ID)] < model.PlaylistRoleRank[role] {
+		return server.NewHTTPError(http.StatusForbidden, server.GenericErrorType, fmt.Sprintf("Only the %s of the playlist or higher can do this", role))
+	}
+
+	return nil
+}
+
+// checkSnapshot rejects an edit made against another version of curr, edits without a
+// snapshot id apply to any version
+func checkSnapshot(curr *model.Playlist, snapshotID string) error {
+	if snapshotID != "" && snapshotID != curr.SnapshotID {
+		return errSnapshotConflict
+	}
+
 	return nil
 }
+
+// editable matches curr for an edit made against snapshotID, at any version without one
+func editable(curr *model.Playlist, snapshotID string) bson.M {
+	if snapshotID == "" {
+		return bson.M{"_id": curr.ID}
+	}
+
+	return atSnapshot(curr.ID, snapshotID)
+}
+
+// atSnapshot matches the playlist with id while at snapshotID. Playlists stored before
+// snapshot ids have none, they match the empty one.
+func atSnapshot(id primitive.ObjectID, snapshotID string) bson.M {
+	if snapshotID == "" {
+		return bson.M{"_id": id, "snapshot_id": bson.M{"$exists": false}}
+	}
+
+	return bson.M{"_id": id, "snapshot_id": snapshotID}
+}
+
+// entries returns the playlist entries of the tracks with ids, in that order. The
+// entries of tracks already in the playlist, given as prev, are kept as they are.
+// Every track has to exist.
+func (s *Playlist) entries(ctx context.Context, authUsr *model.AuthUser, ids []string, prev []*model.PlaylistTrack) ([]*model.PlaylistTrack, error) {
+	objectIDs := make([]primitive.ObjectID, len(ids))
+	for i, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, server.NewHTTPValidationError(fmt.Sprintf("Invalid music track id %s", id))
+		}
+		objectIDs[i] = objectID
+	}
+
+	found, err := s.musicTrackCollection.FindIDs(ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return nil, err
+	}
+	exists := map[primitive.ObjectID]bool{}
+	for _, id := range found {
+		exists[id] = true
+	}
+
+	// * a track listed twice keeps both of its entries, in order
+	kept := map[primitive.ObjectID][]*model.PlaylistTrack{}
+	for _, e := range prev {
+		kept[e.TrackID] = append(kept[e.TrackID], e)
+	}
+	addedBy := userID(authUsr)
+	now := time.Now().UTC()
+
+	result := make([]*model.PlaylistTrack, 0, len(objectIDs))
+	for _, id := range objectIDs {
+		if !exists[id] {
+			return nil, server.NewHTTPValidationError(fmt.Sprintf("Music track %s not found", id.Hex()))
+		}
+		if e := kept[id]; len(e) > 0 {
+			result = append(result, e[0])
+			kept[id] = e[1:]
+			continue
+		}
+		result = append(result, &model.PlaylistTrack{TrackID: id, AddedAt: &now, AddedBy: addedBy})
+	}
+
+	return result, nil
+}
+
+// setCoverURLs points every playlist at the cover of its first track, looked up in one
+// query for all of them
+func (s *Playlist) setCoverURLs(ctx context.Context, playlists ...*model.Playlist) {
+	var ids []primitive.ObjectID
+	for _, p := range playlists {
+		if len(p.Tracks) > 0 && p.Tracks[0] != nil {
+			ids = append(ids, p.Tracks[0].TrackID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	withCover := map[primitive.ObjectID]bool{}
+	where := bson.M{"_id": bson.M{"$in": ids}, "cover": bson.M{"$exists": true}}
+	err := s.musicTrackCollection.EachFields(ctx, where, []string{"cover"}, func(rec *model.MusicTrack) error {
+		withCover[rec.ID] = true
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error looking up playlist covers", err)
+		return
+	}
+
+	for _, p := range playlists {
+		if len(p.Tracks) > 0 && p.Tracks[0] != nil && withCover[p.Tracks[0].TrackID] {
+			p.CoverURL = fmt.Sprintf(coverURLFormat, p.Tracks[0].TrackID.Hex())
+		}
+	}
+}
+
+// setGains sets the volume adjustment of every track of a playlist from the loudness
+// measured for its audio. A playlist of one album keeps the dynamics of the album with
+// the album gain, any other playlist brings all tracks to the same loudness with the
+// track gain. Gains are lowered where they would raise the true peak above -1 dBTP.
+func (s *Playlist) setGains(ctx context.Context, playlist *model.Playlist) {
+	var ids []primitive.ObjectID
+	for _, t := range playlist.Tracks {
+		if t != nil {
+			ids = append(ids, t.TrackID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	measured := map[primitive.ObjectID]*model.MusicTrack{}
+	where := bson.M{"_id": bson.M{"$in": ids}, "loudness": bson.M{"$exists": true}}
+	// * the checksum tells whether the loudness is of the current audio
+	names := []string{"loudness", "audio.checksum", "album"}
+	err := s.musicTrackCollection.EachFields(ctx, where, names, func(rec *model.MusicTrack) error {
+		// * loudness of replaced audio is ignored until measured again
+		if rec.Audio == nil || rec.Loudness.Checksum == rec.Audio.Checksum {
+			measured[rec.ID] = rec
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error looking up playlist loudness", err)
+		return
+	}
+
+	album := singleAlbum(playlist.Tracks, measured)
+	for _, t := range playlist.Tracks {
+		if t == nil || measured[t.TrackID] == nil {
+			continue
+		}
+		rec := measured[t.TrackID]
+		gain, peak := rec.Loudness.TrackGain, rec.Loudness.TruePeak
+		if album {
+			gain, peak = *rec.Loudness.AlbumGain, *rec.Loudness.AlbumPeak
+		}
+		if gain+peak > maxTruePeak {
+			gain = maxTruePeak - peak
+		}
+		gain = math.Round(gain*100) / 100
+		t.Gain = &gain
+	}
+}
+
+// singleAlbum reports whether all tracks are of the same album and the album gain of
+// those measured is known
+func singleAlbum(tracks []*model.PlaylistTrack, measured map[primitive.ObjectID]*model.MusicTrack) bool {
+	album := ""
+	for _, t := range tracks {
+		if t == nil {
+			continue
+		}
+		rec, ok := measured[t.TrackID]
+		if !ok {
+			if rec = t.Track; rec == nil {
+				return false
+			}
+		} else if rec.Loudness.AlbumGain == nil || rec.Loudness.AlbumPeak == nil {
+			return false
+		}
+		if rec.Album == "" || (album != "" && rec.Album != album) {
+			return false
+		}
+		album = rec.Album
+	}
+
+	return album != ""
+}
@@ -0,0 +1,470 @@
+package playlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"music-master/internal/model"
+	"music-master/internal/util/playlistio"
+	"music-master/internal/util/server"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakePlaylists stores a single playlist, smart ones select ruleTracks
+type fakePlaylists struct {
+	PlaylistCollection
+	rec        model.Playlist
+	ruleTracks []*model.MusicTrack
+	updates    int
+}
+
+// FindOneFields reads the named top level fields only, like the projection does
+func (f *fakePlaylists) FindOneFields(ctx context.Context, where bson.M, names []string) (*model.Playlist, error) {
+	if where["_id"] != f.rec.ID {
+		return nil, mongo.ErrNoDocuments
+	}
+	rec := f.rec
+	if len(names) == 0 {
+		return &rec, nil
+	}
+
+	read := map[string]bool{}
+	for _, name := range names {
+		read[strings.SplitN(name, ".", 2)[0]] = true
+	}
+	if !read["name"] {
+		rec.Name = ""
+	}
+	if !read["tracks"] {
+		rec.Tracks = nil
+	}
+	if !read["rules"] {
+		rec.Rules = nil
+	}
+	if !read["owner_id"] {
+		rec.OwnerID = ""
+	}
+
+	return &rec, nil
+}
+
+func (f *fakePlaylists) RuleTracks(ctx context.Context, rules *model.PlaylistRules, page, pageSize int) ([]*model.MusicTrack, int64, error) {
+	return f.ruleTracks, int64(len(f.ruleTracks)), nil
+}
+
+func (f *fakePlaylists) UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.Playlist, error) {
+	f.updates++
+	if where["_id"] != f.rec.ID {
+		return nil, mongo.ErrNoDocuments
+	}
+	if snapshot, ok := where["snapshot_id"].(string); ok && snapshot != f.rec.SnapshotID {
+		return nil, mongo.ErrNoDocuments
+	}
+	if _, ok := where["snapshot_id"].(bson.M); ok && f.rec.SnapshotID != "" {
+		return nil, mongo.ErrNoDocuments
+	}
+	if snapshot, ok := updateData["snapshot_id"].(string); ok {
+		f.rec.SnapshotID = snapshot
+	}
+	rec := f.rec
+
+	return &rec, nil
+}
+
+// fakeRevisions records the baselines it is given, only InsertBaseline is implemented
+type fakeRevisions struct {
+	RevisionCollection
+	baselines []model.Playlist
+	err       error
+}
+
+func (f *fakeRevisions) InsertBaseline(ctx context.Context, rec *model.Playlist) error {
+	f.baselines = append(f.baselines, *rec)
+	return f.err
+}
+
+func TestBaseline(t *testing.T) {
+	tracks := []*model.PlaylistTrack{{TrackID: primitive.NewObjectID()}, {TrackID: primitive.NewObjectID()}}
+	ctx := context.Background()
+
+	t.Run("legacy playlist", func(t *testing.T) {
+		curr := &model.Playlist{ID: primitive.NewObjectID(), Name: "Old", Tracks: tracks}
+		playlists := &fakePlaylists{rec: *curr}
+		revisions := &fakeRevisions{}
+		s := &Playlist{playlistCollection: playlists, revisionCollection: revisions}
+
+		if err := s.baseline(ctx, curr); err != nil {
+			t.Fatalf("baseline() error = %v", err)
+		}
+		if curr.SnapshotID == "" || curr.SnapshotID != playlists.rec.SnapshotID {
+			t.Fatalf("snapshot id = %q, want the one stored %q", curr.SnapshotID, playlists.rec.SnapshotID)
+		}
+		if len(revisions.baselines) != 1 {
+			t.Fatalf("%d baselines recorded, want 1", len(revisions.baselines))
+		}
+		if b := revisions.baselines[0]; b.ID != curr.ID || b.SnapshotID != curr.SnapshotID || b.Name != "Old" || len(b.Tracks) != 2 {
+			t.Errorf("baseline of %+v, want the playlist before the change at its new snapshot", b)
+		}
+	})
+
+	t.Run("playlist with a snapshot", func(t *testing.T) {
+		curr := &model.Playlist{ID: primitive.NewObjectID(), SnapshotID: model.NewSnapshotID(), Tracks: tracks}
+		playlists := &fakePlaylists{rec: *curr}
+		revisions := &fakeRevisions{}
+		s := &Playlist{playlistCollection: playlists, revisionCollection: revisions}
+
+		snapshotID := curr.SnapshotID
+		if err := s.baseline(ctx, curr); err != nil {
+			t.Fatalf("baseline() error = %v", err)
+		}
+		if playlists.updates != 0 || curr.SnapshotID != snapshotID {
+			t.Errorf("playlist updated %d times, snapshot %q, want it left alone", playlists.updates, curr.SnapshotID)
+		}
+		if len(revisions.baselines) != 1 || revisions.baselines[0].SnapshotID != snapshotID {
+			t.Errorf("baselines = %+v, want one at %s", revisions.baselines, snapshotID)
+		}
+	})
+
+	t.Run("legacy playlist changed meanwhile", func(t *testing.T) {
+		curr := &model.Playlist{ID: primitive.NewObjectID()}
+		playlists := &fakePlaylists{rec: model.Playlist{ID: curr.ID, SnapshotID: model.NewSnapshotID()}}
+		revisions := &fakeRevisions{}
+		s := &Playlist{playlistCollection: playlists, revisionCollection: revisions}
+
+		if err := s.baseline(ctx, curr); err != errSnapshotConflict {
+			t.Errorf("baseline() error = %v, want errSnapshotConflict", err)
+		}
+		if len(revisions.baselines) != 0 {
+			t.Errorf("baselines = %+v, want none", revisions.baselines)
+		}
+	})
+
+	t.Run("baseline not stored", func(t *testing.T) {
+		curr := &model.Playlist{ID: primitive.NewObjectID(), SnapshotID: model.NewSnapshotID()}
+		s := &Playlist{playlistCollection: &fakePlaylists{rec: *curr}, revisionCollection: &fakeRevisions{err: errors.New("down")}}
+
+		if err := s.baseline(ctx, curr); err != nil {
+			t.Errorf("baseline() error = %v, want the change to go on", err)
+		}
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	owned := &model.Playlist{
+		OwnerID: "owner",
+		Collaborators: []*model.PlaylistCollaborator{
+			{UserID: "editor", Role: model.PlaylistRoleEditor},
+			{UserID: "viewer", Role: model.PlaylistRoleViewer},
+		},
+	}
+	ownerless := &model.Playlist{}
+
+	tests := []struct {
+		name    string
+		rec     *model.Playlist
+		user    string
+		allowed map[string]bool
+	}{
+		{"owner", owned, "owner", map[string]bool{model.PlaylistRoleViewer: true, model.PlaylistRoleEditor: true, model.PlaylistRoleOwner: true}},
+		{"editor", owned, "editor", map[string]bool{model.PlaylistRoleViewer: true, model.PlaylistRoleEditor: true}},
+		{"viewer", owned, "viewer", map[string]bool{model.PlaylistRoleViewer: true}},
+		{"stranger", owned, "someone", map[string]bool{}},
+		// * nobody owns a playlist made before owners, everyone edits it
+		{"ownerless", ownerless, "someone", map[string]bool{model.PlaylistRoleViewer: true, model.PlaylistRoleEditor: true}},
+	}
+	for _, tt := range tests {
+		for _, role := range []string{model.PlaylistRoleViewer, model.PlaylistRoleEditor, model.PlaylistRoleOwner} {
+			err := authorize(tt.rec, &model.AuthUser{ID: tt.user}, role)
+			if allowed := err == nil; allowed != tt.allowed[role] {
+				t.Errorf("%s: authorize(%s) error = %v, want allowed %v", tt.name, role, err, tt.allowed[role])
+			}
+		}
+	}
+
+	if err := authorize(ownerless, nil, model.PlaylistRoleViewer); err == nil {
+		t.Error("authorize() without a user succeeded")
+	}
+}
+
+// fakeTracks passes its tracks to every EachFields, whatever the filter, and records
+// the fields read
+type fakeTracks struct {
+	MusicTrackCollection
+	tracks []*model.MusicTrack
+	names  [][]string
+}
+
+func (f *fakeTracks) EachFields(ctx context.Context, where bson.M, names []string, fn func(rec *model.MusicTrack) error) error {
+	f.names = append(f.names, names)
+	for _, rec := range f.tracks {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type acceptAll struct{}
+
+func (acceptAll) Validate(i interface{}) error {
+	return nil
+}
+
+func TestViewSmartPlaylistFields(t *testing.T) {
+	tracks := []*model.MusicTrack{{ID: primitive.NewObjectID(), Title: "A"}, {ID: primitive.NewObjectID(), Title: "B"}}
+	rec := model.Playlist{
+		ID:      primitive.NewObjectID(),
+		Name:    "Long ballads",
+		OwnerID: "owner",
+		Rules: &model.PlaylistRules{Conditions: []*model.PlaylistCondition{
+			{Field: "duration", Operator: model.RuleOpGt, Value: 300.0},
+		}},
+	}
+	s := &Playlist{
+		playlistCollection:   &fakePlaylists{rec: rec, ruleTracks: tracks},
+		musicTrackCollection: &fakeTracks{},
+		validator:            acceptAll{},
+	}
+
+	// * the rules select the tracks whether or not they are among the fields asked for
+	for _, f := range []string{"", "tracks", "name,tracks", "tracks.track.title"} {
+		got, err := s.View(context.Background(), &model.AuthUser{ID: "owner"}, rec.ID.Hex(), ViewRequest{Fields: f})
+		if err != nil {
+			t.Fatalf("View(fields=%q) error = %v", f, err)
+		}
+		if len(got.Tracks) != len(tracks) || got.Tracks[0].Track != tracks[0] {
+			t.Errorf("View(fields=%q) tracks = %+v, want the %d selected by the rules", f, got.Tracks, len(tracks))
+		}
+	}
+}
+
+func TestSetCoverURLs(t *testing.T) {
+	withCover, withoutCover := primitive.NewObjectID(), primitive.NewObjectID()
+	tracks := &fakeTracks{tracks: []*model.MusicTrack{{ID: withCover}}}
+	s := &Playlist{musicTrackCollection: tracks}
+
+	covered := &model.Playlist{Tracks: []*model.PlaylistTrack{{TrackID: withCover}, {TrackID: withoutCover}}}
+	plain := &model.Playlist{Tracks: []*model.PlaylistTrack{{TrackID: withoutCover}, {TrackID: withCover}}}
+	s.setCoverURLs(context.Background(), covered, plain, &model.Playlist{})
+
+	if want := "/v1/customer/music-tracks/" + withCover.Hex() + "/cover"; covered.CoverURL != want {
+		t.Errorf("cover = %q, want %q", covered.CoverURL, want)
+	}
+	if plain.CoverURL != "" {
+		t.Errorf("cover = %q of a playlist whose first track has none", plain.CoverURL)
+	}
+	// * one query for all playlists, reading the cover only
+	if len(tracks.names) != 1 || strings.Join(tracks.names[0], ",") != "cover" {
+		t.Errorf("tracks read with fields %q, want cover once", tracks.names)
+	}
+}
+
+func TestSetGains(t *testing.T) {
+	albumGain, albumPeak := -4.0, -2.0
+	loudness := func(checksum string, gain, peak float64) *model.Loudness {
+		return &model.Loudness{Checksum: checksum, TrackGain: gain, TruePeak: peak, AlbumGain: &albumGain, AlbumPeak: &albumPeak}
+	}
+	a, b, stale := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	tracks := &fakeTracks{tracks: []*model.MusicTrack{
+		{ID: a, Album: "X", Audio: &model.AudioFile{Checksum: "a"}, Loudness: loudness("a", -6, -3)},
+		{ID: b, Album: "X", Loudness: loudness("", 3, -0.5)},
+		// * measured from audio since replaced
+		{ID: stale, Album: "X", Audio: &model.AudioFile{Checksum: "new"}, Loudness: loudness("old", 1, -1)},
+	}}
+	s := &Playlist{musicTrackCollection: tracks}
+
+	gains := func(p *model.Playlist) []float64 {
+		var got []float64
+		for _, t := range p.Tracks {
+			if t.Gain == nil {
+				got = append(got, math.NaN())
+				continue
+			}
+			got = append(got, *t.Gain)
+		}
+		return got
+	}
+
+	// * a playlist of one album keeps its dynamics
+	album := &model.Playlist{Tracks: []*model.PlaylistTrack{{TrackID: a}, {TrackID: b}}}
+	s.setGains(context.Background(), album)
+	if got := gains(album); got[0] != -4 || got[1] != -4 {
+		t.Errorf("gains = %v, want the album gain", got)
+	}
+
+	// * others bring every track to the same loudness, below -1 dBTP
+	mixed := &model.Playlist{Tracks: []*model.PlaylistTrack{{TrackID: a}, {TrackID: b}, {TrackID: stale}}}
+	s.setGains(context.Background(), mixed)
+	if got := gains(mixed); got[0] != -6 || got[1] != -0.5 || !math.IsNaN(got[2]) {
+		t.Errorf("gains = %v, want -6, -0.5 and none", got)
+	}
+
+	for _, names := range tracks.names {
+		if strings.Join(names, ",") != "loudness,audio.checksum,album" {
+			t.Errorf("tracks read with fields %q, want the loudness and what it applies to", names)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	short, long, unknown := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	candidates := []*model.MusicTrack{
+		{ID: unknown},
+		{ID: short, Duration: 180},
+		{ID: long, DurationMs: 241500},
+	}
+
+	tests := []struct {
+		name     string
+		duration int64
+		tracks   []*model.MusicTrack
+		want     primitive.ObjectID
+	}{
+		{"closest duration", 240000, candidates, long},
+		{"whole seconds", 181000, candidates, short},
+		{"only a track without duration", 200000, candidates[:1], unknown},
+		{"entry without duration", playlistio.UnknownDuration, candidates[1:], short},
+		{"too far", 300000, candidates[1:], primitive.NilObjectID},
+	}
+	for _, tt := range tests {
+		tracks := &fakeTracks{tracks: tt.tracks}
+		s := &Playlist{musicTrackCollection: tracks}
+		got, err := s.closest(context.Background(), bson.M{"title": "x"}, tt.duration)
+		if err != nil {
+			t.Fatalf("%s: closest() error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: closest() = %s, want %s", tt.name, got.Hex(), tt.want.Hex())
+		}
+		if len(tracks.names) != 1 || strings.Join(tracks.names[0], ",") != "duration,duration_ms" {
+			t.Errorf("%s: tracks read with fields %q, want the durations only", tt.name, tracks.names)
+		}
+	}
+}
+
+func TestDiffTracks(t *testing.T) {
+	ids := map[string]primitive.ObjectID{}
+	for _, name := range strings.Split("abcde", "") {
+		ids[name] = primitive.NewObjectID()
+	}
+	names := map[string]string{}
+	for name, id := range ids {
+		names[id.Hex()] = name
+	}
+	tracks := func(order string) []*model.PlaylistTrack {
+		result := []*model.PlaylistTrack{}
+		for _, name := range strings.Split(order, "") {
+			if name != "" {
+				result = append(result, &model.PlaylistTrack{TrackID: ids[name]})
+			}
+		}
+		return result
+	}
+	changes := func(list []*TrackChange) string {
+		parts := []string{}
+		for _, c := range list {
+			part := names[c.TrackID]
+			if c.From != nil {
+				part += fmt.Sprintf(" from %d", *c.From)
+			}
+			if c.To != nil {
+				part += fmt.Sprintf(" to %d", *c.To)
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	tests := []struct {
+		name                  string
+		from, to              string
+		added, removed, moved string
+	}{
+		{"unchanged", "abc", "abc", "", "", ""},
+		{"added at the end", "ab", "abc", "c to 2", "", ""},
+		{"added in between", "ac", "abc", "b to 1", "", ""},
+		{"removed", "abc", "ac", "", "b from 1", ""},
+		{"moved to the end", "abc", "bca", "", "", "a from 0 to 2"},
+		{"moved to the start", "abc", "cab", "", "", "c from 2 to 0"},
+		// * of two equally short ways to describe a move, the earlier tracks stay
+		{"range moved", "abcde", "adebc", "", "", "d from 3 to 1, e from 4 to 2"},
+		{"moved and removed", "abcd", "dab", "", "c from 2", "d from 3 to 0"},
+		{"duplicate matched in order", "aba", "aab", "", "", "a from 2 to 1"},
+		{"emptied", "ab", "", "", "a from 0, b from 1", ""},
+		{"filled", "", "ab", "a to 0, b to 1", "", ""},
+	}
+	for _, tt := range tests {
+		added, removed, moved := diffTracks(tracks(tt.from), tracks(tt.to))
+		if got := changes(added); got != tt.added {
+			t.Errorf("%s: added = %q, want %q", tt.name, got, tt.added)
+		}
+		if got := changes(removed); got != tt.removed {
+			t.Errorf("%s: removed = %q, want %q", tt.name, got, tt.removed)
+		}
+		if got := changes(moved); got != tt.moved {
+			t.Errorf("%s: moved = %q, want %q", tt.name, got, tt.moved)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	v := server.NewValidator()
+	check := func(conds ...*model.PlaylistCondition) error {
+		rules := &model.PlaylistRules{Conditions: conds}
+		if err := v.Validate(rules); err != nil {
+			return err
+		}
+		return checkRules(rules)
+	}
+
+	tests := []struct {
+		name  string
+		cond  model.PlaylistCondition
+		valid bool
+	}{
+		{"eq text", model.PlaylistCondition{Field: "genre", Operator: model.RuleOpEq, Value: "Jazz"}, true},
+		{"ne text", model.PlaylistCondition{Field: "artist", Operator: model.RuleOpNe, Value: "Nobody"}, true},
+		{"eq number", model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpEq, Value: 1999.0}, true},
+		{"gt", model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpGt, Value: 1990.0}, true},
+		{"gte", model.PlaylistCondition{Field: "bitrate", Operator: model.RuleOpGte, Value: 320.0}, true},
+		{"lt", model.PlaylistCondition{Field: "duration", Operator: model.RuleOpLt, Value: 300.0}, true},
+		{"lte", model.PlaylistCondition{Field: "track_number", Operator: model.RuleOpLte, Value: 3.0}, true},
+		{"contains", model.PlaylistCondition{Field: "title", Operator: model.RuleOpContains, Value: "love"}, true},
+		{"contains on a number", model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpContains, Value: 1999.0}, false},
+		{"number field with a string", model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpGt, Value: "1990"}, false},
+		{"text field with a number", model.PlaylistCondition{Field: "genre", Operator: model.RuleOpEq, Value: 1.0}, false},
+		{"not a number", model.PlaylistCondition{Field: "duration", Operator: model.RuleOpLt, Value: math.Inf(1)}, false},
+		{"no value", model.PlaylistCondition{Field: "genre", Operator: model.RuleOpEq}, false},
+		{"list value", model.PlaylistCondition{Field: "genre", Operator: model.RuleOpEq, Value: []interface{}{"Jazz"}}, false},
+		{"unknown field", model.PlaylistCondition{Field: "mp3_file", Operator: model.RuleOpEq, Value: "x"}, false},
+		{"unknown operator", model.PlaylistCondition{Field: "genre", Operator: "regex", Value: "J.*"}, false},
+	}
+	for _, tt := range tests {
+		cond := tt.cond
+		if err := check(&cond); (err == nil) != tt.valid {
+			t.Errorf("%s: check error = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+
+	// * conditions are all matched, so each of them has to hold up
+	jazz := &model.PlaylistCondition{Field: "genre", Operator: model.RuleOpEq, Value: "Jazz"}
+	sixties := &model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpGte, Value: 1960.0}
+	if err := check(jazz, sixties); err != nil {
+		t.Errorf("check of valid conditions error = %v", err)
+	}
+	bad := &model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpContains, Value: "196"}
+	if err := check(jazz, sixties, bad); err == nil {
+		t.Error("check with one invalid condition succeeded")
+	}
+	if err := check(); err == nil {
+		t.Error("check without conditions succeeded")
+	}
+}
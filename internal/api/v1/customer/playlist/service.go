@@ -5,33 +5,66 @@ import (
 	"music-master/internal/model"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // New creates new playlist application service
-func New(PlaylistCollection PlaylistCollection, converter ModelConverter) *Playlist {
+func New(PlaylistCollection PlaylistCollection, musicTrackCollection MusicTrackCollection, revisionCollection RevisionCollection, converter ModelConverter, validator Validator) *Playlist {
 	return &Playlist{
-		playlistCollection: PlaylistCollection,
-		converter:          converter,
+		playlistCollection:   PlaylistCollection,
+		musicTrackCollection: musicTrackCollection,
+		revisionCollection:   revisionCollection,
+		converter:            converter,
+		validator:            validator,
 	}
 }
 
 // Playlist represents playlist application service
 type Playlist struct {
-	playlistCollection PlaylistCollection
-	converter          ModelConverter
+	playlistCollection   PlaylistCollection
+	musicTrackCollection MusicTrackCollection
+	revisionCollection   RevisionCollection
+	converter            ModelConverter
+	validator            Validator
 }
 
 type PlaylistCollection interface {
 	InsertOne(ctx context.Context, data *model.Playlist) (*model.Playlist, error)
 	FindOne(ctx context.Context, where bson.M) (*model.Playlist, error)
+	FindOneFields(ctx context.Context, where bson.M, names []string) (*model.Playlist, error)
 	UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.Playlist, error)
 	FindOneAndUpdate(ctx context.Context, where bson.M, data *model.Playlist) (*model.Playlist, error)
 	RemoveOne(ctx context.Context, where bson.M) error
-	DeleteTrackFromPlaylist(ctx context.Context, playlistID, trackID string) error
-	Search(ctx context.Context, searchQuery string, page, pageSize int) ([]*model.Playlist, error)
+	DeleteTrackFromPlaylist(ctx context.Context, where bson.M, trackID string) (*model.Playlist, error)
+	PushTracks(ctx context.Context, where bson.M, entries []*model.PlaylistTrack, position *int) (*model.Playlist, error)
+	MoveTracks(ctx context.Context, where bson.M, start, length, before int) (*model.Playlist, error)
+	RuleTracks(ctx context.Context, rules *model.PlaylistRules, page, pageSize int) ([]*model.MusicTrack, int64, error)
+	Stats(ctx context.Context, where bson.M) (*model.PlaylistStats, error)
+	RuleStats(ctx context.Context, rules *model.PlaylistRules) (*model.PlaylistStats, error)
+	SetCollaborator(ctx context.Context, where bson.M, collaborator *model.PlaylistCollaborator) (*model.Playlist, error)
+	RemoveCollaborator(ctx context.Context, where bson.M, userID string) error
+	Search(ctx context.Context, where bson.M, searchQuery string, page, pageSize int, names []string) ([]*model.Playlist, error)
+}
+
+type MusicTrackCollection interface {
+	FindIDs(ctx context.Context, where bson.M) ([]primitive.ObjectID, error)
+	EachFields(ctx context.Context, where bson.M, names []string, fn func(rec *model.MusicTrack) error) error
+}
+
+type RevisionCollection interface {
+	InsertOne(ctx context.Context, data *model.PlaylistRevision) (*model.PlaylistRevision, error)
+	InsertBaseline(ctx context.Context, rec *model.Playlist) error
+	FindOne(ctx context.Context, where bson.M) (*model.PlaylistRevision, error)
+	Previous(ctx context.Context, rev *model.PlaylistRevision) (*model.PlaylistRevision, error)
+	List(ctx context.Context, playlistID primitive.ObjectID, page, pageSize int) ([]*model.PlaylistRevision, int64, error)
+	RemoveMany(ctx context.Context, where bson.M) error
 }
 
 type ModelConverter interface {
 	FromModel(to interface{}, from interface{})
 	ToModel(to interface{}, from interface{})
 }
+
+type Validator interface {
+	Validate(i interface{}) error
+}
@@ -0,0 +1,347 @@
+package upload
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"music-master/internal/model"
+	"music-master/internal/util/server"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	httputil "music-master/internal/util/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Tus protocol details
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,expiration"
+
+	offsetContentType = "application/offset+octet-stream"
+)
+
+// Upload-Metadata keys understood when a completed upload is attached to a music track
+const (
+	MetadataFilename    = "filename"
+	MetadataFiletype    = "filetype"
+	MetadataTrackID     = "track_id"
+	MetadataTitle       = "title"
+	MetadataArtist      = "artist"
+	MetadataAlbum       = "album"
+	MetadataGenre       = "genre"
+	MetadataOnDuplicate = "on_duplicate"
+)
+
+// HTTP represents upload http service
+type HTTP struct {
+	svc  Service
+	auth model.Auth
+}
+
+// Service represents upload application interface
+type Service interface {
+	Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.Upload, error)
+	View(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Upload, error)
+	Write(ctx context.Context, authUsr *model.AuthUser, id string, offset int64, body io.Reader) (*model.Upload, error)
+	Delete(ctx context.Context, authUsr *model.AuthUser, id string) error
+	MaxSize() int64
+}
+
+// NewHTTP creates new upload http service
+func NewHTTP(svc Service, auth model.Auth, eg *echo.Group) {
+	h := HTTP{svc, auth}
+
+	eg.Use(tusResumable)
+
+	// swagger:operation OPTIONS /v1/customer/uploads customer-uploads customerUploadOptions
+	// ---
+	// summary: Reports the tus protocol version, extensions and maximum upload size
+	// responses:
+	//   "204":
+	//     description: Tus-Version, Tus-Extension and Tus-Max-Size headers
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	eg.OPTIONS("", h.options)
+
+	// swagger:operation POST /v1/customer/uploads customer-uploads customerUploadCreate
+	// ---
+	// summary: Starts a resumable upload (tus creation extension)
+	// description: |
+	//   Upload-Metadata may carry filename, filetype, track_id (to replace the audio of a track),
+	//   or title, artist, album, genre and on_duplicate (to create a new track).
+	// parameters:
+	// - name: Tus-Resumable
+	//   in: header
+	//   type: string
+	//   required: true
+	// - name: Upload-Length
+	//   in: header
+	//   description: size of the file in bytes
+	//   type: integer
+	//   required: true
+	// - name: Upload-Metadata
+	//   in: header
+	//   description: comma separated key and base64 encoded value pairs
+	//   type: string
+	// responses:
+	//   "201":
+	//     description: Location header with the url of the upload
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "412":
+	//     "$ref": "#/responses/errDetails"
+	//   "413":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.POST("", h.create)
+
+	// swagger:operation HEAD /v1/customer/uploads/{id} customer-uploads customerUploadView
+	// ---
+	// summary: Returns the offset of a resumable upload
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of upload
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     description: Upload-Offset and Upload-Length headers, Music-Track-Id once the file is attached
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "410":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.HEAD("/:id", h.view)
+
+	// swagger:operation PATCH /v1/customer/uploads/{id} customer-uploads customerUploadWrite
+	// ---
+	// summary: Appends bytes to a resumable upload
+	// description: |
+	//   Once all bytes are received the file is attached to a music track. Only the owner of a
+	//   track replaces its audio, tracks from before owners take audio from anyone signed in.
+	// consumes:
+	// - application/offset+octet-stream
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of upload
+	//   type: string
+	//   required: true
+	// - name: Upload-Offset
+	//   in: header
+	//   description: offset the bytes start at
+	//   type: integer
+	//   required: true
+	// - name: body
+	//   in: body
+	//   required: true
+	//   schema:
+	//     type: string
+	//     format: binary
+	// responses:
+	//   "204":
+	//     description: Upload-Offset header with the new offset, Music-Track-Id once the file is attached
+	//   "400":
+	//     "$ref": "#/responses/errDetails"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "403":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "409":
+	//     "$ref": "#/responses/errDetails"
+	//   "410":
+	//     "$ref": "#/responses/errDetails"
+	//   "415":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.PATCH("/:id", h.write)
+
+	// swagger:operation DELETE /v1/customer/uploads/{id} customer-uploads customerUploadDelete
+	// ---
+	// summary: Terminates a resumable upload (tus termination extension)
+	// parameters:
+	// - name: id
+	//   in: path
+	//   description: id of upload
+	//   type: string
+	//   required: true
+	// responses:
+	//   "204":
+	//     "$ref": "#/responses/ok"
+	//   "401":
+	//     "$ref": "#/responses/errDetails"
+	//   "404":
+	//     "$ref": "#/responses/errDetails"
+	//   "500":
+	//     "$ref": "#/responses/errDetails"
+	eg.DELETE("/:id", h.delete)
+}
+
+// CreationData contains upload data from tus request headers
+type CreationData struct {
+	Length   int64
+	Metadata map[string]string
+}
+
+// tusResumable rejects requests made for another version of the tus protocol
+func tusResumable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", TusVersion)
+		if c.Request().Method != http.MethodOptions && c.Request().Header.Get("Tus-Resumable") != TusVersion {
+			c.Response().Header().Set("Tus-Version", TusVersion)
+			return server.NewHTTPError(http.StatusPreconditionFailed, server.GenericErrorType, "Unsupported Tus-Resumable version")
+		}
+		return next(c)
+	}
+}
+
+func (h *HTTP) options(c echo.Context) error {
+	c.Response().Header().Set("Tus-Version", TusVersion)
+	c.Response().Header().Set("Tus-Extension", TusExtensions)
+	c.Response().Header().Set("Tus-Max-Size", strconv.FormatInt(h.svc.MaxSize(), 10))
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HTTP) create(c echo.Context) error {
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		return server.NewHTTPValidationError("Invalid Upload-Length")
+	}
+	metadata, err := parseMetadata(c.Request().Header.Get("Upload-Metadata"))
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.svc.Create(c.Request().Context(), h.auth.User(c), CreationData{Length: length, Metadata: metadata})
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Location", fmt.Sprintf("%s/%s", strings.TrimSuffix(c.Request().URL.Path, "/"), resp.ID.Hex()))
+	setUploadHeaders(c, resp)
+
+	return c.NoContent(http.StatusCreated)
+}
+
+func (h *HTTP) view(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	resp, err := h.svc.View(c.Request().Context(), h.auth.User(c), id)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(resp.Length, 10))
+	if len(resp.Metadata) > 0 {
+		c.Response().Header().Set("Upload-Metadata", formatMetadata(resp.Metadata))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	setUploadHeaders(c, resp)
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *HTTP) write(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	if c.Request().Header.Get(echo.HeaderContentType) != offsetContentType {
+		return server.NewHTTPError(http.StatusUnsupportedMediaType, server.GenericErrorType, "Content-Type must be "+offsetContentType)
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return server.NewHTTPValidationError("Invalid Upload-Offset")
+	}
+
+	resp, err := h.svc.Write(c.Request().Context(), h.auth.User(c), id, offset, c.Request().Body)
+	if err != nil {
+		return err
+	}
+
+	setUploadHeaders(c, resp)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HTTP) delete(c echo.Context) error {
+	id, err := httputil.ReqID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.svc.Delete(c.Request().Context(), h.auth.User(c), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// setUploadHeaders sets the headers describing the state of an upload
+func setUploadHeaders(c echo.Context, rec *model.Upload) {
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(rec.Offset, 10))
+	c.Response().Header().Set("Upload-Expires", rec.ExpiresAt.UTC().Format(http.TimeFormat))
+	if !rec.TrackID.IsZero() {
+		c.Response().Header().Set("Music-Track-Id", rec.TrackID.Hex())
+	}
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma separated pairs of a key and
+// an optional base64 encoded value
+func parseMetadata(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, server.NewHTTPValidationError("Invalid Upload-Metadata")
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, server.NewHTTPValidationError("Invalid Upload-Metadata value of " + fields[0])
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+
+	return metadata, nil
+}
+
+// formatMetadata encodes metadata as an Upload-Metadata header
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + " " + base64.StdEncoding.EncodeToString([]byte(metadata[k]))
+	}
+
+	return strings.Join(pairs, ",")
+}
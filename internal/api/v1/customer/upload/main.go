@@ -0,0 +1,309 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"music-master/internal/api/v1/customer/musictrack"
+	"music-master/internal/model"
+	"music-master/internal/util/server"
+	"net/http"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const chunkContentType = "application/octet-stream"
+
+// chunkTimeout bounds storing the bytes of a PATCH, which goes on after the client is gone
+const chunkTimeout = 5 * time.Minute
+
+var (
+	errUploadNotFound = server.NewHTTPError(http.StatusNotFound, server.GenericErrorType, "Upload not found")
+	errUploadExpired  = server.NewHTTPError(http.StatusGone, server.GenericErrorType, "Upload has expired")
+	errOffsetConflict = server.NewHTTPConflictError("Upload-Offset does not match the offset of the upload")
+	errForbidden      = server.NewHTTPError(http.StatusForbidden, server.GenericErrorType, "Uploads belong to signed in users")
+)
+
+// Create starts a new resumable upload of the user
+func (s *Upload) Create(ctx context.Context, authUsr *model.AuthUser, data CreationData) (*model.Upload, error) {
+	if authUsr == nil {
+		return nil, errForbidden
+	}
+	if data.Length <= 0 {
+		return nil, server.NewHTTPValidationError("Upload-Length must be positive")
+	}
+	if data.Length > s.maxSize {
+		return nil, server.NewHTTPError(http.StatusRequestEntityTooLarge, server.GenericErrorType, fmt.Sprintf("Uploads are limited to %d bytes", s.maxSize))
+	}
+	if id, ok := data.Metadata[MetadataTrackID]; ok {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			return nil, server.NewHTTPValidationError("track_id metadata is not a valid id")
+		}
+	}
+	if v := data.Metadata[MetadataOnDuplicate]; v != "" && v != musictrack.OnDuplicateError && v != musictrack.OnDuplicateReturn {
+		return nil, server.NewHTTPValidationError("on_duplicate metadata must be error or return")
+	}
+
+	now := time.Now().UTC()
+	rec := &model.Upload{
+		Length:    data.Length,
+		Metadata:  data.Metadata,
+		OwnerID:   authUsr.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	return s.uploadCollection.InsertOne(ctx, rec)
+}
+
+// View returns single Upload of the user. Uploads of others are not found, so their
+// ids cannot be probed.
+func (s *Upload) View(ctx context.Context, authUsr *model.AuthUser, id string) (*model.Upload, error) {
+	if authUsr == nil {
+		return nil, errForbidden
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errUploadNotFound
+	}
+
+	rec, err := s.uploadCollection.FindOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errUploadNotFound
+		}
+		return nil, err
+	}
+	if rec.OwnerID != authUsr.ID {
+		return nil, errUploadNotFound
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		return nil, errUploadExpired
+	}
+
+	return rec, nil
+}
+
+// Write appends the bytes of body to an upload at offset. Bytes received before the
+// client disconnects are kept, so the upload resumes from there. Once all bytes are in,
+// the file is attached to a music track.
+func (s *Upload) Write(ctx context.Context, authUsr *model.AuthUser, id string, offset int64, body io.Reader) (*model.Upload, error) {
+	rec, err := s.View(ctx, authUsr, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Offset != offset {
+		return nil, errOffsetConflict
+	}
+	if rec.Offset == rec.Length {
+		// * an empty PATCH at the end retries attaching a complete upload
+		if rec.TrackID.IsZero() {
+			return s.attach(ctx, authUsr, rec)
+		}
+		return rec, nil
+	}
+
+	// * storage needs the size up front, the request body may come without one
+	spool, err := os.CreateTemp("", "upload-chunk-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	n, readErr := io.Copy(spool, io.LimitReader(body, rec.Length-rec.Offset))
+	if n > 0 {
+		// * ctx is canceled once the client disconnects, the bytes that arrived are
+		// stored regardless so the upload resumes after them
+		storeCtx, cancel := context.WithTimeout(context.Background(), chunkTimeout)
+		rec, err = s.appendChunk(storeCtx, rec, spool, n)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if rec.Offset == rec.Length {
+		return s.attach(ctx, authUsr, rec)
+	}
+
+	return rec, nil
+}
+
+// Delete terminates an upload and removes its chunks
+func (s *Upload) Delete(ctx context.Context, authUsr *model.AuthUser, id string) error {
+	rec, err := s.View(ctx, authUsr, id)
+	if err != nil {
+		return err
+	}
+
+	return s.remove(ctx, rec)
+}
+
+// MaxSize returns the largest upload accepted, in bytes
+func (s *Upload) MaxSize() int64 {
+	return s.maxSize
+}
+
+// ExpireUploads removes the uploads that were not finished in time, with their chunks
+func (s *Upload) ExpireUploads(ctx context.Context) (int, error) {
+	var expired []*model.Upload
+	err := s.uploadCollection.Each(ctx, bson.M{"expires_at": bson.M{"$lt": time.Now().UTC()}}, func(rec *model.Upload) error {
+		expired = append(expired, rec)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rec := range expired {
+		if err := s.remove(ctx, rec); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(expired), nil
+}
+
+// RunJanitor expires abandoned uploads every interval until ctx is done
+func (s *Upload) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.ExpireUploads(ctx)
+			if err != nil {
+				fmt.Println("Error expiring uploads", err)
+			} else if n > 0 {
+				fmt.Println("expired uploads:", n)
+			}
+		}
+	}
+}
+
+// appendChunk stores n spooled bytes and records them as the next chunk of rec
+func (s *Upload) appendChunk(ctx context.Context, rec *model.Upload, spool *os.File, n int64) (*model.Upload, error) {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// * a unique key per write keeps concurrent writes at the same offset apart
+	chunk := model.UploadChunk{
+		Key:    fmt.Sprintf("uploads/%s/%d-%s", rec.ID.Hex(), rec.Offset, primitive.NewObjectID().Hex()),
+		Offset: rec.Offset,
+		Size:   n,
+	}
+	if err := s.chunkStorage.Put(ctx, chunk.Key, spool, n, chunkContentType); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.uploadCollection.AppendChunk(ctx, rec.ID, rec.Offset, chunk, bson.M{"expires_at": time.Now().UTC().Add(s.ttl)})
+	if err != nil {
+		s.deleteChunk(ctx, chunk.Key)
+		if err == mongo.ErrNoDocuments {
+			return nil, errOffsetConflict
+		}
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// attach hands the completed file of rec to the music track service: as new audio of
+// the track named by the track_id metadata, or as a new track otherwise. Files the
+// track service rejects are dropped, the client has to upload a different file.
+func (s *Upload) attach(ctx context.Context, authUsr *model.AuthUser, rec *model.Upload) (*model.Upload, error) {
+	file, err := s.assemble(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	audioFile := musictrack.AudioUpload{
+		Filename:    rec.Metadata[MetadataFilename],
+		ContentType: rec.Metadata[MetadataFiletype],
+		Size:        rec.Length,
+		Content:     file,
+	}
+	var track *model.MusicTrack
+	if id := rec.Metadata[MetadataTrackID]; id != "" {
+		track, err = s.trackService.UploadAudio(ctx, authUsr, id, audioFile)
+	} else {
+		track, err = s.trackService.CreateWithAudio(ctx, authUsr, musictrack.CreationData{
+			Title:       rec.Metadata[MetadataTitle],
+			Artist:      rec.Metadata[MetadataArtist],
+			Album:       rec.Metadata[MetadataAlbum],
+			Genre:       rec.Metadata[MetadataGenre],
+			OnDuplicate: rec.Metadata[MetadataOnDuplicate],
+		}, audioFile)
+	}
+	if err != nil {
+		if he, ok := err.(*server.HTTPError); ok && he.Code < http.StatusInternalServerError {
+			if removeErr := s.remove(ctx, rec); removeErr != nil {
+				fmt.Println("Error removing rejected upload", rec.ID.Hex(), removeErr)
+			}
+		}
+		return nil, err
+	}
+
+	// * the record stays until it expires so the client can look up the track
+	for _, c := range rec.Chunks {
+		s.deleteChunk(ctx, c.Key)
+	}
+
+	return s.uploadCollection.UpdateOne(ctx, bson.M{"_id": rec.ID}, bson.M{"track_id": track.ID, "chunks": []model.UploadChunk{}})
+}
+
+// assemble concatenates the chunks of rec into a temporary file
+func (s *Upload) assemble(ctx context.Context, rec *model.Upload) (*os.File, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*os.File, error) {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	for _, c := range rec.Chunks {
+		chunk, err := s.chunkStorage.Open(ctx, c.Key)
+		if err != nil {
+			return fail(fmt.Errorf("open chunk %s: %w", c.Key, err))
+		}
+		_, err = io.Copy(file, chunk)
+		chunk.Close()
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	return file, nil
+}
+
+// remove deletes the chunks of rec and then rec itself
+func (s *Upload) remove(ctx context.Context, rec *model.Upload) error {
+	for _, c := range rec.Chunks {
+		s.deleteChunk(ctx, c.Key)
+	}
+
+	return s.uploadCollection.RemoveOne(ctx, bson.M{"_id": rec.ID})
+}
+
+// deleteChunk removes a chunk, a failure leaves an orphaned object which is only logged
+func (s *Upload) deleteChunk(ctx context.Context, key string) {
+	if err := s.chunkStorage.Delete(ctx, key); err != nil {
+		fmt.Println("Error deleting upload chunk", key, err)
+	}
+}
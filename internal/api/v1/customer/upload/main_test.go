@@ -0,0 +1,309 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"music-master/internal/api/v1/customer/musictrack"
+	"music-master/internal/model"
+	"music-master/internal/util/server"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeUploads keeps uploads in memory and, like the driver, fails on a done context
+type fakeUploads struct {
+	mu      sync.Mutex
+	uploads map[primitive.ObjectID]model.Upload
+}
+
+func (f *fakeUploads) InsertOne(ctx context.Context, data *model.Upload) (*model.Upload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data.ID = primitive.NewObjectID()
+	f.uploads[data.ID] = *data
+
+	return data, nil
+}
+
+func (f *fakeUploads) FindOne(ctx context.Context, where bson.M) (*model.Upload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec, ok := f.uploads[where["_id"].(primitive.ObjectID)]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return &rec, nil
+}
+
+func (f *fakeUploads) UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.Upload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := where["_id"].(primitive.ObjectID)
+	rec, ok := f.uploads[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	if trackID, ok := updateData["track_id"].(primitive.ObjectID); ok {
+		rec.TrackID = trackID
+	}
+	if chunks, ok := updateData["chunks"].([]model.UploadChunk); ok {
+		rec.Chunks = chunks
+	}
+	f.uploads[id] = rec
+
+	return &rec, nil
+}
+
+func (f *fakeUploads) AppendChunk(ctx context.Context, id primitive.ObjectID, offset int64, chunk model.UploadChunk, updateData bson.M) (*model.Upload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec, ok := f.uploads[id]
+	if !ok || rec.Offset != offset {
+		return nil, mongo.ErrNoDocuments
+	}
+	rec.Chunks = append(rec.Chunks, chunk)
+	rec.Offset += chunk.Size
+	f.uploads[id] = rec
+
+	return &rec, nil
+}
+
+func (f *fakeUploads) RemoveOne(ctx context.Context, where bson.M) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.uploads, where["_id"].(primitive.ObjectID))
+
+	return nil
+}
+
+func (f *fakeUploads) Each(ctx context.Context, where bson.M, fn func(rec *model.Upload) error) error {
+	return nil
+}
+
+// fakeChunks keeps chunks in memory and, like a network store, fails on a done context
+type fakeChunks struct {
+	mu     sync.Mutex
+	chunks map[string][]byte
+}
+
+func (f *fakeChunks) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chunks[key] = data
+
+	return nil
+}
+
+func (f *fakeChunks) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return nopCloser{bytes.NewReader(f.chunks[key])}, nil
+}
+
+func (f *fakeChunks) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.chunks, key)
+
+	return nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// fakeTracks records the files of completed uploads and who attached them
+type fakeTracks struct {
+	files [][]byte
+	users []string
+}
+
+func (f *fakeTracks) CreateWithAudio(ctx context.Context, authUsr *model.AuthUser, data musictrack.CreationData, file musictrack.AudioUpload) (*model.MusicTrack, error) {
+	content, err := io.ReadAll(io.NewSectionReader(file.Content, 0, file.Size))
+	if err != nil {
+		return nil, err
+	}
+	f.files = append(f.files, content)
+	f.users = append(f.users, authUsr.ID)
+
+	return &model.MusicTrack{ID: primitive.NewObjectID()}, nil
+}
+
+func (f *fakeTracks) UploadAudio(ctx context.Context, authUsr *model.AuthUser, id string, data musictrack.AudioUpload) (*model.MusicTrack, error) {
+	return f.CreateWithAudio(ctx, authUsr, musictrack.CreationData{}, data)
+}
+
+// disconnectingBody returns its data and then fails the way a request body does when
+// the client goes away, canceling the request context first
+type disconnectingBody struct {
+	data   []byte
+	cancel context.CancelFunc
+}
+
+func (b *disconnectingBody) Read(p []byte) (int, error) {
+	if len(b.data) == 0 {
+		b.cancel()
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+
+	return n, nil
+}
+
+// headerAuth takes the user from the X-User header, anonymous without one
+type headerAuth struct{}
+
+func (headerAuth) User(c echo.Context) *model.AuthUser {
+	if id := c.Request().Header.Get("X-User"); id != "" {
+		return &model.AuthUser{ID: id}
+	}
+
+	return nil
+}
+
+func tusRequest(ctx context.Context, method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body).WithContext(ctx)
+	req.Header.Set("Tus-Resumable", TusVersion)
+	req.Header.Set("X-User", "uploader")
+
+	return req
+}
+
+func TestWriteKeepsBytesOfDisconnectedClient(t *testing.T) {
+	uploads := &fakeUploads{uploads: map[primitive.ObjectID]model.Upload{}}
+	chunks := &fakeChunks{chunks: map[string][]byte{}}
+	tracks := &fakeTracks{}
+	e := echo.New()
+	NewHTTP(New(uploads, chunks, tracks, 1<<20, time.Hour), headerAuth{}, e.Group("/uploads"))
+
+	file := bytes.Repeat([]byte("0123456789"), 10)
+
+	req := tusRequest(context.Background(), http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(file)))
+	res := httptest.NewRecorder()
+	e.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d, want 201", res.Code)
+	}
+	location := res.Header().Get("Location")
+
+	// * the client sends 40 bytes and disconnects
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req = tusRequest(ctx, http.MethodPatch, location, &disconnectingBody{data: file[:40], cancel: cancel})
+	req.Header.Set("Content-Type", offsetContentType)
+	req.Header.Set("Upload-Offset", "0")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = tusRequest(context.Background(), http.MethodHead, location, nil)
+	res = httptest.NewRecorder()
+	e.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("HEAD status = %d, want 200", res.Code)
+	}
+	if got := res.Header().Get("Upload-Offset"); got != "40" {
+		t.Fatalf("HEAD Upload-Offset = %q, want 40", got)
+	}
+
+	// * the upload resumes from there
+	req = tusRequest(context.Background(), http.MethodPatch, location, bytes.NewReader(file[40:]))
+	req.Header.Set("Content-Type", offsetContentType)
+	req.Header.Set("Upload-Offset", "40")
+	res = httptest.NewRecorder()
+	e.ServeHTTP(res, req)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want 204", res.Code)
+	}
+	if res.Header().Get("Upload-Offset") != "100" || res.Header().Get("Music-Track-Id") == "" {
+		t.Errorf("PATCH headers = %v, want offset 100 and the track id", res.Header())
+	}
+	if len(tracks.files) != 1 || !bytes.Equal(tracks.files[0], file) {
+		t.Errorf("attached files = %q, want %q", tracks.files, file)
+	}
+	if len(tracks.users) != 1 || tracks.users[0] != "uploader" {
+		t.Errorf("files attached by %q, want the uploader", tracks.users)
+	}
+	if len(chunks.chunks) != 0 {
+		t.Errorf("%d chunks left after the upload was attached", len(chunks.chunks))
+	}
+}
+
+func TestUploadsOfOthers(t *testing.T) {
+	uploads := &fakeUploads{uploads: map[primitive.ObjectID]model.Upload{}}
+	chunks := &fakeChunks{chunks: map[string][]byte{}}
+	e := echo.New()
+	e.HTTPErrorHandler = server.NewErrorHandler(e).Handle
+	NewHTTP(New(uploads, chunks, &fakeTracks{}, 1<<20, time.Hour), headerAuth{}, e.Group("/uploads"))
+
+	req := tusRequest(context.Background(), http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", "100")
+	res := httptest.NewRecorder()
+	e.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d, want 201", res.Code)
+	}
+	location := res.Header().Get("Location")
+	for _, rec := range uploads.uploads {
+		if rec.OwnerID != "uploader" {
+			t.Errorf("upload owner = %q, want the uploader", rec.OwnerID)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		target string
+		want   int
+	}{
+		{"anonymous create", "", http.MethodPost, "/uploads", http.StatusForbidden},
+		{"anonymous offset", "", http.MethodHead, location, http.StatusForbidden},
+		{"offset of another user", "someone", http.MethodHead, location, http.StatusNotFound},
+		{"write of another user", "someone", http.MethodPatch, location, http.StatusNotFound},
+		{"delete of another user", "someone", http.MethodDelete, location, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := tusRequest(context.Background(), tt.method, tt.target, bytes.NewReader([]byte("0123456789")))
+		req.Header.Set("X-User", tt.user)
+		req.Header.Set("Upload-Length", "100")
+		req.Header.Set("Content-Type", offsetContentType)
+		req.Header.Set("Upload-Offset", "0")
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, req)
+		if res.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, res.Code, tt.want)
+		}
+	}
+
+	// * the upload is untouched
+	if len(uploads.uploads) != 1 || len(chunks.chunks) != 0 {
+		t.Errorf("%d uploads and %d chunks left, want the upload without bytes", len(uploads.uploads), len(chunks.chunks))
+	}
+}
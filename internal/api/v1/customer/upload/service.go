@@ -0,0 +1,57 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"music-master/internal/api/v1/customer/musictrack"
+	"music-master/internal/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// New creates new upload application service
+func New(uploadCollection UploadCollection,
+	chunkStorage ChunkStorage,
+	trackService TrackService,
+	maxSize int64,
+	ttl time.Duration) *Upload {
+	return &Upload{
+		uploadCollection: uploadCollection,
+		chunkStorage:     chunkStorage,
+		trackService:     trackService,
+		maxSize:          maxSize,
+		ttl:              ttl,
+	}
+}
+
+// Upload represents resumable upload application service
+type Upload struct {
+	uploadCollection UploadCollection
+	chunkStorage     ChunkStorage
+	trackService     TrackService
+	maxSize          int64
+	ttl              time.Duration
+}
+
+type UploadCollection interface {
+	InsertOne(ctx context.Context, data *model.Upload) (*model.Upload, error)
+	FindOne(ctx context.Context, where bson.M) (*model.Upload, error)
+	UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.Upload, error)
+	AppendChunk(ctx context.Context, id primitive.ObjectID, offset int64, chunk model.UploadChunk, updateData bson.M) (*model.Upload, error)
+	RemoveOne(ctx context.Context, where bson.M) error
+	Each(ctx context.Context, where bson.M, fn func(rec *model.Upload) error) error
+}
+
+type ChunkStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// TrackService receives the files of completed uploads
+type TrackService interface {
+	CreateWithAudio(ctx context.Context, authUsr *model.AuthUser, data musictrack.CreationData, file musictrack.AudioUpload) (*model.MusicTrack, error)
+	UploadAudio(ctx context.Context, authUsr *model.AuthUser, id string, data musictrack.AudioUpload) (*model.MusicTrack, error)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -14,9 +15,17 @@ import (
 )
 
 type Database struct {
-	client     *mongo.Client
-	musicTrack *mongo.Collection
-	playlist   *mongo.Collection
+	client      *mongo.Client
+	database    *mongo.Database
+	musicTrack  *mongo.Collection
+	playlist    *mongo.Collection
+	waveform    *mongo.Collection
+	upload      *mongo.Collection
+	hlsIndex    *mongo.Collection
+	fingerprint *mongo.Collection
+
+	playlistRevision *mongo.Collection
+	folder           *mongo.Collection
 }
 
 func New(cfg *config.Configuration) (*Database, error) {
@@ -36,9 +45,17 @@ func New(cfg *config.Configuration) (*Database, error) {
 	mongoDB := client.Database(cfg.DBName)
 
 	db := &Database{
-		client:     client,
-		musicTrack: mongoDB.Collection(model.MusicTrack{}.TableName()),
-		playlist:   mongoDB.Collection(model.Playlist{}.TableName()),
+		client:      client,
+		database:    mongoDB,
+		musicTrack:  mongoDB.Collection(model.MusicTrack{}.TableName()),
+		playlist:    mongoDB.Collection(model.Playlist{}.TableName()),
+		waveform:    mongoDB.Collection(model.Waveform{}.TableName()),
+		upload:      mongoDB.Collection(model.Upload{}.TableName()),
+		hlsIndex:    mongoDB.Collection(model.HLSIndex{}.TableName()),
+		fingerprint: mongoDB.Collection(model.Fingerprint{}.TableName()),
+
+		playlistRevision: mongoDB.Collection(model.PlaylistRevision{}.TableName()),
+		folder:           mongoDB.Collection(model.Folder{}.TableName()),
 	}
 
 	db.CreateIndexes()
@@ -46,37 +63,31 @@ func New(cfg *config.Configuration) (*Database, error) {
 	return db, nil
 }
 
+// Bucket returns the GridFS bucket with the given name
+func (s *Database) Bucket(name string) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(s.database, options.GridFSBucket().SetName(name))
+}
+
 func (s *Database) Disconnect() {
 	s.client.Disconnect(context.Background())
 }
 
+// ExecTx runs fn in a transaction, committed when fn succeeds and aborted otherwise.
+// fn is run again on transient errors, e.g. a write conflict with another transaction.
+// Transactions need MongoDB to run as a replica set.
 func (s *Database) ExecTx(ctx context.Context, fn func(sessionCtx mongo.SessionContext) error) error {
 	wc := writeconcern.New(writeconcern.WMajority())
 	rc := readconcern.Snapshot()
 	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
 	session, err := s.client.StartSession()
-
-	if err := mongo.WithSession(ctx, session, func(sessionContext mongo.SessionContext) error {
-		if err = session.StartTransaction(txnOpts); err != nil {
-			return err
-		}
-
-		if err := fn(sessionContext); err != nil {
-			return err
-		}
-
-		if err = session.CommitTransaction(sessionContext); err != nil {
-			return err
-		}
-
-		return nil
-	}); err != nil {
-		if abortErr := session.AbortTransaction(ctx); abortErr != nil {
-			return err
-		}
-
+	if err != nil {
 		return err
 	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionContext)
+	}, txnOpts)
 
-	return nil
+	return err
 }
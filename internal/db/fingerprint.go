@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FingerprintCollection struct {
+	db *Database
+}
+
+func NewFingerprintCollection(db *Database) *FingerprintCollection {
+	return &FingerprintCollection{
+		db: db,
+	}
+}
+
+func (c *FingerprintCollection) FindOne(ctx context.Context, where bson.M) (*model.Fingerprint, error) {
+	result := &model.Fingerprint{}
+	if err := c.db.fingerprint.FindOne(ctx, where).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Upsert stores the fingerprint of a track, replacing the one computed before
+func (c *FingerprintCollection) Upsert(ctx context.Context, data *model.Fingerprint) (*model.Fingerprint, error) {
+	result := &model.Fingerprint{}
+	opts := options.FindOneAndReplace()
+	opts.SetUpsert(true)
+	opts.SetReturnDocument(options.After)
+	if err := c.db.fingerprint.FindOneAndReplace(ctx, bson.M{"track_id": data.TrackID}, data, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *FingerprintCollection) RemoveOne(ctx context.Context, where bson.M) error {
+	if _, err := c.db.fingerprint.DeleteOne(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Each decodes every fingerprint matching where and passes it to fn, shortest audio
+// first, stopping at the first error
+func (c *FingerprintCollection) Each(ctx context.Context, where bson.M, fn func(rec *model.Fingerprint) error) error {
+	cursor, err := c.db.fingerprint.Find(ctx, where, options.Find().SetSort(bson.D{{Key: "duration", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		rec := &model.Fingerprint{}
+		if err := cursor.Decode(rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FolderCollection struct {
+	db *Database
+}
+
+func NewFolderCollection(db *Database) *FolderCollection {
+	return &FolderCollection{
+		db: db,
+	}
+}
+
+func (c *FolderCollection) InsertOne(ctx context.Context, data *model.Folder) (*model.Folder, error) {
+	result, err := c.db.folder.InsertOne(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, errors.New("invalid objectId")
+	}
+	data.ID = objectID
+
+	return data, nil
+}
+
+func (c *FolderCollection) FindOne(ctx context.Context, where bson.M) (*model.Folder, error) {
+	result := &model.Folder{}
+	if err := c.db.folder.FindOne(ctx, where).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Find returns the folders matching where, by name
+func (c *FolderCollection) Find(ctx context.Context, where bson.M) ([]*model.Folder, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	cursor, err := c.db.folder.Find(ctx, where, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []*model.Folder{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *FolderCollection) UpdateOne(ctx context.Context, where bson.M, update bson.M) (*model.Folder, error) {
+	result := &model.Folder{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.folder.FindOneAndUpdate(ctx, where, update, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *FolderCollection) UpdateMany(ctx context.Context, where bson.M, update bson.M) error {
+	if _, err := c.db.folder.UpdateMany(ctx, where, update); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *FolderCollection) RemoveMany(ctx context.Context, where bson.M) error {
+	if _, err := c.db.folder.DeleteMany(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
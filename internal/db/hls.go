@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type HLSIndexCollection struct {
+	db *Database
+}
+
+func NewHLSIndexCollection(db *Database) *HLSIndexCollection {
+	return &HLSIndexCollection{
+		db: db,
+	}
+}
+
+func (c *HLSIndexCollection) FindOne(ctx context.Context, where bson.M) (*model.HLSIndex, error) {
+	result := &model.HLSIndex{}
+	if err := c.db.hlsIndex.FindOne(ctx, where).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Upsert stores the segment index of a track, replacing the one computed before
+func (c *HLSIndexCollection) Upsert(ctx context.Context, data *model.HLSIndex) (*model.HLSIndex, error) {
+	result := &model.HLSIndex{}
+	opts := options.FindOneAndReplace()
+	opts.SetUpsert(true)
+	opts.SetReturnDocument(options.After)
+	if err := c.db.hlsIndex.FindOneAndReplace(ctx, bson.M{"track_id": data.TrackID}, data, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *HLSIndexCollection) RemoveOne(ctx context.Context, where bson.M) error {
+	if _, err := c.db.hlsIndex.DeleteOne(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/bsonx"
@@ -12,6 +13,13 @@ import (
 func (d *Database) CreateIndexes() {
 	ctx := context.Background()
 	d.createMusicTrackIndexes(ctx)
+	d.createPlaylistIndexes(ctx)
+	d.createWaveformIndexes(ctx)
+	d.createUploadIndexes(ctx)
+	d.createHLSIndexIndexes(ctx)
+	d.createFingerprintIndexes(ctx)
+	d.createPlaylistRevisionIndexes(ctx)
+	d.createFolderIndexes(ctx)
 }
 
 func (d *Database) createMusicTrackIndexes(ctx context.Context) {
@@ -32,9 +40,133 @@ func (d *Database) createMusicTrackIndexes(ctx context.Context) {
 			Keys:    bsonx.Doc{{Key: "genre", Value: bsonx.Int32(3)}},
 			Options: options.Index().SetUnique(false),
 		},
+		{
+			// * fails while duplicates exist, cmd/report-duplicates lists them
+			Keys: bsonx.Doc{{Key: "audio.checksum", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"audio.checksum": bson.M{"$exists": true}}),
+		},
 	}
 
 	if _, err := d.musicTrack.Indexes().CreateMany(ctx, mods); err != nil {
 		fmt.Println("createMusicTrackIndexes().CreateMany() ERROR:", err)
 	}
 }
+
+func (d *Database) createPlaylistIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			// * playlists are searched by their tracks
+			Keys:    bsonx.Doc{{Key: "tracks.track_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+		{
+			// * and listed by their users
+			Keys:    bsonx.Doc{{Key: "owner_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+		{
+			Keys:    bsonx.Doc{{Key: "collaborators.user_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+		{
+			Keys:    bsonx.Doc{{Key: "folder_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+	}
+
+	if _, err := d.playlist.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createPlaylistIndexes().CreateMany() ERROR:", err)
+	}
+}
+
+func (d *Database) createWaveformIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "track_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := d.waveform.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createWaveformIndexes().CreateMany() ERROR:", err)
+	}
+}
+
+func (d *Database) createUploadIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			// * expired uploads are removed by the upload janitor, which deletes their chunks too
+			Keys:    bsonx.Doc{{Key: "expires_at", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+	}
+
+	if _, err := d.upload.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createUploadIndexes().CreateMany() ERROR:", err)
+	}
+}
+
+func (d *Database) createHLSIndexIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "track_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := d.hlsIndex.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createHLSIndexIndexes().CreateMany() ERROR:", err)
+	}
+}
+
+func (d *Database) createFingerprintIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "track_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// * candidates for a match are looked up by duration
+			Keys:    bsonx.Doc{{Key: "duration", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+	}
+
+	if _, err := d.fingerprint.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createFingerprintIndexes().CreateMany() ERROR:", err)
+	}
+}
+
+func (d *Database) createPlaylistRevisionIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			// * revisions are named by the snapshot id of their playlist
+			Keys:    bsonx.Doc{{Key: "playlist_id", Value: bsonx.Int32(1)}, {Key: "snapshot_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// * and listed latest first
+			Keys:    bsonx.Doc{{Key: "playlist_id", Value: bsonx.Int32(1)}, {Key: "_id", Value: bsonx.Int32(-1)}},
+			Options: options.Index().SetUnique(false),
+		},
+	}
+
+	if _, err := d.playlistRevision.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createPlaylistRevisionIndexes().CreateMany() ERROR:", err)
+	}
+}
+
+func (d *Database) createFolderIndexes(ctx context.Context) {
+	mods := []mongo.IndexModel{
+		{
+			// * the folders of a user are read together, for their tree
+			Keys:    bsonx.Doc{{Key: "owner_id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(false),
+		},
+	}
+
+	if _, err := d.folder.Indexes().CreateMany(ctx, mods); err != nil {
+		fmt.Println("createFolderIndexes().CreateMany() ERROR:", err)
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"music-master/internal/model"
+	"music-master/internal/util/fields"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// musicTrackHiddenFields are only read for API responses when asked for by name
+var musicTrackHiddenFields = []string{"mp3_file"}
+
 type MusicTrackCollection struct {
 	db *Database
 }
@@ -46,6 +51,25 @@ func (c *MusicTrackCollection) FindOne(ctx context.Context, where bson.M) (*mode
 	return result, nil
 }
 
+// FindOneFields is FindOne reading only the named JSON fields for an API response,
+// see fields.Projection
+func (c *MusicTrackCollection) FindOneFields(ctx context.Context, where bson.M, names []string) (*model.MusicTrack, error) {
+	projection, err := fields.Projection(model.MusicTrack{}, names, musicTrackHiddenFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.MusicTrack{}
+	opts := options.FindOne()
+	opts.SetSort(bson.M{"_id": 1})
+	opts.SetProjection(projection)
+	if err := c.db.musicTrack.FindOne(ctx, where, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 func (c *MusicTrackCollection) RemoveOne(ctx context.Context, where bson.M) error {
 	if _, err := c.db.musicTrack.DeleteOne(ctx, where); err != nil {
 		return err
@@ -65,6 +89,15 @@ func (c *MusicTrackCollection) UpdateOne(ctx context.Context, where bson.M, upda
 
 }
 
+// UpdateMany sets updateData on every track matching where
+func (c *MusicTrackCollection) UpdateMany(ctx context.Context, where bson.M, updateData bson.M) error {
+	if _, err := c.db.musicTrack.UpdateMany(ctx, where, bson.M{"$set": updateData}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (c *MusicTrackCollection) FindOneAndUpdate(ctx context.Context, where bson.M, data *model.MusicTrack) (*model.MusicTrack, error) {
 	opts := options.FindOneAndUpdate()
 	opts.SetReturnDocument(options.After)
@@ -77,7 +110,144 @@ func (c *MusicTrackCollection) FindOneAndUpdate(ctx context.Context, where bson.
 	return result, nil
 }
 
-func (c *MusicTrackCollection) Search(ctx context.Context, searchQuery string, page, pageSize int) ([]*model.MusicTrack, error) {
+// SetAudio points a track at its audio file and drops any legacy inline bytes, along
+// with the loudness measured from the audio it replaces and the top level sample rate
+// that format.sample_rate took over
+func (c *MusicTrackCollection) SetAudio(ctx context.Context, where bson.M, audio *model.AudioFile) (*model.MusicTrack, error) {
+	update := bson.M{"$unset": bson.M{"mp3_file": "", "loudness": "", "sample_rate": ""}}
+	if audio != nil {
+		update["$set"] = bson.M{"audio": audio}
+	} else {
+		update["$unset"] = bson.M{"mp3_file": "", "loudness": "", "sample_rate": "", "audio": ""}
+	}
+
+	result := &model.MusicTrack{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.musicTrack.FindOneAndUpdate(ctx, where, update, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// MoveSampleRate drops the top level sample_rate of tracks stored before the audio
+// format descriptor. Only MP3 uploads were accepted then, so tracks without a format
+// get an MP3 one carrying the sample rate. Returns how many tracks were changed.
+func (c *MusicTrackCollection) MoveSampleRate(ctx context.Context) (int64, error) {
+	withFormat, err := c.db.musicTrack.UpdateMany(ctx,
+		bson.M{"sample_rate": bson.M{"$exists": true}, "format": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"sample_rate": ""}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	withoutFormat, err := c.db.musicTrack.UpdateMany(ctx,
+		bson.M{"sample_rate": bson.M{"$exists": true}, "format": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"format": bson.M{
+				"format":      "mp3",
+				"codec":       "mp3",
+				"mime_type":   "audio/mpeg",
+				"sample_rate": "$sample_rate",
+				"channels":    bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$channel_mode", "mono"}}, 1, 2}},
+			}}}},
+			{{Key: "$unset", Value: "sample_rate"}},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return withFormat.ModifiedCount + withoutFormat.ModifiedCount, nil
+}
+
+// FindIDs returns the ids of the tracks matching where
+func (c *MusicTrackCollection) FindIDs(ctx context.Context, where bson.M) ([]primitive.ObjectID, error) {
+	return findIDs(ctx, c.db.musicTrack, where)
+}
+
+// Each decodes every track matching where and passes it to fn, stopping at the first error
+func (c *MusicTrackCollection) Each(ctx context.Context, where bson.M, fn func(rec *model.MusicTrack) error) error {
+	return c.each(ctx, where, options.Find(), fn)
+}
+
+// EachFields is Each reading only the named JSON fields of the tracks, see fields.Projection
+func (c *MusicTrackCollection) EachFields(ctx context.Context, where bson.M, names []string, fn func(rec *model.MusicTrack) error) error {
+	projection, err := fields.Projection(model.MusicTrack{}, names, musicTrackHiddenFields...)
+	if err != nil {
+		return err
+	}
+
+	return c.each(ctx, where, options.Find().SetProjection(projection), fn)
+}
+
+func (c *MusicTrackCollection) each(ctx context.Context, where bson.M, opts *options.FindOptions, fn func(rec *model.MusicTrack) error) error {
+	cursor, err := c.db.musicTrack.Find(ctx, where, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		rec := &model.MusicTrack{}
+		if err := cursor.Decode(rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// AudioDuplicates is a group of tracks sharing the same audio
+type AudioDuplicates struct {
+	Checksum string              `bson:"_id"`
+	Tracks   []*model.MusicTrack `bson:"tracks"`
+}
+
+// FindAudioDuplicates groups the tracks whose audio has the same checksum, oldest track first
+func (c *MusicTrackCollection) FindAudioDuplicates(ctx context.Context) ([]*AudioDuplicates, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"audio.checksum": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$audio.checksum",
+			"tracks": bson.M{"$push": bson.M{
+				"_id":    "$_id",
+				"title":  "$title",
+				"artist": "$artist",
+				"audio":  "$audio",
+			}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"count": -1}}},
+	}
+	cursor, err := c.db.musicTrack.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []*AudioDuplicates
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Search reads only the named JSON fields of the tracks found, see fields.Projection
+func (c *MusicTrackCollection) Search(ctx context.Context, searchQuery string, page, pageSize int, names []string) ([]*model.MusicTrack, error) {
+	projection, err := fields.Projection(model.MusicTrack{}, names, musicTrackHiddenFields...)
+	if err != nil {
+		return nil, err
+	}
+
 	// Search for music tracks
 	songFilter := bson.M{
 		"$or": []bson.M{
@@ -94,6 +264,7 @@ func (c *MusicTrackCollection) Search(ctx context.Context, searchQuery string, p
 		skip := (page - 1) * pageSize
 		myOptions.SetSkip(int64(skip)).SetLimit(int64(pageSize))
 	}
+	myOptions.SetProjection(projection)
 
 	dataCursor, err := c.db.musicTrack.Find(context.Background(), songFilter, myOptions)
 	if err != nil {
@@ -111,3 +282,25 @@ func (c *MusicTrackCollection) Search(ctx context.Context, searchQuery string, p
 
 	return foundData, nil
 }
+
+// findIDs returns the ids of the documents of collection matching where
+func findIDs(ctx context.Context, collection *mongo.Collection, where bson.M) ([]primitive.ObjectID, error) {
+	cursor, err := collection.Find(ctx, where, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := []primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.ID)
+	}
+
+	return ids, cursor.Err()
+}
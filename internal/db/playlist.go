@@ -5,12 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"music-master/internal/model"
+	"music-master/internal/util/fields"
+	"regexp"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// playlistHiddenFields are only read for API responses when asked for by name
+var playlistHiddenFields = []string{"tracks.track.mp3_file"}
+
 type PlaylistCollection struct {
 	db *Database
 }
@@ -22,6 +28,7 @@ func NewPlaylistCollection(db *Database) *PlaylistCollection {
 }
 
 func (c *PlaylistCollection) InsertOne(ctx context.Context, data *model.Playlist) (*model.Playlist, error) {
+	data.SnapshotID = model.NewSnapshotID()
 	result, err := c.db.playlist.InsertOne(ctx, data)
 	if err != nil {
 		return nil, err
@@ -46,6 +53,25 @@ func (c *PlaylistCollection) FindOne(ctx context.Context, where bson.M) (*model.
 	return result, nil
 }
 
+// FindOneFields is FindOne for an API response: the tracks of the playlist are looked
+// up and only the named JSON fields are read, see fields.Projection
+func (c *PlaylistCollection) FindOneFields(ctx context.Context, where bson.M, names []string) (*model.Playlist, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: where}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: 1}},
+	}
+	result, err := c.aggregate(ctx, pipeline, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result[0], nil
+}
+
 func (c *PlaylistCollection) RemoveOne(ctx context.Context, where bson.M) error {
 	if _, err := c.db.playlist.DeleteOne(ctx, where); err != nil {
 		return err
@@ -69,7 +95,6 @@ func (c *PlaylistCollection) FindOneAndUpdate(ctx context.Context, where bson.M,
 	opts := options.FindOneAndUpdate()
 	opts.SetReturnDocument(options.After)
 	result := &model.Playlist{}
-	fmt.Println("FindOneAndUpdate", *data)
 	if err := c.db.playlist.FindOneAndUpdate(ctx, where, bson.M{"$set": data}, opts).Decode(result); err != nil {
 		return result, err
 	}
@@ -77,74 +102,672 @@ func (c *PlaylistCollection) FindOneAndUpdate(ctx context.Context, where bson.M,
 	return result, nil
 }
 
-// DeleteTrackFromPlaylist removes a track from a playlist in MongoDB
-func (c *PlaylistCollection) DeleteTrackFromPlaylist(ctx context.Context, playlistID, trackID string) error {
-	// Define filter to identify the playlist
-	playlistObjectID, err := primitive.ObjectIDFromHex(playlistID)
+// DeleteTrackFromPlaylist removes a track from the playlist matching where in MongoDB
+// and returns the playlist, mongo.ErrNoDocuments when there is none
+func (c *PlaylistCollection) DeleteTrackFromPlaylist(ctx context.Context, where bson.M, trackID string) (*model.Playlist, error) {
+	// Define the update to remove the track from the playlist
+	musicTrackObjectID, err := primitive.ObjectIDFromHex(trackID)
 	if err != nil {
-		fmt.Println("Error parse playlist object id", err)
+		fmt.Println("Error parse music track object id", err)
+		return nil, err
+	}
+
+	update := bson.M{
+		"$pull": bson.M{"tracks": bson.M{"track_id": musicTrackObjectID}},
+		"$set":  bson.M{"snapshot_id": model.NewSnapshotID()},
+	}
+	// Perform the update operation
+	result := &model.Playlist{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.playlist.FindOneAndUpdate(ctx, where, update, opts).Decode(result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error deleting track from playlist: %v", err)
+	}
+
+	return result, nil
+}
+
+// PullTrack removes a track from every playlist holding it and records the revisions
+// this makes, in the same transaction when ctx is one
+func (c *PlaylistCollection) PullTrack(ctx context.Context, trackID primitive.ObjectID) error {
+	if err := c.insertBaselines(ctx, bson.M{"tracks.track_id": trackID}); err != nil {
 		return err
 	}
-	filter := bson.M{"_id": playlistObjectID}
 
-	// Define the update to remove the track from the playlist
-	musicTrackObjectID, err := primitive.ObjectIDFromHex(trackID)
+	snapshotID := model.NewSnapshotID()
+	update := bson.M{
+		"$pull": bson.M{"tracks": bson.M{"track_id": trackID}},
+		"$set":  bson.M{"snapshot_id": snapshotID},
+	}
+	result, err := c.db.playlist.UpdateMany(ctx, bson.M{"tracks.track_id": trackID}, update)
 	if err != nil {
-		fmt.Println("Error parse music track object id", err)
 		return err
 	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
 
-	update := bson.M{"$pull": bson.M{"tracks": bson.M{"_id": musicTrackObjectID}}}
-	// Perform the update operation
-	_, err = c.db.playlist.UpdateOne(context.Background(), filter, update)
+	// * the changed playlists are those at the new snapshot
+	cursor, err := c.db.playlist.Find(ctx, bson.M{"snapshot_id": snapshotID})
 	if err != nil {
-		return fmt.Errorf("error deleting track from playlist: %v", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []interface{}{}
+	for cursor.Next(ctx) {
+		rec := &model.Playlist{}
+		if err := cursor.Decode(rec); err != nil {
+			return err
+		}
+		revisions = append(revisions, model.NewPlaylistRevision(rec, model.RevisionOpTrackDeleted, ""))
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		return nil
+	}
+	if _, err := c.db.playlistRevision.InsertMany(ctx, revisions); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertBaselines records a baseline revision of the playlists matching where that have
+// no revision at their snapshot, see PlaylistRevisionCollection.InsertBaseline. Those
+// without a snapshot id are given one first.
+func (c *PlaylistCollection) insertBaselines(ctx context.Context, where bson.M) error {
+	// * snapshot ids only name the versions of one playlist, they can share a new one
+	legacy := bson.M{"$and": bson.A{where, bson.M{"snapshot_id": bson.M{"$exists": false}}}}
+	if _, err := c.db.playlist.UpdateMany(ctx, legacy, bson.M{"$set": bson.M{"snapshot_id": model.NewSnapshotID()}}); err != nil {
+		return err
+	}
+
+	cursor, err := c.db.playlist.Find(ctx, where)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	baselines := []mongo.WriteModel{}
+	for cursor.Next(ctx) {
+		rec := &model.Playlist{}
+		if err := cursor.Decode(rec); err != nil {
+			return err
+		}
+		baselines = append(baselines, baseline(rec))
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(baselines) == 0 {
+		return nil
+	}
+	if _, err := c.db.playlistRevision.BulkWrite(ctx, baselines, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (c *PlaylistCollection) Search(ctx context.Context, searchQuery string, page, pageSize int) ([]*model.Playlist, error) {
-	// Define the filter for full-text search
-	// Define the filter to search within the Tracks field
-	filter := bson.M{
-		"tracks": bson.M{
-			"$elemMatch": bson.M{
-				"$or": []bson.M{
-					{"title": bson.M{"$regex": searchQuery, "$options": "i"}},
-					{"artist": bson.M{"$regex": searchQuery, "$options": "i"}},
-					{"album": bson.M{"$regex": searchQuery, "$options": "i"}},
-					{"genre": bson.M{"$regex": searchQuery, "$options": "i"}},
-				},
+// FindByTrack returns the playlists holding a track, reading only the named JSON
+// fields of them, see fields.Projection
+func (c *PlaylistCollection) FindByTrack(ctx context.Context, trackID primitive.ObjectID, names []string) ([]*model.Playlist, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"tracks.track_id": trackID}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	return c.aggregate(ctx, pipeline, names)
+}
+
+// PushTracks inserts entries into the tracks of the playlist matching where before
+// position, or appends them without one
+func (c *PlaylistCollection) PushTracks(ctx context.Context, where bson.M, entries []*model.PlaylistTrack, position *int) (*model.Playlist, error) {
+	push := bson.M{"$each": entries}
+	if position != nil {
+		push["$position"] = *position
+	}
+	update := bson.M{
+		"$push": bson.M{"tracks": push},
+		"$set":  bson.M{"snapshot_id": model.NewSnapshotID()},
+	}
+
+	result := &model.Playlist{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.playlist.FindOneAndUpdate(ctx, where, update, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// MoveTracks moves the length tracks from start of the playlist matching where to
+// before the track at before, both indexes into the tracks as they are. Playlists
+// too short for the move do not match.
+func (c *PlaylistCollection) MoveTracks(ctx context.Context, where bson.M, start, length, before int) (*model.Playlist, error) {
+	size := bson.M{"$size": bson.M{"$ifNull": bson.A{"$tracks", bson.A{}}}}
+	filter := bson.M{"$and": bson.A{where, bson.M{"$expr": bson.M{"$and": bson.A{
+		bson.M{"$lte": bson.A{start + length, size}},
+		bson.M{"$lte": bson.A{before, size}},
+	}}}}}
+
+	order := bson.A{"$tracks"}
+	if slices := moveSlices(start, length, before); slices != nil {
+		order = bson.A{}
+		for _, sl := range slices {
+			if sl.n < 0 {
+				order = append(order, tracksFrom(sl.from, size))
+			} else {
+				order = append(order, sliceTracks(sl.from, sl.n))
+			}
+		}
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"tracks":      bson.M{"$concatArrays": order},
+		"snapshot_id": model.NewSnapshotID(),
+	}}}}
+
+	result := &model.Playlist{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.playlist.FindOneAndUpdate(ctx, filter, update, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// trackSlice is n tracks from index from, or all tracks from there when n is negative
+type trackSlice struct {
+	from, n int
+}
+
+// moveSlices returns the slices of the tracks that put together in order move the
+// length tracks from start to before the track at before, nil when a move leaves the
+// tracks in place
+func moveSlices(start, length, before int) []trackSlice {
+	// * the moved range and the tracks it jumps over swap places
+	switch {
+	case before < start:
+		return []trackSlice{{0, before}, {start, length}, {before, start - before}, {start + length, -1}}
+	case before > start+length:
+		return []trackSlice{{0, start}, {start + length, before - start - length}, {start, length}, {before, -1}}
+	}
+
+	return nil
+}
+
+// sliceTracks is the expression of n tracks from index from, $slice rejects n of 0
+func sliceTracks(from, n int) interface{} {
+	if n <= 0 {
+		return bson.A{}
+	}
+
+	return bson.M{"$slice": bson.A{"$tracks", from, n}}
+}
+
+// tracksFrom is the expression of the tracks from index from to the end
+func tracksFrom(from int, size bson.M) interface{} {
+	// * a slice starting at the end is empty, whatever its length
+	return bson.M{"$slice": bson.A{"$tracks", from, bson.M{"$max": bson.A{bson.M{"$subtract": bson.A{size, from}}, 1}}}}
+}
+
+// Search finds the playlists with a track whose title, artist, album or genre matches
+// searchQuery. Only the named JSON fields are read, see fields.Projection.
+func (c *PlaylistCollection) Search(ctx context.Context, where bson.M, searchQuery string, page, pageSize int, names []string) ([]*model.Playlist, error) {
+	filter := where
+	if searchQuery != "" {
+		// * the tracks are matched first, playlists only hold their ids
+		songFilter := bson.M{
+			"$or": []bson.M{
+				{"title": bson.M{"$regex": searchQuery, "$options": "i"}},
+				{"artist": bson.M{"$regex": searchQuery, "$options": "i"}},
+				{"album": bson.M{"$regex": searchQuery, "$options": "i"}},
+				{"genre": bson.M{"$regex": searchQuery, "$options": "i"}},
 			},
-		},
+		}
+		trackIDs, err := findIDs(ctx, c.db.musicTrack, songFilter)
+		if err != nil {
+			fmt.Println("Error searching for music tracks:", err)
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{where, bson.M{"tracks.track_id": bson.M{"$in": trackIDs}}}}
 	}
 
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
 	// Paging
-	myOptions := options.Find()
 	if page > 0 && pageSize > 0 {
 		skip := (page - 1) * pageSize
-		myOptions.SetSkip(int64(skip)).SetLimit(int64(pageSize))
+		pipeline = append(pipeline,
+			bson.D{{Key: "$skip", Value: skip}},
+			bson.D{{Key: "$limit", Value: pageSize}},
+		)
 	}
 
-	// Perform the search
-	cursor, err := c.db.playlist.Find(ctx, filter, myOptions)
+	foundData, err := c.aggregate(ctx, pipeline, names)
 	if err != nil {
 		fmt.Println("Error searching for playlists:", err)
 		return nil, err
 	}
+
+	return foundData, nil
+}
+
+// FindSummaries returns the id, name, folder and track count of the playlists matching
+// where, without looking up their tracks. Smart playlists have no track count.
+func (c *PlaylistCollection) FindSummaries(ctx context.Context, where bson.M) ([]*model.Playlist, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: where}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$project", Value: bson.M{
+			"name":        1,
+			"folder_id":   1,
+			"snapshot_id": 1,
+			"rules":       1,
+			"track_count": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$rules"}, "object"}},
+				"$$REMOVE",
+				bson.M{"$size": bson.M{"$ifNull": bson.A{"$tracks", bson.A{}}}},
+			}},
+		}}},
+	}
+	cursor, err := c.db.playlist.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
 	defer cursor.Close(ctx)
 
-	// Process the matched playlists
-	var foundData []*model.Playlist
-	if err := cursor.All(ctx, &foundData); err != nil {
-		fmt.Println("Error decoding playlists:", err)
+	result := []*model.Playlist{}
+	if err := cursor.All(ctx, &result); err != nil {
 		return nil, err
 	}
 
-	for _, playlist := range foundData {
-		fmt.Println(playlist.Name)
+	return result, nil
+}
+
+// SetFolder puts the playlists matching where in the folder folderID, at the top level
+// when nil
+func (c *PlaylistCollection) SetFolder(ctx context.Context, where bson.M, folderID *primitive.ObjectID) (int64, error) {
+	update := bson.M{"$unset": bson.M{"folder_id": ""}}
+	if folderID != nil {
+		update = bson.M{"$set": bson.M{"folder_id": *folderID}}
+	}
+	result, err := c.db.playlist.UpdateMany(ctx, where, update)
+	if err != nil {
+		return 0, err
 	}
 
-	return foundData, nil
+	return result.MatchedCount, nil
+}
+
+// AssignOwner makes ownerID the owner of the playlists matching where that have none
+// and returns how many it assigned
+func (c *PlaylistCollection) AssignOwner(ctx context.Context, where bson.M, ownerID string) (int64, error) {
+	ownerless := bson.M{"$and": bson.A{where, bson.M{"owner_id": bson.M{"$exists": false}}}}
+	result, err := c.db.playlist.UpdateMany(ctx, ownerless, bson.M{"$set": bson.M{"owner_id": ownerID}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (c *PlaylistCollection) RemoveMany(ctx context.Context, where bson.M) error {
+	if _, err := c.db.playlist.DeleteMany(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetCollaborator shares the playlist matching where with a user, replacing the role
+// the user had
+func (c *PlaylistCollection) SetCollaborator(ctx context.Context, where bson.M, collaborator *model.PlaylistCollaborator) (*model.Playlist, error) {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"collaborators": bson.M{"$concatArrays": bson.A{
+			othersThan(collaborator.UserID),
+			// * user ids are data, not field paths
+			bson.A{bson.M{"$literal": collaborator}},
+		}}}}},
+	}
+
+	result := &model.Playlist{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.playlist.FindOneAndUpdate(ctx, where, update, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RemoveCollaborator stops sharing the playlist matching where with a user,
+// mongo.ErrNoDocuments when it is not shared with them
+func (c *PlaylistCollection) RemoveCollaborator(ctx context.Context, where bson.M, userID string) error {
+	filter := bson.M{"$and": bson.A{where, bson.M{"collaborators.user_id": userID}}}
+	result, err := c.db.playlist.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"collaborators": bson.M{"user_id": userID}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// othersThan returns the collaborators of a playlist but the user userID
+func othersThan(userID string) bson.M {
+	return bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$collaborators", bson.A{}}},
+		"as":    "collaborator",
+		"cond":  bson.M{"$ne": bson.A{"$$collaborator.user_id", userID}},
+	}}
+}
+
+// RuleTracks returns a page of the music tracks selected by the rules of a smart
+// playlist, and how many tracks it holds
+func (c *PlaylistCollection) RuleTracks(ctx context.Context, rules *model.PlaylistRules, page, pageSize int) ([]*model.MusicTrack, int64, error) {
+	filter := ruleFilter(rules)
+	total, err := c.db.musicTrack.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rules.Limit > 0 && total > int64(rules.Limit) {
+		total = int64(rules.Limit)
+	}
+
+	skip := 0
+	if page > 1 {
+		skip = (page - 1) * pageSize
+	}
+	limit := int64(pageSize)
+	if rem := total - int64(skip); rem < limit {
+		limit = rem
+	}
+	if limit <= 0 {
+		return []*model.MusicTrack{}, total, nil
+	}
+
+	opts := options.Find().
+		SetSort(ruleSort(rules)).
+		SetSkip(int64(skip)).
+		SetLimit(limit).
+		SetProjection(bson.M{"mp3_file": 0})
+
+	cursor, err := c.db.musicTrack.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []*model.MusicTrack{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+
+	return result, total, nil
+}
+
+// Stats summarizes the tracks of the playlist matching where, mongo.ErrNoDocuments when
+// there is none
+func (c *PlaylistCollection) Stats(ctx context.Context, where bson.M) (*model.PlaylistStats, error) {
+	if n, err := c.db.playlist.CountDocuments(ctx, where, options.Count().SetLimit(1)); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: where}},
+		{{Key: "$limit", Value: 1}},
+	}
+	pipeline = append(pipeline, lookupTracks(false)...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$unwind", Value: "$tracks"}},
+		bson.D{{Key: "$match", Value: bson.M{"tracks.track": bson.M{"$exists": true}}}},
+		bson.D{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$tracks.track"}}},
+	)
+
+	return aggregateStats(ctx, c.db.playlist, pipeline)
+}
+
+// RuleStats summarizes the tracks selected by the rules of a smart playlist
+func (c *PlaylistCollection) RuleStats(ctx context.Context, rules *model.PlaylistRules) (*model.PlaylistStats, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: ruleFilter(rules)}},
+	}
+	if rules.Limit > 0 {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$sort", Value: ruleSort(rules)}},
+			bson.D{{Key: "$limit", Value: rules.Limit}},
+		)
+	}
+
+	return aggregateStats(ctx, c.db.musicTrack, pipeline)
+}
+
+// topArtistsCount is how many artists playlist stats list
+const topArtistsCount = 10
+
+// aggregateStats summarizes the music tracks pipeline ends with in a single pass
+func aggregateStats(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) (*model.PlaylistStats, error) {
+	countBy := func(key interface{}) bson.M {
+		return bson.M{"$group": bson.M{"_id": key, "count": bson.M{"$sum": 1}}}
+	}
+	first := func(field string, def interface{}) bson.M {
+		return bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$totals." + field, 0}}, def}}
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{bson.M{"$group": bson.M{
+				"_id":                  nil,
+				"track_count":          bson.M{"$sum": 1},
+				"total_duration":       bson.M{"$sum": "$duration"},
+				"average_release_year": bson.M{"$avg": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$release_year", 0}}, "$release_year", nil}}},
+			}}},
+			"genres": bson.A{
+				bson.M{"$match": bson.M{"genre": bson.M{"$nin": bson.A{nil, ""}}}},
+				countBy("$genre"),
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"decades": bson.A{
+				bson.M{"$match": bson.M{"release_year": bson.M{"$gt": 0}}},
+				countBy(bson.M{"$subtract": bson.A{"$release_year", bson.M{"$mod": bson.A{"$release_year", 10}}}}),
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"top_artists": bson.A{
+				bson.M{"$match": bson.M{"artist": bson.M{"$nin": bson.A{nil, ""}}}},
+				countBy("$artist"),
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": topArtistsCount},
+			},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"track_count":          first("track_count", 0),
+			"total_duration":       first("total_duration", 0),
+			"average_release_year": bson.M{"$round": bson.A{first("average_release_year", nil), 1}},
+			"genres":               1,
+			"decades":              1,
+			"top_artists":          1,
+		}}},
+	)
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := &model.PlaylistStats{}
+	if !cursor.Next(ctx) {
+		return result, cursor.Err()
+	}
+	if err := cursor.Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ruleSort returns the order of the tracks selected by rules, _id breaks ties so pages
+// do not overlap
+func ruleSort(rules *model.PlaylistRules) bson.D {
+	sort := bson.D{}
+	if rules.SortBy != "" {
+		order := 1
+		if rules.SortOrder == "desc" {
+			order = -1
+		}
+		sort = append(sort, bson.E{Key: rules.SortBy, Value: order})
+	}
+
+	return append(sort, bson.E{Key: "_id", Value: 1})
+}
+
+// ruleFilter translates the conditions of rules into a filter of music tracks
+func ruleFilter(rules *model.PlaylistRules) bson.M {
+	and := bson.A{}
+	for _, cond := range rules.Conditions {
+		var match interface{}
+		if cond.Operator == model.RuleOpContains {
+			match = bson.M{"$regex": regexp.QuoteMeta(fmt.Sprint(cond.Value)), "$options": "i"}
+		} else {
+			// * the operators are named after the Mongo ones
+			match = bson.M{"$" + cond.Operator: cond.Value}
+		}
+		and = append(and, bson.M{cond.Field: match})
+	}
+
+	return bson.M{"$and": and}
+}
+
+// EmbeddedPlaylist is a playlist stored by earlier versions, with copies of its tracks
+type EmbeddedPlaylist struct {
+	ID     primitive.ObjectID  `bson:"_id"`
+	Tracks []*model.MusicTrack `bson:"tracks"`
+}
+
+// EachEmbedded passes every playlist still holding copies of tracks to fn, stopping at
+// the first error
+func (c *PlaylistCollection) EachEmbedded(ctx context.Context, fn func(rec *EmbeddedPlaylist) error) error {
+	where := bson.M{
+		"tracks.0":        bson.M{"$exists": true},
+		"tracks.track_id": bson.M{"$exists": false},
+	}
+	opts := options.Find().SetProjection(bson.M{"tracks.mp3_file": 0})
+	cursor, err := c.db.playlist.Find(ctx, where, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		rec := &EmbeddedPlaylist{}
+		if err := cursor.Decode(rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// aggregate runs pipeline, looks up the tracks of the playlists it yields and reads
+// the named JSON fields of them
+func (c *PlaylistCollection) aggregate(ctx context.Context, pipeline mongo.Pipeline, names []string) ([]*model.Playlist, error) {
+	projection, err := fields.Projection(model.Playlist{}, names, playlistHiddenFields...)
+	if err != nil {
+		return nil, err
+	}
+	pipeline = append(pipeline, lookupTracks(named(names, playlistHiddenFields))...)
+	pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: totals()}})
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+
+	cursor, err := c.db.playlist.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []*model.Playlist
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// named tells whether one of the hidden fields is among names
+func named(names, hidden []string) bool {
+	for _, name := range names {
+		for _, h := range hidden {
+			if name == h {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// totals returns the track count and total duration of a playlist whose tracks are
+// looked up, left out for smart playlists whose tracks are not
+func totals() bson.M {
+	smart := bson.M{"$eq": bson.A{bson.M{"$type": "$rules"}, "object"}}
+	return bson.M{
+		"track_count":    bson.M{"$cond": bson.A{smart, "$$REMOVE", bson.M{"$size": "$tracks"}}},
+		"total_duration": bson.M{"$cond": bson.A{smart, "$$REMOVE", bson.M{"$sum": "$tracks.track.duration"}}},
+	}
+}
+
+// lookupTracks returns the stages filling in the track of every entry of a playlist,
+// keeping the order of the entries. Entries of deleted tracks are left without one.
+// The legacy audio bytes of the tracks are dropped unless withAudio so they do not
+// travel further down the pipeline and to the client.
+func lookupTracks(withAudio bool) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         model.MusicTrack{}.TableName(),
+			"localField":   "tracks.track_id",
+			"foreignField": "_id",
+			"as":           "found_tracks",
+		}}},
+	}
+	if !withAudio {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{"found_tracks.mp3_file": 0}}})
+	}
+
+	return append(pipeline,
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"tracks": bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$tracks", bson.A{}}},
+				"as":    "entry",
+				"in": bson.M{"$mergeObjects": bson.A{"$$entry", bson.M{
+					"track": bson.M{"$arrayElemAt": bson.A{
+						bson.M{"$filter": bson.M{
+							"input": "$found_tracks",
+							"as":    "track",
+							"cond":  bson.M{"$eq": bson.A{"$$track._id", "$$entry.track_id"}},
+						}},
+						0,
+					}},
+				}}},
+			}},
+			"found_tracks": "$$REMOVE",
+		}}},
+	)
 }
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"music-master/internal/model"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// applySlices puts together the slices of tracks like $concatArrays of $slice does
+func applySlices(tracks []string, slices []trackSlice) []string {
+	if slices == nil {
+		return tracks
+	}
+
+	result := []string{}
+	for _, sl := range slices {
+		from, to := sl.from, sl.from+sl.n
+		if sl.n < 0 || to > len(tracks) {
+			to = len(tracks)
+		}
+		if from < to {
+			result = append(result, tracks[from:to]...)
+		}
+	}
+
+	return result
+}
+
+func TestMoveSlices(t *testing.T) {
+	tracks := strings.Split("abcdef", "")
+
+	tests := []struct {
+		name                  string
+		start, length, before int
+		want                  string
+	}{
+		{"before the range", 3, 2, 1, "adebcf"},
+		{"to the start", 2, 1, 0, "cabdef"},
+		{"after the range", 1, 2, 4, "adbcef"},
+		{"to the end", 0, 2, 6, "cdefab"},
+		{"range at the end moved forward", 4, 2, 0, "efabcd"},
+		{"range at the end moved back", 3, 3, 1, "adefbc"},
+		{"range up to the end", 0, 6, 6, "abcdef"},
+		{"before the start of the range", 2, 2, 2, "abcdef"},
+		{"inside the range", 1, 3, 2, "abcdef"},
+		{"right after the range", 1, 3, 4, "abcdef"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(applySlices(tracks, moveSlices(tt.start, tt.length, tt.before)), "")
+		if got != tt.want {
+			t.Errorf("%s: moving %d from %d before %d = %s, want %s", tt.name, tt.length, tt.start, tt.before, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ start, length, before int }{{2, 2, 2}, {1, 3, 2}, {1, 3, 4}} {
+		if slices := moveSlices(tt.start, tt.length, tt.before); slices != nil {
+			t.Errorf("moveSlices(%d, %d, %d) = %v, want nil to leave the tracks in place", tt.start, tt.length, tt.before, slices)
+		}
+	}
+
+	// * moving to the start leaves nothing before the range, $slice rejects a length of 0
+	if got, ok := sliceTracks(0, 0).(bson.A); !ok || len(got) != 0 {
+		t.Errorf("sliceTracks(0, 0) = %v, want an empty array", sliceTracks(0, 0))
+	}
+}
+
+func TestRuleFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		cond model.PlaylistCondition
+		want bson.M
+	}{
+		{"eq", model.PlaylistCondition{Field: "genre", Operator: model.RuleOpEq, Value: "Jazz"}, bson.M{"genre": bson.M{"$eq": "Jazz"}}},
+		{"ne", model.PlaylistCondition{Field: "artist", Operator: model.RuleOpNe, Value: "Nobody"}, bson.M{"artist": bson.M{"$ne": "Nobody"}}},
+		{"gt", model.PlaylistCondition{Field: "release_year", Operator: model.RuleOpGt, Value: 1990.0}, bson.M{"release_year": bson.M{"$gt": 1990.0}}},
+		{"gte", model.PlaylistCondition{Field: "bitrate", Operator: model.RuleOpGte, Value: 320.0}, bson.M{"bitrate": bson.M{"$gte": 320.0}}},
+		{"lt", model.PlaylistCondition{Field: "duration", Operator: model.RuleOpLt, Value: 300.0}, bson.M{"duration": bson.M{"$lt": 300.0}}},
+		{"lte", model.PlaylistCondition{Field: "track_number", Operator: model.RuleOpLte, Value: 3.0}, bson.M{"track_number": bson.M{"$lte": 3.0}}},
+		{"contains", model.PlaylistCondition{Field: "title", Operator: model.RuleOpContains, Value: "love"}, bson.M{"title": bson.M{"$regex": "love", "$options": "i"}}},
+		// * the value is a substring, not a pattern
+		{"contains quoted", model.PlaylistCondition{Field: "album", Operator: model.RuleOpContains, Value: "(Live?)"}, bson.M{"album": bson.M{"$regex": `\(Live\?\)`, "$options": "i"}}},
+	}
+	for _, tt := range tests {
+		cond := tt.cond
+		got := ruleFilter(&model.PlaylistRules{Conditions: []*model.PlaylistCondition{&cond}})
+		want := bson.M{"$and": bson.A{tt.want}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ruleFilter() = %v, want %v", tt.name, got, want)
+		}
+	}
+
+	// * tracks have to match all conditions
+	got := ruleFilter(&model.PlaylistRules{Conditions: []*model.PlaylistCondition{
+		{Field: "genre", Operator: model.RuleOpEq, Value: "Jazz"},
+		{Field: "release_year", Operator: model.RuleOpGte, Value: 1960.0},
+		{Field: "release_year", Operator: model.RuleOpLt, Value: 1970.0},
+	}})
+	want := bson.M{"$and": bson.A{
+		bson.M{"genre": bson.M{"$eq": "Jazz"}},
+		bson.M{"release_year": bson.M{"$gte": 1960.0}},
+		bson.M{"release_year": bson.M{"$lt": 1970.0}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ruleFilter() of several conditions = %v, want %v", got, want)
+	}
+}
+
+func TestRuleSort(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules model.PlaylistRules
+		want  bson.D
+	}{
+		{"unsorted", model.PlaylistRules{}, bson.D{{Key: "_id", Value: 1}}},
+		{"ascending", model.PlaylistRules{SortBy: "title"}, bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{"descending", model.PlaylistRules{SortBy: "release_year", SortOrder: "desc"}, bson.D{{Key: "release_year", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+	for _, tt := range tests {
+		if got := ruleSort(&tt.rules); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ruleSort() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
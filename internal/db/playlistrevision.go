@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PlaylistRevisionCollection struct {
+	db *Database
+}
+
+func NewPlaylistRevisionCollection(db *Database) *PlaylistRevisionCollection {
+	return &PlaylistRevisionCollection{
+		db: db,
+	}
+}
+
+func (c *PlaylistRevisionCollection) InsertOne(ctx context.Context, data *model.PlaylistRevision) (*model.PlaylistRevision, error) {
+	result, err := c.db.playlistRevision.InsertOne(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if objectID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		data.ID = objectID
+	}
+
+	return data, nil
+}
+
+func (c *PlaylistRevisionCollection) FindOne(ctx context.Context, where bson.M) (*model.PlaylistRevision, error) {
+	result := &model.PlaylistRevision{}
+	if err := c.db.playlistRevision.FindOne(ctx, where).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// InsertBaseline records rec as a baseline revision unless there is a revision at its
+// snapshot already. Playlists stored before revisions were recorded have none, their
+// first recorded change would otherwise have nothing to be compared with or reverted to.
+func (c *PlaylistRevisionCollection) InsertBaseline(ctx context.Context, rec *model.Playlist) error {
+	if _, err := c.db.playlistRevision.BulkWrite(ctx, []mongo.WriteModel{baseline(rec)}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// baseline returns the write inserting the baseline revision of rec, which leaves an
+// existing revision at its snapshot alone
+func baseline(rec *model.Playlist) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"playlist_id": rec.ID, "snapshot_id": rec.SnapshotID}).
+		SetUpdate(bson.M{"$setOnInsert": model.NewPlaylistRevision(rec, model.RevisionOpBaseline, "")}).
+		SetUpsert(true)
+}
+
+// Previous returns the revision of the playlist made before rev, mongo.ErrNoDocuments
+// for its first one
+func (c *PlaylistRevisionCollection) Previous(ctx context.Context, rev *model.PlaylistRevision) (*model.PlaylistRevision, error) {
+	where := bson.M{"playlist_id": rev.PlaylistID, "_id": bson.M{"$lt": rev.ID}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	result := &model.PlaylistRevision{}
+	if err := c.db.playlistRevision.FindOne(ctx, where, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// List returns a page of the revisions of a playlist, the latest first and without
+// their tracks, and how many there are
+func (c *PlaylistRevisionCollection) List(ctx context.Context, playlistID primitive.ObjectID, page, pageSize int) ([]*model.PlaylistRevision, int64, error) {
+	where := bson.M{"playlist_id": playlistID}
+	total, err := c.db.playlistRevision.CountDocuments(ctx, where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetProjection(bson.M{"tracks": 0})
+	if page > 0 && pageSize > 0 {
+		opts.SetSkip(int64((page - 1) * pageSize)).SetLimit(int64(pageSize))
+	}
+	cursor, err := c.db.playlistRevision.Find(ctx, where, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []*model.PlaylistRevision{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+
+	return result, total, nil
+}
+
+func (c *PlaylistRevisionCollection) RemoveMany(ctx context.Context, where bson.M) error {
+	if _, err := c.db.playlistRevision.DeleteMany(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type UploadCollection struct {
+	db *Database
+}
+
+func NewUploadCollection(db *Database) *UploadCollection {
+	return &UploadCollection{
+		db: db,
+	}
+}
+
+func (c *UploadCollection) InsertOne(ctx context.Context, data *model.Upload) (*model.Upload, error) {
+	result, err := c.db.upload.InsertOne(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, errors.New("invalid objectId")
+	}
+	data.ID = objectID
+
+	return data, nil
+}
+
+func (c *UploadCollection) FindOne(ctx context.Context, where bson.M) (*model.Upload, error) {
+	result := &model.Upload{}
+	if err := c.db.upload.FindOne(ctx, where).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *UploadCollection) UpdateOne(ctx context.Context, where bson.M, updateData bson.M) (*model.Upload, error) {
+	result := &model.Upload{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.upload.FindOneAndUpdate(ctx, where, bson.M{"$set": updateData}, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// AppendChunk records a chunk written at offset and moves the offset past it. It only
+// matches while the upload is still at offset, so of two concurrent appends one fails
+// with mongo.ErrNoDocuments.
+func (c *UploadCollection) AppendChunk(ctx context.Context, id primitive.ObjectID, offset int64, chunk model.UploadChunk, updateData bson.M) (*model.Upload, error) {
+	update := bson.M{
+		"$push": bson.M{"chunks": chunk},
+		"$inc":  bson.M{"offset": chunk.Size},
+	}
+	if len(updateData) > 0 {
+		update["$set"] = updateData
+	}
+
+	result := &model.Upload{}
+	opts := options.FindOneAndUpdate()
+	opts.SetReturnDocument(options.After)
+	if err := c.db.upload.FindOneAndUpdate(ctx, bson.M{"_id": id, "offset": offset}, update, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *UploadCollection) RemoveOne(ctx context.Context, where bson.M) error {
+	if _, err := c.db.upload.DeleteOne(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Each decodes every upload matching where and passes it to fn, stopping at the first error
+func (c *UploadCollection) Each(ctx context.Context, where bson.M, fn func(rec *model.Upload) error) error {
+	cursor, err := c.db.upload.Find(ctx, where)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		rec := &model.Upload{}
+		if err := cursor.Decode(rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
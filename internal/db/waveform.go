@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"music-master/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WaveformCollection struct {
+	db *Database
+}
+
+func NewWaveformCollection(db *Database) *WaveformCollection {
+	return &WaveformCollection{
+		db: db,
+	}
+}
+
+func (c *WaveformCollection) FindOne(ctx context.Context, where bson.M) (*model.Waveform, error) {
+	result := &model.Waveform{}
+	if err := c.db.waveform.FindOne(ctx, where).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Upsert stores the waveform of a track, replacing the one computed before
+func (c *WaveformCollection) Upsert(ctx context.Context, data *model.Waveform) (*model.Waveform, error) {
+	result := &model.Waveform{}
+	opts := options.FindOneAndReplace()
+	opts.SetUpsert(true)
+	opts.SetReturnDocument(options.After)
+	if err := c.db.waveform.FindOneAndReplace(ctx, bson.M{"track_id": data.TrackID}, data, opts).Decode(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *WaveformCollection) RemoveOne(ctx context.Context, where bson.M) error {
+	if _, err := c.db.waveform.DeleteOne(ctx, where); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fingerprint is the acoustic fingerprint of the audio of a track, which stays nearly
+// the same when the audio is re-encoded or retagged
+type Fingerprint struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TrackID   primitive.ObjectID `bson:"track_id"`
+	Checksum  string             `bson:"checksum"` // Checksum of the audio the fingerprint was computed from
+	Duration  time.Duration      `bson:"duration"` // Duration of the whole audio, candidates for a match are about as long
+	Hashes    []uint32           `bson:"hashes"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+func (Fingerprint) TableName() string {
+	return "fingerprints"
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Folder organizes the playlists of a user, and other folders inside it
+// swagger:model Folder
+type Folder struct {
+	ID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	// example: Road trips
+	Name    string `bson:"name" json:"name"`
+	OwnerID string `bson:"owner_id" json:"owner_id"`
+	// ParentID is the folder holding this one, missing for top level folders
+	ParentID  *primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+func (Folder) TableName() string {
+	return "playlist_folders"
+}
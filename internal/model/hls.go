@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HLSIndex locates the HLS segments of a track inside its stored MP3 audio
+type HLSIndex struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	TrackID        primitive.ObjectID `bson:"track_id"`
+	Checksum       string             `bson:"checksum"` // Checksum of the audio the segments were cut from
+	TargetDuration int                `bson:"target_duration"`
+	Segments       []HLSSegment       `bson:"segments"`
+	CreatedAt      time.Time          `bson:"created_at"`
+}
+
+func (HLSIndex) TableName() string {
+	return "hls_indexes"
+}
+
+// HLSSegment is a byte range of whole MP3 frames
+type HLSSegment struct {
+	Offset   int64         `bson:"offset"`
+	Length   int64         `bson:"length"`
+	Start    time.Duration `bson:"start"`
+	Duration time.Duration `bson:"duration"`
+}
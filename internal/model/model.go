@@ -1,6 +1,6 @@
 package model
 
-import "github.com/labstack/echo"
+import "github.com/labstack/echo/v4"
 
 // AuthUser represents data stored in JWT token for customer
 type AuthUser struct {
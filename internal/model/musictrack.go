@@ -1,19 +1,83 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // swagger:model MusicTrack
 type MusicTrack struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID     string             `bson:"owner_id,omitempty" json:"owner_id,omitempty"` // User who uploaded the track, missing for tracks from before owners
 	Title       string             `bson:"title,omitempty" json:"title"`
 	Artist      string             `bson:"artist,omitempty" json:"artist"`
 	Album       string             `bson:"album,omitempty" json:"album"`
 	Genre       string             `bson:"genre,omitempty" json:"genre"`
 	ReleaseYear int                `bson:"release_year,omitempty" json:"release_year"`
+	TrackNumber int                `bson:"track_number,omitempty" json:"track_number"`
 	Duration    int                `bson:"duration,omitempty" json:"duration"` // Duration in seconds
-	MP3File     []byte             `bson:"mp3_file,omitempty" json:"mp3_file"` // Binary data of the MP3 file
+	DurationMs  int64              `bson:"duration_ms,omitempty" json:"duration_ms"`
+	Bitrate     int                `bson:"bitrate,omitempty" json:"bitrate"` // Average bitrate in kbps
+	ChannelMode string             `bson:"channel_mode,omitempty" json:"channel_mode"`
+	Format      *AudioFormat       `bson:"format,omitempty" json:"format,omitempty"`
+	Audio       *AudioFile         `bson:"audio,omitempty" json:"audio,omitempty"`
+	Cover       *CoverArt          `bson:"cover,omitempty" json:"cover,omitempty"`
+	Loudness    *Loudness          `bson:"loudness,omitempty" json:"loudness,omitempty"`
+	// Deprecated: legacy inline audio, moved into GridFS by cmd/migrate-audio
+	MP3File []byte `bson:"mp3_file,omitempty" json:"mp3_file,omitempty"`
 }
 
 func (MusicTrack) TableName() string {
 	return "music_tracks"
 }
+
+// AudioFormat describes how the audio of a track is encoded, as detected on upload
+// swagger:model AudioFormat
+type AudioFormat struct {
+	Format     string `bson:"format" json:"format"` // Container: mp3, flac, ogg, wav, mp4 or aac
+	Codec      string `bson:"codec" json:"codec"`   // mp3, flac, vorbis, opus, pcm, aac or alac
+	MimeType   string `bson:"mime_type" json:"mime_type"`
+	BitDepth   int    `bson:"bit_depth,omitempty" json:"bit_depth,omitempty"` // Bits per sample, lossless codecs only
+	SampleRate int    `bson:"sample_rate" json:"sample_rate"`                 // Sample rate in Hz
+	Channels   int    `bson:"channels" json:"channels"`
+}
+
+// Loudness of the audio of a track measured per EBU R128, with ReplayGain 2.0 gains
+// swagger:model Loudness
+type Loudness struct {
+	Checksum   string    `bson:"checksum" json:"-"`                                // Checksum of the audio it was measured from
+	Integrated float64   `bson:"integrated" json:"integrated"`                     // Integrated loudness in LUFS
+	Range      float64   `bson:"range" json:"range"`                               // Loudness range in LU
+	TruePeak   float64   `bson:"true_peak" json:"true_peak"`                       // Maximum true peak in dBTP
+	TrackGain  float64   `bson:"track_gain" json:"track_gain"`                     // Gain to -18 LUFS in dB
+	AlbumGain  *float64  `bson:"album_gain,omitempty" json:"album_gain,omitempty"` // Gain of the tracks of the same album as a whole, in dB
+	AlbumPeak  *float64  `bson:"album_peak,omitempty" json:"album_peak,omitempty"` // Highest true peak of the album in dBTP
+	MeasuredAt time.Time `bson:"measured_at" json:"measured_at"`
+}
+
+// CoverArt references the artwork of a track, extracted from its audio file and kept
+// in the configured storage
+// swagger:model CoverArt
+type CoverArt struct {
+	Key        string    `bson:"key" json:"-"`
+	Checksum   string    `bson:"checksum" json:"checksum"` // SHA-256 of the image, hex encoded
+	MimeType   string    `bson:"mime_type" json:"mime_type"`
+	Width      int       `bson:"width" json:"width"`
+	Height     int       `bson:"height" json:"height"`
+	Size       int64     `bson:"size" json:"size"`
+	UploadedAt time.Time `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+// AudioFile references the audio payload of a track kept in the configured storage
+// swagger:model AudioFile
+type AudioFile struct {
+	Key         string    `bson:"key,omitempty" json:"key"`
+	Checksum    string    `bson:"checksum,omitempty" json:"checksum"` // SHA-256 of the payload, hex encoded
+	Size        int64     `bson:"size" json:"size"`
+	Filename    string    `bson:"filename,omitempty" json:"filename,omitempty"`
+	ContentType string    `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	UploadedAt  time.Time `bson:"uploaded_at" json:"uploaded_at"`
+	// Deprecated: GridFS id of files uploaded before storage keys, moved by cmd/migrate-audio
+	FileID primitive.ObjectID `bson:"file_id,omitempty" json:"-"`
+}
@@ -1,14 +1,181 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // swagger:model Playlist
 type Playlist struct {
 	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Name   string             `bson:"name,omitempty" json:"name"`
-	Tracks []*MusicTrack      `bson:"tracks,omitempty" json:"tracks"`
+	Tracks []*PlaylistTrack   `bson:"tracks,omitempty" json:"tracks"`
+	// OwnerID is the id of the user who made the playlist, missing for playlists made
+	// before they had owners, which every user edits until cmd/assign-playlist-owners
+	// gives them one
+	OwnerID string `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	// Collaborators are the users the playlist is shared with
+	Collaborators []*PlaylistCollaborator `bson:"collaborators,omitempty" json:"collaborators,omitempty"`
+	// FolderID is the folder of the owner holding the playlist, missing at the top level
+	FolderID *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	// SnapshotID changes with every change of the playlist, edits made against an older
+	// one are rejected
+	SnapshotID string `bson:"snapshot_id,omitempty" json:"snapshot_id"`
+	// Rules make a smart playlist, whose tracks are the music tracks matching them
+	Rules *PlaylistRules `bson:"rules,omitempty" json:"rules,omitempty"`
+	// TrackCount is the number of tracks, computed when the playlist is read. The tracks
+	// of a smart playlist come in pages, this counts all of them.
+	TrackCount *int64 `bson:"track_count,omitempty" json:"track_count,omitempty"`
+	// TotalDuration is the duration of the tracks in seconds, computed when the playlist
+	// is read, missing for smart playlists
+	TotalDuration *int64 `bson:"total_duration,omitempty" json:"total_duration,omitempty"`
+	// CoverURL points at the cover art of the first track, empty when it has none
+	CoverURL string `bson:"-" json:"cover_url,omitempty"`
 }
 
 func (Playlist) TableName() string {
 	return "playlists"
 }
+
+// Role returns the role of the user userID in p, empty when p is not shared with them.
+// Nobody owns a playlist without an owner, every user is an editor of it.
+func (p *Playlist) Role(userID string) string {
+	if p.OwnerID == "" {
+		return PlaylistRoleEditor
+	}
+	if p.OwnerID == userID {
+		return PlaylistRoleOwner
+	}
+	for _, c := range p.Collaborators {
+		if c.UserID == userID {
+			return c.Role
+		}
+	}
+
+	return ""
+}
+
+// Roles of the users of a playlist: viewers read it, editors also change its tracks and
+// owners also rename, share and delete it
+const (
+	PlaylistRoleViewer = "viewer"
+	PlaylistRoleEditor = "editor"
+	PlaylistRoleOwner  = "owner"
+)
+
+// PlaylistRoleRank orders the roles, each one may do what those ranked below it may
+var PlaylistRoleRank = map[string]int{
+	PlaylistRoleViewer: 1,
+	PlaylistRoleEditor: 2,
+	PlaylistRoleOwner:  3,
+}
+
+// PlaylistCollaborator is a user a playlist is shared with
+// swagger:model PlaylistCollaborator
+type PlaylistCollaborator struct {
+	// example: 6620db0b3e1ac4c9d158ae40
+	UserID string `bson:"user_id" json:"user_id"`
+	// viewer or editor
+	// example: editor
+	Role    string    `bson:"role" json:"role"`
+	AddedAt time.Time `bson:"added_at" json:"added_at"`
+}
+
+// NewSnapshotID returns the snapshot id of a new version of a playlist
+func NewSnapshotID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// PlaylistTrack is an entry of a playlist, referencing a music track
+// swagger:model PlaylistTrack
+type PlaylistTrack struct {
+	TrackID primitive.ObjectID `bson:"track_id" json:"track_id"`
+	AddedAt *time.Time         `bson:"added_at,omitempty" json:"added_at,omitempty"` // Missing in smart playlists
+	AddedBy string             `bson:"added_by,omitempty" json:"added_by,omitempty"` // ID of the user who added the track
+	// Track is looked up when the playlist is read, it is missing once the track is deleted
+	Track *MusicTrack `bson:"track,omitempty" json:"track,omitempty"`
+	// Gain is the adjustment in dB that normalizes the volume of the track
+	Gain *float64 `bson:"-" json:"gain,omitempty"`
+}
+
+// Fields and operators of the conditions of smart playlists
+const (
+	RuleOpEq       = "eq"
+	RuleOpNe       = "ne"
+	RuleOpGt       = "gt"
+	RuleOpGte      = "gte"
+	RuleOpLt       = "lt"
+	RuleOpLte      = "lte"
+	RuleOpContains = "contains" // case insensitive substring, text fields only
+)
+
+// PlaylistRules select the tracks of a smart playlist when it is read: the music tracks
+// matching all conditions, in order, up to limit
+// swagger:model PlaylistRules
+type PlaylistRules struct {
+	Conditions []*PlaylistCondition `bson:"conditions" json:"conditions" validate:"required,min=1,max=20,dive,required"`
+	// example: release_year
+	SortBy string `bson:"sort_by,omitempty" json:"sort_by,omitempty" validate:"omitempty,oneof=title artist album genre release_year track_number duration bitrate"`
+	// example: desc
+	SortOrder string `bson:"sort_order,omitempty" json:"sort_order,omitempty" validate:"omitempty,oneof=asc desc"`
+	// Most tracks the playlist holds, all matching ones without
+	// example: 100
+	Limit int `bson:"limit,omitempty" json:"limit,omitempty" validate:"min=0,max=10000"`
+}
+
+// PlaylistCondition compares a field of music tracks with a value: a string for text
+// fields, a number for the others
+// swagger:model PlaylistCondition
+type PlaylistCondition struct {
+	// example: genre
+	Field string `bson:"field" json:"field" validate:"required,oneof=title artist album genre release_year track_number duration bitrate"`
+	// example: eq
+	Operator string `bson:"operator" json:"operator" validate:"required,oneof=eq ne gt gte lt lte contains"`
+	// example: Ballad
+	Value interface{} `bson:"value" json:"value"`
+}
+
+// IsText reports whether the field of c holds text
+func (c *PlaylistCondition) IsText() bool {
+	switch c.Field {
+	case "title", "artist", "album", "genre":
+		return true
+	}
+
+	return false
+}
+
+// PlaylistStats summarizes the music tracks of a playlist. Tracks without a genre,
+// release year or artist are left out of the distributions of those.
+// swagger:model PlaylistStats
+type PlaylistStats struct {
+	TrackCount    int64 `bson:"track_count" json:"track_count"`
+	TotalDuration int64 `bson:"total_duration" json:"total_duration"` // Total duration in seconds
+	// AverageReleaseYear is missing when no track has a release year
+	AverageReleaseYear *float64       `bson:"average_release_year,omitempty" json:"average_release_year,omitempty"`
+	Genres             []*GenreCount  `bson:"genres" json:"genres"`   // Most tracks first
+	Decades            []*DecadeCount `bson:"decades" json:"decades"` // Oldest first
+	TopArtists         []*ArtistCount `bson:"top_artists" json:"top_artists"`
+}
+
+// GenreCount is the number of tracks of a genre
+// swagger:model GenreCount
+type GenreCount struct {
+	Genre string `bson:"_id" json:"genre"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// DecadeCount is the number of tracks released in a decade
+// swagger:model DecadeCount
+type DecadeCount struct {
+	Decade int   `bson:"_id" json:"decade"` // First year of the decade, e.g. 1990
+	Count  int64 `bson:"count" json:"count"`
+}
+
+// ArtistCount is the number of tracks of an artist
+// swagger:model ArtistCount
+type ArtistCount struct {
+	Artist string `bson:"_id" json:"artist"`
+	Count  int64  `bson:"count" json:"count"`
+}
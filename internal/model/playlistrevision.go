@@ -0,0 +1,71 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Operations that change playlists, recorded with their revisions
+const (
+	RevisionOpCreate       = "create"
+	RevisionOpUpdate       = "update"
+	RevisionOpAddTracks    = "add_tracks"
+	RevisionOpMoveTracks   = "move_tracks"
+	RevisionOpShuffle      = "shuffle"
+	RevisionOpRemoveTrack  = "remove_track"
+	RevisionOpTrackDeleted = "track_deleted" // the music track was deleted
+	RevisionOpRevert       = "revert"
+	RevisionOpBaseline     = "baseline" // the playlist before its first recorded change
+)
+
+// PlaylistRevision is a playlist as a change left it, named by the snapshot id the
+// change gave the playlist
+// swagger:model PlaylistRevision
+type PlaylistRevision struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	PlaylistID primitive.ObjectID `bson:"playlist_id" json:"playlist_id"`
+	// example: 6620db0b3e1ac4c9d158ae39
+	SnapshotID string `bson:"snapshot_id" json:"snapshot_id"`
+	// create, update, add_tracks, move_tracks, shuffle, remove_track, track_deleted, revert
+	// or baseline
+	// example: add_tracks
+	Operation string `bson:"operation" json:"operation"`
+	// ID of the user who made the change, empty for changes made by the server
+	UserID string `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	// Snapshot id of the revision a revert restored
+	RevertedTo string `bson:"reverted_to,omitempty" json:"reverted_to,omitempty"`
+	Name       string `bson:"name" json:"name"`
+	// Tracks in order, without their music tracks. Left out of revision lists.
+	Tracks     []*PlaylistTrack `bson:"tracks" json:"tracks,omitempty"`
+	Rules      *PlaylistRules   `bson:"rules,omitempty" json:"rules,omitempty"`
+	TrackCount int              `bson:"track_count" json:"track_count"`
+	CreatedAt  time.Time        `bson:"created_at" json:"created_at"`
+}
+
+func (PlaylistRevision) TableName() string {
+	return "playlist_revisions"
+}
+
+// NewPlaylistRevision returns the revision of p after operation by the user userID
+func NewPlaylistRevision(p *Playlist, operation, userID string) *PlaylistRevision {
+	tracks := make([]*PlaylistTrack, 0, len(p.Tracks))
+	for _, t := range p.Tracks {
+		if t == nil {
+			continue
+		}
+		tracks = append(tracks, &PlaylistTrack{TrackID: t.TrackID, AddedAt: t.AddedAt, AddedBy: t.AddedBy})
+	}
+
+	return &PlaylistRevision{
+		PlaylistID: p.ID,
+		SnapshotID: p.SnapshotID,
+		Operation:  operation,
+		UserID:     userID,
+		Name:       p.Name,
+		Tracks:     tracks,
+		Rules:      p.Rules,
+		TrackCount: len(tracks),
+		CreatedAt:  time.Now(),
+	}
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Upload is a resumable audio upload in progress, following the tus protocol
+type Upload struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID  string             `bson:"owner_id" json:"owner_id"` // User who started the upload, the only one who sees it
+	Length   int64              `bson:"length" json:"length"`
+	Offset   int64              `bson:"offset" json:"offset"`
+	Metadata map[string]string  `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	Chunks   []UploadChunk      `bson:"chunks,omitempty" json:"-"`
+	// TrackID is the music track the completed file was attached to
+	TrackID   primitive.ObjectID `bson:"track_id,omitempty" json:"track_id,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+func (Upload) TableName() string {
+	return "uploads"
+}
+
+// UploadChunk is a part of an upload kept in the configured storage
+type UploadChunk struct {
+	Key    string `bson:"key"`
+	Offset int64  `bson:"offset"`
+	Size   int64  `bson:"size"`
+}
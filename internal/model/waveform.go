@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Waveform holds the min/max peaks of a track's audio for drawing seek bars. The JSON
+// form follows the format of the audiowaveform tool, so its client libraries can read it.
+// swagger:model Waveform
+type Waveform struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	TrackID         primitive.ObjectID `bson:"track_id" json:"track_id"`
+	Checksum        string             `bson:"checksum" json:"-"` // Checksum of the audio the peaks were computed from
+	Version         int                `bson:"-" json:"version"`
+	Channels        int                `bson:"-" json:"channels"`
+	SampleRate      int                `bson:"sample_rate" json:"sample_rate"`
+	SamplesPerPixel int                `bson:"samples_per_pixel" json:"samples_per_pixel"`
+	Bits            int                `bson:"bits" json:"bits"`
+	Length          int                `bson:"length" json:"length"`
+	Data            []int16            `bson:"data" json:"data"` // Min and max of every bucket, interleaved
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func (Waveform) TableName() string {
+	return "waveforms"
+}
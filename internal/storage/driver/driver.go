@@ -0,0 +1,43 @@
+// Package driver builds the storage selected in the configuration
+package driver
+
+import (
+	"fmt"
+
+	"music-master/config"
+	"music-master/internal/db"
+	"music-master/internal/storage"
+	"music-master/internal/storage/filesystem"
+	"music-master/internal/storage/gridfs"
+	"music-master/internal/storage/s3"
+)
+
+// AudioBucket is the GridFS bucket holding objects when the gridfs driver is used
+const AudioBucket = "audio"
+
+// New returns the storage selected by cfg.StorageDriver
+func New(cfg *config.Configuration, mongoDB *db.Database) (storage.Storage, error) {
+	switch cfg.StorageDriver {
+	case "", "gridfs":
+		bucket, err := mongoDB.Bucket(AudioBucket)
+		if err != nil {
+			return nil, err
+		}
+		return gridfs.New(bucket), nil
+
+	case "filesystem":
+		return filesystem.New(cfg.StoragePath)
+
+	case "s3":
+		return s3.New(s3.Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			UseSSL:    cfg.S3UseSSL,
+		})
+	}
+
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+}
@@ -0,0 +1,156 @@
+// Package gridfs stores objects in a MongoDB GridFS bucket, using the key as file id
+package gridfs
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"music-master/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Storage stores objects in a GridFS bucket
+type Storage struct {
+	bucket *gridfs.Bucket
+}
+
+// New creates a GridFS storage on top of bucket
+func New(bucket *gridfs.Bucket) *Storage {
+	return &Storage{bucket: bucket}
+}
+
+// Put replaces the file with id key, GridFS has no upsert so an existing file is removed first
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := s.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	opts := options.GridFSUpload().SetMetadata(bson.M{"content_type": contentType})
+	stream, err := s.bucket.OpenUploadStreamWithID(key, key, opts)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+
+	if _, err := io.Copy(stream, r); err != nil {
+		stream.Abort()
+		return err
+	}
+
+	return stream.Close()
+}
+
+func (s *Storage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	return OpenFile(ctx, s.bucket, key)
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.bucket.DeleteContext(ctx, key); err != nil && err != gridfs.ErrFileNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// OpenFile returns a seekable reader over the GridFS file with the given id
+func OpenFile(ctx context.Context, bucket *gridfs.Bucket, fileID interface{}) (io.ReadSeekCloser, error) {
+	r := &fileReader{ctx: ctx, bucket: bucket, fileID: fileID}
+	if err := r.open(0); err != nil {
+		if err == gridfs.ErrFileNotFound {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	r.size = r.stream.GetFile().Length
+
+	return r, nil
+}
+
+// fileReader implements io.ReadSeekCloser on top of GridFS download streams,
+// which can only move forward, by reopening the stream when seeking backwards
+type fileReader struct {
+	ctx    context.Context
+	bucket *gridfs.Bucket
+	fileID interface{}
+	stream *gridfs.DownloadStream
+	size   int64
+	pos    int64 // position the next Read starts at
+	sPos   int64 // position of the open stream
+}
+
+func (r *fileReader) open(offset int64) error {
+	if r.stream != nil {
+		r.stream.Close()
+	}
+	stream, err := r.bucket.OpenDownloadStream(r.fileID)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := r.ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+	r.stream = stream
+	r.sPos = 0
+	if offset > 0 {
+		skipped, err := stream.Skip(offset)
+		if err != nil {
+			return err
+		}
+		r.sPos = skipped
+	}
+
+	return nil
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.pos != r.sPos {
+		if r.pos > r.sPos {
+			skipped, err := r.stream.Skip(r.pos - r.sPos)
+			r.sPos += skipped
+			if err != nil {
+				return 0, err
+			}
+		} else if err := r.open(r.pos); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.stream.Read(p)
+	r.pos += int64(n)
+	r.sPos += int64(n)
+
+	return n, err
+}
+
+func (r *fileReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("gridfs: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("gridfs: negative position")
+	}
+	r.pos = abs
+
+	return abs, nil
+}
+
+func (r *fileReader) Close() error {
+	return r.stream.Close()
+}
@@ -0,0 +1,22 @@
+// Package storage defines where binary objects such as track audio are kept.
+// Implementations live in the sub packages and are selected by driver.New.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage represents a blob store addressed by keys like "tracks/<id>/<file>"
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns a seekable reader over the object stored under key
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the object stored under key, deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,100 @@
+// Package artwork decodes and resizes cover images
+package artwork
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+var (
+	// ErrUnsupportedImage is returned for images that are neither JPEG nor PNG
+	ErrUnsupportedImage = errors.New("artwork: unsupported image format")
+	// ErrImageTooLarge is returned for images wider or higher than MaxDimension
+	ErrImageTooLarge = errors.New("artwork: image too large")
+)
+
+// MaxDimension bounds the width and height of images that are decoded, a few KB of
+// compressed data can otherwise expand into gigabytes of pixels
+const MaxDimension = 8192
+
+const jpegQuality = 85
+
+// Dimensions returns the width and height of a JPEG or PNG image without decoding
+// its pixels. Images larger than MaxDimension return ErrImageTooLarge.
+func Dimensions(r io.Reader) (int, int, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if format != "jpeg" && format != "png" {
+		return 0, 0, ErrUnsupportedImage
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return 0, 0, ErrImageTooLarge
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
+
+// Thumbnail scales the image read from r so that it fits a size x size box, keeping
+// its aspect ratio. Images are never scaled up. The thumbnail is encoded in the format
+// of the source, whose MIME type is returned along with the encoded bytes. Images
+// larger than MaxDimension return ErrImageTooLarge before any pixel is decoded.
+func Thumbnail(r io.Reader, size int) ([]byte, string, error) {
+	// * the header read to check the size is replayed to the decoder
+	var header bytes.Buffer
+	if _, _, err := Dimensions(io.TeeReader(r, &header)); err != nil {
+		return nil, "", err
+	}
+	src, format, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := src.Bounds()
+	width, height := fit(bounds.Dx(), bounds.Dy(), size)
+	dst := image.Image(src)
+	if width != bounds.Dx() || height != bounds.Dy() {
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, bounds, draw.Src, nil)
+		dst = scaled
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		return buf.Bytes(), "image/jpeg", err
+	case "png":
+		err = png.Encode(&buf, dst)
+		return buf.Bytes(), "image/png", err
+	}
+
+	return nil, "", ErrUnsupportedImage
+}
+
+// fit returns the size of a width x height image scaled down into a size x size box
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		h := height * size / width
+		if h < 1 {
+			h = 1
+		}
+		return size, h
+	}
+	w := width * size / height
+	if w < 1 {
+		w = 1
+	}
+
+	return w, size
+}
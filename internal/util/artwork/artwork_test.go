@@ -0,0 +1,86 @@
+package artwork
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		image         []byte
+		size          int
+		width, height int
+		mimeType      string
+	}{
+		{"landscape JPEG", encodeJPEG(t, 1000, 500), 300, 300, 150, "image/jpeg"},
+		{"portrait PNG", encodePNG(t, 400, 1200), 300, 100, 300, "image/png"},
+		{"never scaled up", encodePNG(t, 50, 40), 300, 50, 40, "image/png"},
+		{"thin strip", encodePNG(t, 2000, 2), 64, 64, 1, "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumbnail, mimeType, err := Thumbnail(bytes.NewReader(tt.image), tt.size)
+			if err != nil {
+				t.Fatalf("Thumbnail() error = %v", err)
+			}
+			if mimeType != tt.mimeType {
+				t.Errorf("Thumbnail() MIME type = %q, want %q", mimeType, tt.mimeType)
+			}
+			width, height, err := Dimensions(bytes.NewReader(thumbnail))
+			if err != nil || width != tt.width || height != tt.height {
+				t.Errorf("thumbnail is %dx%d (%v), want %dx%d", width, height, err, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestImageTooLarge(t *testing.T) {
+	// * a PNG of a single color compresses to a few KB whatever its size
+	for _, img := range [][]byte{encodePNG(t, MaxDimension+1, 1), encodePNG(t, 1, MaxDimension+1)} {
+		if len(img) > 64<<10 {
+			t.Fatalf("test image is %d bytes", len(img))
+		}
+		if _, _, err := Dimensions(bytes.NewReader(img)); err != ErrImageTooLarge {
+			t.Errorf("Dimensions() error = %v, want ErrImageTooLarge", err)
+		}
+		if _, _, err := Thumbnail(bytes.NewReader(img), 300); err != ErrImageTooLarge {
+			t.Errorf("Thumbnail() error = %v, want ErrImageTooLarge", err)
+		}
+	}
+
+	if w, h, err := Dimensions(bytes.NewReader(encodePNG(t, MaxDimension, 1))); err != nil || w != MaxDimension || h != 1 {
+		t.Errorf("Dimensions() = %d, %d, %v, want %d, 1", w, h, err, MaxDimension)
+	}
+}
+
+func TestUnsupportedImage(t *testing.T) {
+	if _, _, err := Dimensions(bytes.NewReader([]byte("GIF89a not really"))); err == nil {
+		t.Error("Dimensions() of a non image succeeded")
+	}
+	if _, _, err := Thumbnail(bytes.NewReader([]byte("plain text")), 300); err == nil {
+		t.Error("Thumbnail() of a non image succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var errInvalidADTS = errors.New("audio: invalid ADTS stream")
+
+const (
+	adtsHeaderSize     = 7
+	aacSamplesPerBlock = 1024
+)
+
+var adtsSampleRates = [13]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// adtsHeader is a decoded ADTS frame header
+type adtsHeader struct {
+	SampleRate  int
+	Channels    int
+	FrameLength int
+	Blocks      int
+}
+
+func parseADTSHeader(b []byte) (adtsHeader, bool) {
+	if len(b) < adtsHeaderSize || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return adtsHeader{}, false
+	}
+	rateIndex := int(b[2] >> 2 & 0x0F)
+	if rateIndex >= len(adtsSampleRates) {
+		return adtsHeader{}, false
+	}
+	h := adtsHeader{
+		SampleRate:  adtsSampleRates[rateIndex],
+		Channels:    int(b[2]&0x01)<<2 | int(b[3]>>6),
+		FrameLength: int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5]>>5),
+		Blocks:      int(b[6]&0x03) + 1,
+	}
+	if h.FrameLength < adtsHeaderSize {
+		return adtsHeader{}, false
+	}
+	// * channel configuration 7 is 7.1
+	if h.Channels == 7 {
+		h.Channels = 8
+	}
+
+	return h, true
+}
+
+// readADTS walks the frames of a raw AAC stream, which has no global header telling
+// the duration
+func readADTS(r io.ReaderAt, size int64) (*Info, error) {
+	start := id3v2Length(r)
+	end := audioEnd(r, size)
+	br := newBlockReader(r, size)
+
+	first, ok := parseADTSHeader(br.bytes(start, adtsHeaderSize))
+	if !ok {
+		return nil, errInvalidADTS
+	}
+
+	var samples int64
+	pos := start
+	for pos+adtsHeaderSize <= end {
+		h, ok := parseADTSHeader(br.bytes(pos, adtsHeaderSize))
+		if !ok || h.SampleRate != first.SampleRate {
+			break
+		}
+		samples += int64(h.Blocks * aacSamplesPerBlock)
+		pos += int64(h.FrameLength)
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	info := &Info{
+		Codec:      CodecAAC,
+		SampleRate: first.SampleRate,
+		Channels:   first.Channels,
+		Duration:   time.Duration(samples * int64(time.Second) / int64(first.SampleRate)),
+	}
+	info.Bitrate = averageBitrate(pos-start, info.Duration)
+	tags, frames, _ := readID3(r, size)
+	info.Tags = tags
+	info.Picture = id3Picture(frames)
+
+	return info, nil
+}
@@ -0,0 +1,315 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+)
+
+// ErrNoDecoder is returned for codecs whose audio cannot be decoded to PCM. Opus, AAC
+// and ALAC have no pure Go decoder we can use.
+var ErrNoDecoder = errors.New("audio: no decoder for codec")
+
+// decodeChunk is the number of mono samples handed to the callback at once
+const decodeChunk = 4096
+
+// CanDecode reports whether DecodeMono supports the codec
+func CanDecode(codec string) bool {
+	switch codec {
+	case CodecMP3, CodecFLAC, CodecVorbis, CodecPCM:
+		return true
+	}
+
+	return false
+}
+
+// Decode decodes the audio of r, whose format was probed into info, and passes it to fn
+// in chunks of interleaved samples in the range [-1, 1], along with the number of
+// channels. Chunks always hold whole sample frames. The slice passed to fn is reused
+// between calls.
+func Decode(r io.ReadSeeker, info *Info, fn func(samples []float32, channels int) error) error {
+	if !CanDecode(info.Codec) {
+		return ErrNoDecoder
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	at := NewReaderAt(r)
+	start := id3v2Length(at)
+
+	if info.Codec == CodecPCM {
+		return decodeWAV(r, size, fn)
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	// * hide Seek from the decoders, go-mp3 would scan the whole file up front
+	stream := bufio.NewReaderSize(struct{ io.Reader }{r}, 64<<10)
+	switch info.Codec {
+	case CodecMP3:
+		return decodeMP3(stream, fn)
+	case CodecFLAC:
+		return decodeFLAC(stream, fn)
+	case CodecVorbis:
+		return decodeVorbis(stream, fn)
+	}
+
+	return ErrNoDecoder
+}
+
+// DecodeMono decodes the audio of r like Decode and passes it to fn in chunks of mono
+// samples in the range [-1, 1]. Channels are averaged.
+// The slice passed to fn is reused between calls.
+func DecodeMono(r io.ReadSeeker, info *Info, fn func(samples []float32) error) error {
+	var mono []float32
+	return Decode(r, info, func(samples []float32, channels int) error {
+		mono = mono[:0]
+		for i := 0; i+channels <= len(samples); i += channels {
+			var sum float32
+			for _, v := range samples[i : i+channels] {
+				sum += v
+			}
+			mono = append(mono, sum/float32(channels))
+		}
+		return fn(mono)
+	})
+}
+
+// chunker collects interleaved samples into chunks of whole sample frames
+type chunker struct {
+	channels int
+	buf      []float32
+	fn       func([]float32, int) error
+}
+
+func newChunker(channels int, fn func([]float32, int) error) *chunker {
+	if channels < 1 {
+		channels = 1
+	}
+
+	return &chunker{channels: channels, buf: make([]float32, 0, decodeChunk*channels), fn: fn}
+}
+
+// sample adds the next sample, channels are given in order for every frame
+func (c *chunker) sample(v float32) error {
+	c.buf = append(c.buf, v)
+	if len(c.buf) == cap(c.buf) {
+		return c.flush()
+	}
+
+	return nil
+}
+
+func (c *chunker) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.fn(c.buf, c.channels)
+	c.buf = c.buf[:0]
+
+	return err
+}
+
+func decodeMP3(r io.Reader, fn func([]float32, int) error) error {
+	d, err := mp3.NewDecoder(r)
+	if err != nil {
+		return err
+	}
+
+	// * go-mp3 always outputs 16 bit little endian stereo
+	c := newChunker(2, fn)
+	buf := make([]byte, 16<<10)
+	for {
+		n, err := io.ReadFull(d, buf)
+		for i := 0; i+3 < n; i += 4 {
+			for _, off := range [2]int{i, i + 2} {
+				if err := c.sample(float32(int16(binary.LittleEndian.Uint16(buf[off:]))) / 32768); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return c.flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decodeFLAC(r io.Reader, fn func([]float32, int) error) error {
+	stream, err := flac.New(r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	scale := float32(int64(1) << (stream.Info.BitsPerSample - 1))
+	c := newChunker(int(stream.Info.NChannels), fn)
+	for {
+		frame, err := stream.ParseNext()
+		if err == io.EOF {
+			return c.flush()
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < int(frame.BlockSize); i++ {
+			for _, sub := range frame.Subframes {
+				if err := c.sample(float32(sub.Samples[i]) / scale); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func decodeVorbis(r io.Reader, fn func([]float32, int) error) error {
+	d, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	channels := d.Channels()
+	buf := make([]float32, channels*decodeChunk)
+	for {
+		// * the decoder returns whole sample frames
+		n, err := d.Read(buf)
+		if n > 0 {
+			if err := fn(buf[:n-n%channels], channels); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decodeWAV(r io.ReadSeeker, size int64, fn func([]float32, int) error) error {
+	at := NewReaderAt(r)
+	chunks, err := riffChunks(at, size)
+	if err != nil {
+		return err
+	}
+
+	var (
+		format *wavFormat
+		data   *riffChunk
+	)
+	for i, c := range chunks {
+		switch strings.ToLower(c.ID) {
+		case "fmt ":
+			f, err := readWAVFormat(at, c)
+			if err != nil {
+				return err
+			}
+			format = &f
+		case "data":
+			data = &chunks[i]
+		}
+	}
+	if format == nil || data == nil || format.Channels < 1 || format.BlockAlign < format.Channels {
+		return errInvalidWAV
+	}
+	width := format.BlockAlign / format.Channels
+	sample := wavSampleDecoder(format.Float, width)
+	if sample == nil {
+		return ErrUnsupportedFormat
+	}
+
+	if _, err := r.Seek(data.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	stream := bufio.NewReaderSize(io.LimitReader(r, data.Size), 64<<10)
+	c := newChunker(format.Channels, fn)
+	block := make([]byte, format.BlockAlign)
+	for {
+		if _, err := io.ReadFull(stream, block); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return c.flush()
+			}
+			return err
+		}
+		for ch := 0; ch < format.Channels; ch++ {
+			if err := c.sample(sample(block[ch*width : (ch+1)*width])); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// wavSampleDecoder returns the function converting one little endian sample of the
+// given width to [-1, 1]
+func wavSampleDecoder(float bool, width int) func([]byte) float32 {
+	switch {
+	case float && width == 4:
+		return func(b []byte) float32 {
+			return math.Float32frombits(binary.LittleEndian.Uint32(b))
+		}
+	case float && width == 8:
+		return func(b []byte) float32 {
+			return float32(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+	case width == 1:
+		// * 8 bit samples are unsigned
+		return func(b []byte) float32 {
+			return float32(int(b[0])-128) / 128
+		}
+	case width == 2:
+		return func(b []byte) float32 {
+			return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		}
+	case width == 3:
+		return func(b []byte) float32 {
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float32(v) / (1 << 23)
+		}
+	case width == 4:
+		return func(b []byte) float32 {
+			return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+		}
+	}
+
+	return nil
+}
+
+// readerAt serves ReadAt from an io.ReadSeeker by seeking before every read, it is
+// not safe for concurrent use
+type readerAt struct {
+	rs io.ReadSeeker
+}
+
+// NewReaderAt adapts a seekable stream, such as an object opened from storage, to
+// the io.ReaderAt the parsers of this package take
+func NewReaderAt(rs io.ReadSeeker) io.ReaderAt {
+	if at, ok := rs.(io.ReaderAt); ok {
+		return at
+	}
+
+	return readerAt{rs}
+}
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
@@ -0,0 +1,235 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"time"
+)
+
+// Fingerprints follow Haitsma and Kalker, "A Highly Robust Audio Fingerprinting
+// System": the mono mix is downsampled, cut into heavily overlapping frames, and
+// every frame yields a 32 bit hash from the energy differences between 33 bands
+// of its spectrum and those of the previous frame. Re-encoding the audio flips
+// few of those bits, unrelated audio differs in about half of them.
+const (
+	fingerprintSampleRate = 5512
+	fingerprintFrameSize  = 2048
+	fingerprintHop        = 128 // ~23 ms
+	fingerprintBands      = 33
+	fingerprintMinFreq    = 300
+	fingerprintMaxFreq    = 2000
+
+	// FingerprintLength bounds how much audio is fingerprinted, from the start of a track
+	FingerprintLength = 120 * time.Second
+
+	// MaxDurationDifference is how much longer or shorter another file of the same
+	// recording may be, e.g. a rip with more silence at the end
+	MaxDurationDifference = 5 * time.Second
+
+	// maxFingerprintShift is how far fingerprints are slid against each other when
+	// compared, in hashes: about 2 seconds of lead-in silence or encoder delay
+	maxFingerprintShift = 2 * fingerprintSampleRate / fingerprintHop
+
+	// minFingerprintOverlap is the fewest hashes worth comparing, about 5 seconds
+	minFingerprintOverlap = 5 * fingerprintSampleRate / fingerprintHop
+)
+
+// errFingerprintFull stops decoding once FingerprintLength of audio has been seen
+var errFingerprintFull = errors.New("audio: fingerprint complete")
+
+// Fingerprint is the acoustic fingerprint of an audio stream
+type Fingerprint struct {
+	Duration time.Duration // duration of the whole stream
+	Hashes   []uint32
+}
+
+// ComputeFingerprint decodes the start of r and computes its fingerprint
+func ComputeFingerprint(r io.ReadSeeker) (*Fingerprint, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	info, err := Probe(NewReaderAt(r), size)
+	if err != nil {
+		return nil, err
+	}
+	if !CanDecode(info.Codec) {
+		return nil, ErrNoDecoder
+	}
+
+	fp := newFingerprinter(info.SampleRate)
+	if err := DecodeMono(r, info, fp.write); err != nil && err != errFingerprintFull {
+		return nil, err
+	}
+
+	return &Fingerprint{Duration: info.Duration, Hashes: fp.hashes}, nil
+}
+
+// Similarity compares two fingerprints: 1 for the same audio, around 0.5 for unrelated
+// audio. Fingerprints are aligned at the shift scoring best, and fingerprints too short
+// to tell score 0.
+func Similarity(a, b []uint32) float64 {
+	minOverlap := len(a)
+	if len(b) < minOverlap {
+		minOverlap = len(b)
+	}
+	minOverlap /= 2
+	if minOverlap < minFingerprintOverlap {
+		minOverlap = minFingerprintOverlap
+	}
+
+	best := 0.0
+	for shift := -maxFingerprintShift; shift <= maxFingerprintShift; shift++ {
+		// * a[i] is compared with b[i+shift]
+		start, end := 0, len(a)
+		if shift < 0 {
+			start = -shift
+		}
+		if len(b)-shift < end {
+			end = len(b) - shift
+		}
+		if end-start < minOverlap {
+			continue
+		}
+
+		errs := 0
+		for i := start; i < end; i++ {
+			errs += bits.OnesCount32(a[i] ^ b[i+shift])
+		}
+		if score := 1 - float64(errs)/float64((end-start)*32); score > best {
+			best = score
+		}
+	}
+
+	return best
+}
+
+// fingerprinter turns decoded samples into hashes as they arrive
+type fingerprinter struct {
+	ratio  float64 // input samples per output sample
+	pos    float64
+	acc    float64
+	count  int
+	frame  []float64
+	window []float64
+	bands  [fingerprintBands + 1]int // first FFT bin of every band, and the end
+	spec   []complex128
+	prev   []float64
+	energy []float64
+	hashes []uint32
+	limit  int
+}
+
+func newFingerprinter(sampleRate int) *fingerprinter {
+	fp := &fingerprinter{
+		ratio:  float64(sampleRate) / fingerprintSampleRate,
+		frame:  make([]float64, 0, fingerprintFrameSize),
+		window: make([]float64, fingerprintFrameSize),
+		spec:   make([]complex128, fingerprintFrameSize),
+		energy: make([]float64, fingerprintBands),
+		limit:  int(FingerprintLength.Seconds()*fingerprintSampleRate) / fingerprintHop,
+	}
+	if fp.ratio < 1 {
+		fp.ratio = 1
+	}
+	for i := range fp.window {
+		fp.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+	// * bands are spaced logarithmically, like pitch
+	step := math.Pow(float64(fingerprintMaxFreq)/fingerprintMinFreq, 1.0/fingerprintBands)
+	for b := range fp.bands {
+		freq := fingerprintMinFreq * math.Pow(step, float64(b))
+		fp.bands[b] = int(freq * fingerprintFrameSize / fingerprintSampleRate)
+	}
+
+	return fp
+}
+
+// write downsamples samples by averaging, which also filters out what lies above
+// the bands, and hashes every complete frame
+func (fp *fingerprinter) write(samples []float32) error {
+	for _, v := range samples {
+		fp.acc += float64(v)
+		fp.count++
+		fp.pos++
+		if fp.pos < fp.ratio {
+			continue
+		}
+		fp.pos -= fp.ratio
+		fp.frame = append(fp.frame, fp.acc/float64(fp.count))
+		fp.acc, fp.count = 0, 0
+
+		if len(fp.frame) == fingerprintFrameSize {
+			fp.hashFrame()
+			if len(fp.hashes) >= fp.limit {
+				return errFingerprintFull
+			}
+			n := copy(fp.frame, fp.frame[fingerprintHop:])
+			fp.frame = fp.frame[:n]
+		}
+	}
+
+	return nil
+}
+
+// hashFrame computes the band energies of the current frame and, from the second
+// frame on, the hash comparing them with those of the previous frame
+func (fp *fingerprinter) hashFrame() {
+	for i, v := range fp.frame {
+		fp.spec[i] = complex(v*fp.window[i], 0)
+	}
+	fft(fp.spec)
+
+	for b := 0; b < fingerprintBands; b++ {
+		var e float64
+		for k := fp.bands[b]; k < fp.bands[b+1]; k++ {
+			re, im := real(fp.spec[k]), imag(fp.spec[k])
+			e += re*re + im*im
+		}
+		fp.energy[b] = e
+	}
+
+	if fp.prev == nil {
+		fp.prev = make([]float64, fingerprintBands)
+	} else {
+		var h uint32
+		for m := 0; m < fingerprintBands-1; m++ {
+			if fp.energy[m]-fp.energy[m+1]-(fp.prev[m]-fp.prev[m+1]) > 0 {
+				h |= 1 << uint(m)
+			}
+		}
+		fp.hashes = append(fp.hashes, h)
+	}
+	copy(fp.prev, fp.energy)
+}
+
+// fft transforms x in place, len(x) must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := x[start+k]
+				v := x[start+k+size/2] * wk
+				x[start+k] = u + v
+				x[start+k+size/2] = u - v
+				wk *= w
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// fingerprintThreshold is the default FINGERPRINT_THRESHOLD
+const fingerprintThreshold = 0.75
+
+// melody returns seconds of a tune at rate Hz: three random tones with an overtone
+// each, changing every 200 ms, generated from seed. The tune starts after delay
+// samples of silence, is scaled by gain and mixed with white noise of amplitude noise.
+func melody(seed int64, rate, seconds, delay int, gain, noise float64) []float32 {
+	tones := rand.New(rand.NewSource(seed))
+	hiss := rand.New(rand.NewSource(seed + 1))
+	n := rate * seconds
+	samples := make([]float32, delay+n)
+	var freqs [3]float64
+	for i := 0; i < n; i++ {
+		if i%(rate/5) == 0 {
+			freqs = [3]float64{200 + tones.Float64()*800, 200 + tones.Float64()*800, 100 + tones.Float64()*300}
+		}
+		t := float64(i) / float64(rate)
+		var v float64
+		for _, f := range freqs {
+			v += 0.2*math.Sin(2*math.Pi*f*t) + 0.1*math.Sin(4*math.Pi*f*t)
+		}
+		samples[delay+i] = float32(v*gain + noise*(hiss.Float64()*2-1))
+	}
+
+	return samples
+}
+
+// hashes fingerprints samples written in blocks, the way a decoder delivers them
+func hashes(samples []float32, rate int) []uint32 {
+	fp := newFingerprinter(rate)
+	for len(samples) > 0 {
+		n := 4096
+		if n > len(samples) {
+			n = len(samples)
+		}
+		if fp.write(samples[:n]) != nil {
+			break
+		}
+		samples = samples[n:]
+	}
+
+	return fp.hashes
+}
+
+func TestSimilarity(t *testing.T) {
+	const rate = 44100
+	orig := hashes(melody(1, rate, 40, 0, 1, 0), rate)
+
+	tests := []struct {
+		name string
+		b    []uint32
+		same bool
+	}{
+		{"identical", orig, true},
+		{"quieter, noisy, 25 ms late", hashes(melody(1, rate, 40, rate/40, 0.7, 0.05), rate), true},
+		{"1 s of lead-in silence", hashes(melody(1, rate, 40, rate, 1, 0.1), rate), true},
+		{"resampled to 22.05 kHz", hashes(melody(1, rate/2, 40, 0, 1, 0), rate/2), true},
+		{"another tune", hashes(melody(2, rate, 40, 0, 1, 0), rate), false},
+		{"too short", hashes(melody(1, rate, 3, 0, 1, 0), rate), false},
+	}
+	for _, tt := range tests {
+		got := Similarity(orig, tt.b)
+		if tt.same && got < fingerprintThreshold {
+			t.Errorf("%s: Similarity() = %.3f, want at least %.2f", tt.name, got, fingerprintThreshold)
+		}
+		if !tt.same && got >= fingerprintThreshold {
+			t.Errorf("%s: Similarity() = %.3f, want below %.2f", tt.name, got, fingerprintThreshold)
+		}
+		if rev := Similarity(tt.b, orig); rev != got {
+			t.Errorf("%s: Similarity() is %.3f one way and %.3f the other", tt.name, got, rev)
+		}
+	}
+
+	if got := Similarity(orig, orig); got != 1 {
+		t.Errorf("Similarity() of a fingerprint with itself = %v, want 1", got)
+	}
+	if got := Similarity(nil, orig); got != 0 {
+		t.Errorf("Similarity() of an empty fingerprint = %v, want 0", got)
+	}
+}
+
+func TestComputeFingerprint(t *testing.T) {
+	const rate = 22050
+	pcm := func(samples []float32) []int16 {
+		out := make([]int16, len(samples))
+		for i, v := range samples {
+			out[i] = int16(v * 16000)
+		}
+		return out
+	}
+
+	file := wavFile(rate, 1, pcm(melody(1, rate, 150, 0, 1, 0)), nil)
+	fp, err := ComputeFingerprint(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ComputeFingerprint() error = %v", err)
+	}
+	if fp.Duration != 150*time.Second {
+		t.Errorf("Duration = %v, want 150s", fp.Duration)
+	}
+	// * only the first FingerprintLength of audio is hashed
+	if want := int(FingerprintLength.Seconds()*fingerprintSampleRate) / fingerprintHop; len(fp.Hashes) != want {
+		t.Errorf("%d hashes, want %d", len(fp.Hashes), want)
+	}
+
+	other, err := ComputeFingerprint(bytes.NewReader(wavFile(rate, 1, pcm(melody(1, rate, 150, 500, 0.8, 0.05)), nil)))
+	if err != nil {
+		t.Fatalf("ComputeFingerprint() error = %v", err)
+	}
+	if got := Similarity(fp.Hashes, other.Hashes); got < fingerprintThreshold {
+		t.Errorf("Similarity() of two files of the same tune = %.3f, want at least %.2f", got, fingerprintThreshold)
+	}
+
+	if _, err := ComputeFingerprint(bytes.NewReader([]byte("not audio at all"))); err == nil {
+		t.Error("ComputeFingerprint() of garbage succeeded")
+	}
+}
+
+func TestFFT(t *testing.T) {
+	const n = 64
+	x := make([]complex128, n)
+	for i := range x {
+		x[i] = complex(math.Cos(2*math.Pi*5*float64(i)/n)+0.5*math.Sin(2*math.Pi*12*float64(i)/n), 0)
+	}
+	want := make([]complex128, n)
+	for k := range want {
+		for i, v := range x {
+			want[k] += v * cmplx.Exp(complex(0, -2*math.Pi*float64(k*i)/n))
+		}
+	}
+
+	fft(x)
+	for k := range x {
+		if cmplx.Abs(x[k]-want[k]) > 1e-9 {
+			t.Errorf("bin %d = %v, want %v", k, x[k], want[k])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var errInvalidFLAC = errors.New("audio: invalid FLAC stream")
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// maxFLACBlock bounds how much of a metadata block is read into memory, the length
+// field is 24 bits wide so this is only hit by pictures
+const maxFLACBlock = 16 << 20
+
+// flacBlock is a metadata block of a FLAC stream
+type flacBlock struct {
+	Type int
+	Data []byte
+}
+
+// readFLACBlocks returns the metadata blocks of the FLAC stream starting at start and
+// the offset of the first audio frame. Only blocks of the wanted types are loaded.
+func readFLACBlocks(r io.ReaderAt, start int64, wanted ...int) ([]flacBlock, int64, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, start); err != nil || string(magic) != "fLaC" {
+		return nil, 0, errInvalidFLAC
+	}
+
+	var blocks []flacBlock
+	pos := start + 4
+	header := make([]byte, 4)
+	for {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return nil, 0, errInvalidFLAC
+		}
+		last := header[0]&0x80 != 0
+		typ := int(header[0] & 0x7F)
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		pos += 4
+
+		for _, w := range wanted {
+			if w != typ || length > maxFLACBlock {
+				continue
+			}
+			data := make([]byte, length)
+			if _, err := r.ReadAt(data, pos); err != nil {
+				return nil, 0, errInvalidFLAC
+			}
+			blocks = append(blocks, flacBlock{Type: typ, Data: data})
+			break
+		}
+
+		pos += length
+		if last || typ == 0x7F {
+			break
+		}
+	}
+
+	return blocks, pos, nil
+}
+
+func readFLAC(r io.ReaderAt, size int64) (*Info, error) {
+	start := id3v2Length(r)
+	blocks, audioStart, err := readFLACBlocks(r, start, flacStreamInfo, flacVorbisComment, flacPicture)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Codec: CodecFLAC}
+	found := false
+	for _, b := range blocks {
+		switch b.Type {
+		case flacStreamInfo:
+			if len(b.Data) < 18 {
+				return nil, errInvalidFLAC
+			}
+			// * 20 bits sample rate, 3 bits channels-1, 5 bits bits per sample-1,
+			// 36 bits total samples, right after the block and frame size limits
+			v := binary.BigEndian.Uint64(b.Data[10:18])
+			info.SampleRate = int(v >> 44)
+			info.Channels = int(v>>41&0x7) + 1
+			info.BitDepth = int(v>>36&0x1F) + 1
+			samples := int64(v & 0xFFFFFFFFF)
+			if info.SampleRate > 0 {
+				info.Duration = time.Duration(samples * int64(time.Second) / int64(info.SampleRate))
+			}
+			found = true
+		case flacVorbisComment:
+			comments := parseVorbisComments(b.Data)
+			info.Tags = comments.Tags()
+			info.Picture = betterPicture(info.Picture, comments.Picture())
+		case flacPicture:
+			info.Picture = betterPicture(info.Picture, parseFLACPicture(b.Data))
+		}
+	}
+	if !found || info.SampleRate == 0 {
+		return nil, errInvalidFLAC
+	}
+
+	if start > 0 {
+		if tags := readID3Prefix(r); tags != nil {
+			if info.Tags == nil {
+				info.Tags = tags
+			} else {
+				info.Tags.merge(tags)
+			}
+		}
+	}
+	info.Bitrate = averageBitrate(size-audioStart, info.Duration)
+
+	return info, nil
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"strconv"
+	"strings"
+)
+
+// id3Genres lists the ID3v1 genres including the Winamp extensions
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native US", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebob", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore Techno", "Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "Jpop", "Synthpop", "Abstract", "Art Rock", "Baroque", "Bhangra",
+	"Big Beat", "Breakbeat", "Chillout", "Downtempo", "Dub", "EBM", "Eclectic", "Electro",
+	"Electroclash", "Emo", "Experimental", "Garage", "Global", "IDM", "Illbient", "Industro-Goth",
+	"Jam Band", "Krautrock", "Leftfield", "Lounge", "Math Rock", "New Romantic", "Nu-Breakz", "Post-Punk",
+	"Post-Rock", "Psytrance", "Shoegaze", "Space Rock", "Trop Rock", "World Music", "Neoclassical", "Audiobook",
+	"Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock", "G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
+
+// genreName returns the ID3v1 genre with the given index, or "" when unknown
+func genreName(index int) string {
+	if index < 0 || index >= len(id3Genres) {
+		return ""
+	}
+
+	return id3Genres[index]
+}
+
+// resolveGenre turns the content of a TCON frame into a readable genre. It understands
+// plain names, bare numbers ("17"), ID3v2.3 references ("(17)", "(17)Rock", "(RX)") and
+// the "((" escape. A free text refinement wins over the numeric reference it follows.
+func resolveGenre(values []string) string {
+	var genres []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if n, err := strconv.Atoi(v); err == nil {
+			if name := genreName(n); name != "" {
+				genres = append(genres, name)
+			}
+			continue
+		}
+
+		var refs []string
+		for strings.HasPrefix(v, "(") && !strings.HasPrefix(v, "((") {
+			end := strings.IndexByte(v, ')')
+			if end < 0 {
+				break
+			}
+			ref := v[1:end]
+			v = v[end+1:]
+			switch ref {
+			case "RX":
+				refs = append(refs, "Remix")
+			case "CR":
+				refs = append(refs, "Cover")
+			default:
+				if n, err := strconv.Atoi(ref); err == nil {
+					if name := genreName(n); name != "" {
+						refs = append(refs, name)
+					}
+				}
+			}
+		}
+		if strings.HasPrefix(v, "((") {
+			v = v[1:]
+		}
+
+		if v = strings.TrimSpace(v); v != "" {
+			genres = append(genres, v)
+		} else {
+			genres = append(genres, refs...)
+		}
+	}
+
+	return strings.Join(genres, ", ")
+}
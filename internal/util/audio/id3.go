@@ -0,0 +1,508 @@
+package audio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	id3v2HeaderSize = 10
+	id3v1Size       = 128
+
+	// maxID3v2Size bounds how much of a tag is read into memory
+	maxID3v2Size = 64 << 20
+)
+
+var errInvalidID3 = errors.New("audio: invalid ID3v2 tag")
+
+// id3v2Header is the fixed 10 byte header that starts an ID3v2 tag
+type id3v2Header struct {
+	Major uint8
+	Flags uint8
+	Size  int64 // size of the tag excluding header and footer
+}
+
+// readID3v2Header returns the ID3v2 header at the start of r, if any
+func readID3v2Header(r io.ReaderAt) (*id3v2Header, error) {
+	buf := make([]byte, id3v2HeaderSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	if string(buf[:3]) != "ID3" || buf[3] < 2 || buf[3] > 4 || buf[4] == 0xFF {
+		return nil, ErrNoTags
+	}
+	for _, b := range buf[6:10] {
+		if b&0x80 != 0 {
+			return nil, errInvalidID3
+		}
+	}
+
+	return &id3v2Header{
+		Major: buf[3],
+		Flags: buf[5],
+		Size:  int64(syncsafe(buf[6:10])),
+	}, nil
+}
+
+// id3v2Length returns the number of bytes taken by the ID3v2 tag at the start of r,
+// which is where the audio data begins
+func id3v2Length(r io.ReaderAt) int64 {
+	h, err := readID3v2Header(r)
+	if err != nil {
+		return 0
+	}
+	length := id3v2HeaderSize + h.Size
+	if h.Major == 4 && h.Flags&0x10 != 0 {
+		length += id3v2HeaderSize // footer
+	}
+
+	return length
+}
+
+// hasID3v1 reports whether the file ends with an ID3v1 tag
+func hasID3v1(r io.ReaderAt, size int64) bool {
+	if size < id3v1Size {
+		return false
+	}
+	buf := make([]byte, 3)
+	if _, err := r.ReadAt(buf, size-id3v1Size); err != nil {
+		return false
+	}
+
+	return string(buf) == "TAG"
+}
+
+// ReadID3 reads ID3v2.2/2.3/2.4 and ID3v1 tags from an MP3 file. Values from the
+// ID3v2 tag win, the ID3v1 tag only fills what ID3v2 does not carry.
+func ReadID3(r io.ReaderAt, size int64) (*Tags, error) {
+	tags, _, err := readID3(r, size)
+
+	return tags, err
+}
+
+// readID3 is ReadID3 that also returns the raw ID3v2 frames, which are returned
+// even when none of them is a text tag
+func readID3(r io.ReaderAt, size int64) (*Tags, []id3Frame, error) {
+	tags, frames, err := readID3v2(r)
+	if err != nil && err != ErrNoTags {
+		return nil, nil, err
+	}
+	if tags == nil {
+		tags = &Tags{}
+	}
+
+	if v1, err := readID3v1(r, size); err == nil {
+		tags.merge(v1)
+	}
+
+	if tags.empty() {
+		return nil, frames, ErrNoTags
+	}
+
+	return tags, frames, nil
+}
+
+// id3Frame is a single decoded ID3v2 frame
+type id3Frame struct {
+	ID   string
+	Data []byte
+}
+
+// readID3v2 parses the ID3v2 tag at the start of r and returns its text tags
+// together with the raw frames
+func readID3v2(r io.ReaderAt) (*Tags, []id3Frame, error) {
+	h, err := readID3v2Header(r)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, ErrNoTags
+		}
+		return nil, nil, err
+	}
+	if h.Size > maxID3v2Size {
+		return nil, nil, errInvalidID3
+	}
+
+	body := make([]byte, h.Size)
+	n, err := r.ReadAt(body, id3v2HeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	body = body[:n]
+
+	// * before ID3v2.4 unsynchronisation applies to the whole tag
+	if h.Flags&0x80 != 0 && h.Major < 4 {
+		body = unsynchronise(body)
+	}
+
+	if h.Flags&0x40 != 0 {
+		body, err = skipExtendedHeader(body, h.Major)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	frames := parseID3v2Frames(body, h.Major, h.Flags&0x80 != 0)
+
+	return id3v2Tags(frames, h.Major), frames, nil
+}
+
+func skipExtendedHeader(body []byte, major uint8) ([]byte, error) {
+	if len(body) < 4 {
+		return nil, errInvalidID3
+	}
+	var size int
+	switch major {
+	case 3:
+		// * size excludes the 4 size bytes themselves
+		size = int(binary.BigEndian.Uint32(body[:4])) + 4
+	case 4:
+		size = int(syncsafe(body[:4]))
+	default:
+		// * ID3v2.2 used this flag for compression which was never defined
+		return nil, errInvalidID3
+	}
+	if size > len(body) {
+		return nil, errInvalidID3
+	}
+
+	return body[size:], nil
+}
+
+func parseID3v2Frames(body []byte, major uint8, tagUnsync bool) []id3Frame {
+	idLen, headerLen := 4, 10
+	if major == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var frames []id3Frame
+	for pos := 0; pos+headerLen <= len(body); {
+		header := body[pos : pos+headerLen]
+		if header[0] == 0 {
+			break // padding
+		}
+		id := string(header[:idLen])
+		if !validFrameID(id) {
+			break
+		}
+
+		var size int
+		switch major {
+		case 2:
+			size = int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(header[4:8]))
+		case 4:
+			size = int(syncsafe(header[4:8]))
+			// * some writers put plain sizes into ID3v2.4 frames, trust whichever
+			// * interpretation lands on the next frame
+			plain := int(binary.BigEndian.Uint32(header[4:8]))
+			if plain != size && !frameFollows(body, pos+headerLen+size, idLen) && frameFollows(body, pos+headerLen+plain, idLen) {
+				size = plain
+			}
+		}
+
+		start := pos + headerLen
+		end := start + size
+		if size < 0 || end > len(body) {
+			break
+		}
+		pos = end
+
+		var flags uint16
+		if major > 2 {
+			flags = binary.BigEndian.Uint16(header[8:10])
+		}
+		data, ok := frameData(body[start:end], major, flags, tagUnsync)
+		if !ok {
+			continue
+		}
+		frames = append(frames, id3Frame{ID: id, Data: data})
+	}
+
+	return frames
+}
+
+// frameData strips the per-frame extras described by flags from a frame body
+func frameData(data []byte, major uint8, flags uint16, tagUnsync bool) ([]byte, bool) {
+	var compressed bool
+	switch major {
+	case 3:
+		if flags&0x0040 != 0 {
+			return nil, false // encrypted
+		}
+		if flags&0x0080 != 0 {
+			compressed = true
+			if len(data) < 4 {
+				return nil, false
+			}
+			data = data[4:] // decompressed size
+		}
+		if flags&0x0020 != 0 {
+			if len(data) < 1 {
+				return nil, false
+			}
+			data = data[1:] // group id
+		}
+
+	case 4:
+		if flags&0x0004 != 0 {
+			return nil, false // encrypted
+		}
+		if flags&0x0040 != 0 {
+			if len(data) < 1 {
+				return nil, false
+			}
+			data = data[1:] // group id
+		}
+		if flags&0x0001 != 0 {
+			if len(data) < 4 {
+				return nil, false
+			}
+			data = data[4:] // data length indicator
+		}
+		if flags&0x0002 != 0 || tagUnsync {
+			data = unsynchronise(data)
+		}
+		compressed = flags&0x0008 != 0
+	}
+
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false
+		}
+		defer zr.Close()
+		if data, err = io.ReadAll(io.LimitReader(zr, maxID3v2Size)); err != nil {
+			return nil, false
+		}
+	}
+
+	return data, true
+}
+
+func id3v2Tags(frames []id3Frame, major uint8) *Tags {
+	names := map[string]string{
+		"TIT2": "title", "TT2": "title",
+		"TPE1": "artist", "TP1": "artist",
+		"TPE2": "album_artist", "TP2": "album_artist",
+		"TALB": "album", "TAL": "album",
+		"TCON": "genre", "TCO": "genre",
+		"TYER": "year", "TYE": "year", "TDRC": "year",
+		"TORY": "original_year", "TDOR": "original_year",
+		"TRCK": "track", "TRK": "track",
+		"TLEN": "length", "TLE": "length",
+	}
+
+	values := map[string][]string{}
+	for _, f := range frames {
+		name, ok := names[f.ID]
+		if !ok || len(f.Data) == 0 {
+			continue
+		}
+		if _, seen := values[name]; seen {
+			continue
+		}
+		values[name] = decodeTextFrame(f.Data)
+	}
+
+	first := func(name string) string {
+		if v := values[name]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+
+	tags := &Tags{
+		Title:  first("title"),
+		Artist: first("artist"),
+		Album:  first("album"),
+		Genre:  resolveGenre(values["genre"]),
+		Year:   parseYear(first("year")),
+	}
+	if tags.Artist == "" {
+		tags.Artist = first("album_artist")
+	}
+	if tags.Year == 0 {
+		tags.Year = parseYear(first("original_year"))
+	}
+	tags.Track, tags.TrackTotal = parseTrack(first("track"))
+	if ms, err := strconv.ParseInt(first("length"), 10, 64); err == nil && ms > 0 {
+		tags.Length = time.Duration(ms) * time.Millisecond
+	}
+
+	return tags
+}
+
+// decodeTextFrame decodes the body of a text information frame. ID3v2.4 allows
+// several null separated values in one frame, so every value is returned.
+func decodeTextFrame(data []byte) []string {
+	if len(data) < 1 {
+		return nil
+	}
+	text := decodeID3String(data[0], data[1:])
+	text = strings.TrimRight(text, "\x00")
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(text, "\x00")
+}
+
+// decodeID3String decodes s according to the ID3v2 text encoding byte
+func decodeID3String(encoding byte, s []byte) string {
+	switch encoding {
+	case 0:
+		return decodeLatin1(s)
+	case 1:
+		return decodeUTF16(s, nil)
+	case 2:
+		return decodeUTF16(s, binary.BigEndian)
+	default:
+		return string(s)
+	}
+}
+
+func decodeLatin1(s []byte) string {
+	runes := make([]rune, len(s))
+	for i, b := range s {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// decodeUTF16 decodes UTF-16 text. With a nil order every string starts with its own
+// byte order mark, which ID3v2 repeats after each null separator.
+func decodeUTF16(s []byte, order binary.ByteOrder) string {
+	var out []string
+	for _, part := range splitUTF16(s) {
+		o := order
+		if o == nil {
+			o = binary.LittleEndian
+			if len(part) >= 2 {
+				switch {
+				case part[0] == 0xFE && part[1] == 0xFF:
+					o = binary.BigEndian
+					part = part[2:]
+				case part[0] == 0xFF && part[1] == 0xFE:
+					part = part[2:]
+				}
+			}
+		}
+		units := make([]uint16, len(part)/2)
+		for i := range units {
+			units[i] = o.Uint16(part[2*i:])
+		}
+		out = append(out, string(utf16.Decode(units)))
+	}
+
+	return strings.Join(out, "\x00")
+}
+
+// splitUTF16 splits s at two byte aligned null terminators
+func splitUTF16(s []byte) [][]byte {
+	var parts [][]byte
+	start := 0
+	for i := 0; i+1 < len(s); i += 2 {
+		if s[i] == 0 && s[i+1] == 0 {
+			parts = append(parts, s[start:i])
+			start = i + 2
+		}
+	}
+	if start < len(s) {
+		parts = append(parts, s[start:])
+	}
+
+	return parts
+}
+
+// readID3v1 reads the ID3v1/ID3v1.1 tag from the last 128 bytes of r
+func readID3v1(r io.ReaderAt, size int64) (*Tags, error) {
+	if !hasID3v1(r, size) {
+		return nil, ErrNoTags
+	}
+	buf := make([]byte, id3v1Size)
+	if _, err := r.ReadAt(buf, size-id3v1Size); err != nil {
+		return nil, err
+	}
+
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(decodeLatin1(b))
+	}
+
+	tags := &Tags{
+		Title:  field(buf[3:33]),
+		Artist: field(buf[33:63]),
+		Album:  field(buf[63:93]),
+		Year:   parseYear(field(buf[93:97])),
+		Genre:  genreName(int(buf[127])),
+	}
+	// * ID3v1.1 stores the track number in the last byte of the comment
+	if buf[125] == 0 && buf[126] != 0 {
+		tags.Track = int(buf[126])
+	}
+	if tags.empty() {
+		return nil, ErrNoTags
+	}
+
+	return tags, nil
+}
+
+func syncsafe(b []byte) uint32 {
+	var n uint32
+	for _, c := range b {
+		n = n<<7 | uint32(c&0x7F)
+	}
+
+	return n
+}
+
+// unsynchronise reverts the ID3v2 unsynchronisation scheme by dropping the zero
+// byte inserted after every 0xFF
+func unsynchronise(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+
+	return out
+}
+
+func validFrameID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// frameFollows reports whether pos is the end of the tag body or the start of a frame
+func frameFollows(body []byte, pos, idLen int) bool {
+	if pos == len(body) {
+		return true
+	}
+	if pos < 0 || pos+idLen > len(body) {
+		return false
+	}
+	if body[pos] == 0 {
+		return true // padding
+	}
+
+	return validFrameID(string(body[pos : pos+idLen]))
+}
@@ -0,0 +1,298 @@
+package audio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// id3v2Frame encodes one frame of an ID3v2 tag of the given major version
+func id3v2Frame(major uint8, id string, flags uint16, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	switch major {
+	case 2:
+		b.Write([]byte{byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))})
+	case 3:
+		b.Write(be32(uint32(len(data))))
+	case 4:
+		b.Write(syncsafeBytes(uint32(len(data))))
+	}
+	if major > 2 {
+		b.Write([]byte{byte(flags >> 8), byte(flags)})
+	}
+	b.Write(data)
+
+	return b.Bytes()
+}
+
+// id3v2Tag wraps frames into an ID3v2 tag with some padding
+func id3v2Tag(major, flags uint8, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 32)...)
+
+	tag := []byte{'I', 'D', '3', major, 0, flags}
+	tag = append(tag, syncsafeBytes(uint32(len(body)))...)
+
+	return append(tag, body...)
+}
+
+// id3v1Tag encodes an ID3v1.1 tag
+func id3v1Tag(title, artist, album, year string, track, genre byte) []byte {
+	tag := make([]byte, id3v1Size)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	tag[126] = track
+	tag[127] = genre
+
+	return tag
+}
+
+func text(encoding byte, s string) []byte {
+	return append([]byte{encoding}, s...)
+}
+
+func utf16Text(s string, order binary.ByteOrder, bom bool) []byte {
+	var b []byte
+	if bom {
+		b = make([]byte, 2)
+		order.PutUint16(b, 0xFEFF)
+	}
+	for _, r := range s {
+		u := make([]byte, 2)
+		order.PutUint16(u, uint16(r))
+		b = append(b, u...)
+	}
+
+	return b
+}
+
+func syncsafeBytes(n uint32) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func zlibBytes(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+
+	return b.Bytes()
+}
+
+func TestReadID3(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x40}, 64)
+	utf16Title := append([]byte{1}, utf16Text("Ünïcode", binary.LittleEndian, true)...)
+	compressed := append(be32(5), zlibBytes(text(0, "Zipped"))...)
+
+	tests := []struct {
+		name string
+		file []byte
+		want Tags
+	}{
+		{
+			name: "ID3v2.3",
+			file: id3v2Tag(3, 0,
+				id3v2Frame(3, "TIT2", 0, text(0, "Title")),
+				id3v2Frame(3, "TPE1", 0, text(0, "Artist")),
+				id3v2Frame(3, "TALB", 0, text(0, "Album")),
+				id3v2Frame(3, "TYER", 0, text(0, "1999")),
+				id3v2Frame(3, "TRCK", 0, text(0, "3/12")),
+				id3v2Frame(3, "TCON", 0, text(0, "(17)")),
+				id3v2Frame(3, "TLEN", 0, text(0, "215000")),
+			),
+			want: Tags{Title: "Title", Artist: "Artist", Album: "Album", Genre: "Rock", Year: 1999, Track: 3, TrackTotal: 12, Length: 215 * time.Second},
+		},
+		{
+			name: "ID3v2.4 with UTF-8 and several values",
+			file: id3v2Tag(4, 0,
+				id3v2Frame(4, "TIT2", 0, text(3, "Grüße")),
+				id3v2Frame(4, "TCON", 0, text(3, "Rock\x00Pop")),
+				id3v2Frame(4, "TDRC", 0, text(3, "2017-05-01T10:00")),
+			),
+			want: Tags{Title: "Grüße", Genre: "Rock, Pop", Year: 2017},
+		},
+		{
+			name: "ID3v2.2",
+			file: id3v2Tag(2, 0,
+				id3v2Frame(2, "TT2", 0, text(0, "Old")),
+				id3v2Frame(2, "TP2", 0, text(0, "Band")),
+				id3v2Frame(2, "TRK", 0, text(0, "7")),
+			),
+			want: Tags{Title: "Old", Artist: "Band", Track: 7},
+		},
+		{
+			name: "UTF-16 with byte order mark",
+			file: id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, utf16Title)),
+			want: Tags{Title: "Ünïcode"},
+		},
+		{
+			name: "UTF-16BE without byte order mark",
+			file: id3v2Tag(4, 0, id3v2Frame(4, "TIT2", 0, append([]byte{2}, utf16Text("Big", binary.BigEndian, false)...))),
+			want: Tags{Title: "Big"},
+		},
+		{
+			name: "Latin-1",
+			file: id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, []byte{0, 'C', 'a', 'f', 0xE9})),
+			want: Tags{Title: "Café"},
+		},
+		{
+			name: "compressed ID3v2.3 frame",
+			file: id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0x0080, compressed)),
+			want: Tags{Title: "Zipped"},
+		},
+		{
+			name: "ID3v2.4 frame with a plain size",
+			file: id3v2Tag(4, 0,
+				append(append([]byte("TIT2"), be32(201)...), append([]byte{0, 0}, text(0, string(bytes.Repeat([]byte("a"), 200)))...)...),
+				id3v2Frame(4, "TPE1", 0, text(0, "After")),
+			),
+			want: Tags{Title: string(bytes.Repeat([]byte("a"), 200)), Artist: "After"},
+		},
+		{
+			name: "ID3v1.1",
+			file: append(append([]byte(nil), audio...), id3v1Tag("V1 Title", "V1 Artist", "V1 Album", "1987", 5, 9)...),
+			want: Tags{Title: "V1 Title", Artist: "V1 Artist", Album: "V1 Album", Genre: "Metal", Year: 1987, Track: 5},
+		},
+		{
+			name: "ID3v1 fills what ID3v2 lacks",
+			file: append(append(id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, text(0, "V2 Title"))), audio...), id3v1Tag("V1 Title", "", "V1 Album", "", 0, 255)...),
+			want: Tags{Title: "V2 Title", Album: "V1 Album"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := ReadID3(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("ReadID3() error = %v", err)
+			}
+			if *tags != tt.want {
+				t.Errorf("ReadID3() = %+v, want %+v", *tags, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadID3NoTags(t *testing.T) {
+	for name, file := range map[string][]byte{
+		"empty":       {},
+		"audio only":  bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x40}, 64),
+		"empty frame": id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, nil)),
+	} {
+		if _, err := ReadID3(bytes.NewReader(file), int64(len(file))); err != ErrNoTags {
+			t.Errorf("%s: ReadID3() error = %v, want ErrNoTags", name, err)
+		}
+	}
+}
+
+func TestID3v2Length(t *testing.T) {
+	tag := id3v2Tag(4, 0, id3v2Frame(4, "TIT2", 0, text(0, "x")))
+	if got := id3v2Length(bytes.NewReader(tag)); got != int64(len(tag)) {
+		t.Errorf("id3v2Length() = %d, want %d", got, len(tag))
+	}
+
+	// * the ID3v2.4 footer repeats the header
+	footer := append([]byte(nil), tag...)
+	footer[5] |= 0x10
+	if got := id3v2Length(bytes.NewReader(footer)); got != int64(len(tag))+id3v2HeaderSize {
+		t.Errorf("id3v2Length() with footer = %d, want %d", got, len(tag)+id3v2HeaderSize)
+	}
+
+	if got := id3v2Length(bytes.NewReader([]byte("not a tag at all"))); got != 0 {
+		t.Errorf("id3v2Length() without tag = %d, want 0", got)
+	}
+}
+
+func TestResolveGenre(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{[]string{"Rock"}, "Rock"},
+		{[]string{"17"}, "Rock"},
+		{[]string{"(17)"}, "Rock"},
+		{[]string{"(9)(17)"}, "Metal, Rock"},
+		{[]string{"(17)Hard Rock"}, "Hard Rock"},
+		{[]string{"(RX)"}, "Remix"},
+		{[]string{"(CR)"}, "Cover"},
+		{[]string{"((Parens)"}, "(Parens)"},
+		{[]string{"Rock", "Pop"}, "Rock, Pop"},
+		{[]string{"999"}, ""},
+		{[]string{" "}, ""},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := resolveGenre(tt.values); got != tt.want {
+			t.Errorf("resolveGenre(%q) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestGenreName(t *testing.T) {
+	tests := map[int]string{0: "Blues", 17: "Rock", 80: "Folk", 191: "Psybient", 192: "", -1: "", 255: ""}
+	for index, want := range tests {
+		if got := genreName(index); got != want {
+			t.Errorf("genreName(%d) = %q, want %q", index, got, want)
+		}
+	}
+}
+
+func TestUnsynchronise(t *testing.T) {
+	tests := []struct {
+		in, want []byte
+	}{
+		{[]byte{0xFF, 0x00, 0xE0}, []byte{0xFF, 0xE0}},
+		{[]byte{0xFF, 0x00, 0x00}, []byte{0xFF, 0x00}},
+		{[]byte{0xFF}, []byte{0xFF}},
+		{[]byte{0x01, 0x00}, []byte{0x01, 0x00}},
+	}
+
+	for _, tt := range tests {
+		if got := unsynchronise(tt.in); !bytes.Equal(got, tt.want) {
+			t.Errorf("unsynchronise(% x) = % x, want % x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSyncsafe(t *testing.T) {
+	for _, n := range []uint32{0, 1, 127, 128, 16383, 1 << 27} {
+		if got := syncsafe(syncsafeBytes(n)); got != n {
+			t.Errorf("syncsafe(%d) = %d", n, got)
+		}
+	}
+}
+
+func TestParseTrackAndYear(t *testing.T) {
+	tracks := map[string][2]int{"3": {3, 0}, "3/12": {3, 12}, " 4 / 9 ": {4, 9}, "": {0, 0}, "x": {0, 0}}
+	for in, want := range tracks {
+		if track, total := parseTrack(in); track != want[0] || total != want[1] {
+			t.Errorf("parseTrack(%q) = %d, %d, want %d, %d", in, track, total, want[0], want[1])
+		}
+	}
+
+	years := map[string]int{"2017": 2017, "2017-05-01": 2017, "2017-05-01T10:00": 2017, "17": 0, "abcd": 0}
+	for in, want := range years {
+		if got := parseYear(in); got != want {
+			t.Errorf("parseYear(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
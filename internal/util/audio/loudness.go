@@ -0,0 +1,359 @@
+package audio
+
+import (
+	"io"
+	"math"
+	"sort"
+)
+
+// Loudness is measured per EBU R128 (ITU-R BS.1770-4 and EBU Tech 3341/3342): the
+// channels are K-weighted, their power is summed over 400 ms blocks for the
+// integrated loudness and over 3 s blocks for the loudness range, and blocks below
+// the absolute and relative gates are left out. Levels are floored at the absolute
+// gate, so silence measures -70 LUFS instead of minus infinity.
+const (
+	// ReplayGainReference is the loudness ReplayGain 2.0 gains bring audio to, in LUFS
+	ReplayGainReference = -18.0
+
+	absoluteGate  = -70.0 // LUFS
+	integratedGap = -10.0 // LU below the ungated integrated loudness
+	rangeGap      = -20.0 // LU below the ungated short-term loudness
+
+	// loudness blocks are built from 100 ms steps: 4 for momentary, 30 for short-term
+	stepsPerSecond  = 10
+	momentarySteps  = 4
+	shortTermSteps  = 30
+	truePeakFactor  = 4 // oversampling for the true peak below 96 kHz
+	truePeakTaps    = 12
+	surroundWeight  = 1.41
+	lfeChannel      = 3 // of 5.1 audio in WAVE/FLAC channel order
+	surroundStartCh = 4
+)
+
+// Loudness describes how loud an audio stream sounds
+type Loudness struct {
+	Integrated float64 // integrated loudness in LUFS
+	Range      float64 // loudness range in LU
+	TruePeak   float64 // maximum true peak in dBTP
+}
+
+// Gain returns the ReplayGain 2.0 gain of l in dB
+func (l *Loudness) Gain() float64 {
+	return ReplayGainReference - l.Integrated
+}
+
+// AlbumLoudness combines the integrated loudness of the tracks of an album, weighted by
+// their durations, which approximates measuring the album as one stream
+func AlbumLoudness(integrated, durations []float64) float64 {
+	var energy, total float64
+	for i, l := range integrated {
+		energy += durations[i] * math.Pow(10, (l+0.691)/10)
+		total += durations[i]
+	}
+	if total == 0 || energy == 0 {
+		return absoluteGate
+	}
+
+	return round2(math.Max(-0.691+10*math.Log10(energy/total), absoluteGate))
+}
+
+// MeasureLoudness decodes r and measures its loudness
+func MeasureLoudness(r io.ReadSeeker) (*Loudness, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	info, err := Probe(NewReaderAt(r), size)
+	if err != nil {
+		return nil, err
+	}
+	if !CanDecode(info.Codec) {
+		return nil, ErrNoDecoder
+	}
+
+	var m *loudnessMeter
+	err = Decode(r, info, func(samples []float32, channels int) error {
+		if m == nil {
+			m = newLoudnessMeter(info.SampleRate, channels)
+		}
+		m.write(samples)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return &Loudness{Integrated: absoluteGate, TruePeak: absoluteGate}, nil
+	}
+
+	return m.result(), nil
+}
+
+// loudnessMeter accumulates the power of K-weighted audio in 100 ms steps
+type loudnessMeter struct {
+	channels  int
+	weights   []float64
+	filters   []kWeighting
+	peaks     []*truePeakMeter
+	stepSize  int
+	stepPos   int
+	stepPower float64
+	steps     []float64 // mean weighted power of every complete step
+}
+
+func newLoudnessMeter(sampleRate, channels int) *loudnessMeter {
+	m := &loudnessMeter{
+		channels: channels,
+		weights:  make([]float64, channels),
+		filters:  make([]kWeighting, channels),
+		peaks:    make([]*truePeakMeter, channels),
+		stepSize: sampleRate / stepsPerSecond,
+	}
+	for ch := range m.weights {
+		m.weights[ch] = 1
+		if channels == 6 {
+			switch {
+			case ch == lfeChannel:
+				m.weights[ch] = 0
+			case ch >= surroundStartCh:
+				m.weights[ch] = surroundWeight
+			}
+		}
+		m.filters[ch] = newKWeighting(float64(sampleRate))
+		m.peaks[ch] = newTruePeakMeter(sampleRate)
+	}
+	if m.stepSize < 1 {
+		m.stepSize = 1
+	}
+
+	return m
+}
+
+func (m *loudnessMeter) write(samples []float32) {
+	for i := 0; i+m.channels <= len(samples); i += m.channels {
+		for ch := 0; ch < m.channels; ch++ {
+			v := float64(samples[i+ch])
+			m.peaks[ch].write(v)
+			y := m.filters[ch].filter(v)
+			m.stepPower += m.weights[ch] * y * y
+		}
+		m.stepPos++
+		if m.stepPos == m.stepSize {
+			m.steps = append(m.steps, m.stepPower/float64(m.stepSize))
+			m.stepPos, m.stepPower = 0, 0
+		}
+	}
+}
+
+func (m *loudnessMeter) result() *Loudness {
+	peak := 0.0
+	for _, p := range m.peaks {
+		peak = math.Max(peak, p.peak)
+	}
+
+	return &Loudness{
+		Integrated: round2(integratedLoudness(blockPowers(m.steps, momentarySteps))),
+		Range:      round2(loudnessRange(blockPowers(m.steps, shortTermSteps))),
+		TruePeak:   round2(math.Max(20*math.Log10(peak), absoluteGate)),
+	}
+}
+
+// round2 rounds to the 0.01 dB levels are reported with
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// blockPowers returns the mean power of every block of n consecutive steps
+func blockPowers(steps []float64, n int) []float64 {
+	if len(steps) < n {
+		return nil
+	}
+	blocks := make([]float64, 0, len(steps)-n+1)
+	var sum float64
+	for i, p := range steps {
+		sum += p
+		if i >= n {
+			sum -= steps[i-n]
+		}
+		if i >= n-1 {
+			blocks = append(blocks, sum/float64(n))
+		}
+	}
+
+	return blocks
+}
+
+func lufs(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// gatedMean averages the powers of the blocks louder than gate
+func gatedMean(blocks []float64, gate float64) (float64, int) {
+	var sum float64
+	n := 0
+	for _, p := range blocks {
+		if p > 0 && lufs(p) > gate {
+			sum += p
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+
+	return sum / float64(n), n
+}
+
+func integratedLoudness(blocks []float64) float64 {
+	mean, n := gatedMean(blocks, absoluteGate)
+	if n == 0 {
+		return absoluteGate
+	}
+	mean, n = gatedMean(blocks, lufs(mean)+integratedGap)
+	if n == 0 {
+		return absoluteGate
+	}
+
+	return math.Max(lufs(mean), absoluteGate)
+}
+
+// loudnessRange is the spread between the 10th and 95th percentile of the gated
+// short-term loudness
+func loudnessRange(blocks []float64) float64 {
+	mean, n := gatedMean(blocks, absoluteGate)
+	if n == 0 {
+		return 0
+	}
+	gate := lufs(mean) + rangeGap
+
+	var levels []float64
+	for _, p := range blocks {
+		if p > 0 && lufs(p) > absoluteGate && lufs(p) > gate {
+			levels = append(levels, lufs(p))
+		}
+	}
+	if len(levels) == 0 {
+		return 0
+	}
+	sort.Float64s(levels)
+	percentile := func(q float64) float64 {
+		return levels[int(math.Round(q*float64(len(levels)-1)))]
+	}
+
+	return percentile(0.95) - percentile(0.10)
+}
+
+// biquad is a second order IIR filter section
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+
+	return y
+}
+
+// kWeighting is the BS.1770 pre-filter: a high shelf modelling the head, followed by
+// a high pass. The coefficients are derived for the sample rate from the analog
+// prototype, they match the 48 kHz ones of the standard.
+type kWeighting struct {
+	shelf, highPass biquad
+}
+
+func newKWeighting(rate float64) kWeighting {
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return kWeighting{shelf: shelf, highPass: highPass}
+}
+
+func (k *kWeighting) filter(x float64) float64 {
+	return k.highPass.filter(k.shelf.filter(x))
+}
+
+// truePeakMeter finds the peak of a channel between its samples by oversampling it
+// with a windowed sinc interpolator
+type truePeakMeter struct {
+	factor int
+	phases [][]float64
+	hist   []float64
+	pos    int
+	peak   float64
+}
+
+func newTruePeakMeter(sampleRate int) *truePeakMeter {
+	factor := truePeakFactor
+	if sampleRate >= 96000 {
+		factor = 1
+	}
+	t := &truePeakMeter{factor: factor, hist: make([]float64, truePeakTaps)}
+	if factor == 1 {
+		return t
+	}
+
+	// * one polyphase branch of the interpolation filter per output phase
+	n := truePeakTaps * factor
+	t.phases = make([][]float64, factor)
+	for p := range t.phases {
+		t.phases[p] = make([]float64, truePeakTaps)
+		for j := range t.phases[p] {
+			i := j*factor + p
+			x := float64(i) - float64(n-1)/2
+			sinc := 1.0
+			if x != 0 {
+				sinc = math.Sin(math.Pi*x/float64(factor)) / (math.Pi * x / float64(factor))
+			}
+			window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+			t.phases[p][j] = sinc * window
+		}
+	}
+
+	return t
+}
+
+func (t *truePeakMeter) write(x float64) {
+	if a := math.Abs(x); a > t.peak {
+		t.peak = a
+	}
+	if t.factor == 1 {
+		return
+	}
+
+	t.hist[t.pos] = x
+	t.pos = (t.pos + 1) % len(t.hist)
+	for _, taps := range t.phases {
+		var y float64
+		for j, c := range taps {
+			// * taps[0] applies to the newest sample
+			y += c * t.hist[(t.pos-1-j+2*len(t.hist))%len(t.hist)]
+		}
+		if a := math.Abs(y); a > t.peak {
+			t.peak = a
+		}
+	}
+}
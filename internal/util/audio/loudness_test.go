@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// tone is a segment of a 1 kHz sine, level in dBFS per channel
+type tone struct {
+	seconds int
+	level   float64
+}
+
+// measure feeds interleaved stereo 1 kHz tones at 48 kHz to a loudness meter, in blocks
+// the way a decoder delivers them
+func measure(tones ...tone) *Loudness {
+	const rate = 48000
+	m := newLoudnessMeter(rate, 2)
+	buf := make([]float32, 0, 4096*2)
+	i := 0
+	for _, tn := range tones {
+		amp := math.Pow(10, tn.level/20)
+		for end := i + tn.seconds*rate; i < end; i++ {
+			v := float32(amp * math.Sin(2*math.Pi*1000*float64(i)/rate))
+			buf = append(buf, v, v)
+			if len(buf) == cap(buf) {
+				m.write(buf)
+				buf = buf[:0]
+			}
+		}
+	}
+	m.write(buf)
+
+	return m.result()
+}
+
+// TestLoudness checks the meter against the test signals of EBU Tech 3341 and 3342
+func TestLoudness(t *testing.T) {
+	tests := []struct {
+		name       string
+		tones      []tone
+		integrated float64
+		lra        float64
+	}{
+		{"3341 case 1", []tone{{20, -23}}, -23, 0},
+		{"3341 case 2", []tone{{20, -33}}, -33, 0},
+		{"3341 case 3, relative gate", []tone{{10, -36}, {60, -23}, {10, -36}}, -23, -1},
+		{"3341 case 4, absolute gate", []tone{{10, -72}, {10, -36}, {60, -23}, {10, -36}, {10, -72}}, -23, -1},
+		{"3342 case 1", []tone{{20, -20}, {20, -30}}, -1, 10},
+		{"3342 case 2", []tone{{20, -20}, {20, -15}}, -1, 5},
+		{"3342 case 3", []tone{{20, -40}, {20, -20}}, -1, 20},
+	}
+	for _, tt := range tests {
+		l := measure(tt.tones...)
+		// * -1 marks values the case does not specify
+		if tt.integrated != -1 && math.Abs(l.Integrated-tt.integrated) > 0.1 {
+			t.Errorf("%s: integrated = %.2f LUFS, want %.1f ±0.1", tt.name, l.Integrated, tt.integrated)
+		}
+		if tt.lra != -1 && math.Abs(l.Range-tt.lra) > 1 {
+			t.Errorf("%s: range = %.2f LU, want %.0f ±1", tt.name, l.Range, tt.lra)
+		}
+	}
+}
+
+func TestLoudnessSilence(t *testing.T) {
+	l := measure(tone{5, math.Inf(-1)})
+	if *l != (Loudness{Integrated: absoluteGate, Range: 0, TruePeak: absoluteGate}) {
+		t.Errorf("loudness of silence = %+v, want the absolute gate", *l)
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// * a sine at a quarter of the sample rate, 45° out of phase, has every sample at
+	// -3 dBFS while the signal itself peaks at 0 dBFS
+	const rate = 48000
+	m := newLoudnessMeter(rate, 1)
+	samples := make([]float32, rate)
+	for i := range samples {
+		samples[i] = float32(math.Sin(math.Pi*float64(i)/2 + math.Pi/4))
+	}
+	m.write(samples)
+
+	if l := m.result(); l.TruePeak < -0.5 || l.TruePeak > 0.1 {
+		t.Errorf("true peak = %.2f dBTP, want 0 dBTP, sample peak is -3 dBFS", l.TruePeak)
+	}
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	const rate = 48000
+	amp := math.Pow(10, -23.0/20) * math.MaxInt16
+	samples := make([]int16, 2*10*rate)
+	for i := 0; i < len(samples); i += 2 {
+		v := int16(amp * math.Sin(2*math.Pi*1000*float64(i/2)/rate))
+		samples[i], samples[i+1] = v, v
+	}
+
+	l, err := MeasureLoudness(bytes.NewReader(wavFile(rate, 2, samples, nil)))
+	if err != nil {
+		t.Fatalf("MeasureLoudness() error = %v", err)
+	}
+	if math.Abs(l.Integrated+23) > 0.1 || math.Abs(l.TruePeak+23) > 0.1 {
+		t.Errorf("MeasureLoudness() = %+v, want -23 LUFS and -23 dBTP", *l)
+	}
+	if math.Abs(l.Gain()-5) > 0.1 {
+		t.Errorf("Gain() = %.2f dB, want 5 dB", l.Gain())
+	}
+}
+
+func TestAlbumLoudness(t *testing.T) {
+	tests := []struct {
+		name       string
+		integrated []float64
+		durations  []float64
+		want       float64
+	}{
+		{"one track", []float64{-14.5}, []float64{200}, -14.5},
+		{"same loudness", []float64{-9, -9, -9}, []float64{100, 250, 30}, -9},
+		// * 10 dB apart at equal length: the energy mean is 2.6 dB below the louder
+		{"equal durations", []float64{-10, -20}, []float64{100, 100}, -12.6},
+		{"weighted by duration", []float64{-10, -20}, []float64{0, 100}, -20},
+		{"no tracks", nil, nil, absoluteGate},
+	}
+	for _, tt := range tests {
+		if got := AlbumLoudness(tt.integrated, tt.durations); got != tt.want {
+			t.Errorf("%s: AlbumLoudness() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
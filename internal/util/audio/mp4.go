@@ -0,0 +1,252 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var errInvalidMP4 = errors.New("audio: invalid MP4 file")
+
+// maxMoovSize bounds the size of the movie box, which holds the sample tables and
+// the metadata including cover art
+const maxMoovSize = 64 << 20
+
+// mp4Box is an ISO base media box
+type mp4Box struct {
+	Type string
+	Data []byte // body, without the size and type header
+}
+
+// mp4Boxes splits b into its child boxes
+func mp4Boxes(b []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return boxes
+		}
+		boxes = append(boxes, mp4Box{Type: typ, Data: b[header:size]})
+		b = b[size:]
+	}
+
+	return boxes
+}
+
+// mp4Child returns the first box at the given path below b
+func mp4Child(b []byte, path ...string) ([]byte, bool) {
+	for _, typ := range path {
+		found := false
+		for _, box := range mp4Boxes(b) {
+			if box.Type == typ {
+				b, found = box.Data, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		// * meta is a full box, its children follow the version and flags
+		if typ == "meta" && len(b) >= 8 && string(b[4:8]) != "hdlr" {
+			b = b[4:]
+		}
+	}
+
+	return b, true
+}
+
+// readMoov locates the top level movie box, which encoders put either before or
+// after the media data
+func readMoov(r io.ReaderAt, size int64) ([]byte, error) {
+	header := make([]byte, 16)
+	for pos := int64(0); pos+8 <= size; {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return nil, errInvalidMP4
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			if _, err := r.ReadAt(header[8:16], pos+8); err != nil {
+				return nil, errInvalidMP4
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize || pos+boxSize > size {
+			return nil, errInvalidMP4
+		}
+
+		if string(header[4:8]) == "moov" {
+			if boxSize > maxMoovSize {
+				return nil, errInvalidMP4
+			}
+			moov := make([]byte, boxSize-headerSize)
+			if _, err := r.ReadAt(moov, pos+headerSize); err != nil {
+				return nil, errInvalidMP4
+			}
+			return moov, nil
+		}
+		pos += boxSize
+	}
+
+	return nil, errInvalidMP4
+}
+
+func readMP4(r io.ReaderAt, size int64) (*Info, error) {
+	moov, err := readMoov(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{}
+	for _, trak := range mp4Boxes(moov) {
+		if trak.Type != "trak" {
+			continue
+		}
+		if hdlr, ok := mp4Child(trak.Data, "mdia", "hdlr"); !ok || len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+			continue
+		}
+		if mdhd, ok := mp4Child(trak.Data, "mdia", "mdhd"); ok {
+			info.Duration = mp4Duration(mdhd)
+		}
+		if stsd, ok := mp4Child(trak.Data, "mdia", "minf", "stbl", "stsd"); ok && len(stsd) >= 8 {
+			readSampleEntry(info, stsd[8:])
+		}
+		break
+	}
+	if info.Codec == "" {
+		return nil, ErrUnsupportedFormat
+	}
+	if info.Duration == 0 {
+		if mvhd, ok := mp4Child(moov, "mvhd"); ok {
+			info.Duration = mp4Duration(mvhd)
+		}
+	}
+	if ilst, ok := mp4Child(moov, "udta", "meta", "ilst"); ok {
+		info.Tags = mp4Tags(ilst)
+		info.Picture = mp4Picture(ilst)
+	}
+
+	return info, nil
+}
+
+// mp4Duration reads the timescale and duration of a mvhd or mdhd full box
+func mp4Duration(b []byte) time.Duration {
+	var timescale, duration uint64
+	switch {
+	case len(b) >= 20 && b[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	case len(b) >= 32 && b[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	}
+	if timescale == 0 {
+		return 0
+	}
+
+	return time.Duration(duration * uint64(time.Second) / timescale)
+}
+
+// readSampleEntry reads the codec and audio properties of the first sample entry of
+// a stsd box
+func readSampleEntry(info *Info, b []byte) {
+	entries := mp4Boxes(b)
+	if len(entries) == 0 || len(entries[0].Data) < 28 {
+		return
+	}
+	entry := entries[0]
+	switch entry.Type {
+	case "mp4a":
+		info.Codec = CodecAAC
+	case "alac":
+		info.Codec = CodecALAC
+	default:
+		return
+	}
+
+	// * 6 reserved bytes, data reference index, 8 bytes of version, revision and
+	// vendor, then channel count, sample size, 4 reserved bytes and a 16.16 rate
+	info.Channels = int(binary.BigEndian.Uint16(entry.Data[16:18]))
+	info.SampleRate = int(binary.BigEndian.Uint32(entry.Data[24:28]) >> 16)
+	if info.Codec == CodecALAC {
+		info.BitDepth = int(binary.BigEndian.Uint16(entry.Data[18:20]))
+	}
+}
+
+// mp4Picture returns the first image of the covr item, which has no picture types
+func mp4Picture(ilst []byte) *Picture {
+	covr, ok := mp4Child(ilst, "covr")
+	if !ok {
+		return nil
+	}
+	for _, box := range mp4Boxes(covr) {
+		if box.Type != "data" || len(box.Data) < 8 {
+			continue
+		}
+		if p := newPicture(PictureFrontCover, "", box.Data[8:]); p != nil {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// mp4Tags decodes the iTunes style metadata items of an ilst box
+func mp4Tags(ilst []byte) *Tags {
+	tags := &Tags{}
+	for _, item := range mp4Boxes(ilst) {
+		data, ok := mp4Child(item.Data, "data")
+		if !ok || len(data) < 8 {
+			continue
+		}
+		value := data[8:]
+		text := strings.TrimSpace(string(value))
+
+		switch item.Type {
+		case "\xa9nam":
+			tags.Title = text
+		case "\xa9ART":
+			tags.Artist = text
+		case "aART":
+			if tags.Artist == "" {
+				tags.Artist = text
+			}
+		case "\xa9alb":
+			tags.Album = text
+		case "\xa9gen":
+			tags.Genre = text
+		case "gnre":
+			// * ID3v1 genre index plus one
+			if len(value) >= 2 && tags.Genre == "" {
+				tags.Genre = genreName(int(binary.BigEndian.Uint16(value)) - 1)
+			}
+		case "\xa9day":
+			tags.Year = parseYear(text)
+		case "trkn":
+			if len(value) >= 6 {
+				tags.Track = int(binary.BigEndian.Uint16(value[2:4]))
+				tags.TrackTotal = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		}
+	}
+
+	return tags
+}
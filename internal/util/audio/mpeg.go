@@ -0,0 +1,452 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotMPEG is returned when no MPEG audio stream can be found in a file
+var ErrNotMPEG = errors.New("audio: not an MPEG audio stream")
+
+const (
+	mpegHeaderSize = 4
+
+	// maxSyncSearch bounds how far into the file the first frame is looked for
+	maxSyncSearch = 1 << 20
+)
+
+var (
+	mpegBitrates = map[[2]int][15]int{
+		{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mpegSampleRates = map[int][3]int{
+		mpeg1:  {44100, 48000, 32000},
+		mpeg2:  {22050, 24000, 16000},
+		mpeg25: {11025, 12000, 8000},
+	}
+	mpegChannelModes = [4]string{"stereo", "joint_stereo", "dual_channel", "mono"}
+)
+
+// MPEG versions as encoded in the frame header
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
+// mpegHeader is a decoded MPEG audio frame header
+type mpegHeader struct {
+	Version     int
+	Layer       int
+	Bitrate     int // kbps
+	SampleRate  int
+	Padding     bool
+	ChannelMode int
+}
+
+// parseMPEGHeader decodes the 4 byte frame header in b
+func parseMPEGHeader(b []byte) (mpegHeader, bool) {
+	var h mpegHeader
+	if len(b) < mpegHeaderSize || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+	h.Version = int(b[1]>>3) & 3
+	layerBits := int(b[1]>>1) & 3
+	bitrateIdx := int(b[2] >> 4)
+	sampleRateIdx := int(b[2]>>2) & 3
+	if h.Version == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || sampleRateIdx == 3 {
+		// * reserved values, free format streams are not supported
+		return h, false
+	}
+	h.Layer = 4 - layerBits
+
+	table := 1
+	if h.Version != mpeg1 {
+		table = 2
+	}
+	h.Bitrate = mpegBitrates[[2]int{table, h.Layer}][bitrateIdx]
+	h.SampleRate = mpegSampleRates[h.Version][sampleRateIdx]
+	h.Padding = b[2]&0x02 != 0
+	h.ChannelMode = int(b[3] >> 6)
+
+	return h, true
+}
+
+// SamplesPerFrame returns the number of PCM samples per channel in one frame
+func (h mpegHeader) SamplesPerFrame() int {
+	switch {
+	case h.Layer == 1:
+		return 384
+	case h.Layer == 3 && h.Version != mpeg1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// FrameLength returns the length of the frame in bytes including its header
+func (h mpegHeader) FrameLength() int {
+	if h.Layer == 1 {
+		n := 12 * h.Bitrate * 1000 / h.SampleRate
+		if h.Padding {
+			n++
+		}
+		return n * 4
+	}
+	n := h.SamplesPerFrame() / 8 * h.Bitrate * 1000 / h.SampleRate
+	if h.Padding {
+		n++
+	}
+
+	return n
+}
+
+// Duration returns the playing time of one frame
+func (h mpegHeader) Duration() time.Duration {
+	return time.Duration(h.SamplesPerFrame()) * time.Second / time.Duration(h.SampleRate)
+}
+
+func (h mpegHeader) mono() bool {
+	return h.ChannelMode == 3
+}
+
+// sideInfoLength returns the size of the layer III side information after the header
+func (h mpegHeader) sideInfoLength() int {
+	switch {
+	case h.Version == mpeg1 && h.mono():
+		return 17
+	case h.Version == mpeg1:
+		return 32
+	case h.mono():
+		return 9
+	default:
+		return 17
+	}
+}
+
+// sameStream reports whether two headers can belong to the same stream
+func (h mpegHeader) sameStream(o mpegHeader) bool {
+	return h.Version == o.Version && h.Layer == o.Layer && h.SampleRate == o.SampleRate
+}
+
+// MPEGInfo describes the audio stream of an MPEG audio (MP3) file
+type MPEGInfo struct {
+	Version     string // "1", "2" or "2.5"
+	Layer       int
+	SampleRate  int
+	Bitrate     int // average bitrate in kbps
+	ChannelMode string
+	Channels    int
+	VBR         bool
+	Frames      int64
+	Duration    time.Duration
+}
+
+// MPEGFrame locates a single audio frame inside a file
+type MPEGFrame struct {
+	Offset   int64
+	Length   int
+	Duration time.Duration
+}
+
+// ReadMPEG decodes the MPEG audio frame headers of r. The Xing/Info and VBRI headers
+// written by encoders are used when present, otherwise every frame is visited, so the
+// returned duration is exact for CBR and VBR files alike.
+func ReadMPEG(r io.ReaderAt, size int64) (*MPEGInfo, error) {
+	br := newBlockReader(r, size)
+	end := audioEnd(r, size)
+	offset, first, err := findFirstFrame(br, id3v2Length(r), end)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &MPEGInfo{
+		Version:     mpegVersionName(first.Version),
+		Layer:       first.Layer,
+		SampleRate:  first.SampleRate,
+		ChannelMode: mpegChannelModes[first.ChannelMode],
+		Channels:    2,
+	}
+	if first.mono() {
+		info.Channels = 1
+	}
+
+	if vbr := readVBRHeader(br, offset, first); vbr != nil && vbr.Frames > 0 {
+		samples := vbr.Frames*int64(first.SamplesPerFrame()) - vbr.Delay - vbr.Padding
+		if samples <= 0 {
+			samples = vbr.Frames * int64(first.SamplesPerFrame())
+		}
+		info.Frames = vbr.Frames
+		info.Duration = time.Duration(samples) * time.Second / time.Duration(first.SampleRate)
+		info.VBR = vbr.VBR
+		audioBytes := vbr.Bytes
+		if audioBytes <= 0 {
+			audioBytes = end - offset - int64(first.FrameLength())
+		}
+		info.Bitrate = averageBitrate(audioBytes, info.Duration)
+		if !info.VBR {
+			info.Bitrate = first.Bitrate
+		}
+		return info, nil
+	}
+
+	var audioBytes, samples int64
+	err = scanMPEGFrames(br, offset, end, func(f MPEGFrame, h mpegHeader) bool {
+		info.Frames++
+		samples += int64(h.SamplesPerFrame())
+		audioBytes += int64(f.Length)
+		if h.Bitrate != first.Bitrate {
+			info.VBR = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Duration = time.Duration(samples) * time.Second / time.Duration(first.SampleRate)
+	info.Bitrate = averageBitrate(audioBytes, info.Duration)
+	if !info.VBR {
+		info.Bitrate = first.Bitrate
+	}
+
+	return info, nil
+}
+
+// MPEGFrames returns the location of every audio frame of r, skipping the Xing/Info
+// or VBRI frame which carries no audio
+func MPEGFrames(r io.ReaderAt, size int64) ([]MPEGFrame, error) {
+	br := newBlockReader(r, size)
+	end := audioEnd(r, size)
+	offset, first, err := findFirstFrame(br, id3v2Length(r), end)
+	if err != nil {
+		return nil, err
+	}
+	if vbr := readVBRHeader(br, offset, first); vbr != nil {
+		offset += int64(first.FrameLength())
+	}
+
+	var frames []MPEGFrame
+	err = scanMPEGFrames(br, offset, end, func(f MPEGFrame, _ mpegHeader) bool {
+		frames = append(frames, f)
+		return true
+	})
+
+	return frames, err
+}
+
+// audioEnd returns where the audio data stops, before a trailing ID3v1 tag
+func audioEnd(r io.ReaderAt, size int64) int64 {
+	if hasID3v1(r, size) {
+		return size - id3v1Size
+	}
+
+	return size
+}
+
+// findFirstFrame looks for a frame header followed by another header of the same
+// stream, which rules out random bytes that happen to look like a header
+func findFirstFrame(br *blockReader, start, end int64) (int64, mpegHeader, error) {
+	limit := start + maxSyncSearch
+	if limit > end {
+		limit = end
+	}
+	for pos := start; pos+mpegHeaderSize <= limit; pos++ {
+		h, ok := parseMPEGHeader(br.bytes(pos, mpegHeaderSize))
+		if !ok {
+			continue
+		}
+		next := pos + int64(h.FrameLength())
+		if next+mpegHeaderSize > end {
+			// * a single frame file is still audio if it fills the file
+			if next == end {
+				return pos, h, nil
+			}
+			continue
+		}
+		if nh, ok := parseMPEGHeader(br.bytes(next, mpegHeaderSize)); ok && nh.sameStream(h) {
+			return pos, h, nil
+		}
+	}
+
+	return 0, mpegHeader{}, ErrNotMPEG
+}
+
+// scanMPEGFrames walks the frames from offset, resynchronising over garbage, until fn returns false
+func scanMPEGFrames(br *blockReader, offset, end int64, fn func(f MPEGFrame, h mpegHeader) bool) error {
+	first, ok := parseMPEGHeader(br.bytes(offset, mpegHeaderSize))
+	if !ok {
+		return ErrNotMPEG
+	}
+
+	for pos := offset; pos+mpegHeaderSize <= end; {
+		h, ok := parseMPEGHeader(br.bytes(pos, mpegHeaderSize))
+		if !ok || !h.sameStream(first) {
+			pos++
+			continue
+		}
+		length := h.FrameLength()
+		if pos+int64(length) > end {
+			break // truncated last frame
+		}
+		if !fn(MPEGFrame{Offset: pos, Length: length, Duration: h.Duration()}, h) {
+			break
+		}
+		pos += int64(length)
+	}
+
+	return br.err
+}
+
+// vbrHeader holds the frame count found in a Xing/Info or VBRI header
+type vbrHeader struct {
+	Frames  int64
+	Bytes   int64
+	Delay   int64 // encoder delay in samples
+	Padding int64 // encoder padding in samples
+	VBR     bool
+}
+
+func readVBRHeader(br *blockReader, offset int64, h mpegHeader) *vbrHeader {
+	if h.Layer != 3 {
+		return nil
+	}
+
+	xing := offset + mpegHeaderSize + int64(h.sideInfoLength())
+	if id := string(br.bytes(xing, 4)); id == "Xing" || id == "Info" {
+		v := &vbrHeader{VBR: id == "Xing"}
+		flags := br.uint32(xing + 4)
+		pos := xing + 8
+		if flags&0x1 != 0 {
+			v.Frames = int64(br.uint32(pos))
+			pos += 4
+		}
+		if flags&0x2 != 0 {
+			v.Bytes = int64(br.uint32(pos))
+			pos += 4
+		}
+		if flags&0x4 != 0 {
+			pos += 100 // seek table
+		}
+		if flags&0x8 != 0 {
+			pos += 4 // quality
+		}
+		// * the LAME extension stores the encoder delay and padding in 2x12 bits
+		if string(br.bytes(pos, 4)) == "LAME" {
+			b := br.bytes(pos+21, 3)
+			if len(b) == 3 {
+				v.Delay = int64(b[0])<<4 | int64(b[1]>>4)
+				v.Padding = int64(b[1]&0x0F)<<8 | int64(b[2])
+			}
+		}
+		return v
+	}
+
+	vbri := offset + mpegHeaderSize + 32
+	if string(br.bytes(vbri, 4)) == "VBRI" {
+		return &vbrHeader{
+			Bytes:  int64(br.uint32(vbri + 10)),
+			Frames: int64(br.uint32(vbri + 14)),
+			Delay:  int64(br.uint16(vbri + 6)),
+			VBR:    true,
+		}
+	}
+
+	return nil
+}
+
+func averageBitrate(audioBytes int64, d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+
+	return int(float64(audioBytes) * 8 / d.Seconds() / 1000)
+}
+
+func mpegVersionName(v int) string {
+	switch v {
+	case mpeg1:
+		return "1"
+	case mpeg2:
+		return "2"
+	default:
+		return "2.5"
+	}
+}
+
+// blockReader serves small reads of an io.ReaderAt from a cached block, frame headers
+// are only a few bytes apart and reading them one by one would be slow
+type blockReader struct {
+	r     io.ReaderAt
+	size  int64
+	block []byte
+	start int64
+	err   error
+}
+
+const blockSize = 64 << 10
+
+func newBlockReader(r io.ReaderAt, size int64) *blockReader {
+	return &blockReader{r: r, size: size, start: -1}
+}
+
+// bytes returns n bytes at off, or fewer near the end of the data. The returned slice
+// is only valid until the next call.
+func (br *blockReader) bytes(off int64, n int) []byte {
+	if off < 0 || off >= br.size || n <= 0 {
+		return nil
+	}
+	if br.start < 0 || off < br.start || off+int64(n) > br.start+int64(len(br.block)) {
+		length := int64(blockSize)
+		if int64(n) > length {
+			length = int64(n)
+		}
+		if off+length > br.size {
+			length = br.size - off
+		}
+		if cap(br.block) < int(length) {
+			br.block = make([]byte, length)
+		}
+		br.block = br.block[:length]
+		read, err := br.r.ReadAt(br.block, off)
+		if err != nil && err != io.EOF {
+			br.err = err
+		}
+		br.block = br.block[:read]
+		br.start = off
+	}
+
+	rel := int(off - br.start)
+	end := rel + n
+	if end > len(br.block) {
+		end = len(br.block)
+	}
+
+	return br.block[rel:end]
+}
+
+// uint32 returns the big endian value at off, or 0 past the end of the data
+func (br *blockReader) uint32(off int64) uint32 {
+	b := br.bytes(off, 4)
+	if len(b) < 4 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+// uint16 returns the big endian value at off, or 0 past the end of the data
+func (br *blockReader) uint16(off int64) uint16 {
+	b := br.bytes(off, 2)
+	if len(b) < 2 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(b)
+}
@@ -0,0 +1,240 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// mpegFrame returns an MPEG1 layer III joint stereo 44.1kHz frame of the bitrate index,
+// with silence as its data
+func mpegFrame(bitrateIdx byte, padding bool) []byte {
+	header := []byte{0xFF, 0xFB, bitrateIdx << 4, 0x40}
+	if padding {
+		header[2] |= 0x02
+	}
+	h, _ := parseMPEGHeader(header)
+	frame := make([]byte, h.FrameLength())
+	copy(frame, header)
+
+	return frame
+}
+
+// xingFrame returns the frame an encoder puts before the audio, with the frame count
+// and, when lame is set, encoder delay and padding
+func xingFrame(id string, frames uint32, lame bool, delay, padding int) []byte {
+	frame := mpegFrame(9, false)
+	pos := mpegHeaderSize + 32
+	copy(frame[pos:], id)
+	binary.BigEndian.PutUint32(frame[pos+4:], 0x1)
+	binary.BigEndian.PutUint32(frame[pos+8:], frames)
+	if lame {
+		copy(frame[pos+12:], "LAME3.100")
+		frame[pos+12+21] = byte(delay >> 4)
+		frame[pos+12+22] = byte(delay<<4) | byte(padding>>8)
+		frame[pos+12+23] = byte(padding)
+	}
+
+	return frame
+}
+
+// mp3File returns an ID3v2 tag, a few bytes of garbage and the frames
+func mp3File(frames ...[]byte) []byte {
+	file := id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, text(0, "Song")))
+	file = append(file, "junk"...)
+
+	return append(file, bytes.Join(frames, nil)...)
+}
+
+func cbrFrames(n int) [][]byte {
+	frames := make([][]byte, n)
+	for i := range frames {
+		frames[i] = mpegFrame(9, i%3 == 0)
+	}
+
+	return frames
+}
+
+func samples(n int64, rate int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(rate)
+}
+
+func TestParseMPEGHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   mpegHeader
+		length int
+		frame  int
+	}{
+		{"MPEG1 layer III 128kbps", []byte{0xFF, 0xFB, 0x90, 0x40}, mpegHeader{Version: mpeg1, Layer: 3, Bitrate: 128, SampleRate: 44100, ChannelMode: 1}, 417, 1152},
+		{"MPEG1 layer III padded", []byte{0xFF, 0xFB, 0x92, 0xC0}, mpegHeader{Version: mpeg1, Layer: 3, Bitrate: 128, SampleRate: 44100, Padding: true, ChannelMode: 3}, 418, 1152},
+		{"MPEG1 layer III 320kbps 48kHz", []byte{0xFF, 0xFB, 0xE4, 0x00}, mpegHeader{Version: mpeg1, Layer: 3, Bitrate: 320, SampleRate: 48000}, 960, 1152},
+		{"MPEG2 layer III", []byte{0xFF, 0xF3, 0x90, 0x00}, mpegHeader{Version: mpeg2, Layer: 3, Bitrate: 80, SampleRate: 22050}, 261, 576},
+		{"MPEG2.5 layer III", []byte{0xFF, 0xE3, 0x50, 0x00}, mpegHeader{Version: mpeg25, Layer: 3, Bitrate: 40, SampleRate: 11025}, 261, 576},
+		{"MPEG1 layer II", []byte{0xFF, 0xFD, 0x90, 0x00}, mpegHeader{Version: mpeg1, Layer: 2, Bitrate: 160, SampleRate: 44100}, 522, 1152},
+		{"MPEG1 layer I", []byte{0xFF, 0xFF, 0x90, 0x00}, mpegHeader{Version: mpeg1, Layer: 1, Bitrate: 288, SampleRate: 44100}, 312, 384},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseMPEGHeader(tt.header)
+			if !ok {
+				t.Fatalf("parseMPEGHeader(% x) not ok", tt.header)
+			}
+			if h != tt.want {
+				t.Errorf("parseMPEGHeader(% x) = %+v, want %+v", tt.header, h, tt.want)
+			}
+			if got := h.FrameLength(); got != tt.length {
+				t.Errorf("FrameLength() = %d, want %d", got, tt.length)
+			}
+			if got := h.SamplesPerFrame(); got != tt.frame {
+				t.Errorf("SamplesPerFrame() = %d, want %d", got, tt.frame)
+			}
+		})
+	}
+}
+
+func TestParseMPEGHeaderInvalid(t *testing.T) {
+	for _, header := range [][]byte{
+		{0xFF, 0xFB, 0x90},       // short
+		{0xFE, 0xFB, 0x90, 0x40}, // no sync
+		{0xFF, 0xEB, 0x90, 0x40}, // reserved version
+		{0xFF, 0xF9, 0x90, 0x40}, // reserved layer
+		{0xFF, 0xFB, 0x00, 0x40}, // free format
+		{0xFF, 0xFB, 0xF0, 0x40}, // bad bitrate
+		{0xFF, 0xFB, 0x9C, 0x40}, // reserved sample rate
+	} {
+		if _, ok := parseMPEGHeader(header); ok {
+			t.Errorf("parseMPEGHeader(% x) ok, want rejected", header)
+		}
+	}
+}
+
+func TestReadMPEG(t *testing.T) {
+	vbr := [][]byte{}
+	for i := 0; i < 60; i++ {
+		vbr = append(vbr, mpegFrame([]byte{9, 11, 14}[i%3], false))
+	}
+	vbri := mpegFrame(9, false)
+	copy(vbri[mpegHeaderSize+32:], "VBRI")
+	binary.BigEndian.PutUint16(vbri[mpegHeaderSize+32+6:], 576)
+	binary.BigEndian.PutUint32(vbri[mpegHeaderSize+32+10:], 200*417)
+	binary.BigEndian.PutUint32(vbri[mpegHeaderSize+32+14:], 200)
+
+	tests := []struct {
+		name     string
+		file     []byte
+		duration time.Duration
+		frames   int64
+		bitrate  int
+		vbr      bool
+	}{
+		{
+			name:     "CBR without header",
+			file:     mp3File(cbrFrames(100)...),
+			duration: samples(100*1152, 44100),
+			frames:   100,
+			bitrate:  128,
+		},
+		{
+			name:     "CBR with ID3v1 tag",
+			file:     append(mp3File(cbrFrames(50)...), id3v1Tag("t", "", "", "", 0, 0)...),
+			duration: samples(50*1152, 44100),
+			frames:   50,
+			bitrate:  128,
+		},
+		{
+			name:     "VBR without header",
+			file:     mp3File(vbr...),
+			duration: samples(60*1152, 44100),
+			frames:   60,
+			bitrate:  213,
+			vbr:      true,
+		},
+		{
+			name:     "Xing header",
+			file:     mp3File(append([][]byte{xingFrame("Xing", 1000, false, 0, 0)}, cbrFrames(10)...)...),
+			duration: samples(1000*1152, 44100),
+			frames:   1000,
+			vbr:      true,
+		},
+		{
+			name:     "Info header with LAME gapless info",
+			file:     mp3File(append([][]byte{xingFrame("Info", 1000, true, 576, 1000)}, cbrFrames(10)...)...),
+			duration: samples(1000*1152-576-1000, 44100),
+			frames:   1000,
+			bitrate:  128,
+		},
+		{
+			name:     "VBRI header",
+			file:     mp3File(append([][]byte{vbri}, cbrFrames(10)...)...),
+			duration: samples(200*1152-576, 44100),
+			frames:   200,
+			vbr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ReadMPEG(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("ReadMPEG() error = %v", err)
+			}
+			if info.Duration != tt.duration {
+				t.Errorf("Duration = %v, want %v", info.Duration, tt.duration)
+			}
+			if info.Frames != tt.frames {
+				t.Errorf("Frames = %d, want %d", info.Frames, tt.frames)
+			}
+			if info.VBR != tt.vbr {
+				t.Errorf("VBR = %v, want %v", info.VBR, tt.vbr)
+			}
+			if tt.bitrate != 0 && info.Bitrate != tt.bitrate {
+				t.Errorf("Bitrate = %d, want %d", info.Bitrate, tt.bitrate)
+			}
+			if info.Version != "1" || info.Layer != 3 || info.SampleRate != 44100 || info.Channels != 2 || info.ChannelMode != "joint_stereo" {
+				t.Errorf("ReadMPEG() = %+v, want MPEG1 layer III 44.1kHz joint stereo", info)
+			}
+		})
+	}
+}
+
+func TestReadMPEGNotMPEG(t *testing.T) {
+	for name, file := range map[string][]byte{
+		"text":         []byte("hello world, this is not audio at all"),
+		"single sync":  append([]byte{0xFF, 0xFB, 0x90, 0x40}, make([]byte, 2000)...),
+		"only ID3 tag": id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, text(0, "Song"))),
+	} {
+		if _, err := ReadMPEG(bytes.NewReader(file), int64(len(file))); err != ErrNotMPEG {
+			t.Errorf("%s: ReadMPEG() error = %v, want ErrNotMPEG", name, err)
+		}
+	}
+}
+
+func TestMPEGFrames(t *testing.T) {
+	file := mp3File(append([][]byte{xingFrame("Xing", 20, false, 0, 0)}, cbrFrames(20)...)...)
+	file = append(file, id3v1Tag("t", "", "", "", 0, 0)...)
+
+	frames, err := MPEGFrames(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("MPEGFrames() error = %v", err)
+	}
+	if len(frames) != 20 {
+		t.Fatalf("MPEGFrames() returned %d frames, want 20", len(frames))
+	}
+
+	// * the audio starts after the tag, the garbage and the Xing frame
+	offset := int64(len(id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, text(0, "Song"))))) + 4 + 417
+	for i, f := range frames {
+		want := 417
+		if i%3 == 0 {
+			want = 418
+		}
+		if f.Offset != offset || f.Length != want || f.Duration != samples(1152, 44100) {
+			t.Fatalf("frame %d = %+v, want offset %d length %d", i, f, offset, want)
+		}
+		offset += int64(f.Length)
+	}
+}
@@ -0,0 +1,187 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var errInvalidOgg = errors.New("audio: invalid Ogg stream")
+
+const (
+	oggPageHeaderSize = 27
+
+	// maxOggPacket bounds the size of a header packet, comment packets carrying
+	// embedded pictures span many pages
+	maxOggPacket = 16 << 20
+
+	// oggTailSearch is how much of the end of a file is searched for the last page
+	oggTailSearch = 64 << 10
+
+	opusSampleRate = 48000
+)
+
+// oggPage is the header of an Ogg page
+type oggPage struct {
+	Offset   int64
+	Granule  int64
+	Serial   uint32
+	Segments []byte
+}
+
+func (p oggPage) bodyLength() int64 {
+	var n int64
+	for _, s := range p.Segments {
+		n += int64(s)
+	}
+
+	return n
+}
+
+func readOggPage(r io.ReaderAt, off int64) (oggPage, error) {
+	header := make([]byte, oggPageHeaderSize)
+	if _, err := r.ReadAt(header, off); err != nil {
+		return oggPage{}, errInvalidOgg
+	}
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return oggPage{}, errInvalidOgg
+	}
+	segments := make([]byte, header[26])
+	if _, err := r.ReadAt(segments, off+oggPageHeaderSize); err != nil {
+		return oggPage{}, errInvalidOgg
+	}
+
+	return oggPage{
+		Offset:   off,
+		Granule:  int64(binary.LittleEndian.Uint64(header[6:14])),
+		Serial:   binary.LittleEndian.Uint32(header[14:18]),
+		Segments: segments,
+	}, nil
+}
+
+// readOggPackets reassembles the first n packets of the logical stream that starts
+// the file, following packets across page boundaries
+func readOggPackets(r io.ReaderAt, start int64, n int) ([][]byte, error) {
+	var (
+		packets [][]byte
+		current []byte
+		serial  uint32
+		off     = start
+	)
+	for first := true; len(packets) < n; first = false {
+		page, err := readOggPage(r, off)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			serial = page.Serial
+		}
+		body := off + oggPageHeaderSize + int64(len(page.Segments))
+		off = body + page.bodyLength()
+		if page.Serial != serial {
+			continue
+		}
+
+		pos := body
+		for _, s := range page.Segments {
+			if len(current)+int(s) > maxOggPacket {
+				return nil, errInvalidOgg
+			}
+			chunk := make([]byte, s)
+			if _, err := r.ReadAt(chunk, pos); err != nil {
+				return nil, errInvalidOgg
+			}
+			pos += int64(s)
+			current = append(current, chunk...)
+			// * a lacing value below 255 terminates the packet
+			if s < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+	}
+
+	return packets, nil
+}
+
+// lastGranule returns the granule position of the last page of the stream
+func lastGranule(r io.ReaderAt, size int64, serial uint32) (int64, bool) {
+	start := size - oggTailSearch
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, size-start)
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, false
+	}
+	buf = buf[:n]
+
+	for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+		page, err := readOggPage(r, start+int64(i))
+		if err == nil && page.Serial == serial && page.Granule >= 0 {
+			return page.Granule, true
+		}
+	}
+
+	return 0, false
+}
+
+func readOgg(r io.ReaderAt, size int64) (*Info, error) {
+	start := id3v2Length(r)
+	first, err := readOggPage(r, start)
+	if err != nil {
+		return nil, err
+	}
+	packets, err := readOggPackets(r, start, 2)
+	if err != nil {
+		return nil, err
+	}
+	ident, comments := packets[0], packets[1]
+
+	info := &Info{}
+	preSkip := int64(0)
+	switch {
+	case len(ident) >= 30 && bytes.HasPrefix(ident, []byte("\x01vorbis")):
+		info.Codec = CodecVorbis
+		info.Channels = int(ident[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(ident[12:16]))
+		if nominal := int32(binary.LittleEndian.Uint32(ident[20:24])); nominal > 0 {
+			info.Bitrate = int(nominal) / 1000
+		}
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			c := parseVorbisComments(comments[7:])
+			info.Tags, info.Picture = c.Tags(), c.Picture()
+		}
+	case len(ident) >= 19 && bytes.HasPrefix(ident, []byte("OpusHead")):
+		info.Codec = CodecOpus
+		info.Channels = int(ident[9])
+		// * Opus always decodes at 48kHz, the input rate in the header is informational
+		info.SampleRate = opusSampleRate
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			c := parseVorbisComments(comments[8:])
+			info.Tags, info.Picture = c.Tags(), c.Picture()
+		}
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if info.SampleRate == 0 {
+		return nil, errInvalidOgg
+	}
+
+	if granule, ok := lastGranule(r, size, first.Serial); ok && granule > preSkip {
+		samples := granule - preSkip
+		info.Duration = time.Duration(samples * int64(time.Second) / int64(info.SampleRate))
+	}
+	if info.Codec == CodecOpus || info.Bitrate == 0 {
+		info.Bitrate = averageBitrate(size-start, info.Duration)
+	}
+
+	return info, nil
+}
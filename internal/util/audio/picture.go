@@ -0,0 +1,179 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"strings"
+)
+
+// PictureFrontCover is the ID3v2 and FLAC picture type of a front cover
+const PictureFrontCover = 3
+
+// Picture is an image embedded in an audio file
+type Picture struct {
+	MimeType    string // image/jpeg or image/png, sniffed from the data
+	Type        int    // ID3v2/FLAC picture type
+	Description string
+	Data        []byte
+}
+
+// newPicture checks that data holds an image we can serve, the MIME type written by
+// taggers is often wrong or missing so the data is sniffed instead
+func newPicture(typ int, description string, data []byte) *Picture {
+	if len(data) == 0 {
+		return nil
+	}
+	mimeType := http.DetectContentType(data)
+	if mimeType != "image/jpeg" && mimeType != "image/png" {
+		return nil
+	}
+
+	return &Picture{MimeType: mimeType, Type: typ, Description: description, Data: data}
+}
+
+// betterPicture returns the picture to keep as cover: the first front cover, or the
+// first picture of any type when there is none
+func betterPicture(current, candidate *Picture) *Picture {
+	switch {
+	case candidate == nil:
+		return current
+	case current == nil:
+		return candidate
+	case current.Type != PictureFrontCover && candidate.Type == PictureFrontCover:
+		return candidate
+	}
+
+	return current
+}
+
+// id3Picture returns the cover from the APIC (ID3v2.3/2.4) or PIC (ID3v2.2) frames
+func id3Picture(frames []id3Frame) *Picture {
+	var picture *Picture
+	for _, f := range frames {
+		var p *Picture
+		switch f.ID {
+		case "APIC":
+			p = parseAPIC(f.Data)
+		case "PIC":
+			p = parsePIC(f.Data)
+		}
+		picture = betterPicture(picture, p)
+	}
+
+	return picture
+}
+
+// parseAPIC decodes text encoding, null terminated MIME type, picture type,
+// description and picture data
+func parseAPIC(b []byte) *Picture {
+	if len(b) < 2 {
+		return nil
+	}
+	encoding := b[0]
+	end := bytes.IndexByte(b[1:], 0)
+	if end < 0 {
+		return nil
+	}
+	mimeType := string(b[1 : 1+end])
+	rest := b[2+end:]
+	if len(rest) < 1 || mimeType == "-->" {
+		// * "-->" means the frame only links to an external file
+		return nil
+	}
+	typ := int(rest[0])
+	description, data := splitID3String(encoding, rest[1:])
+
+	return newPicture(typ, description, data)
+}
+
+// parsePIC decodes text encoding, three letter image format, picture type,
+// description and picture data
+func parsePIC(b []byte) *Picture {
+	if len(b) < 5 {
+		return nil
+	}
+	description, data := splitID3String(b[0], b[5:])
+
+	return newPicture(int(b[4]), description, data)
+}
+
+// splitID3String splits a terminated string in the given text encoding off b
+func splitID3String(encoding byte, b []byte) (string, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return strings.TrimSpace(decodeID3String(encoding, b[:i])), b[i+2:]
+			}
+		}
+		return "", nil
+	}
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		return "", nil
+	}
+
+	return strings.TrimSpace(decodeID3String(encoding, b[:end])), b[end+1:]
+}
+
+// parseFLACPicture decodes a FLAC PICTURE block, which Ogg files also carry base64
+// encoded in a METADATA_BLOCK_PICTURE comment
+func parseFLACPicture(b []byte) *Picture {
+	pos := 0
+	next := func() (int, bool) {
+		if pos+4 > len(b) {
+			return 0, false
+		}
+		v := int(binary.BigEndian.Uint32(b[pos:]))
+		pos += 4
+		return v, true
+	}
+	field := func() ([]byte, bool) {
+		n, ok := next()
+		if !ok || n < 0 || pos+n > len(b) {
+			return nil, false
+		}
+		v := b[pos : pos+n]
+		pos += n
+		return v, true
+	}
+
+	typ, ok := next()
+	if !ok {
+		return nil
+	}
+	if _, ok := field(); !ok { // MIME type
+		return nil
+	}
+	description, ok := field()
+	if !ok {
+		return nil
+	}
+	// * width, height, color depth and number of colors
+	pos += 16
+	data, ok := field()
+	if !ok {
+		return nil
+	}
+
+	return newPicture(typ, string(description), data)
+}
+
+// Picture returns the cover stored in the comments. METADATA_BLOCK_PICTURE is the
+// standard, COVERART is the raw base64 image older taggers wrote.
+func (c vorbisComments) Picture() *Picture {
+	var picture *Picture
+	for _, v := range c["METADATA_BLOCK_PICTURE"] {
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+			picture = betterPicture(picture, parseFLACPicture(b))
+		}
+	}
+	for _, v := range c["COVERART"] {
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+			picture = betterPicture(picture, newPicture(PictureFrontCover, "", b))
+		}
+	}
+
+	return picture
+}
@@ -0,0 +1,186 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrUnsupportedFormat is returned for files that are not in a supported audio format
+var ErrUnsupportedFormat = errors.New("audio: unsupported format")
+
+// Format identifies the container of an audio file
+type Format string
+
+const (
+	FormatMP3  Format = "mp3"
+	FormatFLAC Format = "flac"
+	FormatOgg  Format = "ogg"
+	FormatWAV  Format = "wav"
+	FormatMP4  Format = "mp4"
+	FormatAAC  Format = "aac"
+)
+
+// Codecs found inside the supported containers
+const (
+	CodecMP3    = "mp3"
+	CodecFLAC   = "flac"
+	CodecVorbis = "vorbis"
+	CodecOpus   = "opus"
+	CodecPCM    = "pcm"
+	CodecAAC    = "aac"
+	CodecALAC   = "alac"
+)
+
+// Extension returns the usual file extension of the format, without the dot
+func (f Format) Extension() string {
+	switch f {
+	case FormatMP4:
+		return "m4a"
+	case "":
+		return "bin"
+	}
+
+	return string(f)
+}
+
+// MimeType returns the media type of a file with the given container and codec
+func MimeType(format Format, codec string) string {
+	switch format {
+	case FormatMP3:
+		return "audio/mpeg"
+	case FormatFLAC:
+		return "audio/flac"
+	case FormatOgg:
+		if codec == CodecOpus {
+			return "audio/ogg; codecs=opus"
+		}
+		return "audio/ogg"
+	case FormatWAV:
+		return "audio/wav"
+	case FormatMP4:
+		return "audio/mp4"
+	case FormatAAC:
+		return "audio/aac"
+	}
+
+	return "application/octet-stream"
+}
+
+// Info describes an audio file and the stream inside it
+type Info struct {
+	Format      Format
+	Codec       string
+	MimeType    string
+	Duration    time.Duration
+	SampleRate  int
+	Channels    int
+	BitDepth    int    // bits per sample of lossless codecs, 0 for lossy ones
+	Bitrate     int    // average bitrate in kbps
+	ChannelMode string // MPEG channel mode, empty for other codecs
+	VBR         bool
+	Tags        *Tags    // nil when the file carries no metadata
+	Picture     *Picture // embedded cover art, nil when the file has none
+}
+
+// Detect identifies the container of r by its magic bytes. ID3v2 tags, which some
+// encoders put in front of FLAC and AAC streams as well, are skipped.
+func Detect(r io.ReaderAt, size int64) (Format, error) {
+	start := id3v2Length(r)
+	head := make([]byte, 12)
+	n, err := r.ReadAt(head, start)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return FormatFLAC, nil
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return FormatOgg, nil
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return FormatWAV, nil
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return FormatMP4, nil
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		// * ADTS shares the MPEG sync word but always has layer bits 00
+		return FormatAAC, nil
+	}
+
+	if _, _, err := findFirstFrame(newBlockReader(r, size), start, audioEnd(r, size)); err == nil {
+		return FormatMP3, nil
+	}
+
+	return "", ErrUnsupportedFormat
+}
+
+// Probe detects the format of r and reads its stream properties and metadata
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	format, err := Detect(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *Info
+	switch format {
+	case FormatMP3:
+		info, err = probeMP3(r, size)
+	case FormatFLAC:
+		info, err = readFLAC(r, size)
+	case FormatOgg:
+		info, err = readOgg(r, size)
+	case FormatWAV:
+		info, err = readWAV(r, size)
+	case FormatMP4:
+		info, err = readMP4(r, size)
+	case FormatAAC:
+		info, err = readADTS(r, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Format = format
+	info.MimeType = MimeType(format, info.Codec)
+	if info.Tags != nil && info.Tags.empty() {
+		info.Tags = nil
+	}
+	if info.Bitrate == 0 {
+		info.Bitrate = averageBitrate(size, info.Duration)
+	}
+
+	return info, nil
+}
+
+func probeMP3(r io.ReaderAt, size int64) (*Info, error) {
+	mpeg, err := ReadMPEG(r, size)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		Codec:       CodecMP3,
+		Duration:    mpeg.Duration,
+		SampleRate:  mpeg.SampleRate,
+		Channels:    mpeg.Channels,
+		Bitrate:     mpeg.Bitrate,
+		ChannelMode: mpeg.ChannelMode,
+		VBR:         mpeg.VBR,
+	}
+	tags, frames, _ := readID3(r, size)
+	info.Tags = tags
+	info.Picture = id3Picture(frames)
+
+	return info, nil
+}
+
+// readID3Prefix returns the tags of an ID3v2 tag in front of a non MP3 stream
+func readID3Prefix(r io.ReaderAt) *Tags {
+	tags, _, err := readID3v2(r)
+	if err != nil {
+		return nil
+	}
+
+	return tags
+}
@@ -0,0 +1,308 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// wavFile returns a RIFF WAVE file of 16 bit PCM samples with an INFO list
+func wavFile(rate, channels int, samples []int16, info []byte) []byte {
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 1)
+	binary.LittleEndian.PutUint16(format[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(format[4:], uint32(rate))
+	binary.LittleEndian.PutUint32(format[8:], uint32(rate*channels*2))
+	binary.LittleEndian.PutUint16(format[12:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(format[14:], 16)
+
+	data := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+
+	body := append([]byte("WAVE"), wavChunk("fmt ", format)...)
+	if info != nil {
+		body = append(body, wavChunk("LIST", append([]byte("INFO"), info...))...)
+	}
+	body = append(body, wavChunk("data", data)...)
+
+	return append(append([]byte("RIFF"), le32(uint32(len(body)))...), body...)
+}
+
+// wavChunk returns a RIFF chunk, padded to an even length
+func wavChunk(id string, data []byte) []byte {
+	chunk := append(append([]byte(id), le32(uint32(len(data)))...), data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+// vorbisComment encodes a Vorbis comment block as used by FLAC, Vorbis and Opus
+func vorbisComment(comments ...string) []byte {
+	b := append(le32(6), "vendor"...)
+	b = append(b, le32(uint32(len(comments)))...)
+	for _, c := range comments {
+		b = append(b, le32(uint32(len(c)))...)
+		b = append(b, c...)
+	}
+
+	return b
+}
+
+// flacFile returns a FLAC stream header with a STREAMINFO and a VORBIS_COMMENT block
+func flacFile(rate, channels, bits int, samples uint64, comments ...string) []byte {
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint64(streamInfo[10:], uint64(rate)<<44|uint64(channels-1)<<41|uint64(bits-1)<<36|samples)
+	comment := vorbisComment(comments...)
+
+	f := []byte("fLaC")
+	f = append(f, 0, 0, 0, 34)
+	f = append(f, streamInfo...)
+	f = append(f, 0x84, byte(len(comment)>>16), byte(len(comment)>>8), byte(len(comment)))
+	f = append(f, comment...)
+
+	return append(f, make([]byte, 4096)...)
+}
+
+// oggPageBytes returns an Ogg page of the logical stream serial holding packets
+func oggPageBytes(serial uint32, granule int64, packets ...[]byte) []byte {
+	var segments, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			segments = append(segments, 255)
+		}
+		segments = append(segments, byte(n))
+		body = append(body, p...)
+	}
+
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint64(header[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(header[14:], serial)
+	header[26] = byte(len(segments))
+
+	return append(append(header, segments...), body...)
+}
+
+// opusFile returns an Ogg Opus file recorded at 44.1kHz, Opus always decodes at 48kHz
+func opusFile(seconds int, comments ...string) []byte {
+	head := append([]byte("OpusHead"), 1, 2)
+	head = binary.LittleEndian.AppendUint16(head, 312) // pre-skip
+	head = append(head, le32(44100)...)
+	head = append(head, 0, 0, 0)
+	tags := append([]byte("OpusTags"), vorbisComment(comments...)...)
+	// * a comment header longer than a segment spans several lacing values
+	tags = append(tags, make([]byte, 600)...)
+
+	f := oggPageBytes(7, 0, head)
+	f = append(f, oggPageBytes(7, 0, tags)...)
+
+	return append(f, oggPageBytes(7, int64(48000*seconds+312), make([]byte, 100))...)
+}
+
+// vorbisFile returns an Ogg Vorbis stereo 44.1kHz 128kbps file
+func vorbisFile(seconds int, comments ...string) []byte {
+	id := append([]byte("\x01vorbis"), le32(0)...)
+	id = append(id, 2)
+	id = append(id, le32(44100)...)
+	id = append(id, le32(0)...)
+	id = append(id, le32(128000)...)
+	id = append(id, le32(0)...)
+	id = append(id, 0xB8, 1)
+	comment := append([]byte("\x03vorbis"), vorbisComment(comments...)...)
+
+	f := oggPageBytes(9, 0, id)
+	f = append(f, oggPageBytes(9, 0, comment)...)
+
+	return append(f, oggPageBytes(9, int64(44100*seconds), make([]byte, 10))...)
+}
+
+// box returns an MP4 box of the type with the parts as its body
+func box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+
+	return append(append(be32(uint32(len(body)+8)), typ...), body...)
+}
+
+// m4aFile returns an M4A file of one AAC track with the mdat before the moov
+func m4aFile(rate, seconds int, items ...[]byte) []byte {
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], uint32(rate))
+	binary.BigEndian.PutUint32(mdhd[16:], uint32(rate*seconds))
+	hdlr := append(make([]byte, 8), "soun"...)
+	hdlr = append(hdlr, make([]byte, 13)...)
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[16:], 2)
+	binary.BigEndian.PutUint16(entry[18:], 16)
+	binary.BigEndian.PutUint32(entry[24:], uint32(rate)<<16)
+	stsd := append(make([]byte, 8), box("mp4a", entry)...)
+
+	trak := box("trak", box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", box("stbl", box("stsd", stsd)))))
+	meta := box("meta", make([]byte, 4), box("hdlr", make([]byte, 25)), box("ilst", items...))
+	moov := box("moov", trak, box("udta", meta))
+
+	f := box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	f = append(f, box("mdat", make([]byte, 5000))...)
+
+	return append(f, moov...)
+}
+
+// mp4Item returns an iTunes metadata item holding value
+func mp4Item(typ string, value []byte) []byte {
+	return box(typ, box("data", make([]byte, 8), value))
+}
+
+// adtsFile returns n AAC LC stereo 44.1kHz ADTS frames of 200 bytes
+func adtsFile(n int) []byte {
+	var f []byte
+	for i := 0; i < n; i++ {
+		frame := make([]byte, 200)
+		frame[0], frame[1], frame[2] = 0xFF, 0xF1, 0x50
+		frame[3] = 0x80 | byte(200>>11)
+		frame[4] = byte(200 >> 3)
+		frame[5] = byte(200&7) << 5
+		f = append(f, frame...)
+	}
+
+	return f
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want Info
+	}{
+		{
+			name: "MP3",
+			file: mp3File(cbrFrames(100)...),
+			want: Info{Format: FormatMP3, Codec: CodecMP3, MimeType: "audio/mpeg", Duration: samples(100*1152, 44100), SampleRate: 44100, Channels: 2, Bitrate: 128, ChannelMode: "joint_stereo", Tags: &Tags{Title: "Song"}},
+		},
+		{
+			name: "WAV",
+			file: wavFile(44100, 2, make([]int16, 44100*2*3), []byte("INAM\x06\x00\x00\x00Hello\x00IART\x03\x00\x00\x00Bob\x00")),
+			want: Info{Format: FormatWAV, Codec: CodecPCM, MimeType: "audio/wav", Duration: 3 * time.Second, SampleRate: 44100, Channels: 2, BitDepth: 16, Bitrate: 1411, Tags: &Tags{Title: "Hello", Artist: "Bob"}},
+		},
+		{
+			name: "FLAC",
+			file: flacFile(48000, 2, 24, 48000*10, "TITLE=Song", "artist=Ann", "TRACKNUMBER=2", "TRACKTOTAL=9", "DATE=2001-02-03"),
+			want: Info{Format: FormatFLAC, Codec: CodecFLAC, MimeType: "audio/flac", Duration: 10 * time.Second, SampleRate: 48000, Channels: 2, BitDepth: 24, Tags: &Tags{Title: "Song", Artist: "Ann", Year: 2001, Track: 2, TrackTotal: 9}},
+		},
+		{
+			name: "Ogg Opus",
+			file: opusFile(5, "TITLE=Op", "GENRE=Rock", "TRACKNUMBER=3/5"),
+			want: Info{Format: FormatOgg, Codec: CodecOpus, MimeType: "audio/ogg; codecs=opus", Duration: 5 * time.Second, SampleRate: 48000, Channels: 2, Tags: &Tags{Title: "Op", Genre: "Rock", Track: 3, TrackTotal: 5}},
+		},
+		{
+			name: "Ogg Vorbis",
+			file: vorbisFile(2, "ARTIST=V"),
+			want: Info{Format: FormatOgg, Codec: CodecVorbis, MimeType: "audio/ogg", Duration: 2 * time.Second, SampleRate: 44100, Channels: 2, Bitrate: 128, Tags: &Tags{Artist: "V"}},
+		},
+		{
+			name: "M4A",
+			file: m4aFile(44100, 7,
+				mp4Item("\xa9nam", []byte("Tune")),
+				mp4Item("\xa9ART", []byte("Singer")),
+				mp4Item("gnre", []byte{0, 18}),
+				mp4Item("trkn", []byte{0, 0, 0, 4, 0, 10, 0, 0}),
+			),
+			want: Info{Format: FormatMP4, Codec: CodecAAC, MimeType: "audio/mp4", Duration: 7 * time.Second, SampleRate: 44100, Channels: 2, Tags: &Tags{Title: "Tune", Artist: "Singer", Genre: "Rock", Track: 4, TrackTotal: 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if (info.Tags == nil) != (tt.want.Tags == nil) || info.Tags != nil && *info.Tags != *tt.want.Tags {
+				t.Errorf("Tags = %+v, want %+v", info.Tags, tt.want.Tags)
+			}
+			got, want := *info, tt.want
+			got.Tags, want.Tags, got.Picture = nil, nil, nil
+			if want.Bitrate == 0 {
+				got.Bitrate = 0
+			}
+			if got != want {
+				t.Errorf("Probe() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestProbeADTS(t *testing.T) {
+	file := adtsFile(431)
+	info, err := Probe(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	// * 431 frames of 1024 samples
+	if info.Format != FormatAAC || info.Codec != CodecAAC || info.SampleRate != 44100 || info.Channels != 2 || info.Duration != samples(431*1024, 44100) {
+		t.Errorf("Probe() = %+v, want AAC LC stereo 44.1kHz of 431 frames", info)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want Format
+	}{
+		{"MP3", mp3File(cbrFrames(3)...), FormatMP3},
+		{"FLAC", flacFile(44100, 2, 16, 44100), FormatFLAC},
+		{"FLAC behind an ID3v2 tag", append(id3v2Tag(3, 0, id3v2Frame(3, "TIT2", 0, text(0, "x"))), flacFile(44100, 2, 16, 44100)...), FormatFLAC},
+		{"Ogg", opusFile(1), FormatOgg},
+		{"WAV", wavFile(8000, 1, make([]int16, 8000), nil), FormatWAV},
+		{"MP4", m4aFile(44100, 1), FormatMP4},
+		{"ADTS", adtsFile(3), FormatAAC},
+	}
+
+	for _, tt := range tests {
+		if got, err := Detect(bytes.NewReader(tt.file), int64(len(tt.file))); err != nil || got != tt.want {
+			t.Errorf("%s: Detect() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for name, file := range map[string][]byte{
+		"empty": {},
+		"text":  []byte("just some text, not audio"),
+		"PNG":   append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...),
+	} {
+		if _, err := Detect(bytes.NewReader(file), int64(len(file))); err != ErrUnsupportedFormat {
+			t.Errorf("%s: Detect() error = %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+}
+
+func TestFormatExtensionAndMimeType(t *testing.T) {
+	tests := []struct {
+		format    Format
+		codec     string
+		extension string
+		mimeType  string
+	}{
+		{FormatMP3, CodecMP3, "mp3", "audio/mpeg"},
+		{FormatFLAC, CodecFLAC, "flac", "audio/flac"},
+		{FormatOgg, CodecVorbis, "ogg", "audio/ogg"},
+		{FormatOgg, CodecOpus, "ogg", "audio/ogg; codecs=opus"},
+		{FormatWAV, CodecPCM, "wav", "audio/wav"},
+		{FormatMP4, CodecALAC, "m4a", "audio/mp4"},
+		{FormatAAC, CodecAAC, "aac", "audio/aac"},
+		{"", "", "bin", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.format.Extension(); got != tt.extension {
+			t.Errorf("Format(%q).Extension() = %q, want %q", tt.format, got, tt.extension)
+		}
+		if got := MimeType(tt.format, tt.codec); got != tt.mimeType {
+			t.Errorf("MimeType(%q, %q) = %q, want %q", tt.format, tt.codec, got, tt.mimeType)
+		}
+	}
+}
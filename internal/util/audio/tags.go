@@ -0,0 +1,84 @@
+package audio
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoTags is returned when a file carries no metadata we can read
+var ErrNoTags = errors.New("audio: no tags found")
+
+// Tags holds the descriptive metadata embedded in an audio file
+type Tags struct {
+	Title      string
+	Artist     string
+	Album      string
+	Genre      string
+	Year       int
+	Track      int
+	TrackTotal int
+	Length     time.Duration // Length declared by the tag, not measured
+}
+
+// merge fills the empty fields of t from other
+func (t *Tags) merge(other *Tags) {
+	if other == nil {
+		return
+	}
+	if t.Title == "" {
+		t.Title = other.Title
+	}
+	if t.Artist == "" {
+		t.Artist = other.Artist
+	}
+	if t.Album == "" {
+		t.Album = other.Album
+	}
+	if t.Genre == "" {
+		t.Genre = other.Genre
+	}
+	if t.Year == 0 {
+		t.Year = other.Year
+	}
+	if t.Track == 0 {
+		t.Track = other.Track
+	}
+	if t.TrackTotal == 0 {
+		t.TrackTotal = other.TrackTotal
+	}
+	if t.Length == 0 {
+		t.Length = other.Length
+	}
+}
+
+func (t *Tags) empty() bool {
+	return *t == Tags{}
+}
+
+// parseYear reads the leading year of values like "2017", "2017-05-01" or "2017-05-01T10:00"
+func parseYear(s string) int {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(s[:4])
+	if err != nil {
+		return 0
+	}
+
+	return year
+}
+
+// parseTrack reads values like "3" or "3/12"
+func parseTrack(s string) (track, total int) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		total, _ = strconv.Atoi(strings.TrimSpace(s[i+1:]))
+		s = s[:i]
+	}
+	track, _ = strconv.Atoi(strings.TrimSpace(s))
+
+	return track, total
+}
@@ -0,0 +1,74 @@
+package audio
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// vorbisComments holds the KEY=value pairs of a Vorbis comment block, the tag format
+// used by FLAC, Ogg Vorbis and Opus. Keys are upper cased, they are case insensitive.
+type vorbisComments map[string][]string
+
+// parseVorbisComments decodes a Vorbis comment block: a little endian length prefixed
+// vendor string followed by a counted list of length prefixed UTF-8 comments
+func parseVorbisComments(b []byte) vorbisComments {
+	comments := vorbisComments{}
+	if len(b) < 4 {
+		return comments
+	}
+	pos := 4 + int(binary.LittleEndian.Uint32(b))
+	if pos+4 > len(b) || pos < 4 {
+		return comments
+	}
+	count := int(binary.LittleEndian.Uint32(b[pos:]))
+	pos += 4
+
+	for i := 0; i < count && pos+4 <= len(b); i++ {
+		length := int(binary.LittleEndian.Uint32(b[pos:]))
+		pos += 4
+		if length < 0 || pos+length > len(b) {
+			break
+		}
+		comment := string(b[pos : pos+length])
+		pos += length
+
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToUpper(key)
+		comments[key] = append(comments[key], value)
+	}
+
+	return comments
+}
+
+func (c vorbisComments) first(keys ...string) string {
+	for _, key := range keys {
+		for _, v := range c[key] {
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		}
+	}
+
+	return ""
+}
+
+// Tags maps the comment names of the Xiph recommendation, plus the common variants
+// written by taggers, onto Tags
+func (c vorbisComments) Tags() *Tags {
+	tags := &Tags{
+		Title:  c.first("TITLE"),
+		Artist: c.first("ARTIST", "ALBUMARTIST", "ALBUM ARTIST"),
+		Album:  c.first("ALBUM"),
+		Genre:  resolveGenre(c["GENRE"]),
+		Year:   parseYear(c.first("DATE", "YEAR", "ORIGINALDATE")),
+	}
+	tags.Track, tags.TrackTotal = parseTrack(c.first("TRACKNUMBER"))
+	if tags.TrackTotal == 0 {
+		tags.TrackTotal, _ = parseTrack(c.first("TRACKTOTAL", "TOTALTRACKS"))
+	}
+
+	return tags
+}
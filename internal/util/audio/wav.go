@@ -0,0 +1,204 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var errInvalidWAV = errors.New("audio: invalid WAV file")
+
+// WAVE format tags
+const (
+	wavePCM        = 0x0001
+	waveFloat      = 0x0003
+	waveExtensible = 0xFFFE
+)
+
+// riffChunk locates a chunk of a RIFF file
+type riffChunk struct {
+	ID     string
+	Offset int64 // start of the chunk body
+	Size   int64
+}
+
+// riffChunks lists the chunks of a RIFF WAVE file
+func riffChunks(r io.ReaderAt, size int64) ([]riffChunk, error) {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, errInvalidWAV
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errInvalidWAV
+	}
+
+	var chunks []riffChunk
+	buf := make([]byte, 8)
+	for pos := int64(12); pos+8 <= size; {
+		if _, err := r.ReadAt(buf, pos); err != nil {
+			break
+		}
+		c := riffChunk{
+			ID:     string(buf[:4]),
+			Offset: pos + 8,
+			Size:   int64(binary.LittleEndian.Uint32(buf[4:])),
+		}
+		if c.Offset+c.Size > size {
+			// * streaming encoders leave the data size at 0 or 0xFFFFFFFF
+			c.Size = size - c.Offset
+		}
+		chunks = append(chunks, c)
+		// * chunks are padded to an even length
+		pos = c.Offset + c.Size + c.Size&1
+	}
+
+	return chunks, nil
+}
+
+func readChunk(r io.ReaderAt, c riffChunk, limit int64) ([]byte, error) {
+	if c.Size > limit {
+		return nil, errInvalidWAV
+	}
+	b := make([]byte, c.Size)
+	if _, err := r.ReadAt(b, c.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// wavFormat is the content of the fmt chunk
+type wavFormat struct {
+	Float      bool
+	Channels   int
+	SampleRate int
+	ByteRate   int64
+	BlockAlign int
+	BitDepth   int
+}
+
+func readWAVFormat(r io.ReaderAt, c riffChunk) (wavFormat, error) {
+	b, err := readChunk(r, c, 1<<10)
+	if err != nil || len(b) < 16 {
+		return wavFormat{}, errInvalidWAV
+	}
+	tag := binary.LittleEndian.Uint16(b[0:2])
+	// * WAVE_FORMAT_EXTENSIBLE carries the actual format in the first two bytes of
+	// its sub format GUID
+	if tag == waveExtensible && len(b) >= 26 {
+		tag = binary.LittleEndian.Uint16(b[24:26])
+	}
+	if tag != wavePCM && tag != waveFloat {
+		return wavFormat{}, ErrUnsupportedFormat
+	}
+
+	return wavFormat{
+		Float:      tag == waveFloat,
+		Channels:   int(binary.LittleEndian.Uint16(b[2:4])),
+		SampleRate: int(binary.LittleEndian.Uint32(b[4:8])),
+		ByteRate:   int64(binary.LittleEndian.Uint32(b[8:12])),
+		BlockAlign: int(binary.LittleEndian.Uint16(b[12:14])),
+		BitDepth:   int(binary.LittleEndian.Uint16(b[14:16])),
+	}, nil
+}
+
+func readWAV(r io.ReaderAt, size int64) (*Info, error) {
+	chunks, err := riffChunks(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Codec: CodecPCM}
+	var (
+		byteRate int64
+		dataSize int64 = -1
+		tags     *Tags
+	)
+	for _, c := range chunks {
+		switch strings.ToLower(c.ID) {
+		case "fmt ":
+			f, err := readWAVFormat(r, c)
+			if err != nil {
+				return nil, err
+			}
+			info.Channels = f.Channels
+			info.SampleRate = f.SampleRate
+			info.BitDepth = f.BitDepth
+			byteRate = f.ByteRate
+		case "data":
+			dataSize = c.Size
+		case "list":
+			if b, err := readChunk(r, c, maxID3v2Size); err == nil {
+				if t := riffInfoTags(b); t != nil {
+					if tags == nil {
+						tags = t
+					} else {
+						tags.merge(t)
+					}
+				}
+			}
+		case "id3 ":
+			// * ID3 tags written by taggers take precedence over the INFO list
+			if b, err := readChunk(r, c, maxID3v2Size); err == nil {
+				if t, frames, err := readID3v2(bytes.NewReader(b)); err == nil {
+					t.merge(tags)
+					tags = t
+					info.Picture = id3Picture(frames)
+				}
+			}
+		}
+	}
+	if info.SampleRate == 0 || byteRate == 0 || dataSize < 0 {
+		return nil, errInvalidWAV
+	}
+
+	info.Duration = time.Duration(dataSize * int64(time.Second) / byteRate)
+	info.Bitrate = int(byteRate * 8 / 1000)
+	info.Tags = tags
+
+	return info, nil
+}
+
+// riffInfoTags decodes a LIST/INFO chunk
+func riffInfoTags(b []byte) *Tags {
+	if len(b) < 4 || string(b[:4]) != "INFO" {
+		return nil
+	}
+
+	values := map[string]string{}
+	for pos := 4; pos+8 <= len(b); {
+		id := string(b[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(b[pos+4:]))
+		pos += 8
+		if length < 0 || pos+length > len(b) {
+			break
+		}
+		value := bytes.TrimRight(b[pos:pos+length], "\x00")
+		// * the spec says ASCII, writers use UTF-8 or their local code page
+		if utf8.Valid(value) {
+			values[id] = strings.TrimSpace(string(value))
+		} else {
+			values[id] = strings.TrimSpace(decodeLatin1(value))
+		}
+		pos += length + length&1
+	}
+
+	tags := &Tags{
+		Title:  values["INAM"],
+		Artist: values["IART"],
+		Album:  values["IPRD"],
+		Genre:  resolveGenre([]string{values["IGNR"]}),
+		Year:   parseYear(values["ICRD"]),
+	}
+	track := values["ITRK"]
+	if track == "" {
+		track = values["IPRT"]
+	}
+	tags.Track, tags.TrackTotal = parseTrack(track)
+
+	return tags
+}
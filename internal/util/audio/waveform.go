@@ -0,0 +1,150 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// ErrInvalidBuckets is returned for a waveform of less than one bucket
+var ErrInvalidBuckets = errors.New("audio: waveform buckets must be positive")
+
+// Waveform holds the min/max peaks of the mono mix of an audio stream, in the layout
+// used by the audiowaveform tool
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Data            []int16 // min and max of every bucket, interleaved
+}
+
+// WaveformBits is the resolution of the peaks, audiowaveform supports 8 and 16
+const WaveformBits = 16
+
+// Length returns the number of buckets
+func (w *Waveform) Length() int {
+	return len(w.Data) / 2
+}
+
+// ComputeWaveform decodes r and reduces its audio to the min and max sample of
+// buckets equally long slices
+func ComputeWaveform(r io.ReadSeeker, buckets int) (*Waveform, error) {
+	if buckets <= 0 {
+		return nil, ErrInvalidBuckets
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	info, err := Probe(NewReaderAt(r), size)
+	if err != nil {
+		return nil, err
+	}
+	if !CanDecode(info.Codec) {
+		return nil, ErrNoDecoder
+	}
+
+	// * the probed duration fixes the bucket width before decoding starts, samples
+	// past the expected end go into the last bucket
+	total := int64(info.Duration.Seconds() * float64(info.SampleRate))
+	spp := int((total + int64(buckets) - 1) / int64(buckets))
+	if spp < 1 {
+		spp = 1
+	}
+
+	mins := make([]float32, buckets)
+	maxs := make([]float32, buckets)
+	used := 0
+	var n int64
+	err = DecodeMono(r, info, func(samples []float32) error {
+		for _, v := range samples {
+			i := int(n / int64(spp))
+			if i >= buckets {
+				i = buckets - 1
+			}
+			if i >= used {
+				used = i + 1
+			}
+			if v < mins[i] {
+				mins[i] = v
+			}
+			if v > maxs[i] {
+				maxs[i] = v
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Waveform{SampleRate: info.SampleRate, SamplesPerPixel: spp, Data: make([]int16, 0, used*2)}
+	for i := 0; i < used; i++ {
+		w.Data = append(w.Data, toInt16(mins[i]), toInt16(maxs[i]))
+	}
+
+	return w, nil
+}
+
+// Resample merges adjacent buckets so that the waveform has at most n of them
+func (w *Waveform) Resample(n int) *Waveform {
+	length := w.Length()
+	if n <= 0 || n >= length {
+		return w
+	}
+
+	out := &Waveform{
+		SampleRate:      w.SampleRate,
+		SamplesPerPixel: int(math.Round(float64(w.SamplesPerPixel) * float64(length) / float64(n))),
+		Data:            make([]int16, 0, n*2),
+	}
+	for i := 0; i < n; i++ {
+		from, to := i*length/n, (i+1)*length/n
+		lo, hi := w.Data[from*2], w.Data[from*2+1]
+		for j := from + 1; j < to; j++ {
+			if w.Data[j*2] < lo {
+				lo = w.Data[j*2]
+			}
+			if w.Data[j*2+1] > hi {
+				hi = w.Data[j*2+1]
+			}
+		}
+		out.Data = append(out.Data, lo, hi)
+	}
+
+	return out
+}
+
+// MarshalDat encodes the waveform in the version 1 binary format of audiowaveform:
+// a little endian header of version, flags, sample rate, samples per pixel and length
+// followed by the min/max pairs
+func (w *Waveform) MarshalDat() []byte {
+	var buf bytes.Buffer
+	header := []interface{}{
+		int32(1),
+		uint32(0), // * flags, bit 0 unset means 16 bit samples
+		int32(w.SampleRate),
+		int32(w.SamplesPerPixel),
+		uint32(w.Length()),
+	}
+	for _, v := range header {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	binary.Write(&buf, binary.LittleEndian, w.Data)
+
+	return buf.Bytes()
+}
+
+func toInt16(v float32) int16 {
+	v *= math.MaxInt16
+	switch {
+	case v > math.MaxInt16:
+		return math.MaxInt16
+	case v < math.MinInt16:
+		return math.MinInt16
+	}
+
+	return int16(math.Round(float64(v)))
+}
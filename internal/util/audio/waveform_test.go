@@ -0,0 +1,106 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sineWAV returns a mono 16 bit WAV file of a 440Hz sine, at amplitude a for the first
+// half and b for the second
+func sineWAV(rate, seconds int, a, b float64) []byte {
+	n := rate * seconds
+	samples := make([]int16, n)
+	for i := range samples {
+		amp := a
+		if i >= n/2 {
+			amp = b
+		}
+		samples[i] = int16(amp * math.MaxInt16 * math.Sin(2*math.Pi*440*float64(i)/float64(rate)))
+	}
+
+	return wavFile(rate, 1, samples, nil)
+}
+
+func TestComputeWaveform(t *testing.T) {
+	file := sineWAV(8000, 4, 0.5, 0.25)
+	w, err := ComputeWaveform(bytes.NewReader(file), 10)
+	if err != nil {
+		t.Fatalf("ComputeWaveform() error = %v", err)
+	}
+	if w.SampleRate != 8000 || w.SamplesPerPixel != 3200 || w.Length() != 10 {
+		t.Fatalf("ComputeWaveform() = %d Hz, %d samples per pixel, %d buckets, want 8000, 3200, 10", w.SampleRate, w.SamplesPerPixel, w.Length())
+	}
+
+	for i := 0; i < w.Length(); i++ {
+		want := 0.5
+		if i >= 5 {
+			want = 0.25
+		}
+		lo, hi := float64(w.Data[2*i])/math.MaxInt16, float64(w.Data[2*i+1])/math.MaxInt16
+		if math.Abs(hi-want) > 0.01 || math.Abs(lo+want) > 0.01 {
+			t.Errorf("bucket %d = [%.3f, %.3f], want [%.3f, %.3f]", i, lo, hi, -want, want)
+		}
+	}
+}
+
+func TestComputeWaveformInvalidBuckets(t *testing.T) {
+	file := sineWAV(8000, 1, 0.5, 0.5)
+	for _, buckets := range []int{0, -1} {
+		if _, err := ComputeWaveform(bytes.NewReader(file), buckets); err != ErrInvalidBuckets {
+			t.Errorf("ComputeWaveform(%d buckets) error = %v, want ErrInvalidBuckets", buckets, err)
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	w := &Waveform{SampleRate: 8000, SamplesPerPixel: 100, Data: []int16{-1, 1, -5, 2, -2, 7, -3, 3, -4, 4, -1, 9}}
+
+	r := w.Resample(2)
+	if want := []int16{-5, 7, -4, 9}; r.SamplesPerPixel != 300 || !equalInt16(r.Data, want) {
+		t.Errorf("Resample(2) = %d samples per pixel %v, want 300 %v", r.SamplesPerPixel, r.Data, want)
+	}
+	r = w.Resample(4)
+	if want := []int16{-1, 1, -5, 7, -3, 3, -4, 9}; r.SamplesPerPixel != 150 || !equalInt16(r.Data, want) {
+		t.Errorf("Resample(4) = %d samples per pixel %v, want 150 %v", r.SamplesPerPixel, r.Data, want)
+	}
+	for _, n := range []int{0, -1, 6, 100} {
+		if r := w.Resample(n); r != w {
+			t.Errorf("Resample(%d) changed the waveform", n)
+		}
+	}
+}
+
+func TestMarshalDat(t *testing.T) {
+	w := &Waveform{SampleRate: 44100, SamplesPerPixel: 512, Data: []int16{-100, 200, -300, 400}}
+	dat := w.MarshalDat()
+
+	header := []uint32{1, 0, 44100, 512, 2}
+	if len(dat) != 20+8 {
+		t.Fatalf("MarshalDat() is %d bytes, want 28", len(dat))
+	}
+	for i, want := range header {
+		if got := binary.LittleEndian.Uint32(dat[4*i:]); got != want {
+			t.Errorf("header field %d = %d, want %d", i, got, want)
+		}
+	}
+	for i, want := range w.Data {
+		if got := int16(binary.LittleEndian.Uint16(dat[20+2*i:])); got != want {
+			t.Errorf("peak %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func equalInt16(a, b []int16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,290 @@
+// Package fields implements sparse fieldsets: the fields= query parameter lists the
+// JSON fields of a resource a client wants, nested ones by dotted path such as
+// tracks.title. The names are checked against the model, turned into a Mongo
+// projection so unused fields are never read, and used to trim the response.
+package fields
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"music-master/internal/util/server"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// idField is always returned, clients need it to refer to what they got
+const idField = "id"
+
+// Parse splits the value of a fields= query parameter, nil when it is empty
+func Parse(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Projection returns the Mongo projection reading the named fields of the documents
+// decoded into model, a struct or a pointer to one. Without names everything but the
+// hidden fields is read. Hidden fields, given as dotted JSON paths, are only read when
+// named themselves: naming the field that contains one reads its other fields.
+// Unknown names are a validation error.
+func Projection(model interface{}, names []string, hidden ...string) (bson.M, error) {
+	t := structType(reflect.TypeOf(model))
+	if len(names) == 0 {
+		projection := bson.M{}
+		for _, h := range hidden {
+			path, _, err := bsonPath(t, h)
+			if err != nil {
+				return nil, err
+			}
+			projection[path] = 0
+		}
+		return projection, nil
+	}
+
+	projection := bson.M{}
+	for _, name := range prune(withID(names)) {
+		path, ft, err := bsonPath(t, name)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			// * computed, not stored
+			continue
+		}
+		include(projection, path, name, ft, hiddenBelow(name, names, hidden))
+	}
+
+	return projection, nil
+}
+
+// Filter returns v marshalled to JSON and back with only the named fields kept of the
+// resource at the keys of path, or of v itself without path. Lists are filtered
+// element by element. Without names v is returned as is.
+func Filter(v interface{}, names []string, path ...string) (interface{}, error) {
+	if len(names) == 0 {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return filter(doc, newTree(withID(names))), nil
+	}
+
+	parent, ok := doc.(map[string]interface{})
+	for _, key := range path[:len(path)-1] {
+		if !ok {
+			break
+		}
+		parent, ok = parent[key].(map[string]interface{})
+	}
+	if ok {
+		key := path[len(path)-1]
+		parent[key] = filter(parent[key], newTree(withID(names)))
+	}
+
+	return doc, nil
+}
+
+// tree holds dotted names by segment, a nil tree keeps everything below it
+type tree map[string]tree
+
+func newTree(names []string) tree {
+	t := tree{}
+	for _, name := range prune(names) {
+		node := t
+		segments := strings.Split(name, ".")
+		for i, seg := range segments {
+			if i == len(segments)-1 {
+				node[seg] = nil
+				break
+			}
+			if node[seg] == nil {
+				node[seg] = tree{}
+			}
+			node = node[seg]
+		}
+	}
+
+	return t
+}
+
+func filter(doc interface{}, t tree) interface{} {
+	if t == nil {
+		return doc
+	}
+	switch v := doc.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = filter(v[i], t)
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			sub, ok := t[k]
+			if !ok {
+				delete(v, k)
+				continue
+			}
+			v[k] = filter(v[k], sub)
+		}
+		return v
+	}
+
+	return doc
+}
+
+func withID(names []string) []string {
+	return append(append([]string(nil), names...), idField)
+}
+
+// prune sorts names and drops duplicates and the names whose parent is named too,
+// Mongo rejects projections naming both
+func prune(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	var result []string
+	for _, name := range sorted {
+		if n := len(result); n > 0 && (name == result[n-1] || strings.HasPrefix(name, result[n-1]+".")) {
+			continue
+		}
+		result = append(result, name)
+	}
+
+	return result
+}
+
+// hiddenBelow returns the hidden fields inside name that are not named themselves
+func hiddenBelow(name string, names, hidden []string) []string {
+	var result []string
+	for _, h := range hidden {
+		if !strings.HasPrefix(h, name+".") {
+			continue
+		}
+		named := false
+		for _, n := range names {
+			if h == n {
+				named = true
+				break
+			}
+		}
+		if !named {
+			result = append(result, h)
+		}
+	}
+
+	return result
+}
+
+// include adds the BSON path of the JSON field name to projection, expanded into the
+// fields of its struct when some of them are hidden
+func include(projection bson.M, path, name string, t reflect.Type, hidden []string) {
+	st := structType(t)
+	if len(hidden) == 0 || st == nil {
+		projection[path] = 1
+		return
+	}
+
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		jsonName, bsonName := tagName(f, "json"), tagName(f, "bson")
+		if jsonName == "" || bsonName == "" {
+			continue
+		}
+		var below []string
+		skip := false
+		for _, h := range hidden {
+			if h == name+"."+jsonName {
+				skip = true
+			} else if strings.HasPrefix(h, name+"."+jsonName+".") {
+				below = append(below, h)
+			}
+		}
+		if !skip {
+			include(projection, path+"."+bsonName, name+"."+jsonName, f.Type, below)
+		}
+	}
+}
+
+// bsonPath translates a dotted JSON path of t into the BSON one, empty for fields
+// that are not stored, and returns the type of the field
+func bsonPath(t reflect.Type, name string) (string, reflect.Type, error) {
+	var path []string
+	ft := t
+	for _, seg := range strings.Split(name, ".") {
+		st := structType(ft)
+		if st == nil {
+			return "", nil, unknownField(name)
+		}
+		found := false
+		for i := 0; i < st.NumField(); i++ {
+			f := st.Field(i)
+			if tagName(f, "json") != seg {
+				continue
+			}
+			found = true
+			ft = f.Type
+			bsonName := tagName(f, "bson")
+			if bsonName == "" {
+				return "", ft, nil
+			}
+			path = append(path, bsonName)
+			break
+		}
+		if !found {
+			return "", nil, unknownField(name)
+		}
+	}
+
+	return strings.Join(path, "."), ft, nil
+}
+
+func unknownField(name string) error {
+	return server.NewHTTPValidationError(fmt.Sprintf("Unknown field %s", name))
+}
+
+// tagName returns the name a struct tag gives a field, empty when it is skipped
+func tagName(f reflect.StructField, key string) string {
+	name := strings.Split(f.Tag.Get(key), ",")[0]
+	if name == "-" || f.PkgPath != "" {
+		return ""
+	}
+	if name == "" && key == "bson" {
+		return strings.ToLower(f.Name)
+	}
+	if name == "" {
+		return f.Name
+	}
+
+	return name
+}
+
+// structType returns the struct t is, points at or holds a list of, nil for other types
+func structType(t reflect.Type) reflect.Type {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		if t.Kind() != reflect.Ptr && t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return t
+}
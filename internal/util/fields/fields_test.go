@@ -0,0 +1,149 @@
+package fields
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type track struct {
+	ID    string  `json:"id" bson:"_id"`
+	Title string  `json:"title" bson:"title"`
+	File  []byte  `json:"mp3_file,omitempty" bson:"mp3_file"`
+	Score float64 `json:"score" bson:"-"`
+}
+
+type entry struct {
+	Track   *track    `json:"track" bson:"track"`
+	AddedAt time.Time `json:"added_at" bson:"added_at"`
+}
+
+type playlist struct {
+	ID     string  `json:"id" bson:"_id"`
+	Name   string  `json:"name" bson:"name"`
+	Tracks []entry `json:"tracks" bson:"tracks"`
+	Count  int     `json:"count" bson:"-"`
+	secret string
+}
+
+func TestParse(t *testing.T) {
+	if got := Parse(""); got != nil {
+		t.Errorf("Parse(\"\") = %q, want nil", got)
+	}
+	if got, want := Parse(" name, ,tracks.title ,,"), []string{"name", "tracks.title"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %q, want %q", got, want)
+	}
+}
+
+func TestProjection(t *testing.T) {
+	const hidden = "tracks.track.mp3_file"
+
+	tests := []struct {
+		name  string
+		names []string
+		want  bson.M
+	}{
+		{"everything", nil, bson.M{"tracks.track.mp3_file": 0}},
+		{"one field and the id", []string{"name"}, bson.M{"_id": 1, "name": 1}},
+		{"nested field", []string{"tracks.track.title"}, bson.M{"_id": 1, "tracks.track.title": 1}},
+		{
+			"container of a hidden field",
+			[]string{"name", "tracks"},
+			bson.M{"_id": 1, "name": 1, "tracks.track._id": 1, "tracks.track.title": 1, "tracks.added_at": 1},
+		},
+		{
+			"child of a named field",
+			[]string{"tracks.track.title", "tracks", "tracks"},
+			bson.M{"_id": 1, "tracks.track._id": 1, "tracks.track.title": 1, "tracks.added_at": 1},
+		},
+		{"hidden field named", []string{"tracks.track.mp3_file"}, bson.M{"_id": 1, "tracks.track.mp3_file": 1}},
+		{"hidden field and its container", []string{"tracks", hidden}, bson.M{"_id": 1, "tracks": 1}},
+		{"computed field", []string{"count", "tracks.track.score"}, bson.M{"_id": 1}},
+	}
+	for _, tt := range tests {
+		got, err := Projection(&playlist{}, tt.names, hidden)
+		if err != nil {
+			t.Errorf("%s: Projection() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Projection(%q) = %v, want %v", tt.name, tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestProjectionUnknownField(t *testing.T) {
+	for _, names := range [][]string{{"nope"}, {"name.first"}, {"tracks.track.album"}, {"secret"}, {"Name"}} {
+		if p, err := Projection(playlist{}, names); err == nil {
+			t.Errorf("Projection(%q) = %v, want an error", names, p)
+		}
+	}
+	if p, err := Projection(playlist{}, nil, "tracks.nope"); err == nil {
+		t.Errorf("Projection() with an unknown hidden field = %v, want an error", p)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	p := playlist{
+		ID:   "p1",
+		Name: "Road trip",
+		Tracks: []entry{
+			{Track: &track{ID: "t1", Title: "One", Score: 1}},
+			{Track: &track{ID: "t2", Title: "Two", Score: 2}},
+		},
+		Count: 2,
+	}
+
+	got, err := Filter(p, nil)
+	if err != nil || !reflect.DeepEqual(got, p) {
+		t.Errorf("Filter() without names = %v, %v, want the value as is", got, err)
+	}
+
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"name"}, `{"id":"p1","name":"Road trip"}`},
+		{[]string{"tracks.track.title", "count"}, `{"count":2,"id":"p1","tracks":[{"track":{"title":"One"}},{"track":{"title":"Two"}}]}`},
+		{[]string{"tracks.track", "tracks.track.title"}, `{"id":"p1","tracks":[{"track":{"id":"t1","score":1,"title":"One"}},{"track":{"id":"t2","score":2,"title":"Two"}}]}`},
+	}
+	for _, tt := range tests {
+		got, err := Filter(p, tt.names)
+		if err != nil {
+			t.Errorf("Filter(%q) error = %v", tt.names, err)
+			continue
+		}
+		if b, _ := json.Marshal(got); string(b) != tt.want {
+			t.Errorf("Filter(%q) = %s, want %s", tt.names, b, tt.want)
+		}
+	}
+}
+
+func TestFilterPath(t *testing.T) {
+	type listResp struct {
+		Data []playlist `json:"data"`
+		Page int        `json:"page"`
+	}
+	resp := listResp{Data: []playlist{{ID: "p1", Name: "A", Count: 1}, {ID: "p2", Name: "B"}}, Page: 3}
+
+	got, err := Filter(resp, []string{"name"}, "data")
+	if err != nil {
+		t.Fatalf("Filter() error = %v", err)
+	}
+	want := `{"data":[{"id":"p1","name":"A"},{"id":"p2","name":"B"}],"page":3}`
+	if b, _ := json.Marshal(got); string(b) != want {
+		t.Errorf("Filter() = %s, want %s", b, want)
+	}
+
+	// * a path that is not there leaves the document alone
+	got, err = Filter(resp, []string{"name"}, "meta", "data")
+	if err != nil {
+		t.Fatalf("Filter() error = %v", err)
+	}
+	if b, _ := json.Marshal(got); string(b) != `{"data":[{"count":1,"id":"p1","name":"A","tracks":null},{"count":0,"id":"p2","name":"B","tracks":null}],"page":3}` {
+		t.Errorf("Filter() with a missing path = %s, want the whole document", b)
+	}
+}
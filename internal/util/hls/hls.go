@@ -0,0 +1,126 @@
+// Package hls packages MPEG audio for HTTP Live Streaming. Segments are byte ranges
+// of whole frames of the original file, served as packed audio behind an ID3 tag
+// carrying their timestamp, so no audio is re-encoded or stored twice.
+package hls
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"music-master/internal/util/audio"
+	"time"
+)
+
+// Content types of HLS media playlists and of MPEG audio segments
+const (
+	PlaylistContentType = "application/vnd.apple.mpegurl"
+	SegmentContentType  = "audio/mpeg"
+)
+
+// DefaultSegmentDuration is the segment length aimed for, a segment ends at the first
+// frame boundary after it
+const DefaultSegmentDuration = 6 * time.Second
+
+// timestampOwner identifies the PRIV frame that carries the timestamp of packed audio
+const timestampOwner = "com.apple.streaming.transportStreamTimestamp"
+
+// Segment is a run of whole frames of an MPEG audio file
+type Segment struct {
+	Offset   int64
+	Length   int64
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// Split groups frames into segments of at least target duration, only the last
+// segment may be shorter
+func Split(frames []audio.MPEGFrame, target time.Duration) []Segment {
+	var (
+		segments []Segment
+		curr     Segment
+		start    time.Duration
+	)
+	for _, f := range frames {
+		if curr.Length == 0 {
+			curr = Segment{Offset: f.Offset, Start: start}
+		}
+		if f.Offset != curr.Offset+curr.Length {
+			// * garbage skipped between frames must not end up in a segment
+			if curr.Length > 0 {
+				segments = append(segments, curr)
+			}
+			curr = Segment{Offset: f.Offset, Start: start}
+		}
+		curr.Length += int64(f.Length)
+		curr.Duration += f.Duration
+		start += f.Duration
+		if curr.Duration >= target {
+			segments = append(segments, curr)
+			curr = Segment{}
+		}
+	}
+	if curr.Length > 0 {
+		segments = append(segments, curr)
+	}
+
+	return segments
+}
+
+// TargetDuration returns the EXT-X-TARGETDURATION of segments: the longest segment
+// rounded to whole seconds
+func TargetDuration(segments []Segment) int {
+	var longest time.Duration
+	for _, s := range segments {
+		if s.Duration > longest {
+			longest = s.Duration
+		}
+	}
+
+	return int(math.Round(longest.Seconds()))
+}
+
+// WritePlaylist writes the VOD media playlist of segments to w, uri names the
+// segment at each index
+func WritePlaylist(w io.Writer, segments []Segment, uri func(i int) string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	fmt.Fprintln(bw, "#EXT-X-VERSION:3")
+	fmt.Fprintf(bw, "#EXT-X-TARGETDURATION:%d\n", TargetDuration(segments))
+	fmt.Fprintln(bw, "#EXT-X-MEDIA-SEQUENCE:0")
+	fmt.Fprintln(bw, "#EXT-X-PLAYLIST-TYPE:VOD")
+	for i, s := range segments {
+		fmt.Fprintf(bw, "#EXTINF:%.3f,\n", s.Duration.Seconds())
+		fmt.Fprintln(bw, uri(i))
+	}
+	fmt.Fprintln(bw, "#EXT-X-ENDLIST")
+
+	return bw.Flush()
+}
+
+// TimestampTag returns the ID3 tag that must open a packed audio segment, telling the
+// player the presentation time of its first sample
+func TimestampTag(start time.Duration) []byte {
+	// * 33 bit MPEG-2 timestamp in 90 kHz units
+	pts := (uint64(start) * 90000 / uint64(time.Second)) & (1<<33 - 1)
+
+	frame := make([]byte, 0, len(timestampOwner)+1+8)
+	frame = append(frame, timestampOwner...)
+	frame = append(frame, 0)
+	frame = binary.BigEndian.AppendUint64(frame, pts)
+
+	tag := make([]byte, 0, 20+len(frame))
+	tag = append(tag, 'I', 'D', '3', 4, 0, 0)
+	tag = appendSyncsafe(tag, 10+len(frame))
+	tag = append(tag, 'P', 'R', 'I', 'V')
+	tag = appendSyncsafe(tag, len(frame))
+	tag = append(tag, 0, 0)
+
+	return append(tag, frame...)
+}
+
+// appendSyncsafe appends n as an ID3v2.4 syncsafe integer
+func appendSyncsafe(b []byte, n int) []byte {
+	return append(b, byte(n>>21)&0x7F, byte(n>>14)&0x7F, byte(n>>7)&0x7F, byte(n)&0x7F)
+}
@@ -0,0 +1,152 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"music-master/internal/util/audio"
+	"testing"
+	"time"
+)
+
+// frameDuration is the length of an MPEG-1 Layer III frame at 44.1 kHz
+const frameDuration = 1152 * time.Second / 44100
+
+// cbrFrames returns n frames of length bytes each, the first at offset
+func cbrFrames(n int, offset int64, length int) []audio.MPEGFrame {
+	frames := make([]audio.MPEGFrame, n)
+	for i := range frames {
+		frames[i] = audio.MPEGFrame{Offset: offset, Length: length, Duration: frameDuration}
+		offset += int64(length)
+	}
+
+	return frames
+}
+
+func TestSplit(t *testing.T) {
+	segments := Split(cbrFrames(500, 100, 417), DefaultSegmentDuration)
+
+	// * 6s is 229.7 frames, segments end at the first boundary after it
+	want := []Segment{
+		{Offset: 100, Length: 230 * 417, Start: 0, Duration: 230 * frameDuration},
+		{Offset: 100 + 230*417, Length: 230 * 417, Start: 230 * frameDuration, Duration: 230 * frameDuration},
+		{Offset: 100 + 460*417, Length: 40 * 417, Start: 460 * frameDuration, Duration: 40 * frameDuration},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("Split() = %d segments, want %d", len(segments), len(want))
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+}
+
+func TestSplitGap(t *testing.T) {
+	frames := cbrFrames(3, 0, 100)
+	// * 50 bytes of garbage after the third frame
+	frames = append(frames, cbrFrames(2, 350, 100)...)
+
+	segments := Split(frames, time.Minute)
+	want := []Segment{
+		{Offset: 0, Length: 300, Start: 0, Duration: 3 * frameDuration},
+		{Offset: 350, Length: 200, Start: 3 * frameDuration, Duration: 2 * frameDuration},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("Split() = %+v, want %+v", segments, want)
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+
+	if segments := Split(nil, DefaultSegmentDuration); len(segments) != 0 {
+		t.Errorf("Split(nil) = %+v, want no segments", segments)
+	}
+}
+
+func TestTargetDuration(t *testing.T) {
+	tests := []struct {
+		durations []time.Duration
+		want      int
+	}{
+		{nil, 0},
+		{[]time.Duration{6008 * time.Millisecond, 1045 * time.Millisecond}, 6},
+		{[]time.Duration{2 * time.Second, 6500 * time.Millisecond}, 7},
+	}
+	for _, tt := range tests {
+		var segments []Segment
+		for _, d := range tt.durations {
+			segments = append(segments, Segment{Duration: d})
+		}
+		if got := TargetDuration(segments); got != tt.want {
+			t.Errorf("TargetDuration(%v) = %d, want %d", tt.durations, got, tt.want)
+		}
+	}
+}
+
+func TestWritePlaylist(t *testing.T) {
+	segments := []Segment{
+		{Duration: 6008163265 * time.Nanosecond},
+		{Duration: 1044897959 * time.Nanosecond},
+	}
+	var buf bytes.Buffer
+	if err := WritePlaylist(&buf, segments, func(i int) string { return fmt.Sprintf("segments/%d.mp3", i) }); err != nil {
+		t.Fatalf("WritePlaylist() error = %v", err)
+	}
+
+	want := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:6\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:6.008,\n" +
+		"segments/0.mp3\n" +
+		"#EXTINF:1.045,\n" +
+		"segments/1.mp3\n" +
+		"#EXT-X-ENDLIST\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WritePlaylist() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTimestampTag(t *testing.T) {
+	tests := []struct {
+		start time.Duration
+		pts   uint64
+	}{
+		{0, 0},
+		{10 * time.Second, 900000},
+		{6008163265 * time.Nanosecond, 540734},
+		// * the timestamp wraps at 33 bits, about 26.5 hours
+		{95454 * time.Second, 95454*90000 - 1<<33},
+	}
+	for _, tt := range tests {
+		tag := TimestampTag(tt.start)
+
+		frameLen := len(timestampOwner) + 1 + 8
+		if len(tag) != 20+frameLen {
+			t.Fatalf("TimestampTag(%v) is %d bytes, want %d", tt.start, len(tag), 20+frameLen)
+		}
+		if !bytes.Equal(tag[:6], []byte{'I', 'D', '3', 4, 0, 0}) {
+			t.Errorf("TimestampTag(%v) header = % x, want an ID3v2.4 header", tt.start, tag[:6])
+		}
+		if got := syncsafe(tag[6:10]); got != 10+frameLen {
+			t.Errorf("TimestampTag(%v) tag size = %d, want %d", tt.start, got, 10+frameLen)
+		}
+		if string(tag[10:14]) != "PRIV" || syncsafe(tag[14:18]) != frameLen {
+			t.Errorf("TimestampTag(%v) frame header = % x, want a PRIV frame of %d bytes", tt.start, tag[10:20], frameLen)
+		}
+		if owner := string(tag[20 : 20+len(timestampOwner)+1]); owner != timestampOwner+"\x00" {
+			t.Errorf("TimestampTag(%v) owner = %q, want %q", tt.start, owner, timestampOwner)
+		}
+		if got := binary.BigEndian.Uint64(tag[len(tag)-8:]); got != tt.pts {
+			t.Errorf("TimestampTag(%v) timestamp = %d, want %d", tt.start, got, tt.pts)
+		}
+	}
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
@@ -27,4 +27,7 @@ type ListRequest struct {
 	// JSON string of filter. E.g: {"field_name":"value"}
 	// default:
 	Filter string `json:"f,omitempty" query:"f"`
+	// Comma separated JSON fields to return, dotted for nested ones. E.g: id,title,artist
+	// default:
+	Fields string `json:"fields,omitempty" query:"fields"`
 }
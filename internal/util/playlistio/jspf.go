@@ -0,0 +1,75 @@
+package playlistio
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title  string      `json:"title,omitempty"`
+	Tracks []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Locations   stringList `json:"location,omitempty"`
+	Identifiers stringList `json:"identifier,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Creator     string     `json:"creator,omitempty"`
+	Album       string     `json:"album,omitempty"`
+	Duration    *int64     `json:"duration,omitempty"`
+}
+
+// WriteJSPF writes p as JSPF, the JSON form of XSPF
+func WriteJSPF(w io.Writer, p *Playlist) error {
+	doc := jspfDocument{Playlist: jspfPlaylist{Title: p.Name, Tracks: make([]jspfTrack, len(p.Entries))}}
+	for i, e := range p.Entries {
+		doc.Playlist.Tracks[i] = jspfTrack{
+			Locations:   nonEmpty(e.Location),
+			Identifiers: nonEmpty(e.Identifier),
+			Title:       e.Title,
+			Creator:     e.Artist,
+			Album:       e.Album,
+			Duration:    knownDuration(e.Duration),
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+// parseJSPF reads JSPF, the first location and identifier of a track are kept
+func parseJSPF(data []byte) (*Playlist, error) {
+	doc := jspfDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	p := &Playlist{Name: strings.TrimSpace(doc.Playlist.Title), Entries: make([]*Entry, len(doc.Playlist.Tracks))}
+	for i, t := range doc.Playlist.Tracks {
+		p.Entries[i] = newEntry(t.Locations, t.Identifiers, t.Title, t.Creator, t.Album, t.Duration)
+	}
+
+	return p, nil
+}
+
+// stringList is a JSPF list of URIs, which early writers of the format give as a
+// single string
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(l))
+}
@@ -0,0 +1,92 @@
+package playlistio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteM3U writes p as extended M3U, encoded in UTF-8
+func WriteM3U(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	if p.Name != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(p.Name))
+	}
+	for _, e := range p.Entries {
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", seconds(e.Duration), oneLine(e.Label()))
+		if e.Album != "" {
+			fmt.Fprintf(bw, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		fmt.Fprintln(bw, oneLine(e.Location))
+	}
+
+	return bw.Flush()
+}
+
+// parseM3U reads plain and extended M3U: a location per line, described by the
+// #EXTINF line before it
+func parseM3U(data []byte) (*Playlist, error) {
+	p := &Playlist{}
+	var info *Entry
+	for i, line := range lines(data) {
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXTINF:"):
+			prev := info
+			info = parseExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+			if prev != nil {
+				info.Album = prev.Album
+			}
+		case strings.HasPrefix(line, "#EXTART:") && info != nil:
+			info.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			if info == nil {
+				info = &Entry{Duration: UnknownDuration}
+			}
+			info.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// * other directives and comments
+		default:
+			e := info
+			if e == nil {
+				e = &Entry{Duration: UnknownDuration}
+			}
+			e.Line, e.Location = i+1, line
+			p.Entries = append(p.Entries, e)
+			info = nil
+		}
+	}
+
+	return p, nil
+}
+
+// parseExtInf reads the "duration attributes,artist - title" of an #EXTINF line
+func parseExtInf(v string) *Entry {
+	e := &Entry{Duration: UnknownDuration}
+	// * attribute values may hold commas, the label follows the first one outside quotes
+	head, label := v, ""
+	quoted := false
+	for i, r := range v {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ',' && !quoted {
+			head, label = v[:i], v[i+1:]
+			break
+		}
+	}
+	// * IPTV lists put attributes after the duration
+	if fields := strings.Fields(head); len(fields) > 0 {
+		if d, err := strconv.ParseFloat(fields[0], 64); err == nil && d >= 0 {
+			e.Duration = int64(d*1000 + 0.5)
+		}
+	}
+	e.Artist, e.Title = SplitLabel(label)
+
+	return e
+}
@@ -0,0 +1,173 @@
+// Package playlistio reads and writes playlist files other players exchange: extended
+// M3U, of which M3U8 is the UTF-8 flavour, PLS, XSPF and its JSON form JSPF. Entries
+// carry what those formats know of a track, its location and, when given, its
+// identifier, artist, title, album and duration.
+package playlistio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formats playlists are written in
+const (
+	FormatM3U8 = "m3u8"
+	FormatPLS  = "pls"
+	FormatXSPF = "xspf"
+	FormatJSPF = "jspf"
+)
+
+// Content types of the formats
+const (
+	M3U8ContentType = "audio/x-mpegurl; charset=utf-8"
+	PLSContentType  = "audio/x-scpls; charset=utf-8"
+	XSPFContentType = "application/xspf+xml"
+	JSPFContentType = "application/jspf+json"
+)
+
+// ContentTypes maps every format to its content type
+var ContentTypes = map[string]string{
+	FormatM3U8: M3U8ContentType,
+	FormatPLS:  PLSContentType,
+	FormatXSPF: XSPFContentType,
+	FormatJSPF: JSPFContentType,
+}
+
+// UnknownDuration is the duration of entries that come without one
+const UnknownDuration = -1
+
+// ErrNoEntries is returned for files without a single entry
+var ErrNoEntries = errors.New("playlistio: no entries")
+
+// Playlist is the content of a playlist file
+type Playlist struct {
+	Name    string
+	Entries []*Entry
+}
+
+// Entry is a track of a playlist file
+type Entry struct {
+	Position   int // in the playlist, from 1
+	Line       int // line of the location in M3U and PLS files, from 1
+	Location   string
+	Identifier string
+	Artist     string
+	Title      string
+	Album      string
+	Duration   int64 // in milliseconds, UnknownDuration when not given
+}
+
+// Label returns the "artist - title" extended M3U and PLS describe an entry with
+func (e *Entry) Label() string {
+	if e.Artist == "" {
+		return e.Title
+	}
+
+	return e.Artist + " - " + e.Title
+}
+
+// Parse reads a playlist in any of the formats, told apart by how the file opens.
+// M3U and PLS files that are not valid UTF-8 are read as Latin-1, the encoding of
+// plain M3U files.
+func Parse(r io.Reader) (*Playlist, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(data)
+
+	var p *Playlist
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		p, err = parseXSPF(data)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		p, err = parseJSPF(data)
+	default:
+		if !utf8.Valid(data) {
+			data = latin1(data)
+		}
+		if isPLS(data) {
+			p, err = parsePLS(data)
+		} else {
+			p, err = parseM3U(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Entries) == 0 {
+		return nil, ErrNoEntries
+	}
+	for i, e := range p.Entries {
+		e.Position = i + 1
+	}
+
+	return p, nil
+}
+
+// Write writes p in format
+func Write(w io.Writer, p *Playlist, format string) error {
+	switch format {
+	case FormatM3U8:
+		return WriteM3U(w, p)
+	case FormatPLS:
+		return WritePLS(w, p)
+	case FormatXSPF:
+		return WriteXSPF(w, p)
+	case FormatJSPF:
+		return WriteJSPF(w, p)
+	}
+
+	return fmt.Errorf("playlistio: unknown format %s", format)
+}
+
+// SplitLabel splits "artist - title", labels without the separator are titles
+func SplitLabel(label string) (string, string) {
+	label = strings.TrimSpace(label)
+	if artist, title, ok := strings.Cut(label, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+
+	return "", label
+}
+
+// seconds rounds a duration in milliseconds to whole seconds
+func seconds(ms int64) int64 {
+	if ms < 0 {
+		return UnknownDuration
+	}
+
+	return (ms + 500) / 1000
+}
+
+// lines splits data into trimmed lines, whatever their line endings
+func lines(data []byte) []string {
+	s := strings.ReplaceAll(string(data), "\r\n", "\n")
+	result := strings.Split(strings.ReplaceAll(s, "\r", "\n"), "\n")
+	for i := range result {
+		result[i] = strings.TrimSpace(result[i])
+	}
+
+	return result
+}
+
+// lineBreaks are replaced in values, they would end the line they are written on
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+func oneLine(s string) string {
+	return lineBreaks.Replace(s)
+}
+
+func latin1(data []byte) []byte {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+
+	return []byte(string(runes))
+}
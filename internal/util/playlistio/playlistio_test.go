@@ -0,0 +1,206 @@
+package playlistio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// samplePlaylist has an entry with everything formats can carry and one with little
+func samplePlaylist() *Playlist {
+	return &Playlist{Name: "Road <trip> & co", Entries: []*Entry{
+		{
+			Location:   "http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream",
+			Identifier: "http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38",
+			Artist:     "Sơn Tùng M-TP",
+			Title:      "Lạc Trôi",
+			Album:      "m-tp M-TP",
+			Duration:   215123,
+		},
+		{Location: "music/b side.mp3", Title: "B", Duration: UnknownDuration},
+	}}
+}
+
+// roundTrip writes p in format and parses it back
+func roundTrip(t *testing.T, p *Playlist, format string) (*Playlist, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, p, format); err != nil {
+		t.Fatalf("Write(%s) error = %v", format, err)
+	}
+	written := buf.String()
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse(%s) error = %v\n%s", format, err, written)
+	}
+
+	return got, written
+}
+
+// checkEntries compares entries, leaving out their lines
+func checkEntries(t *testing.T, got []*Entry, want []Entry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		e := *got[i]
+		e.Line = 0
+		if e != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestM3URoundTrip(t *testing.T) {
+	got, written := roundTrip(t, samplePlaylist(), FormatM3U8)
+
+	want := "#EXTM3U\n" +
+		"#PLAYLIST:Road <trip> & co\n" +
+		"#EXTINF:215,Sơn Tùng M-TP - Lạc Trôi\n" +
+		"#EXTALB:m-tp M-TP\n" +
+		"http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream\n" +
+		"#EXTINF:-1,B\n" +
+		"music/b side.mp3\n"
+	if written != want {
+		t.Errorf("WriteM3U() =\n%s\nwant\n%s", written, want)
+	}
+
+	// * M3U has no identifiers and whole second durations
+	if got.Name != "Road <trip> & co" {
+		t.Errorf("Name = %q, want the playlist name", got.Name)
+	}
+	checkEntries(t, got.Entries, []Entry{
+		{Position: 1, Location: "http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream", Artist: "Sơn Tùng M-TP", Title: "Lạc Trôi", Album: "m-tp M-TP", Duration: 215000},
+		{Position: 2, Location: "music/b side.mp3", Title: "B", Duration: UnknownDuration},
+	})
+	if got.Entries[0].Line != 5 || got.Entries[1].Line != 7 {
+		t.Errorf("lines = %d, %d, want 5, 7", got.Entries[0].Line, got.Entries[1].Line)
+	}
+}
+
+func TestParseM3U(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Entry
+	}{
+		{
+			"plain, CRLF",
+			"a.mp3\r\n\r\n# comment\r\nb.mp3\r\n",
+			[]Entry{{Position: 1, Location: "a.mp3", Duration: UnknownDuration}, {Position: 2, Location: "b.mp3", Duration: UnknownDuration}},
+		},
+		{
+			"fractional duration and IPTV attributes",
+			"#EXTM3U\n#EXTINF:12.4 tvg-id=\"x\" group-title=\"a, b\",Artist - Title - Live\nhttp://stream\n",
+			[]Entry{{Position: 1, Location: "http://stream", Artist: "Artist", Title: "Title - Live", Duration: 12400}},
+		},
+		{
+			"album before #EXTINF and #EXTART",
+			"#EXTM3U\n#EXTALB:Album\n#EXTINF:100,Title\n#EXTART:Artist\nc.flac\nd.flac\n",
+			[]Entry{{Position: 1, Location: "c.flac", Artist: "Artist", Title: "Title", Album: "Album", Duration: 100000}, {Position: 2, Location: "d.flac", Duration: UnknownDuration}},
+		},
+		{
+			"Latin-1",
+			"#EXTM3U\n#EXTINF:60,Beyonc\xe9 - Caf\xe9\nb.mp3\n",
+			[]Entry{{Position: 1, Location: "b.mp3", Artist: "Beyoncé", Title: "Café", Duration: 60000}},
+		},
+		{
+			"byte order mark",
+			"\xef\xbb\xbf#EXTM3U\n#EXTINF:1,Ünïcode\nu.mp3\n",
+			[]Entry{{Position: 1, Location: "u.mp3", Title: "Ünïcode", Duration: 1000}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			checkEntries(t, p.Entries, tt.want)
+		})
+	}
+}
+
+func TestPLSRoundTrip(t *testing.T) {
+	got, written := roundTrip(t, samplePlaylist(), FormatPLS)
+
+	want := "[playlist]\n" +
+		"File1=http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream\n" +
+		"Title1=Sơn Tùng M-TP - Lạc Trôi\n" +
+		"Length1=215\n" +
+		"File2=music/b side.mp3\n" +
+		"Title2=B\n" +
+		"Length2=-1\n" +
+		"NumberOfEntries=2\n" +
+		"Version=2\n"
+	if written != want {
+		t.Errorf("WritePLS() =\n%s\nwant\n%s", written, want)
+	}
+
+	// * PLS has no playlist name, identifiers or albums
+	if got.Name != "" {
+		t.Errorf("Name = %q, want none", got.Name)
+	}
+	checkEntries(t, got.Entries, []Entry{
+		{Position: 1, Location: "http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream", Artist: "Sơn Tùng M-TP", Title: "Lạc Trôi", Duration: 215000},
+		{Position: 2, Location: "music/b side.mp3", Title: "B", Duration: UnknownDuration},
+	})
+	if got.Entries[0].Line != 2 || got.Entries[1].Line != 5 {
+		t.Errorf("lines = %d, %d, want 2, 5", got.Entries[0].Line, got.Entries[1].Line)
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	data := "\n[Playlist]\nnumberofentries=3\nfile10=ten.mp3\nTitle2=Two\nFile2=two.mp3\nLength2=abc\nTitle3=No file\nFILE1 = one.mp3 \nLength1=30\nVersion=2\n"
+	p, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	// * entries are ordered by number, titles without a file are dropped
+	checkEntries(t, p.Entries, []Entry{
+		{Position: 1, Location: "one.mp3", Duration: 30000},
+		{Position: 2, Location: "two.mp3", Title: "Two", Duration: UnknownDuration},
+		{Position: 3, Location: "ten.mp3", Duration: UnknownDuration},
+	})
+}
+
+func TestParseNoEntries(t *testing.T) {
+	for _, data := range []string{"", "#EXTM3U\n#EXTINF:10,Nothing\n", "[playlist]\nNumberOfEntries=0\n"} {
+		if _, err := Parse(strings.NewReader(data)); err != ErrNoEntries {
+			t.Errorf("Parse(%q) error = %v, want ErrNoEntries", data, err)
+		}
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, samplePlaylist(), "wpl"); err == nil {
+		t.Error("Write() in an unknown format succeeded")
+	}
+}
+
+func TestLineBreaks(t *testing.T) {
+	p := &Playlist{Name: "two\nlines", Entries: []*Entry{{Location: "a.mp3", Title: "x\r\n#EXTINF:1,injected", Duration: 1000}}}
+	for _, format := range []string{FormatM3U8, FormatPLS} {
+		got, _ := roundTrip(t, p, format)
+		if len(got.Entries) != 1 || got.Entries[0].Title != "x #EXTINF:1,injected" {
+			t.Errorf("%s: entries = %+v, want the title on one line", format, got.Entries)
+		}
+	}
+}
+
+func TestSplitLabel(t *testing.T) {
+	tests := []struct {
+		label, artist, title string
+	}{
+		{"Artist - Title", "Artist", "Title"},
+		{"  Title only ", "", "Title only"},
+		{"A - B - C", "A", "B - C"},
+		{"Jay-Z - 99 Problems", "Jay-Z", "99 Problems"},
+	}
+	for _, tt := range tests {
+		if artist, title := SplitLabel(tt.label); artist != tt.artist || title != tt.title {
+			t.Errorf("SplitLabel(%q) = %q, %q, want %q, %q", tt.label, artist, title, tt.artist, tt.title)
+		}
+	}
+}
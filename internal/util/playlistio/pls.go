@@ -0,0 +1,88 @@
+package playlistio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePLS writes p as PLS version 2
+func WritePLS(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "[playlist]")
+	for i, e := range p.Entries {
+		fmt.Fprintf(bw, "File%d=%s\n", i+1, oneLine(e.Location))
+		if label := e.Label(); label != "" {
+			fmt.Fprintf(bw, "Title%d=%s\n", i+1, oneLine(label))
+		}
+		fmt.Fprintf(bw, "Length%d=%d\n", i+1, seconds(e.Duration))
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\n", len(p.Entries))
+	fmt.Fprintln(bw, "Version=2")
+
+	return bw.Flush()
+}
+
+// parsePLS reads the FileN, TitleN and LengthN keys of a [playlist] section, entries
+// are ordered by N
+func parsePLS(data []byte) (*Playlist, error) {
+	entries := map[int]*Entry{}
+	entry := func(n int) *Entry {
+		if entries[n] == nil {
+			entries[n] = &Entry{Duration: UnknownDuration}
+		}
+		return entries[n]
+	}
+
+	for i, line := range lines(data) {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		name := strings.TrimRight(key, "0123456789")
+		n, err := strconv.Atoi(key[len(name):])
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "file":
+			e := entry(n)
+			e.Line, e.Location = i+1, value
+		case "title":
+			e := entry(n)
+			e.Artist, e.Title = SplitLabel(value)
+		case "length":
+			if d, err := strconv.ParseInt(value, 10, 64); err == nil && d >= 0 {
+				entry(n).Duration = d * 1000
+			}
+		}
+	}
+
+	keys := make([]int, 0, len(entries))
+	for n, e := range entries {
+		if e.Location != "" {
+			keys = append(keys, n)
+		}
+	}
+	sort.Ints(keys)
+	p := &Playlist{Entries: make([]*Entry, len(keys))}
+	for i, n := range keys {
+		p.Entries[i] = entries[n]
+	}
+
+	return p, nil
+}
+
+func isPLS(data []byte) bool {
+	for _, line := range lines(data) {
+		if line != "" {
+			return strings.EqualFold(line, "[playlist]")
+		}
+	}
+
+	return false
+}
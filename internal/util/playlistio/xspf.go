@@ -0,0 +1,112 @@
+package playlistio
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// xspfNamespace is the namespace of XSPF version 1
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Locations   []string `xml:"location"`
+	Identifiers []string `xml:"identifier"`
+	Title       string   `xml:"title,omitempty"`
+	Creator     string   `xml:"creator,omitempty"`
+	Album       string   `xml:"album,omitempty"`
+	Duration    *int64   `xml:"duration"`
+}
+
+// WriteXSPF writes p as XSPF version 1
+func WriteXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{Xmlns: xspfNamespace, Version: "1", Title: p.Name, Tracks: make([]xspfTrack, len(p.Entries))}
+	for i, e := range p.Entries {
+		doc.Tracks[i] = xspfTrack{
+			Locations:   nonEmpty(e.Location),
+			Identifiers: nonEmpty(e.Identifier),
+			Title:       e.Title,
+			Creator:     e.Artist,
+			Album:       e.Album,
+			Duration:    knownDuration(e.Duration),
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+// parseXSPF reads XSPF, the first location and identifier of a track are kept
+func parseXSPF(data []byte) (*Playlist, error) {
+	doc := xspfPlaylist{}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	p := &Playlist{Name: strings.TrimSpace(doc.Title), Entries: make([]*Entry, len(doc.Tracks))}
+	for i, t := range doc.Tracks {
+		p.Entries[i] = newEntry(t.Locations, t.Identifiers, t.Title, t.Creator, t.Album, t.Duration)
+	}
+
+	return p, nil
+}
+
+// newEntry returns the entry of an XSPF or JSPF track
+func newEntry(locations, identifiers []string, title, creator, album string, duration *int64) *Entry {
+	e := &Entry{
+		Location:   first(locations),
+		Identifier: first(identifiers),
+		Artist:     strings.TrimSpace(creator),
+		Title:      strings.TrimSpace(title),
+		Album:      strings.TrimSpace(album),
+		Duration:   UnknownDuration,
+	}
+	if duration != nil && *duration >= 0 {
+		e.Duration = *duration
+	}
+
+	return e
+}
+
+func first(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func nonEmpty(v string) []string {
+	if v == "" {
+		return nil
+	}
+
+	return []string{v}
+}
+
+func knownDuration(ms int64) *int64 {
+	if ms < 0 {
+		return nil
+	}
+
+	return &ms
+}
@@ -0,0 +1,148 @@
+package playlistio
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXSPFRoundTrip(t *testing.T) {
+	got, written := roundTrip(t, samplePlaylist(), FormatXSPF)
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist xmlns="http://xspf.org/ns/0/" version="1">
+  <title>Road &lt;trip&gt; &amp; co</title>
+  <trackList>
+    <track>
+      <location>http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream</location>
+      <identifier>http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38</identifier>
+      <title>Lạc Trôi</title>
+      <creator>Sơn Tùng M-TP</creator>
+      <album>m-tp M-TP</album>
+      <duration>215123</duration>
+    </track>
+    <track>
+      <location>music/b side.mp3</location>
+      <title>B</title>
+    </track>
+  </trackList>
+</playlist>
+`
+	if written != want {
+		t.Errorf("WriteXSPF() =\n%s\nwant\n%s", written, want)
+	}
+
+	// * XSPF carries everything, durations in milliseconds
+	if got.Name != "Road <trip> & co" {
+		t.Errorf("Name = %q, want the playlist name", got.Name)
+	}
+	checkEntries(t, got.Entries, entriesOf(samplePlaylist()))
+}
+
+func TestJSPFRoundTrip(t *testing.T) {
+	got, written := roundTrip(t, samplePlaylist(), FormatJSPF)
+
+	want := `{
+  "playlist": {
+    "title": "Road <trip> & co",
+    "track": [
+      {
+        "location": [
+          "http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38/stream"
+        ],
+        "identifier": [
+          "http://localhost/v1/customer/music-tracks/6620db0b3e1ac4c9d158ae38"
+        ],
+        "title": "Lạc Trôi",
+        "creator": "Sơn Tùng M-TP",
+        "album": "m-tp M-TP",
+        "duration": 215123
+      },
+      {
+        "location": [
+          "music/b side.mp3"
+        ],
+        "title": "B"
+      }
+    ]
+  }
+}
+`
+	if written != want {
+		t.Errorf("WriteJSPF() =\n%s\nwant\n%s", written, want)
+	}
+
+	if got.Name != "Road <trip> & co" {
+		t.Errorf("Name = %q, want the playlist name", got.Name)
+	}
+	checkEntries(t, got.Entries, entriesOf(samplePlaylist()))
+}
+
+func TestParseXSPF(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title> Mix </title>
+  <trackList>
+    <track>
+      <location></location>
+      <location>http://example.com/a.mp3</location>
+      <location>http://mirror.example.com/a.mp3</location>
+      <creator> Artist </creator>
+      <title>A</title>
+      <duration>-5</duration>
+    </track>
+    <track><identifier>urn:isrc:USRC17607839</identifier></track>
+  </trackList>
+</playlist>`
+	p, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if p.Name != "Mix" {
+		t.Errorf("Name = %q, want Mix", p.Name)
+	}
+	// * the first location that is not empty is kept
+	checkEntries(t, p.Entries, []Entry{
+		{Position: 1, Location: "http://example.com/a.mp3", Artist: "Artist", Title: "A", Duration: UnknownDuration},
+		{Position: 2, Identifier: "urn:isrc:USRC17607839", Duration: UnknownDuration},
+	})
+}
+
+func TestParseJSPF(t *testing.T) {
+	// * early JSPF writers give locations and identifiers as single strings
+	data := `{"playlist": {"title": "Mix", "track": [
+		{"location": "http://example.com/a.mp3", "identifier": ["", "urn:a"], "duration": 5000},
+		{"location": ["http://example.com/b.mp3", "http://mirror.example.com/b.mp3"], "creator": "B"}
+	]}}`
+	p, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	checkEntries(t, p.Entries, []Entry{
+		{Position: 1, Location: "http://example.com/a.mp3", Identifier: "urn:a", Duration: 5000},
+		{Position: 2, Location: "http://example.com/b.mp3", Artist: "B", Duration: UnknownDuration},
+	})
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range []string{
+		`<html><body>not a playlist`,
+		`{"playlist": {"track": {"location": "a.mp3"}}}`,
+		`{"playlist": {"track": []}}`,
+		`<playlist version="1"><trackList/></playlist>`,
+	} {
+		if p, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", data, p)
+		}
+	}
+}
+
+// entriesOf returns the entries of p numbered as Parse does
+func entriesOf(p *Playlist) []Entry {
+	entries := make([]Entry, len(p.Entries))
+	for i, e := range p.Entries {
+		entries[i] = *e
+		entries[i].Position = i + 1
+	}
+
+	return entries
+}
@@ -17,6 +17,8 @@ const (
 	GenericErrorType = "GENERIC"
 	// ValidationErrorType type of common errors
 	ValidationErrorType = "VALIDATION"
+	// ConflictErrorType type of errors caused by a resource that already exists
+	ConflictErrorType = "CONFLICT"
 )
 
 // ErrorResponse represents the error response
@@ -31,6 +33,7 @@ type HTTPError struct {
 	Message  string          `json:"message"`
 	Internal error           `json:"-"`
 	Extra    *HTTPErrorExtra `json:"extra,omitempty"`
+	Data     interface{}     `json:"data,omitempty"`
 }
 
 // HTTPErrorExtra represents an error that occurred while handling a request with extra information
@@ -101,6 +104,11 @@ func NewHTTPValidationError(message string) *HTTPError {
 	return &HTTPError{Code: http.StatusBadRequest, Type: ValidationErrorType, Message: message}
 }
 
+// NewHTTPConflictError creates a new HTTPError instance for conflict error
+func NewHTTPConflictError(message string) *HTTPError {
+	return &HTTPError{Code: http.StatusConflict, Type: ConflictErrorType, Message: message}
+}
+
 // Error makes it compatible with `error` interface
 func (he *HTTPError) Error() string {
 	return fmt.Sprintf("code=%d, type=%s, message=%s", he.Code, he.Type, he.Message)
@@ -136,6 +144,12 @@ func (he *HTTPError) SetButtonLabel(label string) *HTTPError {
 	return he
 }
 
+// SetData sets data the client needs to handle the error, e.g. the id of a conflicting resource
+func (he *HTTPError) SetData(data interface{}) *HTTPError {
+	he.Data = data
+	return he
+}
+
 // SetMessage sets custom message error for more details
 func (he *HTTPError) SetMessage(message string) *HTTPError {
 	he.Message = message
@@ -175,6 +189,7 @@ func (ce *ErrorHandler) Handle(err error, c echo.Context) {
 		if e.Extra != nil {
 			httpErr.Extra = e.Extra
 		}
+		httpErr.Data = e.Data
 
 	case *echo.HTTPError:
 		httpErr.Code = e.Code
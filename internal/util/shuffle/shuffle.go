@@ -0,0 +1,110 @@
+// Package shuffle orders playlists at random without playing the same artist, or the
+// same album, several times in a row. Every artist gets its tracks spread evenly over
+// the playlist from a random offset, the albums of an artist the same way over the
+// tracks of the artist. The order only depends on the items and the seed, so a seed
+// reproduces it.
+package shuffle
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// MaxSeed bounds generated seeds, so they survive JSON numbers read as doubles
+const MaxSeed = 1 << 53
+
+// Item is a track to shuffle
+type Item struct {
+	Artist string
+	Album  string
+	// Weight pulls the item towards the start when above 1 and towards the end when
+	// below, 0 counts as 1
+	Weight float64
+}
+
+// NewSeed returns a random seed below MaxSeed
+func NewSeed() int64 {
+	return rand.Int63n(MaxSeed)
+}
+
+// Order returns the indexes of items in shuffled order
+func Order(items []Item, seed int64) []int {
+	rng := rand.New(rand.NewSource(seed))
+	all := make([]int, len(items))
+	for i := range all {
+		all[i] = i
+	}
+
+	albums := func(idx []int) []int {
+		return byPosition(idx, positions(idx, func(i int) string { return key(items[i].Album) }, func(g []int) []int {
+			perm := rng.Perm(len(g))
+			result := make([]int, len(g))
+			for k, p := range perm {
+				result[k] = g[p]
+			}
+			return result
+		}, rng))
+	}
+	pos := positions(all, func(i int) string { return key(items[i].Artist) }, albums, rng)
+	for i, item := range items {
+		if item.Weight > 0 && item.Weight != 1 {
+			pos[i] = math.Pow(pos[i], item.Weight)
+		}
+	}
+
+	return byPosition(all, pos)
+}
+
+// positions places the items idx in [0, 1). The items of a group, those of the same
+// key, take the order inner gives them and are 1/n apart for a group of n, from a
+// random offset and with a little jitter. Items without a key are groups of their own.
+func positions(idx []int, key func(i int) string, inner func(g []int) []int, rng *rand.Rand) map[int]float64 {
+	// * groups in order of appearance, the random numbers are drawn in the same order for the same items
+	groups := [][]int{}
+	byKey := map[string]int{}
+	for _, i := range idx {
+		k := key(i)
+		if k == "" {
+			groups = append(groups, []int{i})
+			continue
+		}
+		if g, ok := byKey[k]; ok {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+		byKey[k] = len(groups)
+		groups = append(groups, []int{i})
+	}
+
+	pos := make(map[int]float64, len(idx))
+	for _, g := range groups {
+		if len(g) > 1 {
+			g = inner(g)
+		}
+		n := float64(len(g))
+		offset := 0.1 + 0.8*rng.Float64()
+		for k, i := range g {
+			jitter := 0.2 * (rng.Float64() - 0.5)
+			pos[i] = (float64(k) + offset + jitter) / n
+		}
+	}
+
+	return pos
+}
+
+// byPosition returns idx sorted by pos
+func byPosition(idx []int, pos map[int]float64) []int {
+	result := append([]int(nil), idx...)
+	sort.SliceStable(result, func(a, b int) bool {
+		return pos[result[a]] < pos[result[b]]
+	})
+
+	return result
+}
+
+// key groups artists and albums written alike
+func key(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
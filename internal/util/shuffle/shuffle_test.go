@@ -0,0 +1,182 @@
+package shuffle
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// library returns the tracks of artists with counts[a] tracks each, the tracks of an
+// artist alternating between two albums
+func library(counts ...int) []Item {
+	var items []Item
+	for a, n := range counts {
+		for k := 0; k < n; k++ {
+			items = append(items, Item{Artist: fmt.Sprintf("Artist %d", a), Album: fmt.Sprintf("Album %d", k%2)})
+		}
+	}
+
+	return items
+}
+
+// adjacent counts the neighbours in order that share what same compares
+func adjacent(items []Item, order []int, same func(a, b Item) bool) int {
+	n := 0
+	for p := 1; p < len(order); p++ {
+		if same(items[order[p-1]], items[order[p]]) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func sameArtist(a, b Item) bool {
+	return a.Artist == b.Artist
+}
+
+func TestOrderIsPermutation(t *testing.T) {
+	items := library(5, 3, 1, 1, 7)
+	for seed := int64(0); seed < 100; seed++ {
+		order := Order(items, seed)
+		sorted := append([]int(nil), order...)
+		sort.Ints(sorted)
+		for i, v := range sorted {
+			if v != i {
+				t.Fatalf("Order(seed %d) = %v, want a permutation of %d items", seed, order, len(items))
+			}
+		}
+	}
+	if order := Order(nil, 1); len(order) != 0 {
+		t.Errorf("Order(nil) = %v, want none", order)
+	}
+}
+
+func TestOrderDeterministic(t *testing.T) {
+	items := library(4, 4, 4, 2, 1)
+	copied := append([]Item(nil), items...)
+
+	first := Order(items, 42)
+	if again := Order(copied, 42); !reflect.DeepEqual(first, again) {
+		t.Errorf("Order() with the same seed = %v, then %v", first, again)
+	}
+
+	orders := map[string]bool{}
+	for seed := int64(0); seed < 20; seed++ {
+		orders[fmt.Sprint(Order(items, seed))] = true
+	}
+	if len(orders) < 19 {
+		t.Errorf("20 seeds gave %d orders, want different orders", len(orders))
+	}
+
+	// * seeds near MaxSeed are as good as small ones
+	if a, b := Order(items, MaxSeed-1), Order(items, MaxSeed-2); reflect.DeepEqual(a, b) {
+		t.Errorf("Order() of two large seeds = %v, want different orders", a)
+	}
+}
+
+// TestOrderSpacing compares how often tracks of the same artist, or album, follow each
+// other with a plain shuffle of the same tracks
+func TestOrderSpacing(t *testing.T) {
+	const seeds = 1000
+	tests := []struct {
+		name   string
+		items  []Item
+		same   func(a, b Item) bool
+		factor float64 // most of the plain shuffle's neighbours allowed
+	}{
+		{"even artists", library(4, 4, 4), sameArtist, 0.05},
+		{"many artists", library(3, 3, 3, 3, 3, 3), sameArtist, 0.01},
+		{"dominant artist", library(6, 3, 3), sameArtist, 0.5},
+		{"albums of one artist", library(8), func(a, b Item) bool { return a.Album == b.Album }, 0.2},
+	}
+	for _, tt := range tests {
+		shuffled, plain := 0, 0
+		rng := rand.New(rand.NewSource(1))
+		for seed := int64(0); seed < seeds; seed++ {
+			shuffled += adjacent(tt.items, Order(tt.items, seed), tt.same)
+			plain += adjacent(tt.items, rng.Perm(len(tt.items)), tt.same)
+		}
+		if float64(shuffled) > tt.factor*float64(plain) {
+			t.Errorf("%s: %d neighbours in %d orders, plain shuffles have %d, want at most %.0f", tt.name, shuffled, seeds, plain, tt.factor*float64(plain))
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	items := library(5, 3, 1)
+	idx := make([]int, len(items))
+	for i := range idx {
+		idx[i] = i
+	}
+	rng := rand.New(rand.NewSource(7))
+	identity := func(g []int) []int { return g }
+	pos := positions(idx, func(i int) string { return key(items[i].Artist) }, identity, rng)
+
+	// * the k-th of n tracks of an artist falls in the k-th n-th of [0, 1)
+	for _, group := range [][]int{{0, 1, 2, 3, 4}, {5, 6, 7}, {8}} {
+		n := float64(len(group))
+		for k, i := range group {
+			if lo, hi := float64(k)/n, float64(k+1)/n; pos[i] < lo || pos[i] >= hi {
+				t.Errorf("track %d of %d is at %.3f, want it in [%.3f, %.3f)", k, len(group), pos[i], lo, hi)
+			}
+		}
+	}
+}
+
+func TestOrderArtistsWrittenAlike(t *testing.T) {
+	alike := []Item{{Artist: "Queen"}, {Artist: " queen"}, {Artist: "QUEEN "}, {Artist: "ABBA"}, {Artist: "abba"}, {Artist: ""}}
+	same := []Item{{Artist: "queen"}, {Artist: "queen"}, {Artist: "queen"}, {Artist: "abba"}, {Artist: "abba"}, {Artist: ""}}
+	for seed := int64(0); seed < 20; seed++ {
+		if a, b := Order(alike, seed), Order(same, seed); !reflect.DeepEqual(a, b) {
+			t.Fatalf("Order(seed %d) = %v for artists written alike, %v for the same artists", seed, a, b)
+		}
+	}
+}
+
+func TestOrderWeight(t *testing.T) {
+	// * every track is its own artist, only the weights tell them apart
+	items := make([]Item, 20)
+	for i := range items {
+		items[i].Artist = fmt.Sprint(i)
+		items[i].Weight = 0.25
+		if i < 10 {
+			items[i].Weight = 4
+		}
+	}
+
+	var heavy, light float64
+	for seed := int64(0); seed < 200; seed++ {
+		for p, i := range Order(items, seed) {
+			if i < 10 {
+				heavy += float64(p)
+			} else {
+				light += float64(p)
+			}
+		}
+	}
+	heavy, light = heavy/2000, light/2000
+	if heavy > 6 || light < 13 {
+		t.Errorf("mean position of heavy tracks %.1f, light tracks %.1f, want heavy ones first", heavy, light)
+	}
+
+	// * weights of 0 and 1 leave the order alone
+	plain := library(3, 3, 2)
+	weighted := append([]Item(nil), plain...)
+	for i := range weighted {
+		weighted[i].Weight = float64(i % 2)
+	}
+	if a, b := Order(plain, 3), Order(weighted, 3); !reflect.DeepEqual(a, b) {
+		t.Errorf("Order() with weights 0 and 1 = %v, want %v", b, a)
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if seed := NewSeed(); seed < 0 || seed >= MaxSeed {
+			t.Fatalf("NewSeed() = %d, want it in [0, MaxSeed)", seed)
+		}
+	}
+}